@@ -3,9 +3,15 @@ package main
 import (
 	"expense_tracker/internal/database"
 	"expense_tracker/internal/handlers"
+	"expense_tracker/internal/invoiceparse"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,22 +20,122 @@ func main() {
 	// Ensure data directories exist
 	createRequiredDirectories()
 
+	// Currency assumed for payments that don't specify one
+	models.DefaultCurrency = getEnv("DEFAULT_CURRENCY", models.DefaultCurrency)
+	// Currency analytics are reported in unless a request asks otherwise
+	models.BaseCurrency = getEnv("BASE_CURRENCY", models.DefaultCurrency)
+
 	// Initialize database
 	dbPath := getEnv("DB_PATH", "data/expense_tracker.db")
 	db := database.InitDB(dbPath)
 	defer db.Close()
 
+	// Sessions last SESSION_TTL; set COOKIE_SECURE=true when serving over HTTPS
+	sessionTTL, err := time.ParseDuration(getEnv("SESSION_TTL", "168h"))
+	if err != nil {
+		log.Fatalf("Invalid SESSION_TTL: %v", err)
+	}
+	secureCookie := getEnv("COOKIE_SECURE", "false") == "true"
+
+	// Uploaded files go to STORAGE_BACKEND: local (default) or s3
+	store, err := newStorage()
+	if err != nil {
+		log.Fatalf("Failed to set up file storage: %v", err)
+	}
+
+	// Move files uploaded before content-addressed storage into blobs
+	if adopted, err := handlers.AdoptLegacyFiles(db, store); err != nil {
+		log.Fatalf("Failed to move files into content-addressed storage: %v", err)
+	} else if adopted > 0 {
+		log.Printf("Moved %d file(s) into content-addressed storage", adopted)
+	}
+
+	// Uploaded documents and attachments may be at most MAX_UPLOAD_SIZE bytes
+	maxUploadSize, err := strconv.ParseInt(getEnv("MAX_UPLOAD_SIZE", "20971520"), 10, 64)
+	if err != nil || maxUploadSize <= 0 {
+		log.Fatalf("Invalid MAX_UPLOAD_SIZE: %q", getEnv("MAX_UPLOAD_SIZE", ""))
+	}
+
+	// Invoices uploaded to pre-fill payments are read with the default rules,
+	// or those in the JSON file INVOICE_RULES_FILE
+	invoiceRules := invoiceparse.DefaultRules()
+	if path := os.Getenv("INVOICE_RULES_FILE"); path != "" {
+		if invoiceRules, err = invoiceparse.LoadRules(path); err != nil {
+			log.Fatalf("Invalid INVOICE_RULES_FILE: %v", err)
+		}
+	}
+
 	// Create handlers
+	authHandler := handlers.NewAuthHandler(db, sessionTTL, secureCookie)
+	workspaceHandler := handlers.NewWorkspaceHandler(db)
+	apiTokenHandler := handlers.NewAPITokenHandler(db)
+	userHandler := handlers.NewUserHandler(db)
 	tagHandler := handlers.NewTagHandler(db)
-	paymentHandler := handlers.NewPaymentHandler(db)
-	documentHandler := handlers.NewDocumentHandler(db)
+	paymentHandler := handlers.NewPaymentHandler(db, store, maxUploadSize, invoiceRules)
+	documentHandler := handlers.NewDocumentHandler(db, store, maxUploadSize)
+	exchangeRateHandler := handlers.NewExchangeRateHandler(db, maxUploadSize)
+	recurringPaymentHandler := handlers.NewRecurringPaymentHandler(db)
+	budgetHandler := handlers.NewBudgetHandler(db)
+	tagRuleHandler := handlers.NewTagRuleHandler(db)
+	vendorHandler := handlers.NewVendorHandler(db)
+	auditHandler := handlers.NewAuditHandler(db)
+
+	// Deleted payments and documents are purged TRASH_RETENTION after
+	// deletion, checked every PURGE_INTERVAL
+	trashRetention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalf("Invalid TRASH_RETENTION: %v", err)
+	}
+	trashHandler := handlers.NewTrashHandler(db, store, trashRetention)
+	searchHandler := handlers.NewSearchHandler(db)
+
+	// Generate due recurring payments in the background
+	schedulerInterval, err := time.ParseDuration(getEnv("RECURRING_INTERVAL", "1h"))
+	if err != nil {
+		log.Fatalf("Invalid RECURRING_INTERVAL: %v", err)
+	}
+	stopScheduler := make(chan struct{})
+	defer close(stopScheduler)
+	recurringPaymentHandler.StartScheduler(schedulerInterval, stopScheduler)
+
+	purgeInterval, err := time.ParseDuration(getEnv("PURGE_INTERVAL", "1h"))
+	if err != nil {
+		log.Fatalf("Invalid PURGE_INTERVAL: %v", err)
+	}
+	stopPurger := make(chan struct{})
+	defer close(stopPurger)
+	trashHandler.StartPurger(purgeInterval, stopPurger)
+
+	// Extract the text of uploaded PDFs and text files for search, checking
+	// for new uploads and due retries every TEXT_EXTRACT_INTERVAL
+	extractInterval, err := time.ParseDuration(getEnv("TEXT_EXTRACT_INTERVAL", "30s"))
+	if err != nil {
+		log.Fatalf("Invalid TEXT_EXTRACT_INTERVAL: %v", err)
+	}
+	stopExtractor := make(chan struct{})
+	defer close(stopExtractor)
+	handlers.NewTextExtractor(db, store).StartExtractor(extractInterval, stopExtractor)
 
 	// Setup router
 	router := gin.Default()
 
-	// Add CORS middleware
+	// Add CORS middleware. Cross-origin requests are only credentialed
+	// (allowed to send the session cookie) for origins listed in
+	// CORS_ALLOWED_ORIGINS; others can still authenticate with a bearer token.
+	allowedOrigins := make(map[string]bool)
+	for _, origin := range strings.Split(getEnv("CORS_ALLOWED_ORIGINS", ""), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins[origin] = true
+		}
+	}
 	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := c.GetHeader("Origin"); allowedOrigins[origin] {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			c.Writer.Header().Add("Vary", "Origin")
+		} else {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		}
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
@@ -49,10 +155,26 @@ func main() {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
 
-		// Register handlers
-		tagHandler.RegisterRoutes(api)
-		paymentHandler.RegisterRoutes(api)
-		documentHandler.RegisterRoutes(api)
+		// Login is public, everything else requires a session or API token
+		authHandler.RegisterRoutes(api)
+		protected := api.Group("", authHandler.RequireAuth())
+		workspaceHandler.RegisterRoutes(protected)
+		apiTokenHandler.RegisterRoutes(protected)
+		userHandler.RegisterRoutes(protected)
+		exchangeRateHandler.RegisterRoutes(protected)
+
+		// Data handlers only see the session's current workspace
+		scoped := protected.Group("", authHandler.RequireWorkspace())
+		tagHandler.RegisterRoutes(scoped)
+		paymentHandler.RegisterRoutes(scoped)
+		documentHandler.RegisterRoutes(scoped)
+		recurringPaymentHandler.RegisterRoutes(scoped)
+		budgetHandler.RegisterRoutes(scoped)
+		tagRuleHandler.RegisterRoutes(scoped)
+		vendorHandler.RegisterRoutes(scoped)
+		auditHandler.RegisterRoutes(scoped)
+		trashHandler.RegisterRoutes(scoped)
+		searchHandler.RegisterRoutes(scoped)
 	}
 
 	// Static file serving for frontend
@@ -73,9 +195,6 @@ func main() {
 func createRequiredDirectories() {
 	dirs := []string{
 		"data",
-		"storage",
-		filepath.Join("storage", "documents"),
-		filepath.Join("storage", "invoices"),
 		"static",
 	}
 
@@ -86,6 +205,27 @@ func createRequiredDirectories() {
 	}
 }
 
+// newStorage sets up the configured file storage backend. Local files live
+// under STORAGE_DIR; S3 takes its bucket, endpoint and credentials from the
+// S3_* variables, with S3_PATH_STYLE=true for MinIO and similar servers.
+func newStorage() (storage.Storage, error) {
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return storage.NewLocal(getEnv("STORAGE_DIR", "storage"))
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          getEnv("S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       getEnv("S3_PATH_STYLE", "false") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
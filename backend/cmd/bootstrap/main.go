@@ -0,0 +1,123 @@
+// Command bootstrap creates the first admin account. It refuses to run once
+// any user exists. The admin joins the workspaces of an existing install,
+// or a new workspace when there are none, as an owner.
+package main
+
+import (
+	"bufio"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/database"
+	"expense_tracker/internal/models"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func main() {
+	dbPath := flag.String("db", getEnv("DB_PATH", "data/expense_tracker.db"), "path to the SQLite database")
+	email := flag.String("email", "", "admin email address (required)")
+	name := flag.String("name", "Admin", "admin display name")
+	workspace := flag.String("workspace", "Default", "name of the workspace to create when there is none")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: bootstrap [-db path] -email address [-name name] [-workspace name]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "The password is read from ADMIN_PASSWORD, or from standard input.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if strings.TrimSpace(*email) == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Currency assumed for payments that don't specify one
+	models.DefaultCurrency = getEnv("DEFAULT_CURRENCY", models.DefaultCurrency)
+
+	db, err := database.Open(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := database.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		log.Fatal(err)
+	}
+	if users > 0 {
+		log.Fatal("Users already exist; bootstrap only creates the first admin")
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatalf("Failed to read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	userID := uuid.New().String()
+	_, err = tx.Exec(`
+		INSERT INTO users (id, email, name, password_hash, is_admin, created_at, updated_at)
+		VALUES (?, ?, ?, ?, true, ?, ?)
+	`, userID, strings.TrimSpace(*email), *name, hash, now, now)
+	if err != nil {
+		log.Fatalf("Failed to create admin: %v", err)
+	}
+
+	var workspaces int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM workspaces").Scan(&workspaces); err != nil {
+		log.Fatal(err)
+	}
+	if workspaces == 0 {
+		_, err = tx.Exec(
+			"INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+			uuid.New().String(), *workspace, now, now,
+		)
+		if err != nil {
+			log.Fatalf("Failed to create workspace: %v", err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+		SELECT id, ?, ?, ? FROM workspaces
+	`, userID, auth.RoleOwner, now)
+	if err != nil {
+		log.Fatalf("Failed to add admin to workspaces: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Created admin %s", strings.TrimSpace(*email))
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
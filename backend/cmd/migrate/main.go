@@ -0,0 +1,74 @@
+package main
+
+import (
+	"expense_tracker/internal/database"
+	"expense_tracker/internal/models"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+	dbPath := flag.String("db", getEnv("DB_PATH", "data/expense_tracker.db"), "path to the SQLite database")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: migrate [-db path] <up|down [steps]|status>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Currency assumed for payments that don't specify one
+	models.DefaultCurrency = getEnv("DEFAULT_CURRENCY", models.DefaultCurrency)
+
+	db, err := database.Open(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "up":
+		if err := database.Migrate(db); err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			if steps, err = strconv.Atoi(flag.Arg(1)); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps: %s", flag.Arg(1))
+			}
+		}
+		current, err := database.CurrentVersion(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := database.Rollback(db, current-steps); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		applied, err := database.AppliedMigrations(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range applied {
+			fmt.Printf("%4d  %-40s  %s\n", m.Version, m.Name, m.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("Binary supports up to version %d\n", database.LatestVersion())
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
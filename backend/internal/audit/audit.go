@@ -0,0 +1,79 @@
+// Package audit describes changes to payments, documents and tags as
+// field-level diffs for the audit log.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Entity types
+const (
+	EntityPayment    = "payment"
+	EntityAttachment = "payment_attachment"
+	EntityDocument   = "document"
+	EntityTag        = "tag"
+)
+
+// Actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+// Change holds the old and new value of one field. Before is absent for
+// created entities and After for deleted ones.
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// ignoredFields change on every write and would only add noise
+var ignoredFields = map[string]bool{"updatedAt": true, "updated_at": true}
+
+// Diff compares the JSON representations of two versions of an entity and
+// returns the fields that differ. before is nil for a created entity and
+// after is nil for a deleted one.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for name, value := range old {
+		if ignoredFields[name] {
+			continue
+		}
+		if newValue, ok := updated[name]; !ok || !reflect.DeepEqual(value, newValue) {
+			changes[name] = Change{Before: value, After: newValue}
+		}
+	}
+	for name, value := range updated {
+		if _, ok := old[name]; !ok && !ignoredFields[name] {
+			changes[name] = Change{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// fields decodes the JSON object v marshals to
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return map[string]interface{}{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
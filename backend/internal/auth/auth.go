@@ -0,0 +1,69 @@
+// Package auth hashes passwords and issues the opaque tokens used for API
+// sessions.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted for an account
+const MinPasswordLength = 8
+
+// ErrPasswordTooShort is returned when a new password is shorter than
+// MinPasswordLength
+var ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response takes as long as for a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of a new password
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches the hash. An empty hash
+// never matches but still costs a bcrypt comparison.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken returns a random URL-safe token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the value stored for a token, so a leaked database
+// doesn't hand out usable sessions
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokenPrefix starts every API token, telling them apart from session
+// tokens
+const APITokenPrefix = "pat_"
+
+// NewAPIToken returns a random API token
+func NewAPIToken() (string, error) {
+	token, err := NewToken()
+	return APITokenPrefix + token, err
+}
@@ -0,0 +1,58 @@
+package auth
+
+// Role is a user's role within a workspace
+type Role string
+
+const (
+	// RoleOwner has every permission, including managing members
+	RoleOwner Role = "owner"
+	// RoleEditor can create, change and delete data
+	RoleEditor Role = "editor"
+	// RoleAccountant can read and export data and settle payments
+	RoleAccountant Role = "accountant"
+	// RoleViewer can only read data
+	RoleViewer Role = "viewer"
+)
+
+// Permission is an action a route requires
+type Permission string
+
+const (
+	// PermRead allows reading a workspace's data
+	PermRead Permission = "read"
+	// PermWrite allows creating and changing data
+	PermWrite Permission = "write"
+	// PermDelete allows deleting data
+	PermDelete Permission = "delete"
+	// PermExport allows exporting payments
+	PermExport Permission = "export"
+	// PermMarkPaid allows recording instalments and marking payments paid
+	PermMarkPaid Permission = "mark_paid"
+	// PermManageWorkspace allows renaming a workspace and managing its members
+	PermManageWorkspace Permission = "manage_workspace"
+)
+
+// permissions is the permission table: the permissions granted to each role
+var permissions = map[Role][]Permission{
+	RoleOwner:      {PermRead, PermWrite, PermDelete, PermExport, PermMarkPaid, PermManageWorkspace},
+	RoleEditor:     {PermRead, PermWrite, PermDelete, PermExport, PermMarkPaid},
+	RoleAccountant: {PermRead, PermExport, PermMarkPaid},
+	RoleViewer:     {PermRead},
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := permissions[r]
+	return ok
+}
+
+// Can reports whether the role grants a permission. Unknown roles,
+// including the empty role, grant nothing.
+func (r Role) Can(p Permission) bool {
+	for _, granted := range permissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
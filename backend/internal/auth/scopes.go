@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ScopeResources are the resources API token scopes refer to. Each is
+// granted as "<resource>:read" or "<resource>:write"; write implies read.
+var ScopeResources = []string{"payments", "documents", "tags", "recurring-payments", "budgets", "tag-rules", "vendors", "exchange-rates", "audit", "trash"}
+
+// ValidateScopes checks every scope names a known resource and access level
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		resource, access, _ := strings.Cut(scope, ":")
+		if !knownResource(resource) || (access != "read" && access != "write") {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// ScopeAllows reports whether scopes grant access to a resource. Reads need
+// the read or write scope, anything else the write scope.
+func ScopeAllows(scopes []string, resource string, write bool) bool {
+	for _, scope := range scopes {
+		if scope == resource+":write" || (!write && scope == resource+":read") {
+			return true
+		}
+	}
+	return false
+}
+
+func knownResource(resource string) bool {
+	for _, r := range ScopeResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestOpenConcurrentWrites(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE counters (id INTEGER PRIMARY KEY, n INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO counters (id, n) VALUES (1, 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-then-write transactions like the handlers' and workers'
+	const writers = 40
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := db.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback()
+			var n int
+			if err := tx.QueryRow("SELECT n FROM counters WHERE id = 1").Scan(&n); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := tx.Exec("UPDATE counters SET n = ? WHERE id = 1", n+1); err != nil {
+				errs <- err
+				return
+			}
+			errs <- tx.Commit()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent write: %v", err)
+		}
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT n FROM counters WHERE id = 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != writers {
+		t.Errorf("counter = %d, want %d", n, writers)
+	}
+}
+
+func TestOpenEnablesForeignKeysOnEveryConnection(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Hold several connections at once so the pool has to open new ones
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		var on int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&on); err != nil {
+			t.Fatal(err)
+		}
+		if on != 1 {
+			t.Errorf("connection %d: foreign_keys = %d, want 1", i, on)
+		}
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
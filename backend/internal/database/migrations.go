@@ -0,0 +1,966 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/models"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration is a single numbered schema change. Up and Down run inside a
+// transaction together with the bookkeeping row in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// AppliedMigration describes a row in schema_migrations
+type AppliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// migrations holds every known migration. Append new migrations to the end
+// with the next version number; never renumber or edit applied ones.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: execAll(
+			`CREATE TABLE IF NOT EXISTS tags (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				color TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS payments (
+				id TEXT PRIMARY KEY,
+				info TEXT NOT NULL,
+				amount REAL NOT NULL,
+				date_paid DATE NOT NULL,
+				fully_paid BOOLEAN DEFAULT false,
+				invoice_path TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS payment_tags (
+				payment_id TEXT,
+				tag_id TEXT,
+				PRIMARY KEY (payment_id, tag_id),
+				FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS documents (
+				id TEXT PRIMARY KEY,
+				title TEXT NOT NULL,
+				description TEXT,
+				file_path TEXT NOT NULL,
+				original_name TEXT NOT NULL,
+				file_size INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS document_tags (
+				document_id TEXT,
+				tag_id TEXT,
+				PRIMARY KEY (document_id, tag_id),
+				FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_payments_date ON payments(date_paid)`,
+			`CREATE INDEX IF NOT EXISTS idx_tags_name ON tags(name)`,
+			`CREATE INDEX IF NOT EXISTS idx_documents_title ON documents(title)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS document_tags`,
+			`DROP TABLE IF EXISTS documents`,
+			`DROP TABLE IF EXISTS payment_tags`,
+			`DROP TABLE IF EXISTS payments`,
+			`DROP TABLE IF EXISTS tags`,
+		),
+	},
+	{
+		// Older installs were created before updated_at existed on payments
+		// and documents. Installs created by the old createTables already have it.
+		Version: 2,
+		Name:    "add_updated_at_columns",
+		Up: func(tx *sql.Tx) error {
+			for _, table := range []string{"payments", "documents"} {
+				if err := addColumnIfMissing(tx, table, "updated_at", "DATETIME"); err != nil {
+					return err
+				}
+				if _, err := tx.Exec("UPDATE " + table + " SET updated_at = created_at WHERE updated_at IS NULL"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: execAll(
+			`ALTER TABLE documents DROP COLUMN updated_at`,
+			`ALTER TABLE payments DROP COLUMN updated_at`,
+		),
+	},
+	{
+		// Amounts move from a floating point REAL to integer minor units so
+		// sums are exact. Existing rows are assumed to be in DefaultCurrency.
+		Version: 3,
+		Name:    "payments_amount_minor_units",
+		Up: func(tx *sql.Tx) error {
+			currency, err := models.NormalizeCurrency(models.DefaultCurrency)
+			if err != nil {
+				return err
+			}
+			scale := pow10(models.CurrencyExponent(currency))
+
+			return execAll(
+				`ALTER TABLE payments ADD COLUMN amount_minor INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE payments ADD COLUMN currency TEXT NOT NULL DEFAULT '`+currency+`'`,
+				fmt.Sprintf(`UPDATE payments SET amount_minor = CAST(ROUND(amount * %d) AS INTEGER)`, scale),
+				`ALTER TABLE payments DROP COLUMN amount`,
+			)(tx)
+		},
+		Down: func(tx *sql.Tx) error {
+			// Rows in other currencies lose their currency on the way down
+			scale := pow10(models.CurrencyExponent(models.DefaultCurrency))
+			return execAll(
+				`ALTER TABLE payments ADD COLUMN amount REAL NOT NULL DEFAULT 0`,
+				fmt.Sprintf(`UPDATE payments SET amount = amount_minor / %d.0`, scale),
+				`ALTER TABLE payments DROP COLUMN currency`,
+				`ALTER TABLE payments DROP COLUMN amount_minor`,
+			)(tx)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_exchange_rates",
+		Up: execAll(
+			`CREATE TABLE exchange_rates (
+				id TEXT PRIMARY KEY,
+				date DATE NOT NULL,
+				from_currency TEXT NOT NULL,
+				to_currency TEXT NOT NULL,
+				rate TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (date, from_currency, to_currency)
+			)`,
+			`CREATE INDEX idx_exchange_rates_pair ON exchange_rates(from_currency, to_currency, date)`,
+			`CREATE INDEX idx_payments_currency ON payments(currency)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_currency`,
+			`DROP TABLE IF EXISTS exchange_rates`,
+		),
+	},
+	{
+		// fully_paid becomes derived from the ledger, so payments already
+		// marked as paid get a single transaction covering the full amount.
+		Version: 5,
+		Name:    "create_payment_transactions",
+		Up: execAll(
+			`CREATE TABLE payment_transactions (
+				id TEXT PRIMARY KEY,
+				payment_id TEXT NOT NULL,
+				amount_minor INTEGER NOT NULL,
+				date_paid DATE NOT NULL,
+				method TEXT NOT NULL DEFAULT '',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_payment_transactions_payment ON payment_transactions(payment_id)`,
+			`INSERT INTO payment_transactions (id, payment_id, amount_minor, date_paid, method, created_at)
+			SELECT lower(hex(randomblob(16))), id, amount_minor, date_paid, '', CURRENT_TIMESTAMP
+			FROM payments WHERE fully_paid`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS payment_transactions`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "create_recurring_payments",
+		Up: execAll(
+			`CREATE TABLE recurring_payments (
+				id TEXT PRIMARY KEY,
+				info TEXT NOT NULL,
+				amount_minor INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				rule TEXT NOT NULL,
+				start_date DATE NOT NULL,
+				end_date DATE,
+				active BOOLEAN NOT NULL DEFAULT true,
+				generated_until DATE,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE recurring_payment_tags (
+				recurring_payment_id TEXT,
+				tag_id TEXT,
+				PRIMARY KEY (recurring_payment_id, tag_id),
+				FOREIGN KEY (recurring_payment_id) REFERENCES recurring_payments(id) ON DELETE CASCADE,
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)`,
+			`CREATE TABLE recurring_payment_exceptions (
+				recurring_payment_id TEXT NOT NULL,
+				occurrence_date DATE NOT NULL,
+				action TEXT NOT NULL CHECK (action IN ('skip', 'override')),
+				info TEXT,
+				amount_minor INTEGER,
+				date_paid DATE,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (recurring_payment_id, occurrence_date),
+				FOREIGN KEY (recurring_payment_id) REFERENCES recurring_payments(id) ON DELETE CASCADE
+			)`,
+			`ALTER TABLE payments ADD COLUMN recurring_payment_id TEXT`,
+			`ALTER TABLE payments ADD COLUMN occurrence_date DATE`,
+			`CREATE UNIQUE INDEX idx_payments_occurrence ON payments(recurring_payment_id, occurrence_date)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_occurrence`,
+			`ALTER TABLE payments DROP COLUMN occurrence_date`,
+			`ALTER TABLE payments DROP COLUMN recurring_payment_id`,
+			`DROP TABLE IF EXISTS recurring_payment_exceptions`,
+			`DROP TABLE IF EXISTS recurring_payment_tags`,
+			`DROP TABLE IF EXISTS recurring_payments`,
+		),
+	},
+	{
+		Version: 7,
+		Name:    "create_budgets",
+		Up: execAll(
+			`CREATE TABLE budgets (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				amount_minor INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				period TEXT NOT NULL CHECK (period IN ('monthly', 'quarterly', 'yearly')),
+				rollover BOOLEAN NOT NULL DEFAULT false,
+				start_date DATE NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE budget_tags (
+				budget_id TEXT,
+				tag_id TEXT,
+				PRIMARY KEY (budget_id, tag_id),
+				FOREIGN KEY (budget_id) REFERENCES budgets(id) ON DELETE CASCADE,
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS budget_tags`,
+			`DROP TABLE IF EXISTS budgets`,
+		),
+	},
+	{
+		Version: 8,
+		Name:    "add_payment_fingerprints",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(
+				`ALTER TABLE payments ADD COLUMN fingerprint TEXT`,
+				`ALTER TABLE payments ADD COLUMN duplicate_of TEXT`,
+				`CREATE INDEX idx_payments_fingerprint ON payments(fingerprint)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			return backfillFingerprints(tx)
+		},
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_fingerprint`,
+			`ALTER TABLE payments DROP COLUMN duplicate_of`,
+			`ALTER TABLE payments DROP COLUMN fingerprint`,
+		),
+	},
+	{
+		Version: 9,
+		Name:    "add_payment_external_ids",
+		Up: execAll(
+			`ALTER TABLE payments ADD COLUMN external_id TEXT`,
+			`CREATE UNIQUE INDEX idx_payments_external_id ON payments(external_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_external_id`,
+			`ALTER TABLE payments DROP COLUMN external_id`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "create_users",
+		Up: execAll(
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+				name TEXT NOT NULL,
+				password_hash TEXT NOT NULL,
+				is_admin BOOLEAN NOT NULL DEFAULT false,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				expires_at DATETIME NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_sessions_user ON sessions(user_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS sessions`,
+			`DROP TABLE IF EXISTS users`,
+		),
+	},
+	{
+		// Data is owned by a workspace. Existing installs get a single
+		// workspace holding all their data, with every user as a member.
+		Version: 11,
+		Name:    "create_workspaces",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(
+				`CREATE TABLE workspaces (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				)`,
+				`CREATE TABLE workspace_members (
+					workspace_id TEXT NOT NULL,
+					user_id TEXT NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (workspace_id, user_id),
+					FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				)`,
+				`CREATE INDEX idx_workspace_members_user ON workspace_members(user_id)`,
+				`ALTER TABLE sessions ADD COLUMN workspace_id TEXT`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			for _, table := range workspaceTables {
+				err := execAll(
+					fmt.Sprintf("ALTER TABLE %s ADD COLUMN workspace_id TEXT", table),
+					fmt.Sprintf("CREATE INDEX idx_%s_workspace ON %s(workspace_id)", table, table),
+				)(tx)
+				if err != nil {
+					return err
+				}
+			}
+			// Bank transaction IDs only need to be unique within a workspace
+			err = execAll(
+				`DROP INDEX idx_payments_external_id`,
+				`CREATE UNIQUE INDEX idx_payments_external_id ON payments(workspace_id, external_id)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			return backfillWorkspace(tx)
+		},
+		Down: func(tx *sql.Tx) error {
+			err := execAll(
+				`DROP INDEX IF EXISTS idx_payments_external_id`,
+				`CREATE UNIQUE INDEX idx_payments_external_id ON payments(external_id)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			for _, table := range workspaceTables {
+				err := execAll(
+					fmt.Sprintf("DROP INDEX IF EXISTS idx_%s_workspace", table),
+					fmt.Sprintf("ALTER TABLE %s DROP COLUMN workspace_id", table),
+				)(tx)
+				if err != nil {
+					return err
+				}
+			}
+			return execAll(
+				`ALTER TABLE sessions DROP COLUMN workspace_id`,
+				`DROP TABLE IF EXISTS workspace_members`,
+				`DROP TABLE IF EXISTS workspaces`,
+			)(tx)
+		},
+	},
+	{
+		// Members of existing workspaces keep full access as owners
+		Version: 12,
+		Name:    "add_workspace_roles",
+		Up: execAll(
+			`ALTER TABLE workspace_members ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer'`,
+			`UPDATE workspace_members SET role = 'owner'`,
+		),
+		Down: execAll(
+			`ALTER TABLE workspace_members DROP COLUMN role`,
+		),
+	},
+	{
+		Version: 13,
+		Name:    "create_api_tokens",
+		Up: execAll(
+			`CREATE TABLE api_tokens (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				workspace_id TEXT,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				expires_at DATETIME,
+				last_used_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_api_tokens_user ON api_tokens(user_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS api_tokens`,
+		),
+	},
+	{
+		Version: 14,
+		Name:    "create_audit_log",
+		Up: execAll(
+			`CREATE TABLE audit_log (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL,
+				actor_id TEXT,
+				entity_type TEXT NOT NULL,
+				entity_id TEXT NOT NULL,
+				action TEXT NOT NULL,
+				changes TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_audit_log_workspace ON audit_log(workspace_id, created_at)`,
+			`CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS audit_log`,
+		),
+	},
+	{
+		// Deleted payments and documents stay in the trash until purged
+		Version: 15,
+		Name:    "add_soft_delete",
+		Up: execAll(
+			`ALTER TABLE payments ADD COLUMN deleted_at DATETIME`,
+			`ALTER TABLE documents ADD COLUMN deleted_at DATETIME`,
+			`CREATE INDEX idx_payments_deleted_at ON payments(deleted_at)`,
+			`CREATE INDEX idx_documents_deleted_at ON documents(deleted_at)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_documents_deleted_at`,
+			`DROP INDEX IF EXISTS idx_payments_deleted_at`,
+			`ALTER TABLE documents DROP COLUMN deleted_at`,
+			`ALTER TABLE payments DROP COLUMN deleted_at`,
+		),
+	},
+	{
+		// File paths become storage keys relative to the storage root, so
+		// they mean the same thing on disk and in a bucket
+		Version: 16,
+		Name:    "storage_keys",
+		Up: execAll(
+			`UPDATE documents SET file_path = substr(file_path, 9) WHERE file_path LIKE 'storage/%'`,
+			`UPDATE payments SET invoice_path = substr(invoice_path, 9) WHERE invoice_path LIKE 'storage/%'`,
+		),
+		Down: execAll(
+			`UPDATE documents SET file_path = 'storage/' || file_path WHERE file_path NOT LIKE 'storage/%'`,
+			`UPDATE payments SET invoice_path = 'storage/' || invoice_path WHERE invoice_path != '' AND invoice_path NOT LIKE 'storage/%'`,
+		),
+	},
+	{
+		// Uploads are stored once per SHA-256 hash and shared by every
+		// document and invoice with the same content. Existing files are
+		// moved into blobs by the server on startup.
+		Version: 17,
+		Name:    "create_blobs",
+		Up: execAll(
+			`CREATE TABLE blobs (
+				hash TEXT PRIMARY KEY,
+				storage_key TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				ref_count INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`ALTER TABLE documents ADD COLUMN file_hash TEXT`,
+			`ALTER TABLE payments ADD COLUMN invoice_hash TEXT`,
+			`CREATE INDEX idx_documents_file_hash ON documents(file_hash)`,
+			`CREATE INDEX idx_payments_invoice_hash ON payments(invoice_hash)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_invoice_hash`,
+			`DROP INDEX IF EXISTS idx_documents_file_hash`,
+			`ALTER TABLE payments DROP COLUMN invoice_hash`,
+			`ALTER TABLE documents DROP COLUMN file_hash`,
+			`DROP TABLE IF EXISTS blobs`,
+		),
+	},
+	{
+		Version: 18,
+		Name:    "add_file_content_types",
+		Up: execAll(
+			`ALTER TABLE documents ADD COLUMN content_type TEXT`,
+			`ALTER TABLE payments ADD COLUMN invoice_content_type TEXT`,
+		),
+		Down: execAll(
+			`ALTER TABLE payments DROP COLUMN invoice_content_type`,
+			`ALTER TABLE documents DROP COLUMN content_type`,
+		),
+	},
+	{
+		Version: 19,
+		Name:    "create_payment_attachments",
+		Up: execAll(
+			`CREATE TABLE payment_attachments (
+				id TEXT PRIMARY KEY,
+				payment_id TEXT NOT NULL,
+				kind TEXT NOT NULL CHECK (kind IN ('invoice', 'receipt', 'other')),
+				file_path TEXT NOT NULL,
+				file_hash TEXT,
+				original_name TEXT NOT NULL,
+				file_size INTEGER NOT NULL,
+				content_type TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_payment_attachments_payment ON payment_attachments(payment_id)`,
+			`CREATE INDEX idx_payment_attachments_file_hash ON payment_attachments(file_hash)`,
+			// The uploaded name of existing invoices wasn't kept, so they are
+			// named after the last segment of their storage key
+			`INSERT INTO payment_attachments (id, payment_id, kind, file_path, file_hash, original_name, file_size, content_type, created_at)
+			SELECT lower(hex(randomblob(16))), p.id, 'invoice', p.invoice_path, p.invoice_hash,
+				replace(p.invoice_path, rtrim(p.invoice_path, replace(p.invoice_path, '/', '')), ''),
+				COALESCE(b.size, 0), p.invoice_content_type, COALESCE(p.updated_at, p.created_at)
+			FROM payments p
+			LEFT JOIN blobs b ON b.hash = p.invoice_hash
+			WHERE COALESCE(p.invoice_path, '') != ''`,
+			`DROP INDEX IF EXISTS idx_payments_invoice_hash`,
+			`ALTER TABLE payments DROP COLUMN invoice_content_type`,
+			`ALTER TABLE payments DROP COLUMN invoice_hash`,
+			`ALTER TABLE payments DROP COLUMN invoice_path`,
+		),
+		// Each payment gets back its latest invoice, or its latest attachment
+		// if it has no invoice. The files of other attachments stay stored.
+		Down: execAll(
+			`ALTER TABLE payments ADD COLUMN invoice_path TEXT`,
+			`ALTER TABLE payments ADD COLUMN invoice_hash TEXT`,
+			`ALTER TABLE payments ADD COLUMN invoice_content_type TEXT`,
+			`CREATE INDEX idx_payments_invoice_hash ON payments(invoice_hash)`,
+			`UPDATE payments SET (invoice_path, invoice_hash, invoice_content_type) = (
+				SELECT a.file_path, a.file_hash, a.content_type
+				FROM payment_attachments a
+				WHERE a.payment_id = payments.id
+				ORDER BY a.kind = 'invoice' DESC, a.created_at DESC
+				LIMIT 1
+			)`,
+			`DROP TABLE IF EXISTS payment_attachments`,
+		),
+	},
+	{
+		Version: 20,
+		Name:    "create_search_index",
+		Up: execAll(
+			`CREATE VIRTUAL TABLE search_index USING fts5(
+				entity_type UNINDEXED,
+				entity_id UNINDEXED,
+				workspace_id UNINDEXED,
+				title,
+				body,
+				file_text,
+				tokenize = 'unicode61 remove_diacritics 2'
+			)`,
+			`INSERT INTO search_index (entity_type, entity_id, workspace_id, title, body, file_text)
+			SELECT 'payment', p.id, p.workspace_id, p.info,
+				COALESCE((SELECT GROUP_CONCAT(original_name, ' ') FROM payment_attachments WHERE payment_id = p.id), ''), ''
+			FROM payments p`,
+			`INSERT INTO search_index (entity_type, entity_id, workspace_id, title, body, file_text)
+			SELECT 'document', d.id, d.workspace_id, d.title,
+				COALESCE(d.description, '') || ' ' || d.original_name, ''
+			FROM documents d`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS search_index`,
+		),
+	},
+	{
+		// Text is extracted from PDF and plain-text blobs in the background;
+		// existing ones are queued by their recorded content type
+		Version: 21,
+		Name:    "add_blob_text",
+		Up: execAll(
+			`ALTER TABLE blobs ADD COLUMN content_type TEXT`,
+			`ALTER TABLE blobs ADD COLUMN text_status TEXT NOT NULL DEFAULT 'unsupported'`,
+			`ALTER TABLE blobs ADD COLUMN text TEXT`,
+			`ALTER TABLE blobs ADD COLUMN text_attempts INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE blobs ADD COLUMN text_error TEXT`,
+			`ALTER TABLE blobs ADD COLUMN text_retry_at DATETIME`,
+			`ALTER TABLE blobs ADD COLUMN text_extracted_at DATETIME`,
+			`UPDATE blobs SET content_type = COALESCE(
+				(SELECT content_type FROM documents WHERE file_hash = blobs.hash AND content_type IS NOT NULL LIMIT 1),
+				(SELECT content_type FROM payment_attachments WHERE file_hash = blobs.hash AND content_type IS NOT NULL LIMIT 1)
+			)`,
+			`UPDATE blobs SET text_status = 'pending' WHERE content_type IN ('application/pdf', 'text/plain')`,
+			`CREATE INDEX idx_blobs_text_status ON blobs(text_status)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_blobs_text_status`,
+			`ALTER TABLE blobs DROP COLUMN text_extracted_at`,
+			`ALTER TABLE blobs DROP COLUMN text_retry_at`,
+			`ALTER TABLE blobs DROP COLUMN text_error`,
+			`ALTER TABLE blobs DROP COLUMN text_attempts`,
+			`ALTER TABLE blobs DROP COLUMN text`,
+			`ALTER TABLE blobs DROP COLUMN text_status`,
+			`ALTER TABLE blobs DROP COLUMN content_type`,
+		),
+	},
+	{
+		Version: 22,
+		Name:    "create_invoice_drafts",
+		Up: execAll(
+			`CREATE TABLE invoice_drafts (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				file_path TEXT NOT NULL,
+				file_hash TEXT NOT NULL,
+				original_name TEXT NOT NULL,
+				file_size INTEGER NOT NULL,
+				content_type TEXT NOT NULL,
+				info TEXT NOT NULL DEFAULT '',
+				amount TEXT NOT NULL DEFAULT '',
+				currency TEXT NOT NULL DEFAULT '',
+				date_paid TEXT NOT NULL DEFAULT '',
+				sources TEXT NOT NULL DEFAULT '{}',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX idx_invoice_drafts_workspace ON invoice_drafts(workspace_id)`,
+			`CREATE INDEX idx_invoice_drafts_created_at ON invoice_drafts(created_at)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS invoice_drafts`,
+		),
+	},
+	{
+		Version: 23,
+		Name:    "create_tag_rules",
+		Up: execAll(
+			`CREATE TABLE tag_rules (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				active BOOLEAN NOT NULL DEFAULT true,
+				info_contains TEXT NOT NULL DEFAULT '',
+				info_pattern TEXT NOT NULL DEFAULT '',
+				min_amount TEXT NOT NULL DEFAULT '',
+				max_amount TEXT NOT NULL DEFAULT '',
+				currency TEXT NOT NULL DEFAULT '',
+				weekdays TEXT NOT NULL DEFAULT '',
+				set_fully_paid BOOLEAN,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE tag_rule_tags (
+				rule_id TEXT,
+				tag_id TEXT,
+				role TEXT NOT NULL CHECK (role IN ('has', 'lacks', 'add', 'remove')),
+				PRIMARY KEY (rule_id, tag_id, role),
+				FOREIGN KEY (rule_id) REFERENCES tag_rules(id) ON DELETE CASCADE,
+				FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX idx_tag_rules_workspace ON tag_rules(workspace_id, position)`,
+			`CREATE INDEX idx_tag_rule_tags_tag ON tag_rule_tags(tag_id)`,
+		),
+		Down: execAll(
+			`DROP TABLE IF EXISTS tag_rule_tags`,
+			`DROP TABLE IF EXISTS tag_rules`,
+		),
+	},
+	{
+		Version: 24,
+		Name:    "create_vendors",
+		Up: execAll(
+			`CREATE TABLE vendors (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE vendor_aliases (
+				vendor_id TEXT NOT NULL REFERENCES vendors(id) ON DELETE CASCADE,
+				alias TEXT NOT NULL,
+				PRIMARY KEY (vendor_id, alias)
+			)`,
+			`ALTER TABLE payments ADD COLUMN vendor_id TEXT`,
+			`CREATE INDEX idx_vendors_workspace ON vendors(workspace_id)`,
+			`CREATE INDEX idx_payments_vendor ON payments(vendor_id)`,
+		),
+		Down: execAll(
+			`DROP INDEX IF EXISTS idx_payments_vendor`,
+			`ALTER TABLE payments DROP COLUMN vendor_id`,
+			`DROP TABLE IF EXISTS vendor_aliases`,
+			`DROP TABLE IF EXISTS vendors`,
+		),
+	},
+}
+
+// workspaceTables are the tables whose rows belong to a workspace
+var workspaceTables = []string{"payments", "documents", "tags", "recurring_payments", "budgets"}
+
+// LatestVersion returns the highest migration version known to this binary
+func LatestVersion() int {
+	latest := 0
+	for _, m := range migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	return latest
+}
+
+// Migrate applies every pending migration in order. It refuses to touch a
+// database whose schema version is newer than LatestVersion.
+func Migrate(db *sql.DB) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d",
+			ErrSchemaTooNew, current, LatestVersion())
+	}
+
+	for _, m := range sortedMigrations() {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, m, true); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// Rollback reverts the most recent migrations down to (but not including)
+// the target version.
+func Rollback(db *sql.DB, target int) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d",
+			ErrSchemaTooNew, current, LatestVersion())
+	}
+
+	sorted := sortedMigrations()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		m := sorted[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		if m.Down == nil {
+			return fmt.Errorf("migration %d_%s cannot be reverted", m.Version, m.Name)
+		}
+		if err := applyMigration(db, m, false); err != nil {
+			return err
+		}
+		log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// CurrentVersion returns the highest applied migration version, or 0 for a
+// fresh database.
+func CurrentVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// AppliedMigrations lists the migrations recorded in schema_migrations
+func AppliedMigrations(db *sql.DB) ([]AppliedMigration, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make([]AppliedMigration, 0)
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+func applyMigration(db *sql.DB, m Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now()); err != nil {
+			return err
+		}
+	} else {
+		if err := m.Down(tx); err != nil {
+			return fmt.Errorf("reverting migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// execAll returns a migration step that runs each statement in order
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// backfillFingerprints computes the duplicate-detection fingerprint of
+// existing payments
+func backfillFingerprints(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, info, amount_minor, currency FROM payments")
+	if err != nil {
+		return err
+	}
+
+	fingerprints := make(map[string]string)
+	for rows.Next() {
+		var id, info, currency string
+		var minor int64
+		if err := rows.Scan(&id, &info, &minor, &currency); err != nil {
+			rows.Close()
+			return err
+		}
+		fingerprints[id] = models.Fingerprint(info, minor, currency)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, fingerprint := range fingerprints {
+		if _, err := tx.Exec("UPDATE payments SET fingerprint = ? WHERE id = ?", fingerprint, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillWorkspace moves the data and users of an existing install into a
+// "Default" workspace. Fresh databases are left without one.
+func backfillWorkspace(tx *sql.Tx) error {
+	var count int
+	query := "SELECT (SELECT COUNT(*) FROM users)"
+	for _, table := range workspaceTables {
+		query += " + (SELECT COUNT(*) FROM " + table + ")"
+	}
+	if err := tx.QueryRow(query).Scan(&count); err != nil || count == 0 {
+		return err
+	}
+
+	id := uuid.New().String()
+	now := time.Now()
+	_, err := tx.Exec("INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (?, 'Default', ?, ?)", id, now, now)
+	if err != nil {
+		return err
+	}
+	for _, table := range workspaceTables {
+		if _, err := tx.Exec("UPDATE "+table+" SET workspace_id = ?", id); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO workspace_members (workspace_id, user_id, created_at) SELECT ?, id, ? FROM users", id, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE sessions SET workspace_id = ?", id)
+	return err
+}
+
+// addColumnIfMissing adds a column unless the table already has it
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func pow10(exp int) int64 {
+	n := int64(1)
+	for i := 0; i < exp; i++ {
+		n *= 10
+	}
+	return n
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
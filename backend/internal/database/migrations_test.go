@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/models"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema is the schema created by createTables before migrations
+// existed
+const baselineSchema = `
+	CREATE TABLE tags (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE payments (
+		id TEXT PRIMARY KEY,
+		info TEXT NOT NULL,
+		amount REAL NOT NULL,
+		date_paid DATE NOT NULL,
+		fully_paid BOOLEAN DEFAULT false,
+		invoice_path TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE payment_tags (
+		payment_id TEXT,
+		tag_id TEXT,
+		PRIMARY KEY (payment_id, tag_id),
+		FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+	CREATE TABLE documents (
+		id TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		description TEXT,
+		file_path TEXT NOT NULL,
+		original_name TEXT NOT NULL,
+		file_size INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE document_tags (
+		document_id TEXT,
+		tag_id TEXT,
+		PRIMARY KEY (document_id, tag_id),
+		FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+	CREATE INDEX idx_payments_date ON payments(date_paid);
+	CREATE INDEX idx_tags_name ON tags(name);
+	CREATE INDEX idx_documents_title ON documents(title);
+
+	INSERT INTO tags (id, name, color) VALUES ('t1', 'Rent', '#ff0000');
+	INSERT INTO payments (id, info, amount, date_paid, fully_paid, invoice_path)
+		VALUES ('p1', 'Rent March', 19.99, '2024-03-01', true, 'storage/invoices/march.pdf');
+	INSERT INTO payments (id, info, amount, date_paid, fully_paid, invoice_path)
+		VALUES ('p2', 'Refund', -5.5, '2024-03-02', false, '');
+	INSERT INTO payment_tags (payment_id, tag_id) VALUES ('p1', 't1');
+	INSERT INTO documents (id, title, file_path, original_name, file_size)
+		VALUES ('d1', 'Lease', 'storage/documents/lease.pdf', 'lease.pdf', 1234);
+`
+
+// openTestDB opens an empty database in a temporary directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openBaselineDB opens a database as left by the release before
+// migrations, with a little data in it
+func openBaselineDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	db := openBaselineDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := CurrentVersion(db); err != nil || version != LatestVersion() {
+		t.Fatalf("CurrentVersion = %d, %v, want %d", version, err, LatestVersion())
+	}
+
+	// 3: REAL amounts become rounded minor units in the default currency
+	for _, want := range []struct {
+		id    string
+		minor int64
+	}{
+		{"p1", 1999},
+		{"p2", -550},
+	} {
+		var minor int64
+		var currency, fingerprint string
+		err := db.QueryRow("SELECT amount_minor, currency, fingerprint FROM payments WHERE id = ?", want.id).
+			Scan(&minor, &currency, &fingerprint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if minor != want.minor || currency != models.DefaultCurrency {
+			t.Errorf("%s: amount %d %s, want %d %s", want.id, minor, currency, want.minor, models.DefaultCurrency)
+		}
+		// 8: fingerprints are backfilled
+		var info string
+		if err := db.QueryRow("SELECT info FROM payments WHERE id = ?", want.id).Scan(&info); err != nil {
+			t.Fatal(err)
+		}
+		if fingerprint != models.Fingerprint(info, minor, currency) {
+			t.Errorf("%s: fingerprint %q was not backfilled", want.id, fingerprint)
+		}
+	}
+
+	// 5: payments marked as paid get a transaction for the full amount
+	var paid int64
+	if err := db.QueryRow("SELECT COALESCE(SUM(amount_minor), 0) FROM payment_transactions WHERE payment_id = 'p1'").Scan(&paid); err != nil {
+		t.Fatal(err)
+	}
+	if paid != 1999 {
+		t.Errorf("p1 paid %d, want 1999", paid)
+	}
+
+	// 11: everything moves into a single Default workspace
+	var workspaceID string
+	if err := db.QueryRow("SELECT id FROM workspaces WHERE name = 'Default'").Scan(&workspaceID); err != nil {
+		t.Fatalf("default workspace: %v", err)
+	}
+	for _, table := range workspaceTables {
+		var orphans int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE workspace_id IS NOT ?", workspaceID).Scan(&orphans); err != nil {
+			t.Fatal(err)
+		}
+		if orphans != 0 {
+			t.Errorf("%d %s outside the default workspace", orphans, table)
+		}
+	}
+
+	// 16 and 19: invoices become attachments named after their storage key
+	var kind, path, name string
+	var size int64
+	err := db.QueryRow("SELECT kind, file_path, original_name, file_size FROM payment_attachments WHERE payment_id = 'p1'").
+		Scan(&kind, &path, &name, &size)
+	if err != nil {
+		t.Fatalf("p1 attachment: %v", err)
+	}
+	if kind != "invoice" || path != "invoices/march.pdf" || name != "march.pdf" {
+		t.Errorf("p1 attachment = %s %s %s", kind, path, name)
+	}
+	var attachments int
+	if err := db.QueryRow("SELECT COUNT(*) FROM payment_attachments WHERE payment_id = 'p2'").Scan(&attachments); err != nil {
+		t.Fatal(err)
+	}
+	if attachments != 0 {
+		t.Errorf("p2 without an invoice got %d attachments", attachments)
+	}
+	if err := db.QueryRow("SELECT file_path FROM documents WHERE id = 'd1'").Scan(&path); err != nil {
+		t.Fatal(err)
+	}
+	if path != "documents/lease.pdf" {
+		t.Errorf("d1 file_path = %q, want documents/lease.pdf", path)
+	}
+}
+
+func TestMigrateFreshDatabaseHasNoWorkspace(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var workspaces int
+	if err := db.QueryRow("SELECT COUNT(*) FROM workspaces").Scan(&workspaces); err != nil {
+		t.Fatal(err)
+	}
+	if workspaces != 0 {
+		t.Errorf("fresh database has %d workspaces, want 0", workspaces)
+	}
+}
+
+func TestRollbackRoundTrip(t *testing.T) {
+	db := openBaselineDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Down to the first migration the baseline schema maps onto
+	if err := Rollback(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := CurrentVersion(db); err != nil || version != 2 {
+		t.Fatalf("CurrentVersion after Rollback = %d, %v, want 2", version, err)
+	}
+	for _, want := range []struct {
+		id          string
+		amount      float64
+		invoicePath string
+	}{
+		{"p1", 19.99, "storage/invoices/march.pdf"},
+		{"p2", -5.5, ""},
+	} {
+		var amount float64
+		var invoicePath sql.NullString
+		if err := db.QueryRow("SELECT amount, invoice_path FROM payments WHERE id = ?", want.id).Scan(&amount, &invoicePath); err != nil {
+			t.Fatal(err)
+		}
+		if amount != want.amount || invoicePath.String != want.invoicePath {
+			t.Errorf("%s after Rollback = %v %q, want %v %q", want.id, amount, invoicePath.String, want.amount, want.invoicePath)
+		}
+	}
+
+	// And back up again
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var minor int64
+	if err := db.QueryRow("SELECT amount_minor FROM payments WHERE id = 'p1'").Scan(&minor); err != nil {
+		t.Fatal(err)
+	}
+	if minor != 1999 {
+		t.Errorf("p1 after round trip = %d, want 1999", minor)
+	}
+
+	// All the way down leaves only the bookkeeping table
+	if err := Rollback(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	if len(tables) != 1 || tables[0] != "schema_migrations" {
+		t.Errorf("tables after full Rollback = %v", tables)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future')", LatestVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate = %v, want ErrSchemaTooNew", err)
+	}
+	if err := Rollback(db, 0); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Rollback = %v, want ErrSchemaTooNew", err)
+	}
+}
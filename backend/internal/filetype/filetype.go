@@ -0,0 +1,151 @@
+// Package filetype detects the type of uploaded files from their content
+// and decides which types may be uploaded.
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Types accepted for upload
+const (
+	PDF  = "application/pdf"
+	PNG  = "image/png"
+	JPEG = "image/jpeg"
+	HEIC = "image/heic"
+	Text = "text/plain"
+	DOC  = "application/msword"
+	XLS  = "application/vnd.ms-excel"
+	PPT  = "application/vnd.ms-powerpoint"
+	DOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	XLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	PPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	ODT  = "application/vnd.oasis.opendocument.text"
+	ODS  = "application/vnd.oasis.opendocument.spreadsheet"
+)
+
+// extensions maps each allowed type to the extension files of that type are
+// stored with
+var extensions = map[string]string{
+	PDF:  ".pdf",
+	PNG:  ".png",
+	JPEG: ".jpg",
+	HEIC: ".heic",
+	Text: ".txt",
+	DOC:  ".doc",
+	XLS:  ".xls",
+	PPT:  ".ppt",
+	DOCX: ".docx",
+	XLSX: ".xlsx",
+	PPTX: ".pptx",
+	ODT:  ".odt",
+	ODS:  ".ods",
+}
+
+// Allowed reports whether files of a detected type may be uploaded
+func Allowed(contentType string) bool {
+	_, ok := extensions[contentType]
+	return ok
+}
+
+// Extension returns the extension for an allowed type, or "" for others
+func Extension(contentType string) string {
+	return extensions[contentType]
+}
+
+var (
+	oleSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+	zipSignature = []byte("PK\x03\x04")
+)
+
+// heicBrands are the ISO base media file brands used by HEIC/HEIF images
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// Detect returns the type of the size bytes readable from r, judged by
+// their content. The file name is only consulted to tell apart the legacy
+// Office formats, which share one container format.
+func Detect(r io.ReaderAt, size int64, filename string) (string, error) {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return PDF, nil
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && heicBrands[string(head[8:12])]:
+		return HEIC, nil
+	case bytes.HasPrefix(head, oleSignature):
+		return detectOLE(filename), nil
+	case bytes.HasPrefix(head, zipSignature):
+		return detectZip(r, size)
+	}
+
+	contentType := http.DetectContentType(head)
+	if strings.HasPrefix(contentType, "text/plain") {
+		return Text, nil
+	}
+	return strings.TrimSpace(strings.Split(contentType, ";")[0]), nil
+}
+
+// detectOLE names the Office 97-2003 format by extension
+func detectOLE(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".doc":
+		return DOC
+	case ".xls":
+		return XLS
+	case ".ppt":
+		return PPT
+	}
+	return "application/x-ole-storage"
+}
+
+// detectZip recognises Office Open XML and OpenDocument files by the
+// entries of the archive
+func detectZip(r io.ReaderAt, size int64) (string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return "application/zip", nil
+	}
+	for _, f := range archive.File {
+		switch f.Name {
+		case "word/document.xml":
+			return DOCX, nil
+		case "xl/workbook.xml":
+			return XLSX, nil
+		case "ppt/presentation.xml":
+			return PPTX, nil
+		case "mimetype":
+			mimetype, err := readSmall(f)
+			if err != nil {
+				return "", err
+			}
+			switch mimetype {
+			case ODT, ODS:
+				return mimetype, nil
+			}
+		}
+	}
+	return "application/zip", nil
+}
+
+// readSmall reads an OpenDocument mimetype entry, which is a few dozen bytes
+func readSmall(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, 256))
+	return strings.TrimSpace(string(data)), err
+}
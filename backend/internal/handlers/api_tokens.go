@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var errTokenExpiry = errors.New("expiresAt must be in the future")
+
+type APITokenHandler struct {
+	db *sql.DB
+}
+
+func NewAPITokenHandler(db *sql.DB) *APITokenHandler {
+	return &APITokenHandler{db: db}
+}
+
+// RegisterRoutes registers the routes for managing the user's API tokens.
+// They need a session; an API token cannot create or revoke tokens.
+func (h *APITokenHandler) RegisterRoutes(router *gin.RouterGroup) {
+	tokens := router.Group("/auth/tokens", requireSession())
+	{
+		tokens.GET("", h.ListTokens)
+		tokens.POST("", h.CreateToken)
+		tokens.DELETE("/:id", h.RevokeToken)
+	}
+}
+
+const apiTokenColumns = `t.id, t.name, t.scopes, COALESCE(t.workspace_id, ''), t.expires_at, t.last_used_at, t.created_at`
+
+func scanAPIToken(row rowScanner, t *models.APIToken) error {
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.Name, &scopes, &t.WorkspaceID, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	t.Scopes = utils.SplitCommaString(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return nil
+}
+
+// ListTokens returns the user's API tokens. The token values themselves
+// are only shown once, when created.
+func (h *APITokenHandler) ListTokens(c *gin.Context) {
+	user, _ := currentUser(c)
+
+	rows, err := h.db.Query(`
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens t
+		WHERE t.user_id = ?
+		ORDER BY t.created_at
+	`, user.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch API tokens")
+		return
+	}
+	defer rows.Close()
+
+	tokens := make([]models.APIToken, 0)
+	for rows.Next() {
+		var t models.APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan API token")
+			return
+		}
+		tokens = append(tokens, t)
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateToken creates an API token acting in the session's current
+// workspace. The token is returned in the response and cannot be shown
+// again.
+func (h *APITokenHandler) CreateToken(c *gin.Context) {
+	user, _ := currentUser(c)
+
+	var token models.APIToken
+	if err := c.ShouldBindJSON(&token); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid API token data")
+		return
+	}
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("name must not be empty"), "Invalid API token data")
+		return
+	}
+	if err := auth.ValidateScopes(token.Scopes); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid API token data")
+		return
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now()) {
+		utils.RespondWithError(c, http.StatusBadRequest, errTokenExpiry, "Invalid API token data")
+		return
+	}
+
+	secret, err := auth.NewAPIToken()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create API token")
+		return
+	}
+	token.ID = uuid.New().String()
+	token.WorkspaceID = workspaceID(c)
+	token.LastUsedAt = nil
+	token.CreatedAt = time.Now()
+
+	workspace := sql.NullString{String: token.WorkspaceID, Valid: token.WorkspaceID != ""}
+	_, err = h.db.Exec(`
+		INSERT INTO api_tokens (id, user_id, workspace_id, name, token_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, user.ID, workspace, token.Name, auth.HashToken(secret), strings.Join(token.Scopes, ","), token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create API token")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":    secret,
+		"apiToken": token,
+	})
+}
+
+// RevokeToken deletes one of the user's API tokens
+func (h *APITokenHandler) RevokeToken(c *gin.Context) {
+	user, _ := currentUser(c)
+
+	result, err := h.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", c.Param("id"), user.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to revoke API token")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "API token not found")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AuditHandler struct {
+	db *sql.DB
+}
+
+func NewAuditHandler(db *sql.DB) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// RegisterRoutes registers the audit log routes
+func (h *AuditHandler) RegisterRoutes(router *gin.RouterGroup) {
+	auditLog := router.Group("/audit", requireScope("audit"))
+	{
+		auditLog.GET("", requirePermission(auth.PermRead), h.ListAudit)
+	}
+}
+
+// ListAudit returns the workspace's audit log, newest first, filtered by
+// the entity_type, entity_id, actor, action, start_date and end_date query
+// parameters
+func (h *AuditHandler) ListAudit(c *gin.Context) {
+	params := []interface{}{workspaceID(c)}
+	whereClause := []string{"a.workspace_id = ?"}
+
+	for _, filter := range []struct{ param, column string }{
+		{"entity_type", "a.entity_type"},
+		{"entity_id", "a.entity_id"},
+		{"actor", "a.actor_id"},
+		{"action", "a.action"},
+	} {
+		if value := c.Query(filter.param); value != "" {
+			whereClause = append(whereClause, filter.column+" = ?")
+			params = append(params, value)
+		}
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		whereClause = append(whereClause, "substr(a.created_at, 1, 10) >= ?")
+		params = append(params, startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		whereClause = append(whereClause, "substr(a.created_at, 1, 10) <= ?")
+		params = append(params, endDate)
+	}
+
+	page := utils.ParseIntWithDefault(c.Query("page"), 1)
+	limit := utils.ParseIntWithDefault(c.Query("limit"), 50)
+
+	entries, err := queryAudit(h.db, whereClause, params, limit, (page-1)*limit)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch audit log")
+		return
+	}
+
+	var total int
+	err = h.db.QueryRow("SELECT COUNT(*) FROM audit_log a WHERE "+utils.JoinWithAND(whereClause), params...).Scan(&total)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get total count")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+		"results": entries,
+	})
+}
+
+// queryAudit loads audit entries matching whereClause, newest first. A
+// negative limit returns them all.
+func queryAudit(db *sql.DB, whereClause []string, params []interface{}, limit, offset int) ([]models.AuditEntry, error) {
+	rows, err := db.Query(`
+		SELECT a.id, COALESCE(a.actor_id, ''), COALESCE(u.name, ''), a.entity_type, a.entity_id,
+			a.action, a.changes, a.created_at
+		FROM audit_log a
+		LEFT JOIN users u ON u.id = a.actor_id
+		WHERE `+utils.JoinWithAND(whereClause)+`
+		ORDER BY a.created_at DESC
+		LIMIT ? OFFSET ?
+	`, append(params, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]models.AuditEntry, 0)
+	for rows.Next() {
+		var e models.AuditEntry
+		var changes string
+		err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.EntityType, &e.EntityID, &e.Action, &changes, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Changes = json.RawMessage(changes)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// auditor writes audit entries for changes made by a user, or by the
+// server itself when actorID is empty, in a workspace
+type auditor struct {
+	workspaceID string
+	actorID     string
+}
+
+// auditorFor returns an auditor for the request's user and workspace
+func auditorFor(c *gin.Context) auditor {
+	user, _ := currentUser(c)
+	return auditor{workspaceID: workspaceID(c), actorID: user.ID}
+}
+
+// record writes an audit entry with the fields that differ between before
+// and after. It should run in the same transaction as the change. Updates
+// that change nothing are not recorded.
+func (a auditor) record(db execer, entityType, entityID, action string, before, after interface{}) error {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && action == audit.ActionUpdate {
+		return nil
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO audit_log (id, workspace_id, actor_id, entity_type, entity_id, action, changes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), a.workspaceID, sql.NullString{String: a.actorID, Valid: a.actorID != ""},
+		entityType, entityID, action, string(data), time.Now())
+	return err
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestListAudit(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestWorkspace(t, db, "other")
+
+	router := newTestRouter(workspaceID, auth.RoleEditor)
+	NewTagHandler(db).RegisterRoutes(router.Group(""))
+	NewAuditHandler(db).RegisterRoutes(router.Group(""))
+	otherRouter := newTestRouter("other", auth.RoleEditor)
+	NewTagHandler(db).RegisterRoutes(otherRouter.Group(""))
+
+	w := serve(router, http.MethodPost, "/tags", `{"name": "Rent", "color": "#ff0000"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create tag = %d: %s", w.Code, w.Body)
+	}
+	var tag models.Tag
+	if err := json.Unmarshal(w.Body.Bytes(), &tag); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, http.MethodPut, "/tags/"+tag.ID, `{"name": "Housing", "color": "#ff0000"}`); w.Code != http.StatusOK {
+		t.Fatalf("update tag = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(otherRouter, http.MethodPost, "/tags", `{"name": "Elsewhere", "color": "#000000"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create tag in other workspace = %d: %s", w.Code, w.Body)
+	}
+	_, err := db.Exec(`
+		INSERT INTO audit_log (id, workspace_id, actor_id, entity_type, entity_id, action, changes, created_at)
+		VALUES ('old', 'ws', 'user', 'payment', 'p1', 'delete', '{}', '2024-03-01 12:00:00')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		query   string
+		actions []string
+	}{
+		{"", []string{"update", "create", "delete"}},
+		{"?entity_type=tag", []string{"update", "create"}},
+		{"?entity_id=" + tag.ID + "&action=create", []string{"create"}},
+		{"?start_date=2024-03-01&end_date=2024-03-01", []string{"delete"}},
+		{"?end_date=2024-02-29", nil},
+		{"?limit=1", []string{"update"}},
+	} {
+		w := serve(router, http.MethodGet, "/audit"+tc.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /audit%s = %d: %s", tc.query, w.Code, w.Body)
+		}
+		var resp struct {
+			Total   int                 `json:"total"`
+			Results []models.AuditEntry `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, e := range resp.Results {
+			actions = append(actions, e.Action)
+		}
+		if len(actions) != len(tc.actions) {
+			t.Errorf("GET /audit%s = %v, want %v", tc.query, actions, tc.actions)
+			continue
+		}
+		for i := range actions {
+			if actions[i] != tc.actions[i] {
+				t.Errorf("GET /audit%s = %v, want %v", tc.query, actions, tc.actions)
+				break
+			}
+		}
+	}
+
+	// The update records only the fields that changed
+	w = serve(router, http.MethodGet, "/audit?action=update", "")
+	var resp struct {
+		Total   int                 `json:"total"`
+		Results []models.AuditEntry `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 {
+		t.Fatalf("update entries = %d, want 1", resp.Total)
+	}
+	var changes map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Results[0].Changes, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := changes["name"]; !ok || len(changes) != 1 {
+		t.Errorf("update changes = %s, want only name", resp.Results[0].Changes)
+	}
+	if resp.Results[0].ActorID != "user" {
+		t.Errorf("actor = %q, want user", resp.Results[0].ActorID)
+	}
+}
+
+func TestListAuditNeedsScope(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	router := newTestRouter(workspaceID, auth.RoleOwner, "payments:read")
+	NewAuditHandler(db).RegisterRoutes(router.Group(""))
+
+	if w := serve(router, http.MethodGet, "/audit", ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET /audit with a payments token = %d, want 403", w.Code)
+	}
+}
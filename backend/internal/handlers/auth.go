@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionCookie is the cookie holding a browser's session token
+const sessionCookie = "session"
+
+// currentUserKey is the gin context key holding the authenticated user
+const currentUserKey = "user"
+
+// currentWorkspaceKey is the gin context key holding the ID of the
+// workspace the session has switched to
+const currentWorkspaceKey = "workspace"
+
+// tokenScopesKey is the gin context key holding the scopes of the API
+// token a request authenticated with. It is unset for sessions.
+const tokenScopesKey = "scopes"
+
+// currentRoleKey is the gin context key holding the user's role in the
+// session's workspace
+const currentRoleKey = "role"
+
+var (
+	errUnauthorized       = errors.New("authentication required")
+	errInvalidCredentials = errors.New("invalid email or password")
+	errNoWorkspace        = errors.New("no workspace selected")
+	errForbidden          = errors.New("permission denied")
+	errSessionRequired    = errors.New("session required")
+	errMissingScope       = errors.New("missing API token scope")
+	errAdminRequired      = errors.New("admin required")
+)
+
+type AuthHandler struct {
+	db           *sql.DB
+	sessionTTL   time.Duration
+	secureCookie bool
+}
+
+// NewAuthHandler creates an auth handler issuing sessions valid for
+// sessionTTL. secureCookie marks the session cookie HTTPS-only.
+func NewAuthHandler(db *sql.DB, sessionTTL time.Duration, secureCookie bool) *AuthHandler {
+	return &AuthHandler{db: db, sessionTTL: sessionTTL, secureCookie: secureCookie}
+}
+
+// RegisterRoutes registers the login, logout and current user routes.
+// Login is public; the others require a session.
+func (h *AuthHandler) RegisterRoutes(router *gin.RouterGroup) {
+	authRoutes := router.Group("/auth")
+	{
+		authRoutes.POST("/login", h.Login)
+		authRoutes.POST("/logout", h.RequireAuth(), h.Logout)
+		authRoutes.GET("/me", h.RequireAuth(), h.Me)
+	}
+}
+
+const userColumns = `u.id, u.email, u.name, u.is_admin, u.created_at, u.updated_at`
+
+func scanUser(row rowScanner, u *models.User, extra ...interface{}) error {
+	dest := append([]interface{}{&u.ID, &u.Email, &u.Name, &u.IsAdmin, &u.CreatedAt, &u.UpdatedAt}, extra...)
+	return row.Scan(dest...)
+}
+
+// Login checks an email and password and starts a session. The token is
+// set as an HTTP-only cookie for browsers and returned in the body for
+// clients that send it as a bearer token.
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid login data")
+		return
+	}
+
+	var user models.User
+	var passwordHash string
+	row := h.db.QueryRow("SELECT "+userColumns+", u.password_hash FROM users u WHERE u.email = ?", strings.TrimSpace(req.Email))
+	err := scanUser(row, &user, &passwordHash)
+	if err != nil && err != sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch user")
+		return
+	}
+	if !auth.CheckPassword(passwordHash, req.Password) {
+		utils.RespondWithError(c, http.StatusUnauthorized, errInvalidCredentials, "Login failed")
+		return
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create session")
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(h.sessionTTL)
+
+	// Start in the workspace the user joined first
+	var workspaceID sql.NullString
+	err = h.db.QueryRow(
+		"SELECT workspace_id FROM workspace_members WHERE user_id = ? ORDER BY created_at LIMIT 1",
+		user.ID,
+	).Scan(&workspaceID)
+	if err != nil && err != sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch workspaces")
+		return
+	}
+
+	// Drop this user's expired sessions while we're here
+	_, err = h.db.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at < ?", user.ID, now)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create session")
+		return
+	}
+	_, err = h.db.Exec(
+		"INSERT INTO sessions (id, user_id, workspace_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		auth.HashToken(token), user.ID, workspaceID, now, expiresAt,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create session")
+		return
+	}
+
+	h.setSessionCookie(c, token, int(h.sessionTTL.Seconds()))
+	c.JSON(http.StatusOK, gin.H{
+		"token":       token,
+		"expiresAt":   expiresAt,
+		"user":        user,
+		"workspaceId": workspaceID.String,
+	})
+}
+
+// Logout ends the current session
+func (h *AuthHandler) Logout(c *gin.Context) {
+	_, err := h.db.Exec("DELETE FROM sessions WHERE id = ?", auth.HashToken(requestToken(c)))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to end session")
+		return
+	}
+
+	h.setSessionCookie(c, "", -1)
+	c.Status(http.StatusNoContent)
+}
+
+// Me returns the authenticated user
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, user)
+}
+
+// RequireAuth is middleware rejecting requests without a valid session or
+// API token with 401. The token is read from the session cookie or an
+// "Authorization: Bearer" header. The session's or API token's workspace,
+// and the user's role in it, are only kept while the user is still a
+// member of it.
+func (h *AuthHandler) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if token == "" {
+			utils.RespondWithError(c, http.StatusUnauthorized, errUnauthorized, "Missing session token")
+			c.Abort()
+			return
+		}
+
+		var ok bool
+		if strings.HasPrefix(token, auth.APITokenPrefix) {
+			ok = h.authenticateAPIToken(c, token)
+		} else {
+			ok = h.authenticateSession(c, token)
+		}
+		if !ok {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticateSession looks up a session token, responding with an error
+// and returning false if it is not valid
+func (h *AuthHandler) authenticateSession(c *gin.Context, token string) bool {
+	var user models.User
+	var expiresAt time.Time
+	var workspaceID, role string
+	row := h.db.QueryRow(`
+		SELECT `+userColumns+`, s.expires_at, COALESCE(m.workspace_id, ''), COALESCE(m.role, '')
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		LEFT JOIN workspace_members m ON m.workspace_id = s.workspace_id AND m.user_id = u.id
+		WHERE s.id = ?
+	`, auth.HashToken(token))
+	err := scanUser(row, &user, &expiresAt, &workspaceID, &role)
+	if err == sql.ErrNoRows || (err == nil && time.Now().After(expiresAt)) {
+		utils.RespondWithError(c, http.StatusUnauthorized, errUnauthorized, "Session is invalid or has expired")
+		return false
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check session")
+		return false
+	}
+
+	c.Set(currentUserKey, user)
+	c.Set(currentWorkspaceKey, workspaceID)
+	c.Set(currentRoleKey, auth.Role(role))
+	return true
+}
+
+// authenticateAPIToken looks up an API token, responding with an error and
+// returning false if it is not valid. Its scopes are kept for requireScope.
+func (h *AuthHandler) authenticateAPIToken(c *gin.Context, token string) bool {
+	var user models.User
+	var tokenID, scopes, workspaceID, role string
+	var expiresAt sql.NullTime
+	row := h.db.QueryRow(`
+		SELECT `+userColumns+`, t.id, t.scopes, t.expires_at, COALESCE(m.workspace_id, ''), COALESCE(m.role, '')
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		LEFT JOIN workspace_members m ON m.workspace_id = t.workspace_id AND m.user_id = u.id
+		WHERE t.token_hash = ?
+	`, auth.HashToken(token))
+	err := scanUser(row, &user, &tokenID, &scopes, &expiresAt, &workspaceID, &role)
+	now := time.Now()
+	if err == sql.ErrNoRows || (err == nil && expiresAt.Valid && now.After(expiresAt.Time)) {
+		utils.RespondWithError(c, http.StatusUnauthorized, errUnauthorized, "API token is invalid or has expired")
+		return false
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check API token")
+		return false
+	}
+
+	// Record use at most once a minute rather than writing on every request
+	_, err = h.db.Exec(
+		"UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now, tokenID, now.Add(-time.Minute),
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update API token")
+		return false
+	}
+
+	c.Set(currentUserKey, user)
+	c.Set(currentWorkspaceKey, workspaceID)
+	c.Set(currentRoleKey, auth.Role(role))
+	c.Set(tokenScopesKey, utils.SplitCommaString(scopes))
+	return true
+}
+
+// RequireWorkspace is middleware rejecting requests with 403 unless the
+// session has switched to a workspace. It must run after RequireAuth.
+func (h *AuthHandler) RequireWorkspace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if workspaceID(c) == "" {
+			utils.RespondWithError(c, http.StatusForbidden, errNoWorkspace, "Create or switch to a workspace first")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requirePermission is middleware rejecting requests with 403 unless the
+// user's role in the session's workspace grants perm. Routes declare the
+// permission they need with it in RegisterRoutes.
+func requirePermission(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allow(c, currentRole(c), perm) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireScope is middleware limiting API tokens to the resources their
+// scopes cover, responding with 403 otherwise. GET requests need the read
+// scope and anything else the write scope. Sessions are not limited.
+func requireScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		write := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+		if !scopeAllows(c, resource, write) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireReadScope is like requireScope but needs only the read scope, for
+// POST routes that don't change anything
+func requireReadScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !scopeAllows(c, resource, false) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// scopeAllows responds with 403 and returns false when the request's API
+// token lacks the read or write scope for a resource
+func scopeAllows(c *gin.Context, resource string, write bool) bool {
+	scopes, ok := c.Get(tokenScopesKey)
+	if !ok || auth.ScopeAllows(scopes.([]string), resource, write) {
+		return true
+	}
+	access := "read"
+	if write {
+		access = "write"
+	}
+	utils.RespondWithError(c, http.StatusForbidden, errMissingScope, fmt.Sprintf("API token needs the %s:%s scope", resource, access))
+	return false
+}
+
+// requireSession is middleware rejecting requests made with an API token
+// with 403, for routes that manage the account rather than its data
+func requireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(tokenScopesKey); ok {
+			utils.RespondWithError(c, http.StatusForbidden, errSessionRequired, "API tokens cannot call this endpoint")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin is middleware rejecting requests with 403 unless the user
+// is an admin, for routes that manage the whole install rather than one
+// workspace
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user, _ := currentUser(c); !user.IsAdmin {
+			utils.RespondWithError(c, http.StatusForbidden, errAdminRequired, "Only admins can call this endpoint")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// allow responds with 403 and returns false unless role grants perm
+func allow(c *gin.Context, role auth.Role, perm auth.Permission) bool {
+	if role.Can(perm) {
+		return true
+	}
+	utils.RespondWithError(c, http.StatusForbidden, errForbidden, fmt.Sprintf("Your role does not allow the %s permission", perm))
+	return false
+}
+
+// currentUser returns the user set by RequireAuth
+func currentUser(c *gin.Context) (models.User, bool) {
+	value, ok := c.Get(currentUserKey)
+	if !ok {
+		return models.User{}, false
+	}
+	user, ok := value.(models.User)
+	return user, ok
+}
+
+// workspaceID returns the ID of the session's workspace set by RequireAuth,
+// or "" when there is none
+func workspaceID(c *gin.Context) string {
+	return c.GetString(currentWorkspaceKey)
+}
+
+// currentRole returns the user's role in the session's workspace set by
+// RequireAuth, or "" when there is none
+func currentRole(c *gin.Context) auth.Role {
+	role, _ := c.Get(currentRoleKey)
+	r, _ := role.(auth.Role)
+	return r
+}
+
+// requestToken reads the session token from the Authorization header,
+// falling back to the session cookie
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	token, _ := c.Cookie(sessionCookie)
+	return token
+}
+
+func (h *AuthHandler) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", h.secureCookie, true)
+}
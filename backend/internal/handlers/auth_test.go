@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if scopes := c.GetHeader("X-Scopes"); scopes != "-" {
+			c.Set(tokenScopesKey, strings.Split(scopes, ","))
+		}
+	})
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/rules", requireScope("tag-rules"), ok)
+	router.POST("/rules", requireScope("tag-rules"), ok)
+	router.POST("/rules/test", requireReadScope("tag-rules"), ok)
+
+	tests := []struct {
+		method, path, scopes string
+		want                 int
+	}{
+		// Sessions are not limited
+		{"POST", "/rules", "-", http.StatusNoContent},
+		{"GET", "/rules", "tag-rules:read", http.StatusNoContent},
+		{"GET", "/rules", "tag-rules:write", http.StatusNoContent},
+		{"GET", "/rules", "payments:read", http.StatusForbidden},
+		{"POST", "/rules", "tag-rules:read", http.StatusForbidden},
+		{"POST", "/rules", "tag-rules:write", http.StatusNoContent},
+		{"POST", "/rules/test", "tag-rules:read", http.StatusNoContent},
+		{"POST", "/rules/test", "tag-rules:write", http.StatusNoContent},
+		{"POST", "/rules/test", "payments:write", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("X-Scopes", tt.scopes)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %s = %d, want %d", tt.method, tt.path, tt.scopes, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"expense_tracker/internal/filetype"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
+	"expense_tracker/internal/textextract"
+	"io"
+	"log"
+	"mime/multipart"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blob is a stored file shared by every document and attachment with the same
+// content
+type blob struct {
+	Hash        string
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// blobKey returns the storage key for content with the given hash. The
+// extension lets local files be served with their content type.
+func blobKey(hash, ext string) string {
+	return storage.Key(path.Join("blobs", hash[:2]), hash+strings.ToLower(ext))
+}
+
+// putBlob stores an uploaded file of a detected content type under its
+// SHA-256 hash, unless the same content is already stored, and takes a
+// reference to it. The returned discard function deletes a file this call
+// stored unless it ends up recorded; defer it so a request whose
+// transaction doesn't commit leaves no unreferenced file behind.
+func putBlob(c *gin.Context, db *sql.DB, tx *sql.Tx, store storage.Storage, file *multipart.FileHeader, contentType string) (blob, func(), error) {
+	src, err := file.Open()
+	if err != nil {
+		return blob{}, nil, err
+	}
+	defer src.Close()
+
+	hash, err := hashReader(src)
+	if err != nil {
+		return blob{}, nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return blob{}, nil, err
+	}
+
+	b := blob{Hash: hash, Size: file.Size, ContentType: contentType}
+	discard := func() {}
+	err = tx.QueryRow("SELECT storage_key FROM blobs WHERE hash = ?", hash).Scan(&b.Key)
+	if err == sql.ErrNoRows {
+		b.Key = blobKey(hash, filetype.Extension(contentType))
+		if err := store.Put(c.Request.Context(), b.Key, src, file.Size, contentType); err != nil {
+			return blob{}, nil, err
+		}
+		discard = func() { discardBlob(db, tx, store, b) }
+	} else if err != nil {
+		return blob{}, nil, err
+	}
+
+	if err := retainBlob(tx, b); err != nil {
+		discard()
+		return blob{}, nil, err
+	}
+	return b, discard, nil
+}
+
+// discardBlob deletes a newly stored file unless a committed blob record
+// refers to it. The transaction is rolled back first, which does nothing
+// once it has committed, and frees its connection for the check. Another
+// upload of the same content may have recorded the file meanwhile.
+func discardBlob(db *sql.DB, tx *sql.Tx, store storage.Storage, b blob) {
+	tx.Rollback()
+
+	var recorded int
+	if err := db.QueryRow("SELECT COUNT(*) FROM blobs WHERE hash = ?", b.Hash).Scan(&recorded); err != nil {
+		log.Printf("Failed to check stored file %s: %v", b.Key, err)
+		return
+	}
+	if recorded == 0 {
+		deleteFiles(context.Background(), store, b.Key)
+	}
+}
+
+// retainBlob takes a reference to a blob, recording it if it is new. New
+// blobs of types text can be extracted from are queued for extraction.
+func retainBlob(tx *sql.Tx, b blob) error {
+	textStatus := models.TextUnsupported
+	if textextract.Supported(b.ContentType) {
+		textStatus = models.TextPending
+	}
+	_, err := tx.Exec(`
+		INSERT INTO blobs (hash, storage_key, size, ref_count, content_type, text_status, created_at) VALUES (?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
+	`, b.Hash, b.Key, b.Size, b.ContentType, textStatus, time.Now())
+	return err
+}
+
+// releaseFile drops a reference to a stored file. It returns the storage
+// key if nothing refers to the file any more, to be deleted once the
+// transaction has committed. Files stored before blobs have no hash and
+// belong to a single record.
+func releaseFile(tx *sql.Tx, hash, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	if hash == "" {
+		return key, nil
+	}
+
+	var refs int
+	err := tx.QueryRow("UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ? RETURNING ref_count, storage_key", hash).Scan(&refs, &key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if refs > 0 {
+		return "", nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM blobs WHERE hash = ?", hash); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// deleteFiles removes released files from storage. Failures only leave
+// unreferenced files behind, so they are logged rather than returned.
+func deleteFiles(ctx context.Context, store storage.Storage, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete stored file %s: %v", key, err)
+		}
+	}
+}
+
+func hashReader(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// AdoptLegacyFiles moves documents and payment attachments stored before blobs into
+// content-addressed storage, deduplicating them along the way. Files that
+// are missing from storage are skipped. It returns how many records were
+// moved.
+func AdoptLegacyFiles(db *sql.DB, store storage.Storage) (int, error) {
+	adopted := 0
+	for _, table := range []struct{ name, pathColumn, hashColumn string }{
+		{"documents", "file_path", "file_hash"},
+		{"payment_attachments", "file_path", "file_hash"},
+	} {
+		rows, err := db.Query("SELECT id, " + table.pathColumn + " FROM " + table.name +
+			" WHERE " + table.hashColumn + " IS NULL AND COALESCE(" + table.pathColumn + ", '') != ''")
+		if err != nil {
+			return adopted, err
+		}
+		type legacyFile struct{ id, key string }
+		var files []legacyFile
+		for rows.Next() {
+			var f legacyFile
+			if err := rows.Scan(&f.id, &f.key); err != nil {
+				rows.Close()
+				return adopted, err
+			}
+			files = append(files, f)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return adopted, err
+		}
+
+		for _, f := range files {
+			b, err := adoptFile(db, store, table.name, table.pathColumn, table.hashColumn, f.id, f.key)
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Printf("Skipping %s %s: file %s not found in storage", table.name, f.id, f.key)
+				continue
+			} else if err != nil {
+				return adopted, err
+			}
+			if b.Key != f.key {
+				deleteFiles(context.Background(), store, f.key)
+			}
+			adopted++
+		}
+	}
+	return adopted, nil
+}
+
+// adoptFile copies one legacy file into its blob and points the record at
+// it. Records migrated before their file was adopted don't know its size or
+// type, so those are filled in from storage too.
+func adoptFile(db *sql.DB, store storage.Storage, table, pathColumn, hashColumn, id, key string) (blob, error) {
+	ctx := context.Background()
+
+	src, info, err := store.Get(ctx, key)
+	if err != nil {
+		return blob{}, err
+	}
+	hash, err := hashReader(src)
+	src.Close()
+	if err != nil {
+		return blob{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return blob{}, err
+	}
+	defer tx.Rollback()
+
+	b := blob{Hash: hash, Size: info.Size, ContentType: strings.TrimSpace(strings.Split(info.ContentType, ";")[0])}
+	err = tx.QueryRow("SELECT storage_key FROM blobs WHERE hash = ?", hash).Scan(&b.Key)
+	if err == sql.ErrNoRows {
+		b.Key = blobKey(hash, path.Ext(key))
+		src, info, err := store.Get(ctx, key)
+		if err != nil {
+			return blob{}, err
+		}
+		err = store.Put(ctx, b.Key, src, info.Size, info.ContentType)
+		src.Close()
+		if err != nil {
+			return blob{}, err
+		}
+	} else if err != nil {
+		return blob{}, err
+	}
+
+	if err := retainBlob(tx, b); err != nil {
+		return blob{}, err
+	}
+	_, err = tx.Exec("UPDATE "+table+" SET "+pathColumn+" = ?, "+hashColumn+" = ?, file_size = ?, "+
+		"content_type = COALESCE(NULLIF(content_type, ''), NULLIF(?, '')) WHERE id = ?",
+		b.Key, b.Hash, b.Size, b.ContentType, id)
+	if err != nil {
+		return blob{}, err
+	}
+	return b, tx.Commit()
+}
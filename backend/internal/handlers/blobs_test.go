@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"expense_tracker/internal/storage"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadedFile returns the header of a file posted in a multipart form
+func uploadedFile(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { req.MultipartForm.RemoveAll() })
+	return req.MultipartForm.File["file"][0]
+}
+
+func TestPutBlobDiscard(t *testing.T) {
+	db := newTestDB(t)
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", nil)
+	ctx := context.Background()
+
+	exists := func(key string) bool {
+		_, err := store.Stat(ctx, key)
+		if err != nil && err != storage.ErrNotFound {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	refCount := func(hash string) int {
+		var refs int
+		if err := db.QueryRow("SELECT COALESCE(SUM(ref_count), 0) FROM blobs WHERE hash = ?", hash).Scan(&refs); err != nil {
+			t.Fatal(err)
+		}
+		return refs
+	}
+	put := func(data string, commit bool) blob {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		b, discard, err := putBlob(c, db, tx, store, uploadedFile(t, "a.txt", []byte(data)), "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer discard()
+		if commit {
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b
+	}
+
+	// A rolled back upload of new content leaves no file behind
+	b := put("first", false)
+	if exists(b.Key) || refCount(b.Hash) != 0 {
+		t.Errorf("rolled back upload left %s behind", b.Key)
+	}
+
+	// A committed upload keeps its file
+	b = put("second", true)
+	if !exists(b.Key) || refCount(b.Hash) != 1 {
+		t.Errorf("committed upload lost %s", b.Key)
+	}
+
+	// Rolling back another upload of stored content keeps the shared file
+	if again := put("second", false); again.Key != b.Key {
+		t.Errorf("same content stored as %s and %s", b.Key, again.Key)
+	}
+	if !exists(b.Key) || refCount(b.Hash) != 1 {
+		t.Errorf("rolled back upload of stored content deleted %s", b.Key)
+	}
+}
+
+func TestAdoptLegacyFilesFillsSizeAndType(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := []byte("%PDF-1.4 invoice")
+	if err := store.Put(ctx, "invoices/old.pdf", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "documents/old.pdf", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows as left by the migrations: no hash, size or type yet
+	now := time.Now()
+	if _, err := db.Exec(`
+		INSERT INTO payments (id, info, amount_minor, currency, date_paid, workspace_id, created_at, updated_at)
+		VALUES ('p1', 'Paid', 100, 'EUR', ?, ?, ?, ?)
+	`, now, workspaceID, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO payment_attachments (id, payment_id, kind, file_path, original_name, file_size)
+		VALUES ('a1', 'p1', 'invoice', 'invoices/old.pdf', 'old.pdf', 0)
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO documents (id, title, file_path, original_name, file_size, workspace_id, created_at, updated_at)
+		VALUES ('d1', 'Old', 'documents/old.pdf', 'old.pdf', 0, ?, ?, ?)
+	`, workspaceID, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	adopted, err := AdoptLegacyFiles(db, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adopted != 2 {
+		t.Errorf("adopted %d files, want 2", adopted)
+	}
+	for _, table := range []string{"payment_attachments", "documents"} {
+		var size int64
+		var contentType sql.NullString
+		if err := db.QueryRow("SELECT file_size, content_type FROM "+table).Scan(&size, &contentType); err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(data)) || contentType.String != "application/pdf" {
+			t.Errorf("%s: size %d, content type %q, want %d, application/pdf", table, size, contentType.String, len(data))
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/recurring"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type BudgetHandler struct {
+	db *sql.DB
+}
+
+func NewBudgetHandler(db *sql.DB) *BudgetHandler {
+	return &BudgetHandler{db: db}
+}
+
+// RegisterRoutes registers all budget routes
+func (h *BudgetHandler) RegisterRoutes(router *gin.RouterGroup) {
+	budgets := router.Group("/budgets", requireScope("budgets"))
+	{
+		budgets.GET("", requirePermission(auth.PermRead), h.ListBudgets)
+		budgets.POST("", requirePermission(auth.PermWrite), h.CreateBudget)
+		budgets.GET("/status", requirePermission(auth.PermRead), h.ListBudgetStatus)
+		budgets.GET("/:id", requirePermission(auth.PermRead), h.GetBudget)
+		budgets.PUT("/:id", requirePermission(auth.PermWrite), h.UpdateBudget)
+		budgets.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteBudget)
+		budgets.GET("/:id/status", requirePermission(auth.PermRead), h.GetBudgetStatus)
+	}
+}
+
+const budgetColumns = `b.id, b.name, b.amount_minor, b.currency, b.period, b.rollover, b.start_date, b.created_at, b.updated_at`
+
+// budgetPayload is the request body for creating or updating a budget
+type budgetPayload struct {
+	Name      string         `json:"name"`
+	Amount    models.Decimal `json:"amount"`
+	Currency  string         `json:"currency"`
+	Period    string         `json:"period"`
+	Rollover  bool           `json:"rollover"`
+	StartDate string         `json:"startDate"`
+	Tags      []string       `json:"tags"`
+}
+
+// bindBudget parses and validates the request body, responding with 400 on failure
+func bindBudget(c *gin.Context) (models.Budget, bool) {
+	var b models.Budget
+	var payload budgetPayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid budget data")
+		return b, false
+	}
+
+	if strings.TrimSpace(payload.Name) == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("name is required"), "Invalid budget data")
+		return b, false
+	}
+	if len(payload.Tags) == 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("at least one tag is required"), "Invalid budget data")
+		return b, false
+	}
+
+	switch payload.Period {
+	case models.PeriodMonthly, models.PeriodQuarterly, models.PeriodYearly:
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("period must be monthly, quarterly or yearly"), "Invalid budget data")
+		return b, false
+	}
+
+	currency, err := models.NormalizeCurrency(payload.Currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid currency")
+		return b, false
+	}
+
+	amount, err := payload.Amount.Money(currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid amount")
+		return b, false
+	}
+
+	startDate := time.Now()
+	if payload.StartDate != "" {
+		if startDate, err = time.Parse("2006-01-02", payload.StartDate); err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid start date format")
+			return b, false
+		}
+	}
+
+	b.Name = payload.Name
+	b.Amount = amount
+	b.Currency = currency
+	b.Period = payload.Period
+	b.Rollover = payload.Rollover
+	// Budgets always start at the beginning of a period
+	b.StartDate, _ = periodBounds(payload.Period, startDate)
+	b.Tags = payload.Tags
+	return b, true
+}
+
+// ListBudgets returns all budgets of the workspace
+func (h *BudgetHandler) ListBudgets(c *gin.Context) {
+	budgets, err := fetchBudgets(h.db, workspaceID(c), "")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch budgets")
+		return
+	}
+
+	c.JSON(http.StatusOK, budgets)
+}
+
+// CreateBudget creates a new budget
+func (h *BudgetHandler) CreateBudget(c *gin.Context) {
+	b, ok := bindBudget(c)
+	if !ok || !validateTags(c, h.db, b.Tags) {
+		return
+	}
+
+	b.ID = uuid.New().String()
+	b.CreatedAt = time.Now()
+	b.UpdatedAt = time.Now()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO budgets (id, name, amount_minor, currency, period, rollover, start_date, workspace_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, b.ID, b.Name, b.Amount.Minor, b.Currency, b.Period, b.Rollover, b.StartDate, workspaceID(c), b.CreatedAt, b.UpdatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create budget")
+		return
+	}
+
+	if err := setBudgetTags(tx, b.ID, b.Tags); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, b)
+}
+
+// GetBudget returns a specific budget by ID
+func (h *BudgetHandler) GetBudget(c *gin.Context) {
+	b, ok := h.findBudget(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, b)
+}
+
+// UpdateBudget updates a specific budget
+func (h *BudgetHandler) UpdateBudget(c *gin.Context) {
+	id := c.Param("id")
+
+	b, ok := bindBudget(c)
+	if !ok || !validateTags(c, h.db, b.Tags) {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE budgets
+		SET name = ?, amount_minor = ?, currency = ?, period = ?, rollover = ?, start_date = ?, updated_at = ?
+		WHERE id = ? AND workspace_id = ?
+	`, b.Name, b.Amount.Minor, b.Currency, b.Period, b.Rollover, b.StartDate, time.Now(), id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update budget")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Budget not found")
+		return
+	}
+
+	if err := setBudgetTags(tx, id, b.Tags); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	b.ID = id
+	c.JSON(http.StatusOK, b)
+}
+
+// DeleteBudget deletes a specific budget
+func (h *BudgetHandler) DeleteBudget(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM budget_tags WHERE budget_id IN (SELECT id FROM budgets WHERE id = ? AND workspace_id = ?)", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete budget tags")
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM budgets WHERE id = ? AND workspace_id = ?", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete budget")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Budget not found")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListBudgetStatus returns budget vs. actual for every budget in the period
+// containing ?date= (default today)
+func (h *BudgetHandler) ListBudgetStatus(c *gin.Context) {
+	date, ok := statusDate(c)
+	if !ok {
+		return
+	}
+
+	budgets, err := fetchBudgets(h.db, workspaceID(c), "")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch budgets")
+		return
+	}
+
+	statuses := make([]models.BudgetStatus, 0, len(budgets))
+	for _, b := range budgets {
+		status, err := h.budgetStatus(b, date)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to compute budget status")
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetBudgetStatus returns budget vs. actual for one budget in the period
+// containing ?date= (default today)
+func (h *BudgetHandler) GetBudgetStatus(c *gin.Context) {
+	date, ok := statusDate(c)
+	if !ok {
+		return
+	}
+
+	b, ok := h.findBudget(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.budgetStatus(b, date)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to compute budget status")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// budgetStatus computes spending for the period containing date. Spending
+// is the sum of payments carrying any of the budget's tags, converted into
+// the budget's currency. With rollover, every earlier period since the
+// budget's start contributes its unspent amount (or overspend).
+func (h *BudgetHandler) budgetStatus(b models.Budget, date time.Time) (models.BudgetStatus, error) {
+	periodStart, periodEnd := periodBounds(b.Period, date)
+	status := models.BudgetStatus{
+		Budget:      b,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd.AddDate(0, 0, -1),
+		Budgeted:    b.Amount,
+		CarriedOver: models.NewMoney(0, b.Currency),
+	}
+
+	cv, err := newCurrencyConverter(h.db, b.Currency)
+	if err != nil {
+		return status, err
+	}
+
+	// Payments are filtered by period below since rollover needs the
+	// spending of earlier periods as well
+	query := `
+		SELECT DISTINCT p.id, p.amount_minor, p.currency, p.date_paid
+		FROM payments p
+		JOIN payment_tags pt ON pt.payment_id = p.id
+		JOIN budget_tags bt ON bt.tag_id = pt.tag_id
+		WHERE bt.budget_id = ? AND p.deleted_at IS NULL
+	`
+	rows, err := h.db.Query(query, b.ID)
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+
+	var actual int64
+	spentByPeriod := make(map[string]int64)
+	for rows.Next() {
+		var id, currency string
+		var minor int64
+		var datePaid time.Time
+		if err := rows.Scan(&id, &minor, &currency, &datePaid); err != nil {
+			return status, err
+		}
+
+		day := recurring.Day(datePaid)
+		if day.Before(recurring.Day(b.StartDate)) || !day.Before(periodEnd) {
+			continue
+		}
+		inPeriod := !day.Before(periodStart)
+		if !inPeriod && !b.Rollover {
+			continue
+		}
+
+		amount, ok := cv.convert(minor, currency, datePaid)
+		if inPeriod {
+			status.PaymentCount++
+		}
+		if !ok {
+			continue
+		}
+		if inPeriod {
+			actual += amount
+		} else {
+			start, _ := periodBounds(b.Period, day)
+			spentByPeriod[dayKey(start)] += amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return status, err
+	}
+
+	if b.Rollover {
+		var carried int64
+		for start := recurring.Day(b.StartDate); start.Before(periodStart); {
+			_, next := periodBounds(b.Period, start)
+			carried += b.Amount.Minor - spentByPeriod[dayKey(start)]
+			start = next
+		}
+		status.CarriedOver = models.NewMoney(carried, b.Currency)
+	}
+
+	available := b.Amount.Minor + status.CarriedOver.Minor
+	status.Available = models.NewMoney(available, b.Currency)
+	status.Actual = models.NewMoney(actual, b.Currency)
+	status.Remaining = models.NewMoney(available-actual, b.Currency)
+	status.Projected = models.NewMoney(projectSpend(actual, periodStart, periodEnd, time.Now()), b.Currency)
+	status.OverBudget = actual > available
+	status.ProjectedOver = status.Projected.Minor > available
+	status.Unconverted = cv.unconverted
+
+	return status, nil
+}
+
+// projectSpend extrapolates spending so far to the whole period at the
+// current daily rate. Past periods project to their actual spend.
+func projectSpend(actual int64, start, end, now time.Time) int64 {
+	today := recurring.Day(now)
+	if !today.Before(end) {
+		return actual
+	}
+	if today.Before(start) {
+		return 0
+	}
+
+	elapsed := int64(today.Sub(start).Hours()/24) + 1
+	total := int64(end.Sub(start).Hours() / 24)
+	return actual * total / elapsed
+}
+
+// periodBounds returns the first day of the period containing date and the
+// first day of the following period
+func periodBounds(period string, date time.Time) (time.Time, time.Time) {
+	date = recurring.Day(date)
+	switch period {
+	case models.PeriodYearly:
+		start := time.Date(date.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	case models.PeriodQuarterly:
+		month := time.Month((int(date.Month())-1)/3*3 + 1)
+		start := time.Date(date.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0)
+	default:
+		start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// statusDate reads ?date= (default today), responding with 400 when invalid
+func statusDate(c *gin.Context) (time.Time, bool) {
+	dateStr := c.Query("date")
+	if dateStr == "" {
+		return time.Now(), true
+	}
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		return date, false
+	}
+	return date, true
+}
+
+// findBudget loads the budget named by the :id parameter, responding with
+// an error if it cannot
+func (h *BudgetHandler) findBudget(c *gin.Context) (models.Budget, bool) {
+	budgets, err := fetchBudgets(h.db, workspaceID(c), c.Param("id"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch budget")
+		return models.Budget{}, false
+	}
+	if len(budgets) == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Budget not found")
+		return models.Budget{}, false
+	}
+	return budgets[0], true
+}
+
+// fetchBudgets loads all budgets of the workspace, or only the one with the
+// given ID
+func fetchBudgets(db querier, workspaceID, id string) ([]models.Budget, error) {
+	query := `
+		SELECT ` + budgetColumns + `, GROUP_CONCAT(bt.tag_id) as tag_ids
+		FROM budgets b
+		LEFT JOIN budget_tags bt ON b.id = bt.budget_id
+		WHERE b.workspace_id = ?
+	`
+	params := []interface{}{workspaceID}
+	if id != "" {
+		query += " AND b.id = ?"
+		params = append(params, id)
+	}
+	query += " GROUP BY b.id ORDER BY b.name"
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := make([]models.Budget, 0)
+	for rows.Next() {
+		var b models.Budget
+		var minor int64
+		var tagIDs sql.NullString
+		err := rows.Scan(&b.ID, &b.Name, &minor, &b.Currency, &b.Period, &b.Rollover,
+			&b.StartDate, &b.CreatedAt, &b.UpdatedAt, &tagIDs)
+		if err != nil {
+			return nil, err
+		}
+		b.Amount = models.NewMoney(minor, b.Currency)
+		b.Tags = []string{}
+		if tagIDs.Valid {
+			b.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+func setBudgetTags(tx *sql.Tx, budgetID string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM budget_tags WHERE budget_id = ?", budgetID); err != nil {
+		return err
+	}
+	for _, tagID := range tags {
+		if _, err := tx.Exec("INSERT INTO budget_tags (budget_id, tag_id) VALUES (?, ?)", budgetID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
 	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
 	"expense_tracker/internal/utils"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,29 +18,36 @@ import (
 )
 
 type DocumentHandler struct {
-	db *sql.DB
+	db            *sql.DB
+	storage       storage.Storage
+	maxUploadSize int64
 }
 
-func NewDocumentHandler(db *sql.DB) *DocumentHandler {
-	return &DocumentHandler{db: db}
+func NewDocumentHandler(db *sql.DB, store storage.Storage, maxUploadSize int64) *DocumentHandler {
+	return &DocumentHandler{db: db, storage: store, maxUploadSize: maxUploadSize}
 }
 
 func (h *DocumentHandler) RegisterRoutes(router *gin.RouterGroup) {
-	documents := router.Group("/documents")
+	documents := router.Group("/documents", requireScope("documents"))
 	{
-		documents.POST("", h.CreateDocument)
-		documents.GET("", h.ListDocuments)
-		documents.GET("/:id", h.GetDocument)
-		documents.PUT("/:id", h.UpdateDocument)
-		documents.DELETE("/:id", h.DeleteDocument)
-		documents.GET("/:id/download", h.DownloadDocument)
+		documents.POST("", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.CreateDocument)
+		documents.GET("", requirePermission(auth.PermRead), h.ListDocuments)
+		documents.GET("/:id", requirePermission(auth.PermRead), h.GetDocument)
+		documents.PUT("/:id", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.UpdateDocument)
+		documents.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteDocument)
+		documents.GET("/:id/download", requirePermission(auth.PermRead), h.DownloadDocument)
+		documents.GET("/:id/text", requirePermission(auth.PermRead), h.GetDocumentText)
 	}
 }
 
 func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
-		utils.RespondWithError(c, http.StatusBadRequest, err, "File is required")
+		respondFormError(c, err, h.maxUploadSize, "File is required")
+		return
+	}
+	fileType, ok := checkUpload(c, file, h.maxUploadSize)
+	if !ok {
 		return
 	}
 
@@ -56,31 +65,36 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 			doc.Tags = tagIds
 		}
 	}
+	if !validateTags(c, h.db, doc.Tags) {
+		return
+	}
 
-	doc.ID = uuid.New().String()
-	filename := filepath.Join("storage", "documents", doc.ID+filepath.Ext(file.Filename))
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
 
-	if err := c.SaveUploadedFile(file, filename); err != nil {
+	stored, discard, err := putBlob(c, h.db, tx, h.storage, file, fileType)
+	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save file")
 		return
 	}
+	defer discard()
 
-	doc.FilePath = filename
+	doc.ID = uuid.New().String()
+	doc.FilePath = stored.Key
+	doc.FileHash = stored.Hash
+	doc.ContentType = fileType
 	doc.OriginalName = file.Filename
 	doc.FileSize = file.Size
 	doc.CreatedAt = time.Now()
 	doc.UpdatedAt = time.Now()
 
-	tx, err := h.db.Begin()
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
-		return
-	}
-	defer tx.Rollback()
-
 	_, err = tx.Exec(
-		`INSERT INTO documents (id, title, description, file_path, original_name, file_size, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		doc.ID, doc.Title, doc.Description, doc.FilePath, doc.OriginalName, doc.FileSize, doc.CreatedAt, doc.UpdatedAt,
+		`INSERT INTO documents (id, title, description, file_path, file_hash, content_type, original_name, file_size, workspace_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		doc.ID, doc.Title, doc.Description, doc.FilePath, doc.FileHash, doc.ContentType, doc.OriginalName, doc.FileSize, workspaceID(c), doc.CreatedAt, doc.UpdatedAt,
 	)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create document")
@@ -95,6 +109,19 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 		}
 	}
 
+	if err := indexDocument(tx, doc.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	if doc.Tags == nil {
+		doc.Tags = []string{}
+	}
+	if err := auditorFor(c).record(tx, audit.EntityDocument, doc.ID, audit.ActionCreate, nil, doc); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
@@ -105,6 +132,8 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 		"title":        doc.Title,
 		"description":  doc.Description,
 		"filePath":     doc.FilePath,
+		"fileHash":     doc.FileHash,
+		"contentType":  doc.ContentType,
 		"originalName": doc.OriginalName,
 		"fileSize":     doc.FileSize,
 		"tags":         doc.Tags,
@@ -114,19 +143,26 @@ func (h *DocumentHandler) CreateDocument(c *gin.Context) {
 }
 
 func (h *DocumentHandler) ListDocuments(c *gin.Context) {
-	query := `SELECT DISTINCT d.id, d.title, d.description, d.file_path as filePath, d.original_name as originalName, d.file_size as fileSize, d.created_at as createdAt, d.updated_at as updatedAt, GROUP_CONCAT(dt.tag_id) as tag_ids FROM documents d LEFT JOIN document_tags dt ON d.id = dt.document_id`
-	var params []interface{}
-	var whereClause []string
+	query := `SELECT DISTINCT d.id, d.title, d.description, d.file_path as filePath, COALESCE(d.file_hash, ''), COALESCE(d.content_type, ''), d.original_name as originalName, d.file_size as fileSize, d.created_at as createdAt, d.updated_at as updatedAt, d.deleted_at, GROUP_CONCAT(dt.tag_id) as tag_ids FROM documents d LEFT JOIN document_tags dt ON d.id = dt.document_id`
+	params := []interface{}{workspaceID(c)}
+	whereClause := []string{"d.workspace_id = ?"}
+
+	// Trashed documents are hidden unless asked for
+	if c.Query("include_trashed") != "true" {
+		whereClause = append(whereClause, "d.deleted_at IS NULL")
+	}
 
 	if tag := c.Query("tag"); tag != "" {
 		whereClause = append(whereClause, "EXISTS (SELECT 1 FROM document_tags WHERE document_id = d.id AND tag_id = ?)")
 		params = append(params, tag)
 	}
 
-	if len(whereClause) > 0 {
-		query += " WHERE " + utils.JoinWithAND(whereClause)
+	if condition, searchParams := searchFilter(c, searchDocument, "d.id"); condition != "" {
+		whereClause = append(whereClause, condition)
+		params = append(params, searchParams...)
 	}
 
+	query += " WHERE " + utils.JoinWithAND(whereClause)
 	query += " GROUP BY d.id ORDER BY d.created_at DESC"
 
 	limit := utils.ParseIntWithDefault(c.Query("limit"), 10)
@@ -145,11 +181,15 @@ func (h *DocumentHandler) ListDocuments(c *gin.Context) {
 	for rows.Next() {
 		var doc models.Document
 		var tagIDs sql.NullString
-		err := rows.Scan(&doc.ID, &doc.Title, &doc.Description, &doc.FilePath, &doc.OriginalName, &doc.FileSize, &doc.CreatedAt, &doc.UpdatedAt, &tagIDs)
+		var deletedAt sql.NullTime
+		err := rows.Scan(&doc.ID, &doc.Title, &doc.Description, &doc.FilePath, &doc.FileHash, &doc.ContentType, &doc.OriginalName, &doc.FileSize, &doc.CreatedAt, &doc.UpdatedAt, &deletedAt, &tagIDs)
 		if err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan document")
 			return
 		}
+		if deletedAt.Valid {
+			doc.DeletedAt = &deletedAt.Time
+		}
 
 		if tagIDs.Valid {
 			doc.Tags = utils.SplitCommaString(tagIDs.String)
@@ -159,7 +199,11 @@ func (h *DocumentHandler) ListDocuments(c *gin.Context) {
 
 	resp := make([]gin.H, 0, len(documents))
 	for _, d := range documents {
-		resp = append(resp, gin.H{"id": d.ID, "title": d.Title, "description": d.Description, "filePath": d.FilePath, "originalName": d.OriginalName, "fileSize": d.FileSize, "tags": d.Tags, "createdAt": d.CreatedAt, "updatedAt": d.UpdatedAt})
+		item := gin.H{"id": d.ID, "title": d.Title, "description": d.Description, "filePath": d.FilePath, "fileHash": d.FileHash, "contentType": d.ContentType, "originalName": d.OriginalName, "fileSize": d.FileSize, "tags": d.Tags, "createdAt": d.CreatedAt, "updatedAt": d.UpdatedAt}
+		if d.DeletedAt != nil {
+			item["deletedAt"] = d.DeletedAt
+		}
+		resp = append(resp, item)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"results": resp, "total": len(documents)})
@@ -168,10 +212,7 @@ func (h *DocumentHandler) ListDocuments(c *gin.Context) {
 func (h *DocumentHandler) GetDocument(c *gin.Context) {
 	id := c.Param("id")
 
-	var doc models.Document
-	var tagIDs sql.NullString
-
-	err := h.db.QueryRow(`SELECT d.id, d.title, d.description, d.file_path, d.original_name, d.file_size, d.created_at, d.updated_at, GROUP_CONCAT(dt.tag_id) as tag_ids FROM documents d LEFT JOIN document_tags dt ON d.id = dt.document_id WHERE d.id = ? GROUP BY d.id`, id).Scan(&doc.ID, &doc.Title, &doc.Description, &doc.FilePath, &doc.OriginalName, &doc.FileSize, &doc.CreatedAt, &doc.UpdatedAt, &tagIDs)
+	doc, err := fetchDocument(h.db, workspaceID(c), id)
 	if err == sql.ErrNoRows {
 		utils.RespondWithError(c, http.StatusNotFound, err, "Document not found")
 		return
@@ -180,11 +221,7 @@ func (h *DocumentHandler) GetDocument(c *gin.Context) {
 		return
 	}
 
-	if tagIDs.Valid {
-		doc.Tags = utils.SplitCommaString(tagIDs.String)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"id": doc.ID, "title": doc.Title, "description": doc.Description, "filePath": doc.FilePath, "originalName": doc.OriginalName, "fileSize": doc.FileSize, "tags": doc.Tags, "createdAt": doc.CreatedAt, "updatedAt": doc.UpdatedAt})
+	c.JSON(http.StatusOK, gin.H{"id": doc.ID, "title": doc.Title, "description": doc.Description, "filePath": doc.FilePath, "fileHash": doc.FileHash, "contentType": doc.ContentType, "originalName": doc.OriginalName, "fileSize": doc.FileSize, "tags": doc.Tags, "createdAt": doc.CreatedAt, "updatedAt": doc.UpdatedAt})
 }
 
 func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
@@ -197,7 +234,7 @@ func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 
 	if strings.HasPrefix(strings.ToLower(contentType), "multipart/form-data") {
 		if err := c.ShouldBind(&doc); err != nil {
-			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid document data")
+			respondFormError(c, err, h.maxUploadSize, "Invalid document data")
 			return
 		}
 
@@ -217,6 +254,17 @@ func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 			return
 		}
 	}
+	if !validateTags(c, h.db, doc.Tags) {
+		return
+	}
+
+	var fileType string
+	if fileErr == nil && fileHeader != nil {
+		var ok bool
+		if fileType, ok = checkUpload(c, fileHeader, h.maxUploadSize); !ok {
+			return
+		}
+	}
 
 	tx, err := h.db.Begin()
 	if err != nil {
@@ -225,27 +273,38 @@ func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	if fileErr == nil && fileHeader != nil {
-		var oldPath string
-		_ = tx.QueryRow("SELECT file_path FROM documents WHERE id = ?", id).Scan(&oldPath)
+	before, err := fetchDocument(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Document not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch document")
+		return
+	}
 
-		newFilename := filepath.Join("storage", "documents", id+filepath.Ext(fileHeader.Filename))
-		if err := c.SaveUploadedFile(fileHeader, newFilename); err != nil {
+	// The replaced file, if no longer referenced, goes after commit
+	var released string
+	if fileErr == nil && fileHeader != nil {
+		stored, discard, err := putBlob(c, h.db, tx, h.storage, fileHeader, fileType)
+		if err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save uploaded file")
 			return
 		}
+		defer discard()
 
-		_, err = tx.Exec("UPDATE documents SET title = ?, description = ?, file_path = ?, original_name = ?, file_size = ?, updated_at = ? WHERE id = ?", doc.Title, doc.Description, newFilename, fileHeader.Filename, fileHeader.Size, time.Now(), id)
+		_, err = tx.Exec("UPDATE documents SET title = ?, description = ?, file_path = ?, file_hash = ?, content_type = ?, original_name = ?, file_size = ?, updated_at = ? WHERE id = ?", doc.Title, doc.Description, stored.Key, stored.Hash, fileType, fileHeader.Filename, fileHeader.Size, time.Now(), id)
 		if err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update document with new file")
 			return
 		}
 
-		if oldPath != "" && oldPath != newFilename {
-			_ = utils.DeleteFile(oldPath)
+		released, err = releaseFile(tx, before.FileHash, before.FilePath)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to release old file")
+			return
 		}
 	} else {
-		result, err := tx.Exec("UPDATE documents SET title = ?, description = ?, updated_at = ? WHERE id = ?", doc.Title, doc.Description, time.Now(), id)
+		result, err := tx.Exec("UPDATE documents SET title = ?, description = ?, updated_at = ? WHERE id = ? AND workspace_id = ?", doc.Title, doc.Description, time.Now(), id, workspaceID(c))
 		if err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update document")
 			return
@@ -277,15 +336,34 @@ func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 		}
 	}
 
+	if err := indexDocument(tx, id); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	updated, err := fetchDocument(tx, workspaceID(c), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch document")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityDocument, id, audit.ActionUpdate, before, updated); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
 	}
+	deleteFiles(c.Request.Context(), h.storage, released)
 
 	doc.ID = id
 	c.JSON(http.StatusOK, doc)
 }
 
+// DeleteDocument moves a document to the trash. Its file is kept until the
+// document is purged.
 func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
 	id := c.Param("id")
 
@@ -296,8 +374,7 @@ func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	var filePath string
-	err = tx.QueryRow("SELECT file_path FROM documents WHERE id = ?", id).Scan(&filePath)
+	doc, err := fetchDocument(tx, workspaceID(c), id)
 	if err == sql.ErrNoRows {
 		utils.RespondWithError(c, http.StatusNotFound, err, "Document not found")
 		return
@@ -306,46 +383,70 @@ func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
 		return
 	}
 
-	_, err = tx.Exec("DELETE FROM document_tags WHERE document_id = ?", id)
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete document tags")
-		return
-	}
-
-	result, err := tx.Exec("DELETE FROM documents WHERE id = ?", id)
+	_, err = tx.Exec("UPDATE documents SET deleted_at = ? WHERE id = ?", time.Now(), id)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete document")
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+	if err := auditorFor(c).record(tx, audit.EntityDocument, id, audit.ActionDelete, doc, nil); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
 		return
 	}
 
-	if rowsAffected == 0 {
-		utils.RespondWithError(c, http.StatusNotFound, nil, "Document not found")
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
 	}
 
-	if err := utils.DeleteFile(filePath); err != nil {
-		c.Error(err)
+	c.Status(http.StatusNoContent)
+}
+
+// purgeDocument permanently deletes a document with its tags and search
+// entry. It returns the
+// storage key of its file if no other record shares it, which the caller
+// deletes once the transaction has committed.
+func purgeDocument(tx *sql.Tx, id string) (string, error) {
+	var hash, key string
+	err := tx.QueryRow("SELECT COALESCE(file_hash, ''), file_path FROM documents WHERE id = ?", id).Scan(&hash, &key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM document_tags WHERE document_id = ?", id); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM documents WHERE id = ?", id); err != nil {
+		return "", err
 	}
+	if err := indexDocument(tx, id); err != nil {
+		return "", err
+	}
+	return releaseFile(tx, hash, key)
+}
 
-	if err := tx.Commit(); err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
-		return
+// fetchDocument loads a single document of the workspace with its tags.
+// Trashed documents are not found.
+func fetchDocument(db queryRower, workspaceID, id string) (models.Document, error) {
+	var doc models.Document
+	var tagIDs sql.NullString
+
+	err := db.QueryRow(`SELECT d.id, d.title, d.description, d.file_path, COALESCE(d.file_hash, ''), COALESCE(d.content_type, ''), d.original_name, d.file_size, d.created_at, d.updated_at, GROUP_CONCAT(dt.tag_id) as tag_ids FROM documents d LEFT JOIN document_tags dt ON d.id = dt.document_id WHERE d.id = ? AND d.workspace_id = ? AND d.deleted_at IS NULL GROUP BY d.id`, id, workspaceID).Scan(&doc.ID, &doc.Title, &doc.Description, &doc.FilePath, &doc.FileHash, &doc.ContentType, &doc.OriginalName, &doc.FileSize, &doc.CreatedAt, &doc.UpdatedAt, &tagIDs)
+	if err != nil {
+		return doc, err
 	}
 
-	c.Status(http.StatusNoContent)
+	doc.Tags = []string{}
+	if tagIDs.Valid {
+		doc.Tags = utils.SplitCommaString(tagIDs.String)
+	}
+	return doc, nil
 }
 
 func (h *DocumentHandler) DownloadDocument(c *gin.Context) {
 	id := c.Param("id")
 
-	var filePath string
-	err := h.db.QueryRow("SELECT file_path FROM documents WHERE id = ?", id).Scan(&filePath)
+	var filePath, fileHash string
+	err := h.db.QueryRow("SELECT file_path, COALESCE(file_hash, '') FROM documents WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL", id, workspaceID(c)).Scan(&filePath, &fileHash)
 	if err == sql.ErrNoRows {
 		utils.RespondWithError(c, http.StatusNotFound, err, "Document not found")
 		return
@@ -354,5 +455,27 @@ func (h *DocumentHandler) DownloadDocument(c *gin.Context) {
 		return
 	}
 
-	c.File(filePath)
+	serveFile(c, h.storage, filePath, fileHash)
+}
+
+// GetDocumentText returns the text extracted from a document's file and
+// how far extraction has got
+func (h *DocumentHandler) GetDocumentText(c *gin.Context) {
+	var fileHash string
+	err := h.db.QueryRow("SELECT COALESCE(file_hash, '') FROM documents WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL", c.Param("id"), workspaceID(c)).Scan(&fileHash)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Document not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch document")
+		return
+	}
+
+	text, err := fetchExtractedText(h.db, fileHash)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch extracted text")
+		return
+	}
+
+	c.JSON(http.StatusOK, text)
 }
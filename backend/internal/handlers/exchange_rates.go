@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ExchangeRateHandler struct {
+	db            *sql.DB
+	maxUploadSize int64
+}
+
+func NewExchangeRateHandler(db *sql.DB, maxUploadSize int64) *ExchangeRateHandler {
+	return &ExchangeRateHandler{db: db, maxUploadSize: maxUploadSize}
+}
+
+// RegisterRoutes registers all exchange-rate routes. Rates are shared by
+// every workspace, so any user may read them but only admins may change
+// them; a role in one workspace must not alter the others' conversions.
+func (h *ExchangeRateHandler) RegisterRoutes(router *gin.RouterGroup) {
+	rates := router.Group("/exchange-rates", requireScope("exchange-rates"))
+	{
+		rates.GET("", h.ListExchangeRates)
+		rates.POST("", requireAdmin(), h.CreateExchangeRate)
+		rates.POST("/import", requireAdmin(), limitUploadSize(h.maxUploadSize), h.ImportExchangeRates)
+		rates.GET("/:id", h.GetExchangeRate)
+		rates.PUT("/:id", requireAdmin(), h.UpdateExchangeRate)
+		rates.DELETE("/:id", requireAdmin(), h.DeleteExchangeRate)
+	}
+}
+
+// exchangeRatePayload is the request body for creating or updating a rate
+type exchangeRatePayload struct {
+	Date string         `json:"date"`
+	From string         `json:"from"`
+	To   string         `json:"to"`
+	Rate models.Decimal `json:"rate"`
+}
+
+// toExchangeRate validates the payload and normalises currencies and rate
+func (p exchangeRatePayload) toExchangeRate() (models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(p.Date))
+	if err != nil {
+		return rate, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", p.Date)
+	}
+	if strings.TrimSpace(p.From) == "" || strings.TrimSpace(p.To) == "" {
+		return rate, errors.New("from and to currencies are required")
+	}
+	from, err := models.NormalizeCurrency(p.From)
+	if err != nil {
+		return rate, err
+	}
+	to, err := models.NormalizeCurrency(p.To)
+	if err != nil {
+		return rate, err
+	}
+	if from == to {
+		return rate, errors.New("from and to currencies must differ")
+	}
+	if _, err := models.ParseRate(string(p.Rate)); err != nil {
+		return rate, err
+	}
+
+	rate.Date = date
+	rate.From = from
+	rate.To = to
+	rate.Rate = strings.TrimSpace(string(p.Rate))
+	return rate, nil
+}
+
+// ListExchangeRates returns rates with optional currency and date filtering
+func (h *ExchangeRateHandler) ListExchangeRates(c *gin.Context) {
+	query := "SELECT id, date, from_currency, to_currency, rate, created_at FROM exchange_rates"
+	params := []interface{}{}
+	whereClause := []string{}
+
+	if from := c.Query("from"); from != "" {
+		whereClause = append(whereClause, "from_currency = ?")
+		params = append(params, strings.ToUpper(from))
+	}
+	if to := c.Query("to"); to != "" {
+		whereClause = append(whereClause, "to_currency = ?")
+		params = append(params, strings.ToUpper(to))
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		whereClause = append(whereClause, "substr(date, 1, 10) >= ?")
+		params = append(params, startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		whereClause = append(whereClause, "substr(date, 1, 10) <= ?")
+		params = append(params, endDate)
+	}
+
+	if len(whereClause) > 0 {
+		query += " WHERE " + utils.JoinWithAND(whereClause)
+	}
+	query += " ORDER BY date DESC, from_currency, to_currency"
+
+	rows, err := h.db.Query(query, params...)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch exchange rates")
+		return
+	}
+	defer rows.Close()
+
+	rates := make([]models.ExchangeRate, 0)
+	for rows.Next() {
+		var rate models.ExchangeRate
+		if err := rows.Scan(&rate.ID, &rate.Date, &rate.From, &rate.To, &rate.Rate, &rate.CreatedAt); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan exchange rate")
+			return
+		}
+		rates = append(rates, rate)
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// CreateExchangeRate adds a rate for a currency pair and date
+func (h *ExchangeRateHandler) CreateExchangeRate(c *gin.Context) {
+	var payload exchangeRatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid exchange rate data")
+		return
+	}
+
+	rate, err := payload.toExchangeRate()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid exchange rate data")
+		return
+	}
+	rate.ID = uuid.New().String()
+	rate.CreatedAt = time.Now()
+
+	_, err = h.db.Exec(
+		"INSERT INTO exchange_rates (id, date, from_currency, to_currency, rate, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		rate.ID, rate.Date, rate.From, rate.To, rate.Rate, rate.CreatedAt,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusConflict, err, "Failed to create exchange rate, a rate for this pair and date may already exist")
+		return
+	}
+
+	c.JSON(http.StatusCreated, rate)
+}
+
+// GetExchangeRate returns a specific rate by ID
+func (h *ExchangeRateHandler) GetExchangeRate(c *gin.Context) {
+	id := c.Param("id")
+
+	var rate models.ExchangeRate
+	err := h.db.QueryRow(
+		"SELECT id, date, from_currency, to_currency, rate, created_at FROM exchange_rates WHERE id = ?",
+		id,
+	).Scan(&rate.ID, &rate.Date, &rate.From, &rate.To, &rate.Rate, &rate.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Exchange rate not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch exchange rate")
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+// UpdateExchangeRate replaces a specific rate
+func (h *ExchangeRateHandler) UpdateExchangeRate(c *gin.Context) {
+	id := c.Param("id")
+
+	var payload exchangeRatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid exchange rate data")
+		return
+	}
+
+	rate, err := payload.toExchangeRate()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid exchange rate data")
+		return
+	}
+
+	result, err := h.db.Exec(
+		"UPDATE exchange_rates SET date = ?, from_currency = ?, to_currency = ?, rate = ? WHERE id = ?",
+		rate.Date, rate.From, rate.To, rate.Rate, id,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update exchange rate")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Exchange rate not found")
+		return
+	}
+
+	rate.ID = id
+	c.JSON(http.StatusOK, rate)
+}
+
+// DeleteExchangeRate deletes a specific rate
+func (h *ExchangeRateHandler) DeleteExchangeRate(c *gin.Context) {
+	id := c.Param("id")
+
+	result, err := h.db.Exec("DELETE FROM exchange_rates WHERE id = ?", id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete exchange rate")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Exchange rate not found")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ImportExchangeRates loads rates from an uploaded CSV file with the columns
+// date,from,to,rate. A header row is optional. Existing rates for the same
+// pair and date are overwritten. The whole file is imported or nothing is.
+func (h *ExchangeRateHandler) ImportExchangeRates(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		respondFormError(c, err, h.maxUploadSize, "File is required")
+		return
+	}
+	if fileHeader.Size > h.maxUploadSize {
+		respondTooLarge(c, h.maxUploadSize)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var rates []models.ExchangeRate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid CSV file")
+			return
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			continue
+		}
+
+		payload := exchangeRatePayload{Date: record[0], From: record[1], To: record[2], Rate: models.Decimal(record[3])}
+		rate, err := payload.toExchangeRate()
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, fmt.Sprintf("Invalid exchange rate on line %d", line))
+			return
+		}
+		rates = append(rates, rate)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		_, err = tx.Exec(`
+			INSERT INTO exchange_rates (id, date, from_currency, to_currency, rate, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (date, from_currency, to_currency) DO UPDATE SET rate = excluded.rate
+		`, uuid.New().String(), rate.Date, rate.From, rate.To, rate.Rate, time.Now())
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to import exchange rates")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Exchange rates imported successfully",
+		"imported": len(rates),
+	})
+}
+
+// ratePoint is a single rate effective from a date
+type ratePoint struct {
+	date time.Time
+	rate *big.Rat
+}
+
+// currencyConverter converts payment amounts into a base currency using the
+// rate effective on each payment's date. Amounts that cannot be converted
+// because no rate exists are collected in unconverted.
+type currencyConverter struct {
+	base        string
+	rates       map[[2]string][]ratePoint
+	unconverted models.Totals
+}
+
+// newCurrencyConverter loads all exchange rates into memory
+func newCurrencyConverter(db *sql.DB, base string) (*currencyConverter, error) {
+	rows, err := db.Query("SELECT date, from_currency, to_currency, rate FROM exchange_rates ORDER BY date")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cv := &currencyConverter{
+		base:        base,
+		rates:       make(map[[2]string][]ratePoint),
+		unconverted: models.Totals{},
+	}
+	for rows.Next() {
+		var date time.Time
+		var from, to, rateStr string
+		if err := rows.Scan(&date, &from, &to, &rateStr); err != nil {
+			return nil, err
+		}
+		rate, err := models.ParseRate(rateStr)
+		if err != nil {
+			return nil, fmt.Errorf("exchange rate %s/%s on %s: %w", from, to, date.Format("2006-01-02"), err)
+		}
+		key := [2]string{from, to}
+		cv.rates[key] = append(cv.rates[key], ratePoint{date: date, rate: rate})
+	}
+
+	return cv, rows.Err()
+}
+
+// rateOn returns the most recent rate for the pair on or before date. An
+// inverse rate is used when only the opposite direction is recorded.
+func (cv *currencyConverter) rateOn(from, to string, date time.Time) (*big.Rat, bool) {
+	if rate, ok := latestRate(cv.rates[[2]string{from, to}], date); ok {
+		return rate, true
+	}
+	if rate, ok := latestRate(cv.rates[[2]string{to, from}], date); ok {
+		return new(big.Rat).Inv(rate), true
+	}
+	return nil, false
+}
+
+func latestRate(points []ratePoint, date time.Time) (*big.Rat, bool) {
+	day := date.Format("2006-01-02")
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].date.Format("2006-01-02") > day
+	})
+	if i == 0 {
+		return nil, false
+	}
+	return points[i-1].rate, true
+}
+
+// convert returns the amount in the base currency. If no rate is available
+// the amount is recorded in unconverted and ok is false.
+func (cv *currencyConverter) convert(minor int64, currency string, date time.Time) (int64, bool) {
+	if currency == cv.base {
+		return minor, true
+	}
+	rate, ok := cv.rateOn(currency, cv.base, date)
+	if !ok {
+		cv.unconverted.Add(currency, minor)
+		return 0, false
+	}
+	return models.NewMoney(minor, currency).Convert(rate, cv.base).Minor, true
+}
+
+// money wraps minor units of the base currency
+func (cv *currencyConverter) money(minor int64) models.Money {
+	return models.NewMoney(minor, cv.base)
+}
+
+// baseCurrency returns the reporting currency for a request, taken from the
+// "currency" query parameter or the configured BaseCurrency.
+func baseCurrency(c *gin.Context) (string, error) {
+	if currency := c.Query("currency"); currency != "" {
+		return models.NormalizeCurrency(currency)
+	}
+	return models.NormalizeCurrency(models.BaseCurrency)
+}
+
+// requestConverter builds a converter for the request's reporting currency,
+// responding with an error if that fails.
+func requestConverter(c *gin.Context, db *sql.DB) (*currencyConverter, bool) {
+	base, err := baseCurrency(c)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid currency")
+		return nil, false
+	}
+
+	cv, err := newCurrencyConverter(db, base)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to load exchange rates")
+		return nil, false
+	}
+	return cv, true
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImportExchangeRatesSizeLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewExchangeRateHandler(newTestDB(t), 64)
+	router := gin.New()
+	router.POST("/import", limitUploadSize(h.maxUploadSize), h.ImportExchangeRates)
+
+	post := func(data string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile("file", "rates.csv")
+		part.Write([]byte(data))
+		w.Close()
+		req := httptest.NewRequest("POST", "/import", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("date,from,to,rate\n2024-01-01,USD,EUR,0.9\n"); rec.Code != http.StatusOK {
+		t.Errorf("small file = %d %s, want 200", rec.Code, rec.Body)
+	}
+	// Larger than the limit, but within the room left for the form
+	if rec := post(strings.Repeat("2024-01-01,USD,EUR,0.9\n", 10)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("file over the limit = %d, want 413", rec.Code)
+	}
+	// Cut off while the form is read
+	if rec := post(strings.Repeat("2024-01-01,USD,EUR,0.9\n", 100000)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit = %d, want 413", rec.Code)
+	}
+}
+
+func TestListExchangeRatesDateRangeIsInclusive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	h := NewExchangeRateHandler(db, 1<<20)
+	router := gin.New()
+	router.POST("/import", h.ImportExchangeRates)
+	router.GET("/", h.ListExchangeRates)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "rates.csv")
+	part.Write([]byte("2024-01-01,USD,EUR,0.9\n2024-01-02,USD,EUR,0.91\n2024-01-03,USD,EUR,0.92\n"))
+	w.Close()
+	req := httptest.NewRequest("POST", "/import", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/?start_date=2024-01-02&end_date=2024-01-02", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list = %d %s", rec.Code, rec.Body)
+	}
+	if got := strings.Count(rec.Body.String(), `"rate":`); got != 1 || !strings.Contains(rec.Body.String(), `"0.91"`) {
+		t.Errorf("rates for 2024-01-02 = %s, want only that day's rate", rec.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"expense_tracker/internal/filetype"
+	"expense_tracker/internal/storage"
+	"expense_tracker/internal/utils"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signedURLExpiry is how long download redirects to the storage backend
+// stay valid
+const signedURLExpiry = 15 * time.Minute
+
+// multipartOverhead is the room left for form fields and multipart framing
+// on top of the largest file allowed in an upload request
+const multipartOverhead = 1 << 20
+
+var (
+	errFileTooLarge        = errors.New("file too large")
+	errUnsupportedFileType = errors.New("unsupported file type")
+)
+
+// limitUploadSize is middleware capping the request body of upload routes
+// so oversized uploads are cut off while they are read
+func limitUploadSize(maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+		c.Next()
+	}
+}
+
+// respondFormError responds to a failure to read an upload form: 413 if the
+// body was cut off by limitUploadSize, otherwise 400 with details
+func respondFormError(c *gin.Context, err error, maxSize int64, details string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondTooLarge(c, maxSize)
+		return
+	}
+	utils.RespondWithError(c, http.StatusBadRequest, err, details)
+}
+
+func respondTooLarge(c *gin.Context, maxSize int64) {
+	utils.RespondWithError(c, http.StatusRequestEntityTooLarge, errFileTooLarge, fmt.Sprintf("Files may be at most %d bytes", maxSize))
+}
+
+// checkUpload detects an uploaded file's type from its content. It responds
+// with 413 if the file is larger than maxSize or 415 if its type is not
+// allowed, and returns false in either case.
+func checkUpload(c *gin.Context, file *multipart.FileHeader, maxSize int64) (string, bool) {
+	if file.Size > maxSize {
+		respondTooLarge(c, maxSize)
+		return "", false
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read uploaded file")
+		return "", false
+	}
+	defer src.Close()
+
+	contentType, err := filetype.Detect(src, file.Size, file.Filename)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read uploaded file")
+		return "", false
+	}
+	if !filetype.Allowed(contentType) {
+		utils.RespondWithError(c, http.StatusUnsupportedMediaType, errUnsupportedFileType,
+			fmt.Sprintf("Files of type %s are not accepted; upload a PDF, image, text file or office document", contentType))
+		return "", false
+	}
+	return contentType, true
+}
+
+// serveFile responds with the file stored under key. Backends that support
+// signed URLs get a redirect so the file doesn't pass through the API.
+// Files with a content hash use it as their ETag.
+func serveFile(c *gin.Context, store storage.Storage, key, hash string) {
+	ctx := c.Request.Context()
+
+	url, err := store.SignedURL(ctx, key, signedURLExpiry)
+	if err == nil {
+		c.Redirect(http.StatusFound, url)
+		return
+	} else if !errors.Is(err, storage.ErrSignedURLUnsupported) {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to sign file URL")
+		return
+	}
+
+	file, info, err := store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RespondWithError(c, http.StatusNotFound, err, "File not found in storage")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	if hash != "" {
+		c.Header("ETag", `"`+hash+`"`)
+	}
+
+	// Local files can seek, which gives range and conditional requests
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		c.Header("Content-Type", info.ContentType)
+		http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, file, nil)
+}
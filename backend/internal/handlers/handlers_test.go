@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"database/sql"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/database"
+	"expense_tracker/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createTestWorkspace inserts an empty workspace and returns its ID
+func createTestWorkspace(t *testing.T, db *sql.DB, id string) string {
+	t.Helper()
+	now := time.Now()
+	if _, err := db.Exec("INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)", id, id, now, now); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// newTestRouter returns a router whose requests are made by a user with
+// role in the workspace, through an API token limited to scopes if any are
+// given
+func newTestRouter(workspaceID string, role auth.Role, scopes ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(currentUserKey, models.User{ID: "user", Email: "user@example.com"})
+		c.Set(currentWorkspaceKey, workspaceID)
+		c.Set(currentRoleKey, role)
+		if len(scopes) > 0 {
+			c.Set(tokenScopesKey, scopes)
+		}
+	})
+	return router
+}
+
+// serve sends a request with an optional JSON body to the router
+func serve(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createTestPayment inserts a payment of amount minor units in EUR dated
+// date and returns its ID
+func createTestPayment(t *testing.T, db *sql.DB, workspaceID, id, info string, amount int64, date string) string {
+	t.Helper()
+	datePaid, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	_, err = db.Exec(`
+		INSERT INTO payments (id, info, amount_minor, currency, date_paid, fingerprint, workspace_id, created_at, updated_at)
+		VALUES (?, ?, ?, 'EUR', ?, ?, ?, ?, ?)
+	`, id, info, amount, datePaid, models.Fingerprint(info, amount, "EUR"), workspaceID, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
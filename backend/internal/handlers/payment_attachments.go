@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// attachmentKinds are the accepted values of an attachment's kind
+var attachmentKinds = map[string]bool{
+	models.AttachmentInvoice: true,
+	models.AttachmentReceipt: true,
+	models.AttachmentOther:   true,
+}
+
+const attachmentColumns = `id, payment_id, kind, file_path, COALESCE(file_hash, ''), original_name, file_size, COALESCE(content_type, ''), created_at`
+
+func scanAttachment(row rowScanner, a *models.PaymentAttachment) error {
+	return row.Scan(&a.ID, &a.PaymentID, &a.Kind, &a.FilePath, &a.FileHash, &a.OriginalName, &a.FileSize, &a.ContentType, &a.CreatedAt)
+}
+
+// listAttachments returns the files attached to a payment, oldest first
+func listAttachments(db querier, paymentID string) ([]models.PaymentAttachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM payment_attachments WHERE payment_id = ? ORDER BY created_at, id", paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]models.PaymentAttachment, 0)
+	for rows.Next() {
+		var a models.PaymentAttachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// fetchAttachment loads an attachment of a live payment in the workspace
+func fetchAttachment(db queryRower, workspaceID, paymentID, id string) (models.PaymentAttachment, error) {
+	var a models.PaymentAttachment
+	err := scanAttachment(db.QueryRow(`
+		SELECT `+attachmentColumns+` FROM payment_attachments
+		WHERE id = ? AND payment_id = ? AND payment_id IN (
+			SELECT id FROM payments WHERE workspace_id = ? AND deleted_at IS NULL
+		)
+	`, id, paymentID, workspaceID), &a)
+	return a, err
+}
+
+// ListAttachments returns the files attached to a payment
+func (h *PaymentHandler) ListAttachments(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := fetchPayment(h.db, workspaceID(c), id); err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	attachments, err := listAttachments(h.db, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch attachments")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// AddAttachment attaches an uploaded file (multipart field "file") of the
+// given kind, "other" by default, to a payment
+func (h *PaymentHandler) AddAttachment(c *gin.Context) {
+	h.addAttachment(c, "file", c.DefaultPostForm("kind", models.AttachmentOther))
+}
+
+// UploadInvoice attaches an uploaded invoice (multipart field "invoice") to
+// a payment, alongside any invoices it already has
+func (h *PaymentHandler) UploadInvoice(c *gin.Context) {
+	h.addAttachment(c, "invoice", models.AttachmentInvoice)
+}
+
+func (h *PaymentHandler) addAttachment(c *gin.Context, field, kind string) {
+	id := c.Param("id")
+
+	file, err := c.FormFile(field)
+	if err != nil {
+		respondFormError(c, err, h.maxUploadSize, "No file uploaded")
+		return
+	}
+	if !attachmentKinds[kind] {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("kind must be invoice, receipt or other"), "Invalid attachment kind")
+		return
+	}
+	fileType, ok := checkUpload(c, file, h.maxUploadSize)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := fetchPayment(tx, workspaceID(c), id); err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	// Save file, or share the stored copy of identical content
+	stored, discard, err := putBlob(c, h.db, tx, h.storage, file, fileType)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save file")
+		return
+	}
+	defer discard()
+
+	attachment := models.PaymentAttachment{
+		ID:           uuid.New().String(),
+		PaymentID:    id,
+		Kind:         kind,
+		FilePath:     stored.Key,
+		FileHash:     stored.Hash,
+		OriginalName: file.Filename,
+		FileSize:     file.Size,
+		ContentType:  fileType,
+		CreatedAt:    time.Now(),
+	}
+	if err := insertAttachment(tx, attachment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create attachment")
+		return
+	}
+
+	if err := indexPayment(tx, id); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityAttachment, attachment.ID, audit.ActionCreate, nil, attachment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// insertAttachment records an attachment whose file is already stored and
+// referenced
+func insertAttachment(tx *sql.Tx, a models.PaymentAttachment) error {
+	_, err := tx.Exec(`
+		INSERT INTO payment_attachments (id, payment_id, kind, file_path, file_hash, original_name, file_size, content_type, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.ID, a.PaymentID, a.Kind, a.FilePath, a.FileHash, a.OriginalName, a.FileSize, a.ContentType, a.CreatedAt)
+	return err
+}
+
+// DeleteAttachment removes a file from a payment, deleting it from storage
+// if no other record shares it
+func (h *PaymentHandler) DeleteAttachment(c *gin.Context) {
+	id := c.Param("id")
+	attachmentID := c.Param("attachmentId")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	attachment, err := fetchAttachment(tx, workspaceID(c), id, attachmentID)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Attachment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch attachment")
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM payment_attachments WHERE id = ?", attachmentID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete attachment")
+		return
+	}
+
+	released, err := releaseFile(tx, attachment.FileHash, attachment.FilePath)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to release file")
+		return
+	}
+
+	if err := indexPayment(tx, id); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityAttachment, attachmentID, audit.ActionDelete, attachment, nil); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+	deleteFiles(c.Request.Context(), h.storage, released)
+
+	c.Status(http.StatusNoContent)
+}
+
+// DownloadAttachment serves an attached file
+func (h *PaymentHandler) DownloadAttachment(c *gin.Context) {
+	attachment, err := fetchAttachment(h.db, workspaceID(c), c.Param("id"), c.Param("attachmentId"))
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Attachment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch attachment")
+		return
+	}
+
+	serveFile(c, h.storage, attachment.FilePath, attachment.FileHash)
+}
+
+// GetAttachmentText returns the text extracted from an attached file and
+// how far extraction has got
+func (h *PaymentHandler) GetAttachmentText(c *gin.Context) {
+	attachment, err := fetchAttachment(h.db, workspaceID(c), c.Param("id"), c.Param("attachmentId"))
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Attachment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch attachment")
+		return
+	}
+
+	text, err := fetchExtractedText(h.db, attachment.FileHash)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch extracted text")
+		return
+	}
+
+	c.JSON(http.StatusOK, text)
+}
+
+// DownloadInvoice serves the most recently attached invoice of a payment
+func (h *PaymentHandler) DownloadInvoice(c *gin.Context) {
+	id := c.Param("id")
+
+	var attachment models.PaymentAttachment
+	err := scanAttachment(h.db.QueryRow(`
+		SELECT `+attachmentColumns+` FROM payment_attachments
+		WHERE payment_id = ? AND kind = ? AND payment_id IN (
+			SELECT id FROM payments WHERE workspace_id = ? AND deleted_at IS NULL
+		)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, id, models.AttachmentInvoice, workspaceID(c)), &attachment)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Invoice not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch invoice")
+		return
+	}
+
+	serveFile(c, h.storage, attachment.FilePath, attachment.FileHash)
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/recurring"
+	"expense_tracker/internal/utils"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Duplicate handling policies for creating and importing payments
+const (
+	duplicateAllow  = "allow"
+	duplicateFlag   = "flag"
+	duplicateReject = "reject"
+)
+
+// duplicateWindowDays is how many days apart two payments with the same
+// fingerprint may be and still count as duplicates
+const duplicateWindowDays = 3
+
+var errDuplicatePayment = errors.New("payment is a probable duplicate")
+
+// parseDuplicatePolicy validates an onDuplicate option, defaulting to allow
+func parseDuplicatePolicy(value string) (string, error) {
+	switch value {
+	case "":
+		return duplicateAllow, nil
+	case duplicateAllow, duplicateFlag, duplicateReject:
+		return value, nil
+	}
+	return "", fmt.Errorf("onDuplicate must be %s, %s or %s", duplicateAllow, duplicateFlag, duplicateReject)
+}
+
+// findDuplicate returns the ID of an existing payment in the payment's
+// workspace with the same fingerprint dated within the duplicate window, or
+// "" if there is none
+func findDuplicate(db querier, payment models.Payment) (string, error) {
+	rows, err := db.Query(
+		"SELECT id, date_paid FROM payments WHERE workspace_id = ? AND fingerprint = ? AND id != ? AND deleted_at IS NULL ORDER BY created_at",
+		payment.WorkspaceID, models.Fingerprint(payment.Info, payment.Amount.Minor, payment.Currency), payment.ID,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var datePaid time.Time
+		if err := rows.Scan(&id, &datePaid); err != nil {
+			return "", err
+		}
+		if withinDuplicateWindow(datePaid, payment.DatePaid, duplicateWindowDays) {
+			return id, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// withinDuplicateWindow reports whether two dates are at most window days apart
+func withinDuplicateWindow(a, b time.Time, window int) bool {
+	days := recurring.Day(a).Sub(recurring.Day(b)).Hours() / 24
+	return days <= float64(window) && days >= -float64(window)
+}
+
+// ListDuplicates returns clusters of payments that share a fingerprint and
+// whose dates are chained within the window (the `window` query parameter,
+// in days)
+func (h *PaymentHandler) ListDuplicates(c *gin.Context) {
+	window := utils.ParseIntWithDefault(c.Query("window"), duplicateWindowDays)
+	if window < 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("window must not be negative"), "Invalid window")
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT
+			`+paymentColumns+`,
+			p.fingerprint,
+			GROUP_CONCAT(pt.tag_id) as tag_ids
+		FROM payments p
+		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
+		WHERE p.workspace_id = ? AND p.deleted_at IS NULL AND p.fingerprint IN (
+			SELECT fingerprint FROM payments WHERE workspace_id = ? AND deleted_at IS NULL GROUP BY fingerprint HAVING COUNT(*) > 1
+		)
+		GROUP BY p.id
+		ORDER BY p.fingerprint, p.date_paid, p.created_at
+	`, workspaceID(c), workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
+		return
+	}
+	defer rows.Close()
+
+	type cluster struct {
+		Fingerprint string           `json:"fingerprint"`
+		Payments    []models.Payment `json:"payments"`
+	}
+
+	clusters := make([]cluster, 0)
+	var current *cluster
+	flush := func() {
+		if current != nil && len(current.Payments) > 1 {
+			clusters = append(clusters, *current)
+		}
+		current = nil
+	}
+
+	for rows.Next() {
+		var p models.Payment
+		var fingerprint string
+		var tagIDs sql.NullString
+		if err := scanPayment(rows, &p, &fingerprint, &tagIDs); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment")
+			return
+		}
+		p.Tags = []string{}
+		if tagIDs.Valid {
+			p.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+
+		// Rows are ordered by date within a fingerprint, so a cluster ends
+		// at the first gap wider than the window
+		if current != nil {
+			last := current.Payments[len(current.Payments)-1]
+			if current.Fingerprint != fingerprint || !withinDuplicateWindow(last.DatePaid, p.DatePaid, window) {
+				flush()
+			}
+		}
+		if current == nil {
+			current = &cluster{Fingerprint: fingerprint}
+		}
+		current.Payments = append(current.Payments, p)
+	}
+	flush()
+
+	if err := rows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window":   window,
+		"clusters": clusters,
+		"total":    len(clusters),
+	})
+}
+
+// MergePayments merges the payment given as paymentId in the body into the
+// payment in the URL. The kept payment gains the other's tags and
+// attachments, and its recurring occurrence and bank transaction ID if it
+// has none.
+// The other payment's instalments are moved over only when the kept
+// payment has none, so a duplicated expense isn't counted as paid twice.
+// The other payment is then moved to the trash, keeping its tags and any
+// instalments that were not moved.
+func (h *PaymentHandler) MergePayments(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		PaymentID string `json:"paymentId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid merge request")
+		return
+	}
+	if req.PaymentID == id {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("cannot merge a payment into itself"), "Invalid merge request")
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	kept, err := fetchPayment(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	merged, err := fetchPayment(tx, workspaceID(c), req.PaymentID)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment to merge not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO payment_tags (payment_id, tag_id)
+		SELECT ?, tag_id FROM payment_tags WHERE payment_id = ?
+	`, kept.ID, merged.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to merge tags")
+		return
+	}
+
+	if kept.PaidAmount.Minor == 0 {
+		_, err = tx.Exec("UPDATE payment_transactions SET payment_id = ? WHERE payment_id = ?", kept.ID, merged.ID)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to merge payment transactions")
+			return
+		}
+	}
+
+	// Payments flagged against the merged payment now point at the kept one
+	_, err = tx.Exec("UPDATE payments SET duplicate_of = ? WHERE duplicate_of = ? AND id != ?", kept.ID, merged.ID, kept.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update duplicate flags")
+		return
+	}
+
+	_, err = tx.Exec("UPDATE payment_attachments SET payment_id = ? WHERE payment_id = ?", kept.ID, merged.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to merge attachments")
+		return
+	}
+
+	// Keep the recurring occurrence link, bank transaction ID and vendor if
+	// only the merged payment had them. The kept payment is no longer a
+	// duplicate of the payment it absorbed.
+	recurringID, occurrenceDate := kept.RecurringPaymentID, kept.OccurrenceDate
+	takeOccurrence := recurringID == "" && merged.RecurringPaymentID != ""
+	if takeOccurrence {
+		recurringID, occurrenceDate = merged.RecurringPaymentID, merged.OccurrenceDate
+	}
+	externalID := kept.ExternalID
+	takeExternalID := externalID == "" && merged.ExternalID != ""
+	if takeExternalID {
+		externalID = merged.ExternalID
+	}
+
+	// Move the merged payment to the trash. The links handed to the kept
+	// payment are unique, so the trashed payment gives them up.
+	_, err = tx.Exec(`
+		UPDATE payments
+		SET deleted_at = ?,
+			recurring_payment_id = CASE WHEN ? THEN NULL ELSE recurring_payment_id END,
+			occurrence_date = CASE WHEN ? THEN NULL ELSE occurrence_date END,
+			external_id = CASE WHEN ? THEN NULL ELSE external_id END
+		WHERE id = ?
+	`, time.Now(), takeOccurrence, takeOccurrence, takeExternalID, merged.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete merged payment")
+		return
+	}
+	vendorID := kept.VendorID
+	if vendorID == "" {
+		vendorID = merged.VendorID
+	}
+	duplicateOf := kept.DuplicateOf
+	if duplicateOf == merged.ID {
+		duplicateOf = ""
+	}
+	_, err = tx.Exec(`
+		UPDATE payments
+		SET recurring_payment_id = NULLIF(?, ''), occurrence_date = ?,
+			duplicate_of = NULLIF(?, ''), external_id = NULLIF(?, ''), vendor_id = NULLIF(?, ''), updated_at = ?
+		WHERE id = ?
+	`, recurringID, occurrenceDate, duplicateOf, externalID, vendorID, time.Now(), kept.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment")
+		return
+	}
+
+	if err := syncFullyPaid(tx, kept.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment status")
+		return
+	}
+
+	// The kept payment's entry gains the merged payment's attachments
+	err = indexPayment(tx, kept.ID)
+	if err == nil {
+		err = indexPayment(tx, merged.ID)
+	}
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	updated, err := fetchPayment(tx, workspaceID(c), kept.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	a := auditorFor(c)
+	err = a.record(tx, audit.EntityPayment, merged.ID, audit.ActionDelete, merged, nil)
+	if err == nil {
+		err = a.record(tx, audit.EntityPayment, kept.ID, audit.ActionUpdate, kept, updated)
+	}
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/invoiceparse"
+	"net/http"
+	"testing"
+)
+
+func TestMergeDuplicatePayments(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestPayment(t, db, workspaceID, "kept", "Coffee Shop", 450, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "dupe", "coffee  shop", 450, "2024-03-02")
+	createTestPayment(t, db, workspaceID, "later", "Coffee Shop", 450, "2024-04-01")
+	for _, query := range []string{
+		"INSERT INTO tags (id, name, color, workspace_id) VALUES ('food', 'Food', '#00ff00', 'ws')",
+		"INSERT INTO payment_tags (payment_id, tag_id) VALUES ('dupe', 'food')",
+		"INSERT INTO payment_transactions (id, payment_id, amount_minor, date_paid) VALUES ('tx1', 'dupe', 450, '2024-03-02')",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewPaymentHandler(db, nil, 1<<20, invoiceparse.Rules{})
+	router := newTestRouter(workspaceID, auth.RoleEditor)
+	h.RegisterRoutes(router.Group(""))
+
+	listClusters := func() [][]string {
+		t.Helper()
+		w := serve(router, "GET", "/payments/duplicates", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("list duplicates = %d %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Clusters []struct {
+				Payments []struct {
+					ID string `json:"id"`
+				} `json:"payments"`
+			} `json:"clusters"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var clusters [][]string
+		for _, c := range resp.Clusters {
+			var ids []string
+			for _, p := range c.Payments {
+				ids = append(ids, p.ID)
+			}
+			clusters = append(clusters, ids)
+		}
+		return clusters
+	}
+
+	// The payment a month later is outside the window
+	if clusters := listClusters(); len(clusters) != 1 || len(clusters[0]) != 2 || clusters[0][0] != "kept" || clusters[0][1] != "dupe" {
+		t.Fatalf("clusters = %v, want [[kept dupe]]", clusters)
+	}
+
+	if w := serve(router, "POST", "/payments/kept/merge", `{"paymentId": "kept"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("merge into itself = %d, want 400", w.Code)
+	}
+	w := serve(router, "POST", "/payments/kept/merge", `{"paymentId": "dupe"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge = %d %s", w.Code, w.Body)
+	}
+	var kept struct {
+		Tags      []string `json:"tags"`
+		FullyPaid bool     `json:"fullyPaid"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &kept); err != nil {
+		t.Fatal(err)
+	}
+	if len(kept.Tags) != 1 || kept.Tags[0] != "food" || !kept.FullyPaid {
+		t.Errorf("kept payment = %+v, want the food tag and the moved instalment", kept)
+	}
+
+	// The merged payment is in the trash, not gone
+	var trashed bool
+	if err := db.QueryRow("SELECT deleted_at IS NOT NULL FROM payments WHERE id = 'dupe'").Scan(&trashed); err != nil {
+		t.Fatalf("merged payment: %v", err)
+	}
+	if !trashed {
+		t.Error("merged payment was not moved to the trash")
+	}
+	if clusters := listClusters(); len(clusters) != 0 {
+		t.Errorf("clusters after merge = %v, want none", clusters)
+	}
+	if w := serve(router, "POST", "/payments/kept/merge", `{"paymentId": "dupe"}`); w.Code != http.StatusNotFound {
+		t.Errorf("merging a trashed payment = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"expense_tracker/internal/xlsx"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportColumns is the header row of CSV and XLSX exports
+var exportColumns = []string{
+	"ID", "Date Paid", "Info", "Amount", "Currency", "Paid Amount", "Outstanding", "Fully Paid", "Tags",
+}
+
+// exportedPayment is a payment as written by ExportPayments, with tag names
+// instead of IDs
+type exportedPayment struct {
+	ID          string       `json:"id"`
+	DatePaid    string       `json:"datePaid"`
+	Info        string       `json:"info"`
+	Amount      models.Money `json:"amount"`
+	Currency    string       `json:"currency"`
+	PaidAmount  models.Money `json:"paidAmount"`
+	Outstanding models.Money `json:"outstanding"`
+	FullyPaid   bool         `json:"fullyPaid"`
+	Tags        []string     `json:"tags"`
+}
+
+// paymentExporter writes exported payments in one format
+type paymentExporter interface {
+	write(p exportedPayment) error
+	close() error
+}
+
+// ExportPayments streams every payment of the workspace matching the
+// ListPayments filters (tag, start_date, end_date, fully_paid) as csv, xlsx
+// or json, oldest first
+func (h *PaymentHandler) ExportPayments(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case "json":
+		contentType = "application/json; charset=utf-8"
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("format must be csv, xlsx or json"), "Unsupported export format")
+		return
+	}
+
+	tagNames, err := loadTagNames(h.db, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tags")
+		return
+	}
+
+	query := `
+		SELECT
+			` + paymentColumns + `,
+			GROUP_CONCAT(pt.tag_id) as tag_ids
+		FROM payments p
+		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
+	`
+	whereClause, params := paymentFilters(c)
+	if len(whereClause) > 0 {
+		query += " WHERE " + utils.JoinWithAND(whereClause)
+	}
+	query += " GROUP BY p.id ORDER BY p.date_paid, p.created_at"
+
+	rows, err := h.db.Query(query, params...)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(c, format)))
+	c.Status(http.StatusOK)
+
+	var exporter paymentExporter
+	switch format {
+	case "csv":
+		exporter, err = newCSVExporter(c.Writer)
+	case "xlsx":
+		exporter, err = newXLSXExporter(c.Writer)
+	case "json":
+		exporter, err = newJSONExporter(c.Writer)
+	}
+
+	// Headers are already sent, so failures from here on can only be logged
+	// and the response cut short
+	if err == nil {
+		for rows.Next() {
+			var p models.Payment
+			var tagIDs sql.NullString
+			if err = scanPayment(rows, &p, &tagIDs); err != nil {
+				break
+			}
+
+			names := []string{}
+			if tagIDs.Valid {
+				for _, id := range utils.SplitCommaString(tagIDs.String) {
+					names = append(names, tagNames[id])
+				}
+			}
+
+			err = exporter.write(exportedPayment{
+				ID:          p.ID,
+				DatePaid:    p.DatePaid.Format("2006-01-02"),
+				Info:        p.Info,
+				Amount:      p.Amount,
+				Currency:    p.Currency,
+				PaidAmount:  p.PaidAmount,
+				Outstanding: p.Outstanding,
+				FullyPaid:   p.FullyPaid,
+				Tags:        names,
+			})
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		if err == nil {
+			err = exporter.close()
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to export payments: %v", err)
+		c.Abort()
+	}
+}
+
+// loadTagNames maps the workspace's tag IDs to names
+func loadTagNames(db *sql.DB, workspaceID string) (map[string]string, error) {
+	rows, err := db.Query("SELECT id, name FROM tags WHERE workspace_id = ?", workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// exportFilename names the download after the requested date range, or
+// today's date when there is none
+func exportFilename(c *gin.Context, format string) string {
+	start, end := c.Query("start_date"), c.Query("end_date")
+	var name string
+	switch {
+	case start != "" && end != "":
+		name = "payments_" + start + "_to_" + end
+	case start != "":
+		name = "payments_from_" + start
+	case end != "":
+		name = "payments_to_" + end
+	default:
+		name = "payments_" + time.Now().Format("2006-01-02")
+	}
+	// Keep the name safe to quote in the header
+	name = strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r == '/' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return name + "." + format
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w http.ResponseWriter) (*csvExporter, error) {
+	e := &csvExporter{w: csv.NewWriter(w)}
+	return e, e.w.Write(exportColumns)
+}
+
+func (e *csvExporter) write(p exportedPayment) error {
+	return e.w.Write([]string{
+		p.ID, p.DatePaid, p.Info, p.Amount.String(), p.Currency,
+		p.PaidAmount.String(), p.Outstanding.String(), fmt.Sprint(p.FullyPaid),
+		strings.Join(p.Tags, "; "),
+	})
+}
+
+func (e *csvExporter) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type xlsxExporter struct {
+	w *xlsx.Writer
+}
+
+func newXLSXExporter(w http.ResponseWriter) (*xlsxExporter, error) {
+	xw, err := xlsx.NewWriter(w, "Payments")
+	if err != nil {
+		return nil, err
+	}
+	header := make([]interface{}, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column
+	}
+	return &xlsxExporter{w: xw}, xw.WriteRow(header...)
+}
+
+func (e *xlsxExporter) write(p exportedPayment) error {
+	return e.w.WriteRow(
+		p.ID, p.DatePaid, p.Info, xlsx.Number(p.Amount.String()), p.Currency,
+		xlsx.Number(p.PaidAmount.String()), xlsx.Number(p.Outstanding.String()), p.FullyPaid,
+		strings.Join(p.Tags, "; "),
+	)
+}
+
+func (e *xlsxExporter) close() error {
+	return e.w.Close()
+}
+
+// jsonExporter writes a JSON array one element at a time
+type jsonExporter struct {
+	w     http.ResponseWriter
+	count int
+}
+
+func newJSONExporter(w http.ResponseWriter) (*jsonExporter, error) {
+	_, err := w.Write([]byte("["))
+	return &jsonExporter{w: w}, err
+}
+
+func (e *jsonExporter) write(p exportedPayment) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		data = append([]byte(","), data...)
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExporter) close() error {
+	_, err := e.w.Write([]byte("]"))
+	return err
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/invoiceparse"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportPayments(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	otherID := createTestWorkspace(t, db, "other")
+	createTestPayment(t, db, workspaceID, "p2", "Groceries, weekly", 4550, "2024-03-05")
+	createTestPayment(t, db, workspaceID, "p1", "Rent", 85000, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "p3", "Rent", 85000, "2024-04-01")
+	createTestPayment(t, db, otherID, "o1", "Rent", 85000, "2024-03-01")
+	for _, query := range []string{
+		"INSERT INTO tags (id, name, color, workspace_id) VALUES ('food', 'Food', '#00ff00', 'ws')",
+		"INSERT INTO payment_tags (payment_id, tag_id) VALUES ('p2', 'food')",
+		"INSERT INTO payment_transactions (id, payment_id, amount_minor, date_paid) VALUES ('tx1', 'p2', 1000, '2024-03-05')",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewPaymentHandler(db, nil, 1<<20, invoiceparse.Rules{})
+	router := newTestRouter(workspaceID, auth.RoleAccountant)
+	h.RegisterRoutes(router.Group(""))
+
+	// CSV, oldest first, limited to the workspace and the date range
+	w := serve(router, "GET", "/payments/export?format=csv&start_date=2024-03-01&end_date=2024-03-31", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("csv export = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		exportColumns,
+		{"p1", "2024-03-01", "Rent", "850.00", "EUR", "0.00", "850.00", "false", ""},
+		{"p2", "2024-03-05", "Groceries, weekly", "45.50", "EUR", "10.00", "35.50", "false", "Food"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv export =\n%v\nwant\n%v", records, want)
+	}
+
+	// JSON takes the same filters
+	w = serve(router, "GET", "/payments/export?format=json&tag=food", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("json export = %d %s", w.Code, w.Body)
+	}
+	var exported []struct {
+		ID          string   `json:"id"`
+		Outstanding string   `json:"outstanding"`
+		Tags        []string `json:"tags"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("json export: %v\n%s", err, w.Body)
+	}
+	if len(exported) != 1 || exported[0].ID != "p2" || exported[0].Outstanding != "35.50" || len(exported[0].Tags) != 1 {
+		t.Errorf("json export = %+v, want p2 tagged Food with 35.50 outstanding", exported)
+	}
+
+	w = serve(router, "GET", "/payments/export?format=xlsx", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PK") {
+		t.Errorf("xlsx export = %d, want a zip file", w.Code)
+	}
+	if w := serve(router, "GET", "/payments/export?format=pdf", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("pdf export = %d, want 400", w.Code)
+	}
+
+	// Viewers can read payments but not export them
+	viewer := newTestRouter(workspaceID, auth.RoleViewer)
+	h.RegisterRoutes(viewer.Group(""))
+	if w := serve(viewer, "GET", "/payments/export", ""); w.Code != http.StatusForbidden {
+		t.Errorf("export as a viewer = %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/importer"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/tagrules"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Import modes
+const (
+	importDryRun = "dry_run"
+	importCommit = "commit"
+)
+
+// importTagColor is the color given to tags created by an import
+const importTagColor = "#9e9e9e"
+
+// Statement formats accepted by ImportPayments
+const (
+	formatCSV     = "csv"
+	formatOFX     = "ofx"
+	formatCAMT053 = "camt053"
+)
+
+// ImportPayments imports payments from an uploaded bank statement.
+//
+// The multipart form takes the statement as "file" and a "mode" of dry_run
+// (the default) or commit. "format" is csv, ofx (also used for QFX) or
+// camt053, and is otherwise guessed from the file extension. CSV files
+// need a JSON column "mapping"; OFX files use "currency" when they don't
+// declare one. A dry run only parses and validates. A commit inserts every
+// valid row in a single transaction and is refused if any row is invalid.
+// Skipped rows (income, or bank transactions that were already imported)
+// are never imported. "onDuplicate" works as on CreatePayment, checking
+// rows against existing payments and earlier rows of the same file.
+func (h *PaymentHandler) ImportPayments(c *gin.Context) {
+	mode := c.DefaultPostForm("mode", importDryRun)
+	if mode != importDryRun && mode != importCommit {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("mode must be dry_run or commit"), "Invalid import mode")
+		return
+	}
+
+	policy, err := parseDuplicatePolicy(c.PostForm("onDuplicate"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid duplicate policy")
+		return
+	}
+
+	fullyPaid := true
+	if value := c.PostForm("fullyPaid"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid fullyPaid value")
+			return
+		}
+		fullyPaid = parsed
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		respondFormError(c, err, h.maxUploadSize, "File is required")
+		return
+	}
+	if fileHeader.Size > h.maxUploadSize {
+		respondTooLarge(c, h.maxUploadSize)
+		return
+	}
+
+	format := strings.ToLower(c.PostForm("format"))
+	if format == "" {
+		format = statementFormat(fileHeader.Filename)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read file")
+		return
+	}
+	defer file.Close()
+
+	var rows []importer.Row
+	switch format {
+	case formatCSV:
+		var mapping importer.Mapping
+		if err := json.Unmarshal([]byte(c.PostForm("mapping")), &mapping); err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid column mapping")
+			return
+		}
+		rows, err = importer.ParseCSV(file, mapping)
+	case formatOFX, "qfx":
+		rows, err = importer.ParseOFX(file, c.PostForm("currency"))
+	case formatCAMT053:
+		rows, err = importer.ParseCAMT053(file)
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("format must be csv, ofx or camt053"), "Unsupported statement format")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid statement file")
+		return
+	}
+
+	h.respondWithImport(c, mode, rows, fullyPaid, policy)
+}
+
+// statementFormat guesses a statement's format from its file name
+func statementFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ofx", ".qfx":
+		return formatOFX
+	case ".xml":
+		return formatCAMT053
+	}
+	return formatCSV
+}
+
+// respondWithImport previews or commits parsed statement rows
+func (h *PaymentHandler) respondWithImport(c *gin.Context, mode string, rows []importer.Row, fullyPaid bool, policy string) {
+	workspaceID := workspaceID(c)
+	if mode == importDryRun {
+		if err := markImported(h.db, workspaceID, rows); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check for imported transactions")
+			return
+		}
+		if err := markDuplicates(h.db, workspaceID, rows, policy); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check for duplicates")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"mode": mode, "summary": importer.Summarize(rows), "rows": rows})
+		return
+	}
+
+	if summary := importer.Summarize(rows); summary.Invalid > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "Import contains invalid rows",
+			"details": "Fix or remove the invalid rows and try again; nothing was imported",
+			"mode":    mode,
+			"summary": summary,
+			"rows":    rows,
+		})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := markImported(tx, workspaceID, rows); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check for imported transactions")
+		return
+	}
+	if err := markDuplicates(tx, workspaceID, rows, policy); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check for duplicates")
+		return
+	}
+
+	payments, err := importRows(tx, auditorFor(c), rows, fullyPaid)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to import payments")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"mode":     mode,
+		"summary":  importer.Summarize(rows),
+		"rows":     rows,
+		"payments": payments,
+	})
+}
+
+// rowPayment builds the payment a statement row would create in the workspace
+func rowPayment(workspaceID string, row importer.Row, fullyPaid bool) models.Payment {
+	now := time.Now()
+	return models.Payment{
+		ID:          uuid.New().String(),
+		Info:        row.Info,
+		Amount:      row.Amount,
+		Currency:    row.Currency,
+		DatePaid:    row.DatePaid,
+		FullyPaid:   fullyPaid,
+		Tags:        []string{},
+		DuplicateOf: row.DuplicateOf,
+		ExternalID:  row.ExternalID,
+		WorkspaceID: workspaceID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// markImported skips rows whose bank transaction ID already belongs to a
+// payment of the workspace or to an earlier row, so importing a statement
+// twice is harmless
+func markImported(db querier, workspaceID string, rows []importer.Row) error {
+	seen := make(map[string]bool)
+	for i := range rows {
+		row := &rows[i]
+		if row.Status != importer.StatusValid || row.ExternalID == "" {
+			continue
+		}
+		if seen[row.ExternalID] {
+			row.MarkImported("")
+			continue
+		}
+		seen[row.ExternalID] = true
+
+		var id string
+		err := db.QueryRow("SELECT id FROM payments WHERE workspace_id = ? AND external_id = ?", workspaceID, row.ExternalID).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		row.MarkImported(id)
+	}
+	return nil
+}
+
+// markDuplicates flags valid rows that match an existing payment of the
+// workspace or an earlier row of the same import. Under the reject policy
+// they are marked as duplicates and won't be imported.
+func markDuplicates(db querier, workspaceID string, rows []importer.Row, policy string) error {
+	if policy == duplicateAllow {
+		return nil
+	}
+
+	seen := make(map[string][]importer.Row)
+	for i := range rows {
+		row := &rows[i]
+		if row.Status != importer.StatusValid {
+			continue
+		}
+
+		fingerprint := models.Fingerprint(row.Info, row.Amount.Minor, row.Currency)
+		duplicateOf, err := findDuplicate(db, rowPayment(workspaceID, *row, false))
+		if err != nil {
+			return err
+		}
+		duplicateLine := 0
+		if duplicateOf == "" {
+			for _, earlier := range seen[fingerprint] {
+				if withinDuplicateWindow(earlier.DatePaid, row.DatePaid, duplicateWindowDays) {
+					duplicateLine = earlier.Line
+					break
+				}
+			}
+		}
+		seen[fingerprint] = append(seen[fingerprint], *row)
+
+		if duplicateOf != "" || duplicateLine != 0 {
+			row.MarkDuplicate(duplicateOf, duplicateLine, policy == duplicateReject)
+		}
+	}
+	return nil
+}
+
+// importRows inserts the valid rows as payments of the auditor's workspace,
+// looking up tags by name and creating the ones that don't exist yet. The
+// workspace's tag rules run on them and vendors are resolved from the info.
+func importRows(tx *sql.Tx, a auditor, rows []importer.Row, fullyPaid bool) ([]models.Payment, error) {
+	rules, err := loadTagRules(tx, a.workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	vendors, err := loadVendorMatcher(tx, a.workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	tagIDs := make(map[string]string)
+	lineIDs := make(map[int]string)
+	payments := make([]models.Payment, 0, len(rows))
+
+	for _, row := range rows {
+		if row.Status != importer.StatusValid {
+			continue
+		}
+
+		payment := rowPayment(a.workspaceID, row, fullyPaid)
+		if row.DuplicateOfLine != 0 {
+			payment.DuplicateOf = lineIDs[row.DuplicateOfLine]
+		}
+		for _, name := range row.Tags {
+			id, err := lookupOrCreateTag(tx, a, tagIDs, name)
+			if err != nil {
+				return nil, err
+			}
+			payment.Tags = appendUnique(payment.Tags, id)
+		}
+		tagrules.ApplyAll(rules, &payment)
+		payment.VendorID = vendors.match(payment.Info)
+
+		if err := insertPayment(tx, &payment); err != nil {
+			return nil, err
+		}
+		if err := a.record(tx, audit.EntityPayment, payment.ID, audit.ActionCreate, nil, payment); err != nil {
+			return nil, err
+		}
+		lineIDs[row.Line] = payment.ID
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// lookupOrCreateTag returns the ID of the auditor's workspace's tag with
+// the given name, compared case-insensitively, creating it if needed.
+// Resolved IDs are cached in ids.
+func lookupOrCreateTag(tx *sql.Tx, a auditor, ids map[string]string, name string) (string, error) {
+	key := strings.ToLower(name)
+	if id, ok := ids[key]; ok {
+		return id, nil
+	}
+
+	var id string
+	err := tx.QueryRow(
+		"SELECT id FROM tags WHERE workspace_id = ? AND name = ? COLLATE NOCASE ORDER BY created_at LIMIT 1",
+		a.workspaceID, name,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		tag := models.Tag{ID: uuid.New().String(), Name: name, Color: importTagColor, CreatedAt: time.Now()}
+		_, err = tx.Exec(
+			"INSERT INTO tags (id, name, color, workspace_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			tag.ID, tag.Name, tag.Color, a.workspaceID, tag.CreatedAt,
+		)
+		if err == nil {
+			err = a.record(tx, audit.EntityTag, tag.ID, audit.ActionCreate, nil, tag)
+		}
+		id = tag.ID
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ids[key] = id
+	return id, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
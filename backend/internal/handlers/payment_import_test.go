@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"expense_tracker/internal/importer"
+	"testing"
+	"time"
+)
+
+func TestMarkImported(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	otherID := createTestWorkspace(t, db, "other")
+
+	now := time.Now()
+	for _, p := range []struct{ id, workspaceID, externalID string }{
+		{"p1", workspaceID, "acct:1"},
+		{"p2", otherID, "acct:2"},
+	} {
+		_, err := db.Exec(`
+			INSERT INTO payments (id, info, amount_minor, currency, date_paid, external_id, workspace_id, created_at, updated_at)
+			VALUES (?, 'Paid', 100, 'EUR', ?, ?, ?, ?, ?)
+		`, p.id, now, p.externalID, p.workspaceID, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := []importer.Row{
+		{Line: 1, Status: importer.StatusValid, ExternalID: "acct:1"},
+		{Line: 2, Status: importer.StatusValid, ExternalID: "acct:2"},
+		{Line: 3, Status: importer.StatusValid, ExternalID: "acct:2"},
+		{Line: 4, Status: importer.StatusValid},
+		{Line: 5, Status: importer.StatusValid},
+		{Line: 6, Status: importer.StatusInvalid, ExternalID: "acct:1"},
+	}
+	if err := markImported(db, workspaceID, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		status string
+		errors int
+	}{
+		// Already imported into this workspace
+		{importer.StatusSkipped, 1},
+		// Only imported into another workspace
+		{importer.StatusValid, 0},
+		// Repeats line 2 of the same file
+		{importer.StatusSkipped, 1},
+		// Rows without a transaction ID are never matched
+		{importer.StatusValid, 0},
+		{importer.StatusValid, 0},
+		// Invalid rows keep their status
+		{importer.StatusInvalid, 0},
+	}
+	for i, w := range want {
+		if rows[i].Status != w.status || len(rows[i].Errors) != w.errors {
+			t.Errorf("line %d: status %q errors %q, want %q with %d errors", rows[i].Line, rows[i].Status, rows[i].Errors, w.status, w.errors)
+		}
+	}
+
+	// Marking the same rows again must not change them
+	if err := markImported(db, workspaceID, rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows[0].Errors) != 1 || len(rows[2].Errors) != 1 {
+		t.Errorf("second markImported changed skipped rows: %+v", rows)
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/invoiceparse"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/textextract"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// invoiceDraftTTL is how long an unconfirmed invoice draft is kept
+const invoiceDraftTTL = 24 * time.Hour
+
+const invoiceDraftColumns = `id, info, amount, currency, date_paid, sources, original_name, file_size, content_type, file_path, file_hash, created_at`
+
+func scanInvoiceDraft(row rowScanner, d *models.InvoiceDraft) error {
+	var sources string
+	err := row.Scan(&d.ID, &d.Info, &d.Amount, &d.Currency, &d.DatePaid, &sources, &d.OriginalName, &d.FileSize, &d.ContentType, &d.FilePath, &d.FileHash, &d.CreatedAt)
+	if err != nil {
+		return err
+	}
+	d.ExpiresAt = d.CreatedAt.Add(invoiceDraftTTL)
+	return json.Unmarshal([]byte(sources), &d.Sources)
+}
+
+// fetchInvoiceDraft loads an unexpired draft of the workspace
+func fetchInvoiceDraft(db queryRower, workspaceID, id string, now time.Time) (models.InvoiceDraft, error) {
+	var d models.InvoiceDraft
+	err := scanInvoiceDraft(db.QueryRow(
+		"SELECT "+invoiceDraftColumns+" FROM invoice_drafts WHERE id = ? AND workspace_id = ? AND created_at >= ?",
+		id, workspaceID, now.Add(-invoiceDraftTTL),
+	), &d)
+	return d, err
+}
+
+// DraftFromInvoice reads an uploaded invoice (multipart field "file") into
+// a draft payment for the user to check. The vendor, total, currency and
+// date are read from the file's text with the server's rules, which a JSON
+// "rules" field can override for the upload. The file is stored right away
+// and attached to the payment once the draft is confirmed.
+func (h *PaymentHandler) DraftFromInvoice(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondFormError(c, err, h.maxUploadSize, "No file uploaded")
+		return
+	}
+	fileType, ok := checkUpload(c, file, h.maxUploadSize)
+	if !ok {
+		return
+	}
+
+	rules := h.invoiceRules
+	if raw := c.PostForm("rules"); raw != "" {
+		var override invoiceparse.Rules
+		if err := json.Unmarshal([]byte(raw), &override); err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid parsing rules")
+			return
+		}
+		rules = rules.Merge(override)
+	}
+	parser, err := invoiceparse.New(rules)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid parsing rules")
+		return
+	}
+
+	now := time.Now()
+	draft := models.InvoiceDraft{
+		ID:           uuid.New().String(),
+		Sources:      map[string]string{},
+		OriginalName: file.Filename,
+		FileSize:     file.Size,
+		ContentType:  fileType,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(invoiceDraftTTL),
+	}
+
+	// The text is extracted now rather than by the background worker so the
+	// draft can be filled in. Files without text give an empty draft.
+	text, textErr := "", textextract.ErrUnsupported
+	if textextract.Supported(fileType) {
+		src, err := file.Open()
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to read file")
+			return
+		}
+		text, textErr = extractText(src, fileType)
+		src.Close()
+	}
+	if textErr == nil {
+		parsed := parser.Parse(text)
+		draft.Info = parsed.Vendor
+		draft.Amount = parsed.Amount
+		draft.Currency = parsed.Currency
+		if !parsed.Date.IsZero() {
+			draft.DatePaid = parsed.Date.Format("2006-01-02")
+		}
+		draft.Sources = parsed.Sources
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	expired, err := purgeExpiredDrafts(tx, now)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to purge expired drafts")
+		return
+	}
+
+	stored, discard, err := putBlob(c, h.db, tx, h.storage, file, fileType)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save file")
+		return
+	}
+	defer discard()
+	draft.FilePath = stored.Key
+	draft.FileHash = stored.Hash
+	if textErr == nil {
+		if err := storeBlobText(tx, stored.Hash, text, now); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to store extracted text")
+			return
+		}
+	}
+
+	sources, err := json.Marshal(draft.Sources)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create draft")
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO invoice_drafts (id, workspace_id, file_path, file_hash, original_name, file_size, content_type, info, amount, currency, date_paid, sources, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, draft.ID, workspaceID(c), draft.FilePath, draft.FileHash, draft.OriginalName, draft.FileSize, draft.ContentType,
+		draft.Info, draft.Amount, draft.Currency, draft.DatePaid, string(sources), draft.CreatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create draft")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+	deleteFiles(c.Request.Context(), h.storage, expired...)
+
+	c.JSON(http.StatusCreated, draft)
+}
+
+// GetInvoiceDraft returns a draft payment read from an invoice
+func (h *PaymentHandler) GetInvoiceDraft(c *gin.Context) {
+	draft, err := fetchInvoiceDraft(h.db, workspaceID(c), c.Param("draftId"), time.Now())
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Invoice draft not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch invoice draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, draft)
+}
+
+// ConfirmInvoiceDraft creates a payment from a draft: the request body is
+// the payment as the user checked it, with the same fields and duplicate
+// handling as creating a payment, and the draft's file becomes its invoice
+func (h *PaymentHandler) ConfirmInvoiceDraft(c *gin.Context) {
+	policy, err := parseDuplicatePolicy(c.Query("onDuplicate"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid duplicate policy")
+		return
+	}
+
+	payment, ok := bindPayment(c)
+	if !ok || !validateTags(c, h.db, payment.Tags) || !validateVendor(c, h.db, payment.VendorID) {
+		return
+	}
+
+	now := time.Now()
+	payment.ID = uuid.New().String()
+	payment.WorkspaceID = workspaceID(c)
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	draft, err := fetchInvoiceDraft(tx, payment.WorkspaceID, c.Param("draftId"), now)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Invoice draft not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch invoice draft")
+		return
+	}
+
+	if err := applyTagRules(tx, payment.WorkspaceID, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to apply tag rules")
+		return
+	}
+	if err := resolveVendor(tx, payment.WorkspaceID, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to resolve vendor")
+		return
+	}
+
+	if !checkDuplicate(c, tx, &payment, policy) {
+		return
+	}
+
+	if err := insertPayment(tx, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create payment")
+		return
+	}
+
+	// The draft's reference to the stored file passes to the attachment
+	attachment := models.PaymentAttachment{
+		ID:           uuid.New().String(),
+		PaymentID:    payment.ID,
+		Kind:         models.AttachmentInvoice,
+		FilePath:     draft.FilePath,
+		FileHash:     draft.FileHash,
+		OriginalName: draft.OriginalName,
+		FileSize:     draft.FileSize,
+		ContentType:  draft.ContentType,
+		CreatedAt:    now,
+	}
+	if err := insertAttachment(tx, attachment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create attachment")
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM invoice_drafts WHERE id = ?", draft.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete invoice draft")
+		return
+	}
+	payment.AttachmentCount = 1
+
+	if err := indexPayment(tx, payment.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
+		return
+	}
+
+	a := auditorFor(c)
+	if err := a.record(tx, audit.EntityPayment, payment.ID, audit.ActionCreate, nil, payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+	if err := a.record(tx, audit.EntityAttachment, attachment.ID, audit.ActionCreate, nil, attachment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, payment)
+}
+
+// DiscardInvoiceDraft deletes a draft, and its file if nothing else shares
+// it
+func (h *PaymentHandler) DiscardInvoiceDraft(c *gin.Context) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	draft, err := fetchInvoiceDraft(tx, workspaceID(c), c.Param("draftId"), time.Now())
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Invoice draft not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch invoice draft")
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM invoice_drafts WHERE id = ?", draft.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete invoice draft")
+		return
+	}
+	released, err := releaseFile(tx, draft.FileHash, draft.FilePath)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to release file")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+	deleteFiles(c.Request.Context(), h.storage, released)
+
+	c.Status(http.StatusNoContent)
+}
+
+// purgeExpiredDrafts deletes drafts nobody confirmed within invoiceDraftTTL.
+// It returns the storage keys of files no other record shares, which the
+// caller deletes once the transaction has committed.
+func purgeExpiredDrafts(tx *sql.Tx, now time.Time) ([]string, error) {
+	rows, err := tx.Query("SELECT id, file_hash, file_path FROM invoice_drafts WHERE created_at < ?", now.Add(-invoiceDraftTTL))
+	if err != nil {
+		return nil, err
+	}
+	type expiredDraft struct{ id, hash, key string }
+	var drafts []expiredDraft
+	for rows.Next() {
+		var d expiredDraft
+		if err := rows.Scan(&d.id, &d.hash, &d.key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var released []string
+	for _, d := range drafts {
+		if _, err := tx.Exec("DELETE FROM invoice_drafts WHERE id = ?", d.id); err != nil {
+			return nil, err
+		}
+		key, err := releaseFile(tx, d.hash, d.key)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			released = append(released, key)
+		}
+	}
+	return released, nil
+}
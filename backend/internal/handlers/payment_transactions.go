@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// errNothingToPay rejects instalments against refunds and credits, whose
+// amount is zero or less
+var errNothingToPay = errors.New("payment has no amount to pay off")
+
+// transactionPayload is the request body for recording an instalment
+type transactionPayload struct {
+	Amount   models.Decimal `json:"amount"`
+	DatePaid string         `json:"datePaid"`
+	Method   string         `json:"method"`
+}
+
+// ListTransactions returns the instalments recorded against a payment
+func (h *PaymentHandler) ListTransactions(c *gin.Context) {
+	id := c.Param("id")
+
+	var currency string
+	err := h.db.QueryRow("SELECT currency FROM payments WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL", id, workspaceID(c)).Scan(&currency)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, payment_id, amount_minor, date_paid, method, created_at
+		FROM payment_transactions
+		WHERE payment_id = ?
+		ORDER BY date_paid, created_at
+	`, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch transactions")
+		return
+	}
+	defer rows.Close()
+
+	transactions := make([]models.PaymentTransaction, 0)
+	for rows.Next() {
+		var t models.PaymentTransaction
+		var minor int64
+		if err := rows.Scan(&t.ID, &t.PaymentID, &minor, &t.DatePaid, &t.Method, &t.CreatedAt); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan transaction")
+			return
+		}
+		t.Amount = models.NewMoney(minor, currency)
+		transactions = append(transactions, t)
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// CreateTransaction records an instalment and updates the payment's status
+func (h *PaymentHandler) CreateTransaction(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	transaction, before, ok := bindTransaction(c, tx, id)
+	if !ok {
+		return
+	}
+	transaction.ID = uuid.New().String()
+	transaction.CreatedAt = time.Now()
+
+	_, err = tx.Exec(`
+		INSERT INTO payment_transactions (id, payment_id, amount_minor, date_paid, method, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, transaction.ID, id, transaction.Amount.Minor, transaction.DatePaid, transaction.Method, transaction.CreatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create transaction")
+		return
+	}
+
+	h.respondWithLedger(c, tx, before, http.StatusCreated, transaction)
+}
+
+// UpdateTransaction changes an instalment and updates the payment's status
+func (h *PaymentHandler) UpdateTransaction(c *gin.Context) {
+	id := c.Param("id")
+	transactionID := c.Param("transactionId")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	transaction, before, ok := bindTransaction(c, tx, id)
+	if !ok {
+		return
+	}
+	transaction.ID = transactionID
+
+	result, err := tx.Exec(`
+		UPDATE payment_transactions SET amount_minor = ?, date_paid = ?, method = ?
+		WHERE id = ? AND payment_id = ?
+	`, transaction.Amount.Minor, transaction.DatePaid, transaction.Method, transactionID, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update transaction")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Transaction not found")
+		return
+	}
+
+	if err := tx.QueryRow("SELECT created_at FROM payment_transactions WHERE id = ?", transactionID).Scan(&transaction.CreatedAt); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch transaction")
+		return
+	}
+
+	h.respondWithLedger(c, tx, before, http.StatusOK, transaction)
+}
+
+// DeleteTransaction removes an instalment and updates the payment's status
+func (h *PaymentHandler) DeleteTransaction(c *gin.Context) {
+	id := c.Param("id")
+	transactionID := c.Param("transactionId")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := fetchPayment(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM payment_transactions WHERE id = ? AND payment_id = ?", transactionID, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete transaction")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Transaction not found")
+		return
+	}
+
+	if err := syncFullyPaid(tx, id); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment status")
+		return
+	}
+
+	if _, ok := fetchLedgerChange(c, tx, before); !ok {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// MarkPaid marks a payment as fully paid by settling whatever is still
+// outstanding with one more instalment
+func (h *PaymentHandler) MarkPaid(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	before, err := fetchPayment(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	if err := settlePayment(tx, id, time.Now()); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment status")
+		return
+	}
+
+	payment, err := fetchPayment(tx, workspaceID(c), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityPayment, id, audit.ActionUpdate, before, payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// bindTransaction parses an instalment for the payment, responding with an
+// error if the payment does not exist in the workspace or the body is
+// invalid. The payment is returned as it was before the instalment.
+func bindTransaction(c *gin.Context, tx *sql.Tx, paymentID string) (models.PaymentTransaction, models.Payment, bool) {
+	var transaction models.PaymentTransaction
+
+	payment, err := fetchPayment(tx, workspaceID(c), paymentID)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return transaction, payment, false
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return transaction, payment, false
+	}
+
+	var payload transactionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid transaction data")
+		return transaction, payment, false
+	}
+
+	amount, err := payload.Amount.Money(payment.Currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid amount")
+		return transaction, payment, false
+	}
+	if amount.Minor <= 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("amount must be greater than zero"), "Invalid amount")
+		return transaction, payment, false
+	}
+	if payment.Amount.Minor <= 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, errNothingToPay, "Invalid transaction")
+		return transaction, payment, false
+	}
+
+	datePaid, err := time.Parse("2006-01-02", payload.DatePaid)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		return transaction, payment, false
+	}
+
+	transaction.PaymentID = paymentID
+	transaction.Amount = amount
+	transaction.DatePaid = datePaid
+	transaction.Method = payload.Method
+	return transaction, payment, true
+}
+
+// respondWithLedger recomputes the payment status, records the change to
+// the payment's balance in the audit log, commits and returns the
+// transaction along with the payment's new balance
+func (h *PaymentHandler) respondWithLedger(c *gin.Context, tx *sql.Tx, before models.Payment, status int, transaction models.PaymentTransaction) {
+	if err := syncFullyPaid(tx, before.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment status")
+		return
+	}
+
+	payment, ok := fetchLedgerChange(c, tx, before)
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"transaction": transaction,
+		"payment":     payment,
+	})
+}
+
+// fetchLedgerChange reloads a payment after its instalments changed and
+// records the difference in the audit log, responding with an error and
+// returning false if either fails
+func fetchLedgerChange(c *gin.Context, tx *sql.Tx, before models.Payment) (models.Payment, bool) {
+	payment, err := fetchPayment(tx, workspaceID(c), before.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return payment, false
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityPayment, before.ID, audit.ActionUpdate, before, payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return payment, false
+	}
+	return payment, true
+}
+
+// syncFullyPaid derives fully_paid from the instalments recorded so far.
+// Refunds and credits of zero or less have nothing to pay off, so they
+// keep the status they were given.
+func syncFullyPaid(tx *sql.Tx, paymentID string) error {
+	_, err := tx.Exec(`
+		UPDATE payments
+		SET fully_paid = (
+			SELECT COALESCE(SUM(amount_minor), 0) FROM payment_transactions WHERE payment_id = payments.id
+		) >= amount_minor
+		WHERE id = ? AND amount_minor > 0
+	`, paymentID)
+	return err
+}
+
+// settlePayment records an instalment for whatever is still outstanding
+// and marks the payment as fully paid
+func settlePayment(tx *sql.Tx, paymentID string, date time.Time) error {
+	var outstanding int64
+	err := tx.QueryRow(`
+		SELECT amount_minor - COALESCE((SELECT SUM(amount_minor) FROM payment_transactions WHERE payment_id = ?), 0)
+		FROM payments WHERE id = ?
+	`, paymentID, paymentID).Scan(&outstanding)
+	if err != nil {
+		return err
+	}
+
+	if outstanding > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO payment_transactions (id, payment_id, amount_minor, date_paid, method, created_at)
+			VALUES (?, ?, ?, ?, '', ?)
+		`, uuid.New().String(), paymentID, outstanding, date, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE payments SET fully_paid = true WHERE id = ?", paymentID)
+	return err
+}
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"database/sql"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/invoiceparse"
 	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
 	"expense_tracker/internal/utils"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,26 +19,142 @@ import (
 )
 
 type PaymentHandler struct {
-	db *sql.DB
+	db            *sql.DB
+	storage       storage.Storage
+	maxUploadSize int64
+	invoiceRules  invoiceparse.Rules
 }
 
-func NewPaymentHandler(db *sql.DB) *PaymentHandler {
-	return &PaymentHandler{db: db}
+func NewPaymentHandler(db *sql.DB, store storage.Storage, maxUploadSize int64, invoiceRules invoiceparse.Rules) *PaymentHandler {
+	return &PaymentHandler{db: db, storage: store, maxUploadSize: maxUploadSize, invoiceRules: invoiceRules}
+}
+
+// paymentColumns is the column list expected by scanPayment
+const paymentColumns = `p.id, p.info, p.amount_minor, p.currency, p.date_paid, p.fully_paid,
+	p.created_at, p.updated_at, COALESCE(p.recurring_payment_id, ''), p.occurrence_date,
+	COALESCE(p.duplicate_of, ''), COALESCE(p.external_id, ''), COALESCE(p.vendor_id, ''), p.deleted_at, ` + paidAmountColumn + `,
+	` + attachmentCountColumn
+
+// paidAmountColumn sums the instalments recorded against payment p
+const paidAmountColumn = `COALESCE((SELECT SUM(amount_minor) FROM payment_transactions WHERE payment_id = p.id), 0)`
+
+// attachmentCountColumn counts the files attached to payment p
+const attachmentCountColumn = `(SELECT COUNT(*) FROM payment_attachments WHERE payment_id = p.id)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPayment reads paymentColumns followed by any extra destinations
+func scanPayment(row rowScanner, p *models.Payment, extra ...interface{}) error {
+	var minor, paid int64
+	var occurrenceDate, deletedAt sql.NullTime
+	dest := append([]interface{}{
+		&p.ID, &p.Info, &minor, &p.Currency, &p.DatePaid, &p.FullyPaid,
+		&p.CreatedAt, &p.UpdatedAt, &p.RecurringPaymentID, &occurrenceDate,
+		&p.DuplicateOf, &p.ExternalID, &p.VendorID, &deletedAt, &paid, &p.AttachmentCount,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if occurrenceDate.Valid {
+		p.OccurrenceDate = &occurrenceDate.Time
+	}
+	if deletedAt.Valid {
+		p.DeletedAt = &deletedAt.Time
+	}
+	p.Amount = models.NewMoney(minor, p.Currency)
+	p.PaidAmount = models.NewMoney(paid, p.Currency)
+	p.Outstanding = models.NewMoney(minor-paid, p.Currency)
+	return nil
+}
+
+// paymentPayload is the request body accepted when creating or updating a payment
+type paymentPayload struct {
+	Info      string         `json:"info"`
+	Amount    models.Decimal `json:"amount"`
+	Currency  string         `json:"currency"`
+	DatePaid  string         `json:"datePaid"`
+	FullyPaid bool           `json:"fullyPaid"`
+	Tags      []string       `json:"tags"`
+	VendorID  string         `json:"vendorId"`
+}
+
+// bindPayment parses the request body into a payment, responding with 400 on failure
+func bindPayment(c *gin.Context) (models.Payment, bool) {
+	var payment models.Payment
+	var payload paymentPayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid payment data")
+		return payment, false
+	}
+
+	// Parse date string to time.Time
+	datePaid, err := time.Parse("2006-01-02", payload.DatePaid)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		return payment, false
+	}
+
+	currency, err := models.NormalizeCurrency(payload.Currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid currency")
+		return payment, false
+	}
+
+	amount, err := payload.Amount.Money(currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid amount")
+		return payment, false
+	}
+
+	payment.Info = payload.Info
+	payment.Amount = amount
+	payment.Currency = currency
+	payment.DatePaid = datePaid
+	payment.FullyPaid = payload.FullyPaid
+	payment.VendorID = payload.VendorID
+	payment.Tags = payload.Tags
+	if payment.Tags == nil {
+		payment.Tags = []string{}
+	}
+
+	return payment, true
 }
 
 // RegisterRoutes registers all payment-related routes
 func (h *PaymentHandler) RegisterRoutes(router *gin.RouterGroup) {
-	payments := router.Group("/payments")
+	payments := router.Group("/payments", requireScope("payments"))
 	{
-		payments.GET("", h.ListPayments)
-		payments.POST("", h.CreatePayment)
-		payments.GET("/:id", h.GetPayment)
-		payments.PUT("/:id", h.UpdatePayment)
-		payments.DELETE("/:id", h.DeletePayment)
-		payments.POST("/:id/invoice", h.UploadInvoice)
-		// Serve uploaded invoice files
-		payments.GET("/:id/invoice", h.DownloadInvoice)
-		payments.GET("/analytics", h.GetPaymentAnalytics)
+		payments.GET("", requirePermission(auth.PermRead), h.ListPayments)
+		payments.POST("", requirePermission(auth.PermWrite), h.CreatePayment)
+		payments.GET("/:id", requirePermission(auth.PermRead), h.GetPayment)
+		payments.PUT("/:id", requirePermission(auth.PermWrite), h.UpdatePayment)
+		payments.DELETE("/:id", requirePermission(auth.PermDelete), h.DeletePayment)
+		payments.POST("/:id/invoice", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.UploadInvoice)
+		payments.GET("/:id/invoice", requirePermission(auth.PermRead), h.DownloadInvoice)
+		payments.GET("/analytics", requirePermission(auth.PermRead), h.GetPaymentAnalytics)
+		payments.POST("/import", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.ImportPayments)
+		payments.POST("/from-invoice", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.DraftFromInvoice)
+		payments.GET("/from-invoice/:draftId", requirePermission(auth.PermRead), h.GetInvoiceDraft)
+		payments.POST("/from-invoice/:draftId", requirePermission(auth.PermWrite), h.ConfirmInvoiceDraft)
+		payments.DELETE("/from-invoice/:draftId", requirePermission(auth.PermWrite), h.DiscardInvoiceDraft)
+		payments.GET("/duplicates", requirePermission(auth.PermRead), h.ListDuplicates)
+		payments.GET("/export", requirePermission(auth.PermExport), h.ExportPayments)
+		payments.POST("/:id/merge", requirePermission(auth.PermDelete), h.MergePayments)
+		payments.POST("/:id/paid", requirePermission(auth.PermMarkPaid), h.MarkPaid)
+		payments.GET("/:id/history", requirePermission(auth.PermRead), h.PaymentHistory)
+		payments.GET("/:id/transactions", requirePermission(auth.PermRead), h.ListTransactions)
+		payments.POST("/:id/transactions", requirePermission(auth.PermMarkPaid), h.CreateTransaction)
+		payments.PUT("/:id/transactions/:transactionId", requirePermission(auth.PermMarkPaid), h.UpdateTransaction)
+		payments.DELETE("/:id/transactions/:transactionId", requirePermission(auth.PermDelete), h.DeleteTransaction)
+		payments.GET("/:id/attachments", requirePermission(auth.PermRead), h.ListAttachments)
+		payments.POST("/:id/attachments", requirePermission(auth.PermWrite), limitUploadSize(h.maxUploadSize), h.AddAttachment)
+		payments.DELETE("/:id/attachments/:attachmentId", requirePermission(auth.PermDelete), h.DeleteAttachment)
+		payments.GET("/:id/attachments/:attachmentId/download", requirePermission(auth.PermRead), h.DownloadAttachment)
+		payments.GET("/:id/attachments/:attachmentId/text", requirePermission(auth.PermRead), h.GetAttachmentText)
 	}
 }
 
@@ -42,41 +162,14 @@ func (h *PaymentHandler) RegisterRoutes(router *gin.RouterGroup) {
 func (h *PaymentHandler) ListPayments(c *gin.Context) {
 	query := `
 		SELECT DISTINCT
-			p.id, p.info, p.amount, p.date_paid as datePaid, p.fully_paid as fullyPaid,
-			p.invoice_path as invoicePath, p.created_at as createdAt, p.updated_at as updatedAt,
+			` + paymentColumns + `,
 			GROUP_CONCAT(pt.tag_id) as tag_ids
 		FROM payments p
 		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
 	`
-	params := []interface{}{}
-	whereClause := []string{}
+	whereClause, params := paymentFilters(c)
 
-	// Filter by tag if provided
-	if tagID := c.Query("tag"); tagID != "" {
-		whereClause = append(whereClause, "EXISTS (SELECT 1 FROM payment_tags WHERE payment_id = p.id AND tag_id = ?)")
-		params = append(params, tagID)
-	}
-
-	// Filter by date range
-	if startDate := c.Query("start_date"); startDate != "" {
-		whereClause = append(whereClause, "date_paid >= ?")
-		params = append(params, startDate)
-	}
-	if endDate := c.Query("end_date"); endDate != "" {
-		whereClause = append(whereClause, "date_paid <= ?")
-		params = append(params, endDate)
-	}
-
-	// Filter by payment status
-	if status := c.Query("fully_paid"); status != "" {
-		whereClause = append(whereClause, "fully_paid = ?")
-		params = append(params, status == "true")
-	}
-
-	// Add WHERE clause if filters exist
-	if len(whereClause) > 0 {
-		query += " WHERE " + utils.JoinWithAND(whereClause)
-	}
+	query += " WHERE " + utils.JoinWithAND(whereClause)
 
 	query += " GROUP BY p.id ORDER BY p.date_paid DESC LIMIT ? OFFSET ?"
 
@@ -84,10 +177,9 @@ func (h *PaymentHandler) ListPayments(c *gin.Context) {
 	page := utils.ParseIntWithDefault(c.Query("page"), 1)
 	limit := utils.ParseIntWithDefault(c.Query("limit"), 10)
 	offset := (page - 1) * limit
-	params = append(params, limit, offset)
 
 	// Execute query
-	rows, err := h.db.Query(query, params...)
+	rows, err := h.db.Query(query, append(params, limit, offset)...)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
 		return
@@ -98,11 +190,7 @@ func (h *PaymentHandler) ListPayments(c *gin.Context) {
 	for rows.Next() {
 		var p models.Payment
 		var tagIDs sql.NullString
-		err := rows.Scan(
-			&p.ID, &p.Info, &p.Amount, &p.DatePaid, &p.FullyPaid,
-			&p.InvoicePath, &p.CreatedAt, &p.UpdatedAt, &tagIDs,
-		)
-		if err != nil {
+		if err := scanPayment(rows, &p, &tagIDs); err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment")
 			return
 		}
@@ -115,7 +203,7 @@ func (h *PaymentHandler) ListPayments(c *gin.Context) {
 
 	// Get total count for pagination
 	var total int
-	err = h.db.QueryRow("SELECT COUNT(*) FROM payments").Scan(&total)
+	err = h.db.QueryRow("SELECT COUNT(*) FROM payments p WHERE "+utils.JoinWithAND(whereClause), params...).Scan(&total)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get total count")
 		return
@@ -124,37 +212,50 @@ func (h *PaymentHandler) ListPayments(c *gin.Context) {
 	// Include payment stats if requested
 	var stats gin.H
 	if c.Query("stats") == "true" {
-		var totalAmount, monthlyAmount float64
-		var pendingCount int
+		cv, ok := requestConverter(c, h.db)
+		if !ok {
+			return
+		}
 
-		// Get total amount and pending count
-		err = h.db.QueryRow(`
-	        SELECT
-	            COALESCE(SUM(amount), 0),
-	            COALESCE(SUM(CASE WHEN NOT fully_paid THEN 1 ELSE 0 END), 0)
-	        FROM payments
-	    `).Scan(&totalAmount, &pendingCount)
+		rows, err := h.db.Query("SELECT amount_minor, currency, date_paid, fully_paid FROM payments WHERE workspace_id = ? AND deleted_at IS NULL", workspaceID(c))
 		if err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get payment stats")
 			return
 		}
+		defer rows.Close()
 
-		// Get monthly amount
+		var totalAmount, monthlyAmount int64
+		var pendingCount int
 		currentMonth := time.Now().Format("2006-01")
-		err = h.db.QueryRow(`
-	        SELECT COALESCE(SUM(amount), 0)
-	        FROM payments
-	        WHERE strftime('%Y-%m', date_paid) = ?
-	    `, currentMonth).Scan(&monthlyAmount)
-		if err != nil {
-			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get monthly stats")
-			return
+		for rows.Next() {
+			var minor int64
+			var currency string
+			var datePaid time.Time
+			var fullyPaid bool
+			if err := rows.Scan(&minor, &currency, &datePaid, &fullyPaid); err != nil {
+				utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment stats")
+				return
+			}
+
+			if !fullyPaid {
+				pendingCount++
+			}
+			amount, ok := cv.convert(minor, currency, datePaid)
+			if !ok {
+				continue
+			}
+			totalAmount += amount
+			if datePaid.Format("2006-01") == currentMonth {
+				monthlyAmount += amount
+			}
 		}
 
 		stats = gin.H{
-			"total":   totalAmount,
-			"pending": pendingCount,
-			"monthly": monthlyAmount,
+			"total":       cv.money(totalAmount),
+			"pending":     pendingCount,
+			"monthly":     cv.money(monthlyAmount),
+			"currency":    cv.base,
+			"unconverted": cv.unconverted,
 		}
 	}
 
@@ -167,36 +268,73 @@ func (h *PaymentHandler) ListPayments(c *gin.Context) {
 	})
 }
 
-// CreatePayment creates a new payment
-func (h *PaymentHandler) CreatePayment(c *gin.Context) {
-	var payment models.Payment
-	var payload struct {
-		Info      string   `json:"info"`
-		Amount    float64  `json:"amount"`
-		DatePaid  string   `json:"datePaid"`
-		FullyPaid bool     `json:"fullyPaid"`
-		Tags      []string `json:"tags"`
+// paymentFilters builds the WHERE conditions for the workspace and the tag,
+// vendor, date range, fully_paid and include_trashed query parameters shared by
+// ListPayments and ExportPayments. Trashed payments are left out unless
+// include_trashed is true.
+func paymentFilters(c *gin.Context) ([]string, []interface{}) {
+	params := []interface{}{workspaceID(c)}
+	whereClause := []string{"p.workspace_id = ?"}
+
+	if c.Query("include_trashed") != "true" {
+		whereClause = append(whereClause, "p.deleted_at IS NULL")
 	}
 
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid payment data")
-		return
+	// Filter by tag if provided
+	if tagID := c.Query("tag"); tagID != "" {
+		whereClause = append(whereClause, "EXISTS (SELECT 1 FROM payment_tags WHERE payment_id = p.id AND tag_id = ?)")
+		params = append(params, tagID)
 	}
 
-	// Parse date string to time.Time
-	datePaid, err := time.Parse("2006-01-02", payload.DatePaid)
+	// Filter by date range. date_paid is stored with a time part, so compare
+	// the calendar day only to keep end_date inclusive.
+	if startDate := c.Query("start_date"); startDate != "" {
+		whereClause = append(whereClause, "substr(p.date_paid, 1, 10) >= ?")
+		params = append(params, startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		whereClause = append(whereClause, "substr(p.date_paid, 1, 10) <= ?")
+		params = append(params, endDate)
+	}
+
+	// Filter by vendor
+	if vendorID := c.Query("vendor"); vendorID != "" {
+		whereClause = append(whereClause, "p.vendor_id = ?")
+		params = append(params, vendorID)
+	}
+
+	// Filter by payment status
+	if status := c.Query("fully_paid"); status != "" {
+		whereClause = append(whereClause, "p.fully_paid = ?")
+		params = append(params, status == "true")
+	}
+
+	// Filter by words in the info or attachment names
+	if condition, searchParams := searchFilter(c, searchPayment, "p.id"); condition != "" {
+		whereClause = append(whereClause, condition)
+		params = append(params, searchParams...)
+	}
+
+	return whereClause, params
+}
+
+// CreatePayment creates a new payment. The onDuplicate query parameter
+// (allow, flag or reject) controls what happens when the payment looks
+// like one that already exists.
+func (h *PaymentHandler) CreatePayment(c *gin.Context) {
+	policy, err := parseDuplicatePolicy(c.Query("onDuplicate"))
 	if err != nil {
-		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid duplicate policy")
 		return
 	}
 
-	payment.Info = payload.Info
-	payment.Amount = payload.Amount
-	payment.DatePaid = datePaid
-	payment.FullyPaid = payload.FullyPaid
-	payment.Tags = payload.Tags
+	payment, ok := bindPayment(c)
+	if !ok || !validateTags(c, h.db, payment.Tags) || !validateVendor(c, h.db, payment.VendorID) {
+		return
+	}
 
 	payment.ID = uuid.New().String()
+	payment.WorkspaceID = workspaceID(c)
 	payment.CreatedAt = time.Now()
 	payment.UpdatedAt = time.Now()
 
@@ -207,82 +345,200 @@ func (h *PaymentHandler) CreatePayment(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	// Insert payment
-	_, err = tx.Exec(`
-		INSERT INTO payments (id, info, amount, date_paid, fully_paid, invoice_path, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	if err := applyTagRules(tx, payment.WorkspaceID, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to apply tag rules")
+		return
+	}
+	if err := resolveVendor(tx, payment.WorkspaceID, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to resolve vendor")
+		return
+	}
+
+	if !checkDuplicate(c, tx, &payment, policy) {
+		return
+	}
+
+	if err := insertPayment(tx, &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create payment")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityPayment, payment.ID, audit.ActionCreate, nil, payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, payment)
+}
+
+// checkDuplicate applies a duplicate policy to a payment about to be
+// created, flagging it as a duplicate or responding with 409 if it is
+// rejected. It reports whether the payment may be created.
+func checkDuplicate(c *gin.Context, tx *sql.Tx, payment *models.Payment, policy string) bool {
+	if policy == duplicateAllow {
+		return true
+	}
+	duplicateOf, err := findDuplicate(tx, *payment)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to check for duplicates")
+		return false
+	}
+	if duplicateOf != "" && policy == duplicateReject {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       errDuplicatePayment.Error(),
+			"details":     "A payment with the same info and amount exists within the duplicate window",
+			"duplicateOf": duplicateOf,
+		})
+		return false
+	}
+	payment.DuplicateOf = duplicateOf
+	return true
+}
+
+// insertPayment inserts a new payment with its tags into payment.WorkspaceID.
+// A payment marked as fully paid is settled by a single instalment.
+func insertPayment(tx *sql.Tx, payment *models.Payment) error {
+	var recurringID, duplicateOf, externalID, vendorID interface{}
+	if payment.RecurringPaymentID != "" {
+		recurringID = payment.RecurringPaymentID
+	}
+	if payment.DuplicateOf != "" {
+		duplicateOf = payment.DuplicateOf
+	}
+	if payment.ExternalID != "" {
+		externalID = payment.ExternalID
+	}
+	if payment.VendorID != "" {
+		vendorID = payment.VendorID
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO payments (id, info, amount_minor, currency, date_paid, fully_paid,
+			recurring_payment_id, occurrence_date, fingerprint, duplicate_of, external_id,
+			vendor_id, workspace_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		payment.ID, payment.Info, payment.Amount, payment.DatePaid,
-		payment.FullyPaid, payment.InvoicePath, payment.CreatedAt, payment.UpdatedAt,
+		payment.ID, payment.Info, payment.Amount.Minor, payment.Currency, payment.DatePaid,
+		payment.FullyPaid, recurringID, payment.OccurrenceDate,
+		models.Fingerprint(payment.Info, payment.Amount.Minor, payment.Currency), duplicateOf,
+		externalID, vendorID, payment.WorkspaceID, payment.CreatedAt, payment.UpdatedAt,
 	)
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create payment")
-		return
+		return err
 	}
 
-	// Insert tags
 	for _, tagID := range payment.Tags {
 		_, err = tx.Exec("INSERT INTO payment_tags (payment_id, tag_id) VALUES (?, ?)", payment.ID, tagID)
 		if err != nil {
-			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
-			return
+			return fmt.Errorf("failed to associate tags: %w", err)
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
-		return
+	if err := indexPayment(tx, payment.ID); err != nil {
+		return fmt.Errorf("failed to index payment: %w", err)
 	}
 
-	c.JSON(http.StatusCreated, payment)
+	payment.PaidAmount = models.NewMoney(0, payment.Currency)
+	if payment.FullyPaid {
+		if err := settlePayment(tx, payment.ID, payment.DatePaid); err != nil {
+			return fmt.Errorf("failed to record payment transaction: %w", err)
+		}
+		payment.PaidAmount = payment.Amount
+	}
+	payment.Outstanding = models.NewMoney(payment.Amount.Minor-payment.PaidAmount.Minor, payment.Currency)
+
+	return nil
 }
 
 // GetPayment returns a specific payment by ID
 func (h *PaymentHandler) GetPayment(c *gin.Context) {
 	id := c.Param("id")
 
+	payment, err := fetchPayment(h.db, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// PaymentHistory returns the audit log entries of a payment, newest first.
+// The history of a deleted payment stays available.
+func (h *PaymentHandler) PaymentHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	entries, err := queryAudit(h.db,
+		[]string{"a.workspace_id = ?", "a.entity_type = ?", "a.entity_id = ?"},
+		[]interface{}{workspaceID(c), audit.EntityPayment, id}, -1, 0,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment history")
+		return
+	}
+
+	if len(entries) == 0 {
+		if _, err := fetchPayment(h.db, workspaceID(c), id); err == sql.ErrNoRows {
+			utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+			return
+		} else if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// fetchPayment loads a single payment of the workspace with its tags.
+// Trashed payments are not found.
+func fetchPayment(db queryRower, workspaceID, id string) (models.Payment, error) {
 	var payment models.Payment
 	var tagIDs sql.NullString
 
-	err := h.db.QueryRow(`
-		SELECT 
-			p.id, p.info, p.amount, p.date_paid, p.fully_paid,
-			p.invoice_path, p.created_at, p.updated_at,
+	row := db.QueryRow(`
+		SELECT
+			`+paymentColumns+`,
 			GROUP_CONCAT(pt.tag_id) as tag_ids
 		FROM payments p
 		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
-		WHERE p.id = ?
+		WHERE p.id = ? AND p.workspace_id = ? AND p.deleted_at IS NULL
 		GROUP BY p.id
-	`, id).Scan(
-		&payment.ID, &payment.Info, &payment.Amount, &payment.DatePaid,
-		&payment.FullyPaid, &payment.InvoicePath, &payment.CreatedAt,
-		&payment.UpdatedAt, &tagIDs,
-	)
-
-	if err == sql.ErrNoRows {
-		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
-		return
-	} else if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
-		return
+	`, id, workspaceID)
+	if err := scanPayment(row, &payment, &tagIDs); err != nil {
+		return payment, err
 	}
 
+	payment.Tags = []string{}
 	if tagIDs.Valid {
 		payment.Tags = utils.SplitCommaString(tagIDs.String)
 	}
-
-	c.JSON(http.StatusOK, payment)
+	payment.WorkspaceID = workspaceID
+	return payment, nil
 }
 
 // UpdatePayment updates a specific payment
 func (h *PaymentHandler) UpdatePayment(c *gin.Context) {
 	id := c.Param("id")
 
-	var payment models.Payment
-	if err := c.ShouldBindJSON(&payment); err != nil {
-		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid payment data")
+	payment, ok := bindPayment(c)
+	if !ok || !validateTags(c, h.db, payment.Tags) || !validateVendor(c, h.db, payment.VendorID) {
 		return
 	}
+	payment.UpdatedAt = time.Now()
 
 	tx, err := h.db.Begin()
 	if err != nil {
@@ -291,14 +547,33 @@ func (h *PaymentHandler) UpdatePayment(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	before, err := fetchPayment(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	if err := applyTagRules(tx, workspaceID(c), &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to apply tag rules")
+		return
+	}
+	if err := resolveVendor(tx, workspaceID(c), &payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to resolve vendor")
+		return
+	}
+
 	// Update payment
 	result, err := tx.Exec(`
 		UPDATE payments 
-		SET info = ?, amount = ?, date_paid = ?, fully_paid = ?, updated_at = ?
-		WHERE id = ?
+		SET info = ?, amount_minor = ?, currency = ?, date_paid = ?, fingerprint = ?, vendor_id = NULLIF(?, ''), updated_at = ?
+		WHERE id = ? AND workspace_id = ?
 	`,
-		payment.Info, payment.Amount, payment.DatePaid,
-		payment.FullyPaid, time.Now(), id,
+		payment.Info, payment.Amount.Minor, payment.Currency, payment.DatePaid,
+		models.Fingerprint(payment.Info, payment.Amount.Minor, payment.Currency), payment.VendorID, payment.UpdatedAt,
+		id, workspaceID(c),
 	)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment")
@@ -331,67 +606,33 @@ func (h *PaymentHandler) UpdatePayment(c *gin.Context) {
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
-		return
-	}
-
-	payment.ID = id
-	c.JSON(http.StatusOK, payment)
-}
-
-// DeletePayment deletes a specific payment
-func (h *PaymentHandler) DeletePayment(c *gin.Context) {
-	id := c.Param("id")
-
-	tx, err := h.db.Begin()
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
-		return
-	}
-	defer tx.Rollback()
-
-	// Get the payment to check if it has an invoice
-	var invoicePath sql.NullString
-	err = tx.QueryRow("SELECT invoice_path FROM payments WHERE id = ?", id).Scan(&invoicePath)
-	if err == sql.ErrNoRows {
-		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
-		return
-	} else if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
-		return
+	// fully_paid is derived from the ledger. Marking a payment as paid
+	// settles whatever is still outstanding with one more instalment.
+	if payment.FullyPaid {
+		err = settlePayment(tx, id, time.Now())
+	} else if payment.Amount.Minor <= 0 {
+		_, err = tx.Exec("UPDATE payments SET fully_paid = false WHERE id = ?", id)
+	} else {
+		err = syncFullyPaid(tx, id)
 	}
-
-	// Delete invoice file if it exists
-	if invoicePath.Valid && invoicePath.String != "" {
-		if err := utils.DeleteFile(invoicePath.String); err != nil {
-			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete invoice file")
-			return
-		}
-	}
-
-	// Delete payment tags
-	_, err = tx.Exec("DELETE FROM payment_tags WHERE payment_id = ?", id)
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete payment tags")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment status")
 		return
 	}
 
-	// Delete payment
-	result, err := tx.Exec("DELETE FROM payments WHERE id = ?", id)
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete payment")
+	if err := indexPayment(tx, id); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update search index")
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	updated, err := fetchPayment(tx, workspaceID(c), id)
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
 		return
 	}
 
-	if rowsAffected == 0 {
-		utils.RespondWithError(c, http.StatusNotFound, nil, "Payment not found")
+	if err := auditorFor(c).record(tx, audit.EntityPayment, id, audit.ActionUpdate, before, updated); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
 		return
 	}
 
@@ -400,16 +641,23 @@ func (h *PaymentHandler) DeletePayment(c *gin.Context) {
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	c.JSON(http.StatusOK, updated)
 }
 
-// UploadInvoice handles invoice file upload for a payment
-func (h *PaymentHandler) UploadInvoice(c *gin.Context) {
+// DeletePayment moves a payment to the trash. It is hidden from lists and
+// lookups until restored, and purged for good once the trash retention has
+// passed.
+func (h *PaymentHandler) DeletePayment(c *gin.Context) {
 	id := c.Param("id")
 
-	// Check if payment exists
-	var payment models.Payment
-	err := h.db.QueryRow("SELECT id, invoice_path FROM payments WHERE id = ?", id).Scan(&payment.ID, &payment.InvoicePath)
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	payment, err := fetchPayment(tx, workspaceID(c), id)
 	if err == sql.ErrNoRows {
 		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
 		return
@@ -418,208 +666,203 @@ func (h *PaymentHandler) UploadInvoice(c *gin.Context) {
 		return
 	}
 
-	// Handle file upload
-	file, err := c.FormFile("invoice")
+	_, err = tx.Exec("UPDATE payments SET deleted_at = ? WHERE id = ?", time.Now(), id)
 	if err != nil {
-		utils.RespondWithError(c, http.StatusBadRequest, err, "No file uploaded")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete payment")
 		return
 	}
 
-	// Generate unique filename
-	filename := filepath.Join("storage", "invoices", uuid.New().String()+filepath.Ext(file.Filename))
-
-	// Save file
-	if err := c.SaveUploadedFile(file, filename); err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save file")
+	if err := auditorFor(c).record(tx, audit.EntityPayment, id, audit.ActionDelete, payment, nil); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
 		return
 	}
 
-	// Delete old invoice if it exists
-	if payment.InvoicePath != "" {
-		if err := utils.DeleteFile(payment.InvoicePath); err != nil {
-			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete old invoice")
-			return
-		}
-	}
-
-	// Update payment with new invoice path
-	_, err = h.db.Exec("UPDATE payments SET invoice_path = ?, updated_at = ? WHERE id = ?",
-		filename, time.Now(), id)
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update payment")
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
 	}
 
-	fileInfo := models.FileInfo{
-		FileName:     filepath.Base(filename),
-		FilePath:     filename,
-		FileSize:     file.Size,
-		ContentType:  file.Header.Get("Content-Type"),
-		OriginalName: file.Filename,
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Invoice uploaded successfully",
-		"file_info":  fileInfo,
-		"payment_id": id,
-	})
+	c.Status(http.StatusNoContent)
 }
 
-// DownloadInvoice serves the uploaded invoice file for a payment
-func (h *PaymentHandler) DownloadInvoice(c *gin.Context) {
-	id := c.Param("id")
-
-	// Fetch payment to get invoice path
-	var invoicePath sql.NullString
-	err := h.db.QueryRow("SELECT invoice_path FROM payments WHERE id = ?", id).Scan(&invoicePath)
-	if err == sql.ErrNoRows {
-		utils.RespondWithError(c, http.StatusNotFound, err, "Payment not found")
-		return
-	} else if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
-		return
+// purgePayment permanently deletes a payment with its tags, transactions,
+// attachments and search entry and clears duplicate flags pointing at it. It returns the
+// storage keys of attachment files no other record shares, which the caller
+// deletes once the transaction has committed.
+func purgePayment(tx *sql.Tx, id string) ([]string, error) {
+	attachments, err := listAttachments(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range []string{
+		"DELETE FROM payment_tags WHERE payment_id = ?",
+		"DELETE FROM payment_transactions WHERE payment_id = ?",
+		"DELETE FROM payment_attachments WHERE payment_id = ?",
+		"UPDATE payments SET duplicate_of = NULL WHERE duplicate_of = ?",
+		"DELETE FROM payments WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return nil, err
+		}
 	}
-
-	if !invoicePath.Valid || invoicePath.String == "" {
-		utils.RespondWithError(c, http.StatusNotFound, nil, "Invoice not found")
-		return
+	if err := indexPayment(tx, id); err != nil {
+		return nil, err
 	}
 
-	// Check file exists
-	if _, err := os.Stat(invoicePath.String); os.IsNotExist(err) {
-		utils.RespondWithError(c, http.StatusNotFound, err, "Invoice file not found on disk")
-		return
-	} else if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to access invoice file")
-		return
+	var released []string
+	for _, a := range attachments {
+		key, err := releaseFile(tx, a.FileHash, a.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, key)
 	}
-
-	// Serve the file as an attachment or inline depending on client preference
-	c.File(invoicePath.String)
+	return released, nil
 }
 
-// GetPaymentAnalytics returns analytics data for payments
+// GetPaymentAnalytics returns analytics data for payments. Amounts are
+// converted into the base currency using the rate effective on each
+// payment's date; payments without a usable rate are reported separately.
 func (h *PaymentHandler) GetPaymentAnalytics(c *gin.Context) {
 	type monthlyStats struct {
-		Year   string  `json:"year"`
-		Month  string  `json:"month"`
-		Amount float64 `json:"amount"`
-		Count  int     `json:"count"`
+		Year   string       `json:"year"`
+		Month  string       `json:"month"`
+		Amount models.Money `json:"amount"`
+		Count  int          `json:"count"`
 	}
 
 	type tagStats struct {
-		TagName  string  `json:"tag_name"`
-		TagColor string  `json:"tag_color"`
-		Amount   float64 `json:"amount"`
-		Count    int     `json:"count"`
+		TagName  string       `json:"tag_name"`
+		TagColor string       `json:"tag_color"`
+		Amount   models.Money `json:"amount"`
+		Count    int          `json:"count"`
+	}
+
+	cv, ok := requestConverter(c, h.db)
+	if !ok {
+		return
 	}
 
 	// Initialize response structure with empty arrays
 	stats := struct {
+		Currency   string `json:"currency"`
 		TotalStats struct {
-			TotalAmount  float64 `json:"total_amount"`
-			TotalCount   int     `json:"total_count"`
-			PaidAmount   float64 `json:"paid_amount"`
-			UnpaidAmount float64 `json:"unpaid_amount"`
+			TotalAmount  models.Money `json:"total_amount"`
+			TotalCount   int          `json:"total_count"`
+			PaidAmount   models.Money `json:"paid_amount"`
+			UnpaidAmount models.Money `json:"unpaid_amount"`
 		} `json:"total_stats"`
 		MonthlyStats []monthlyStats `json:"monthly_stats"`
 		TagStats     []tagStats     `json:"tag_stats"`
+		Unconverted  models.Totals  `json:"unconverted"`
 	}{
+		Currency:     cv.base,
 		MonthlyStats: make([]monthlyStats, 0),
 		TagStats:     make([]tagStats, 0),
+		Unconverted:  cv.unconverted,
 	}
+	stats.TotalStats.TotalAmount = cv.money(0)
+	stats.TotalStats.PaidAmount = cv.money(0)
+	stats.TotalStats.UnpaidAmount = cv.money(0)
 
-	// Check if there are any payments
-	var count int
-	if err := h.db.QueryRow("SELECT COUNT(*) FROM payments").Scan(&count); err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get payment count")
-		return
-	}
-
-	if count == 0 {
-		c.JSON(http.StatusOK, stats)
-		return
-	}
-
-	// Get total stats for non-empty database
-	err := h.db.QueryRow(`
-		SELECT
-			COALESCE(SUM(amount), 0) as total_amount,
-			COUNT(*) as total_count,
-			COALESCE(SUM(CASE WHEN fully_paid THEN amount ELSE 0 END), 0) as paid_amount,
-			COALESCE(SUM(CASE WHEN NOT fully_paid THEN amount ELSE 0 END), 0) as unpaid_amount
-		FROM payments
-	`).Scan(
-		&stats.TotalStats.TotalAmount,
-		&stats.TotalStats.TotalCount,
-		&stats.TotalStats.PaidAmount,
-		&stats.TotalStats.UnpaidAmount,
-	)
-	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get total stats")
-		return
-	}
-
-	// Get monthly stats
+	// Get total and monthly stats. Paid and unpaid amounts come from each
+	// payment's instalments, so partially paid payments count towards both.
 	rows, err := h.db.Query(`
-		WITH payment_months AS (
-			SELECT
-				substr(date_paid, 1, 4) as year,
-				substr(date_paid, 6, 2) as month,
-				amount
-			FROM payments
-			WHERE date_paid IS NOT NULL
-		)
-		SELECT
-			year,
-			month,
-			COALESCE(SUM(amount), 0) as amount,
-			COUNT(*) as count
-		FROM payment_months
-		GROUP BY year, month
-		ORDER BY year DESC, month DESC
-	`)
+		SELECT p.amount_minor, p.currency, p.date_paid, `+paidAmountColumn+`
+		FROM payments p
+		WHERE p.date_paid IS NOT NULL AND p.workspace_id = ? AND p.deleted_at IS NULL
+	`, workspaceID(c))
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get monthly stats")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get total stats")
 		return
 	}
 	defer rows.Close()
 
+	months := make(map[string]*monthlyStats)
 	for rows.Next() {
-		var stat monthlyStats
-		if err := rows.Scan(&stat.Year, &stat.Month, &stat.Amount, &stat.Count); err != nil {
-			log.Printf("Error scanning monthly stats: %v", err)
-			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan monthly stats")
+		var minor, paid int64
+		var currency string
+		var datePaid time.Time
+		if err := rows.Scan(&minor, &currency, &datePaid, &paid); err != nil {
+			log.Printf("Error scanning payment stats: %v", err)
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment stats")
 			return
 		}
-		stats.MonthlyStats = append(stats.MonthlyStats, stat)
+
+		stats.TotalStats.TotalCount++
+		key := datePaid.Format("2006-01")
+		month, exists := months[key]
+		if !exists {
+			month = &monthlyStats{Year: key[:4], Month: key[5:], Amount: cv.money(0)}
+			months[key] = month
+		}
+		month.Count++
+
+		amount, ok := cv.convert(minor, currency, datePaid)
+		if !ok {
+			continue
+		}
+		// Convert the paid part at the same rate so paid + unpaid = total
+		paidAmount, _ := cv.convert(paid, currency, datePaid)
+		stats.TotalStats.TotalAmount.Minor += amount
+		stats.TotalStats.PaidAmount.Minor += paidAmount
+		stats.TotalStats.UnpaidAmount.Minor += amount - paidAmount
+		month.Amount.Minor += amount
 	}
 
-	// Get tag stats
-	rows, err = h.db.Query(`
+	keys := make([]string, 0, len(months))
+	for key := range months {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	for _, key := range keys {
+		stats.MonthlyStats = append(stats.MonthlyStats, *months[key])
+	}
+
+	// Get tag stats. Conversion happens before aggregation so the
+	// unconverted totals above already account for every payment.
+	tagRows, err := h.db.Query(`
 		SELECT 
+			t.id,
 			t.name as tag_name,
 			t.color as tag_color,
-			SUM(p.amount) as amount,
-			COUNT(DISTINCT p.id) as count
+			p.amount_minor,
+			p.currency,
+			p.date_paid
 		FROM tags t
 		JOIN payment_tags pt ON t.id = pt.tag_id
 		JOIN payments p ON pt.payment_id = p.id
-		GROUP BY t.id, t.name, t.color
-	`)
+		WHERE p.workspace_id = ? AND p.deleted_at IS NULL
+		ORDER BY t.name, t.id
+	`, workspaceID(c))
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get tag stats")
 		return
 	}
-	defer rows.Close()
+	defer tagRows.Close()
 
-	for rows.Next() {
+	tagConverter := &currencyConverter{base: cv.base, rates: cv.rates, unconverted: models.Totals{}}
+	lastTagID := ""
+	for tagRows.Next() {
+		var tagID, currency string
 		var stat tagStats
-		if err := rows.Scan(&stat.TagName, &stat.TagColor, &stat.Amount, &stat.Count); err != nil {
+		var minor int64
+		var datePaid time.Time
+		if err := tagRows.Scan(&tagID, &stat.TagName, &stat.TagColor, &minor, &currency, &datePaid); err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan tag stats")
 			return
 		}
-		stats.TagStats = append(stats.TagStats, stat)
+
+		if tagID != lastTagID {
+			stat.Amount = cv.money(0)
+			stats.TagStats = append(stats.TagStats, stat)
+			lastTagID = tagID
+		}
+		current := &stats.TagStats[len(stats.TagStats)-1]
+		current.Count++
+		if amount, ok := tagConverter.convert(minor, currency, datePaid); ok {
+			current.Amount.Minor += amount
+		}
 	}
 
 	c.JSON(http.StatusOK, stats)
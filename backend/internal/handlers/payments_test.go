@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"database/sql"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/invoiceparse"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindPayment(t *testing.T) {
+	tests := []struct {
+		body  string
+		minor int64
+		ok    bool
+	}{
+		{`{"info": "Rent", "amount": "850.00", "currency": "eur", "datePaid": "2024-03-01"}`, 85000, true},
+		{`{"info": "Rent", "amount": 0.29, "datePaid": "2024-03-01"}`, 29, true},
+		{`{"info": "Rent", "amount": "0", "datePaid": "2024-03-01"}`, 0, true},
+		{`{"info": "Refund", "amount": "-5.00", "datePaid": "2024-03-01"}`, -500, true},
+		{`{"info": "Rent", "datePaid": "2024-03-01"}`, 0, false},
+		{`{"info": "Rent", "amount": "1.005", "datePaid": "2024-03-01"}`, 0, false},
+		{`{"info": "Rent", "amount": "5", "datePaid": "01/03/2024"}`, 0, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/payments", strings.NewReader(tt.body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		p, ok := bindPayment(c)
+		if ok != tt.ok {
+			t.Errorf("bindPayment(%s) ok = %v, want %v (%s)", tt.body, ok, tt.ok, w.Body)
+			continue
+		}
+		if !ok {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("bindPayment(%s) responded %d, want 400", tt.body, w.Code)
+			}
+			continue
+		}
+		if p.Amount.Minor != tt.minor || p.Amount.Currency != p.Currency {
+			t.Errorf("bindPayment(%s) amount = %+v, want %d", tt.body, p.Amount, tt.minor)
+		}
+	}
+}
+
+func TestSettlingRefunds(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestPayment(t, db, workspaceID, "rent", "Rent", 85000, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "refund", "Refund", -500, "2024-03-02")
+
+	inTx := func(f func(tx *sql.Tx) error) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err := f(tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := func(id string) (fullyPaid bool, instalments int) {
+		t.Helper()
+		err := db.QueryRow(`
+			SELECT fully_paid, (SELECT COUNT(*) FROM payment_transactions WHERE payment_id = payments.id)
+			FROM payments WHERE id = ?
+		`, id).Scan(&fullyPaid, &instalments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fullyPaid, instalments
+	}
+
+	// Syncing leaves an unsettled refund unsettled
+	inTx(func(tx *sql.Tx) error { return syncFullyPaid(tx, "refund") })
+	if paid, _ := status("refund"); paid {
+		t.Error("syncing an empty ledger settled the refund")
+	}
+
+	// Settling a refund records no instalment, and syncing keeps it settled
+	inTx(func(tx *sql.Tx) error { return settlePayment(tx, "refund", time.Now()) })
+	inTx(func(tx *sql.Tx) error { return syncFullyPaid(tx, "refund") })
+	if paid, instalments := status("refund"); !paid || instalments != 0 {
+		t.Errorf("settled refund: fully paid %v with %d instalments, want true with 0", paid, instalments)
+	}
+
+	// Refunds take no instalments, payments still do
+	h := NewPaymentHandler(db, nil, 1<<20, invoiceparse.Rules{})
+	router := newTestRouter(workspaceID, auth.RoleEditor)
+	router.POST("/payments/:id/transactions", h.CreateTransaction)
+	if w := serve(router, "POST", "/payments/refund/transactions", `{"amount": "5.00", "datePaid": "2024-03-03"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("instalment on a refund = %d, want 400", w.Code)
+	}
+	if w := serve(router, "POST", "/payments/rent/transactions", `{"amount": "850.00", "datePaid": "2024-03-03"}`); w.Code != http.StatusCreated {
+		t.Errorf("instalment on a payment = %d %s, want 201", w.Code, w.Body)
+	}
+	if paid, instalments := status("rent"); !paid || instalments != 1 {
+		t.Errorf("paid off payment: fully paid %v with %d instalments, want true with 1", paid, instalments)
+	}
+}
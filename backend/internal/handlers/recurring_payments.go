@@ -0,0 +1,897 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/recurring"
+	"expense_tracker/internal/tagrules"
+	"expense_tracker/internal/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RecurringPaymentHandler struct {
+	db *sql.DB
+}
+
+func NewRecurringPaymentHandler(db *sql.DB) *RecurringPaymentHandler {
+	return &RecurringPaymentHandler{db: db}
+}
+
+// RegisterRoutes registers all recurring-payment routes
+func (h *RecurringPaymentHandler) RegisterRoutes(router *gin.RouterGroup) {
+	recurringPayments := router.Group("/recurring-payments", requireScope("recurring-payments"))
+	{
+		recurringPayments.GET("", requirePermission(auth.PermRead), h.ListRecurringPayments)
+		recurringPayments.POST("", requirePermission(auth.PermWrite), h.CreateRecurringPayment)
+		recurringPayments.POST("/generate", requirePermission(auth.PermWrite), h.GeneratePayments)
+		recurringPayments.GET("/:id", requirePermission(auth.PermRead), h.GetRecurringPayment)
+		recurringPayments.PUT("/:id", requirePermission(auth.PermWrite), h.UpdateRecurringPayment)
+		recurringPayments.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteRecurringPayment)
+		recurringPayments.GET("/:id/occurrences", requirePermission(auth.PermRead), h.ListOccurrences)
+		recurringPayments.PUT("/:id/occurrences/:date", requirePermission(auth.PermWrite), h.SetOccurrenceException)
+		recurringPayments.DELETE("/:id/occurrences/:date", requirePermission(auth.PermWrite), h.DeleteOccurrenceException)
+	}
+}
+
+const recurringPaymentColumns = `r.id, r.info, r.amount_minor, r.currency, r.rule, r.start_date, r.end_date,
+	r.active, r.generated_until, r.created_at, r.updated_at, COALESCE(r.workspace_id, '')`
+
+// scanRecurringPayment reads recurringPaymentColumns followed by any extra destinations
+func scanRecurringPayment(row rowScanner, r *models.RecurringPayment, extra ...interface{}) error {
+	var minor int64
+	var endDate, generatedUntil sql.NullTime
+	dest := append([]interface{}{
+		&r.ID, &r.Info, &minor, &r.Currency, &r.Rule, &r.StartDate, &endDate,
+		&r.Active, &generatedUntil, &r.CreatedAt, &r.UpdatedAt, &r.WorkspaceID,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	r.Amount = models.NewMoney(minor, r.Currency)
+	if endDate.Valid {
+		r.EndDate = &endDate.Time
+	}
+	if generatedUntil.Valid {
+		r.GeneratedUntil = &generatedUntil.Time
+	}
+	return nil
+}
+
+// recurringPaymentPayload is the request body for creating or updating a schedule
+type recurringPaymentPayload struct {
+	Info      string         `json:"info"`
+	Amount    models.Decimal `json:"amount"`
+	Currency  string         `json:"currency"`
+	Rule      string         `json:"rule"`
+	StartDate string         `json:"startDate"`
+	EndDate   string         `json:"endDate"`
+	Active    *bool          `json:"active"`
+	Tags      []string       `json:"tags"`
+}
+
+// bindRecurringPayment parses and validates the request body, responding with 400 on failure
+func bindRecurringPayment(c *gin.Context) (models.RecurringPayment, bool) {
+	var r models.RecurringPayment
+	var payload recurringPaymentPayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid recurring payment data")
+		return r, false
+	}
+
+	if strings.TrimSpace(payload.Info) == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("info is required"), "Invalid recurring payment data")
+		return r, false
+	}
+
+	rule, err := recurring.ParseRule(payload.Rule)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid recurrence rule")
+		return r, false
+	}
+
+	startDate, err := time.Parse("2006-01-02", payload.StartDate)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid start date format")
+		return r, false
+	}
+
+	if payload.EndDate != "" {
+		endDate, err := time.Parse("2006-01-02", payload.EndDate)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid end date format")
+			return r, false
+		}
+		if endDate.Before(startDate) {
+			utils.RespondWithError(c, http.StatusBadRequest, errors.New("end date is before start date"), "Invalid end date")
+			return r, false
+		}
+		r.EndDate = &endDate
+	}
+
+	currency, err := models.NormalizeCurrency(payload.Currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid currency")
+		return r, false
+	}
+
+	amount, err := payload.Amount.Money(currency)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid amount")
+		return r, false
+	}
+
+	r.Info = payload.Info
+	r.Amount = amount
+	r.Currency = currency
+	r.Rule = rule.String()
+	r.StartDate = startDate
+	r.Active = payload.Active == nil || *payload.Active
+	r.Tags = payload.Tags
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+
+	return r, true
+}
+
+// ListRecurringPayments returns all recurring payment schedules of the workspace
+func (h *RecurringPaymentHandler) ListRecurringPayments(c *gin.Context) {
+	query := `
+		SELECT ` + recurringPaymentColumns + `, GROUP_CONCAT(rt.tag_id) as tag_ids
+		FROM recurring_payments r
+		LEFT JOIN recurring_payment_tags rt ON r.id = rt.recurring_payment_id
+		WHERE r.workspace_id = ?
+	`
+	params := []interface{}{workspaceID(c)}
+	if active := c.Query("active"); active != "" {
+		query += " AND r.active = ?"
+		params = append(params, active == "true")
+	}
+	query += " GROUP BY r.id ORDER BY r.start_date"
+
+	rows, err := h.db.Query(query, params...)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payments")
+		return
+	}
+	defer rows.Close()
+
+	results := make([]models.RecurringPayment, 0)
+	for rows.Next() {
+		var r models.RecurringPayment
+		var tagIDs sql.NullString
+		if err := scanRecurringPayment(rows, &r, &tagIDs); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan recurring payment")
+			return
+		}
+		r.Tags = []string{}
+		if tagIDs.Valid {
+			r.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		results = append(results, r)
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// CreateRecurringPayment creates a new schedule
+func (h *RecurringPaymentHandler) CreateRecurringPayment(c *gin.Context) {
+	r, ok := bindRecurringPayment(c)
+	if !ok || !validateTags(c, h.db, r.Tags) {
+		return
+	}
+
+	r.ID = uuid.New().String()
+	r.WorkspaceID = workspaceID(c)
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO recurring_payments (id, info, amount_minor, currency, rule, start_date, end_date, active, workspace_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ID, r.Info, r.Amount.Minor, r.Currency, r.Rule, r.StartDate, r.EndDate, r.Active, r.WorkspaceID, r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create recurring payment")
+		return
+	}
+
+	if err := setRecurringTags(tx, r.ID, r.Tags); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, r)
+}
+
+// GetRecurringPayment returns a specific schedule by ID
+func (h *RecurringPaymentHandler) GetRecurringPayment(c *gin.Context) {
+	r, err := fetchRecurringPayment(h.db, workspaceID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Recurring payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payment")
+		return
+	}
+
+	c.JSON(http.StatusOK, r)
+}
+
+// UpdateRecurringPayment updates a schedule. Payments already generated are
+// left untouched; the changes apply to future occurrences.
+func (h *RecurringPaymentHandler) UpdateRecurringPayment(c *gin.Context) {
+	id := c.Param("id")
+
+	r, ok := bindRecurringPayment(c)
+	if !ok || !validateTags(c, h.db, r.Tags) {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE recurring_payments
+		SET info = ?, amount_minor = ?, currency = ?, rule = ?, start_date = ?, end_date = ?, active = ?, updated_at = ?
+		WHERE id = ? AND workspace_id = ?
+	`, r.Info, r.Amount.Minor, r.Currency, r.Rule, r.StartDate, r.EndDate, r.Active, time.Now(), id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update recurring payment")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Recurring payment not found")
+		return
+	}
+
+	if err := setRecurringTags(tx, id, r.Tags); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	updated, err := fetchRecurringPayment(tx, workspaceID(c), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payment")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRecurringPayment deletes a schedule. Generated payments are kept
+// but no longer linked to it.
+func (h *RecurringPaymentHandler) DeleteRecurringPayment(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	// Check the schedule belongs to the workspace before unlinking it
+	var exists int
+	err = tx.QueryRow("SELECT COUNT(*) FROM recurring_payments WHERE id = ? AND workspace_id = ?", id, workspaceID(c)).Scan(&exists)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payment")
+		return
+	}
+	if exists == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Recurring payment not found")
+		return
+	}
+
+	_, err = tx.Exec("UPDATE payments SET recurring_payment_id = NULL, occurrence_date = NULL WHERE recurring_payment_id = ?", id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to unlink generated payments")
+		return
+	}
+
+	for _, table := range []string{"recurring_payment_tags", "recurring_payment_exceptions"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE recurring_payment_id = ?", id); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete recurring payment")
+			return
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM recurring_payments WHERE id = ?", id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete recurring payment")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Recurring payment not found")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListOccurrences previews the next occurrences of a schedule with skips,
+// overrides and already generated payments applied. Query parameters:
+// count (default 10, max 366) and from (YYYY-MM-DD, default today).
+func (h *RecurringPaymentHandler) ListOccurrences(c *gin.Context) {
+	id := c.Param("id")
+
+	r, err := fetchRecurringPayment(h.db, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Recurring payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payment")
+		return
+	}
+
+	count := utils.ParseIntWithDefault(c.Query("count"), 10)
+	if count < 1 || count > 366 {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("count must be between 1 and 366"), "Invalid count")
+		return
+	}
+
+	from := time.Now()
+	if fromStr := c.Query("from"); fromStr != "" {
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+			return
+		}
+	}
+
+	schedule, err := recurringSchedule(r)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Invalid stored recurrence rule")
+		return
+	}
+
+	exceptions, err := loadExceptions(h.db, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch occurrence exceptions")
+		return
+	}
+
+	generated, err := loadGeneratedPayments(h.db, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch generated payments")
+		return
+	}
+
+	occurrences := make([]models.RecurringOccurrence, 0, count)
+	for _, date := range schedule.Next(from, count) {
+		occurrence := buildOccurrence(r, date, exceptions[dayKey(date)])
+		if paymentID, ok := generated[dayKey(date)]; ok {
+			occurrence.Status = models.OccurrenceGenerated
+			occurrence.PaymentID = paymentID
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+
+	c.JSON(http.StatusOK, occurrences)
+}
+
+// occurrenceExceptionPayload skips or overrides a single occurrence
+type occurrenceExceptionPayload struct {
+	Action   string         `json:"action"`
+	Info     string         `json:"info"`
+	Amount   models.Decimal `json:"amount"`
+	DatePaid string         `json:"datePaid"`
+}
+
+// SetOccurrenceException skips or overrides a single occurrence that has
+// not been generated yet
+func (h *RecurringPaymentHandler) SetOccurrenceException(c *gin.Context) {
+	id := c.Param("id")
+
+	r, err := fetchRecurringPayment(h.db, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Recurring payment not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch recurring payment")
+		return
+	}
+
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		return
+	}
+
+	schedule, err := recurringSchedule(r)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Invalid stored recurrence rule")
+		return
+	}
+	if !schedule.Includes(date) {
+		utils.RespondWithError(c, http.StatusNotFound, errors.New("no occurrence on this date"), "Occurrence not found")
+		return
+	}
+
+	var payload occurrenceExceptionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid occurrence data")
+		return
+	}
+
+	var info, datePaid, amount interface{}
+	switch payload.Action {
+	case "skip":
+	case "override":
+		if payload.Info != "" {
+			info = payload.Info
+		}
+		if payload.Amount != "" {
+			money, err := payload.Amount.Money(r.Currency)
+			if err != nil {
+				utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid amount")
+				return
+			}
+			amount = money.Minor
+		}
+		if payload.DatePaid != "" {
+			d, err := time.Parse("2006-01-02", payload.DatePaid)
+			if err != nil {
+				utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+				return
+			}
+			datePaid = d
+		}
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New(`action must be "skip" or "override"`), "Invalid occurrence data")
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var paymentID string
+	err = tx.QueryRow("SELECT id FROM payments WHERE recurring_payment_id = ? AND occurrence_date = ?", id, date).Scan(&paymentID)
+	if err == nil {
+		utils.RespondWithError(c, http.StatusConflict, errors.New("occurrence already generated"), "Edit payment "+paymentID+" instead")
+		return
+	} else if err != sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch generated payment")
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO recurring_payment_exceptions (recurring_payment_id, occurrence_date, action, info, amount_minor, date_paid, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (recurring_payment_id, occurrence_date) DO UPDATE SET
+			action = excluded.action, info = excluded.info, amount_minor = excluded.amount_minor, date_paid = excluded.date_paid
+	`, id, date, payload.Action, info, amount, datePaid, time.Now())
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save occurrence exception")
+		return
+	}
+
+	exceptions, err := loadExceptions(tx, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch occurrence exceptions")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, buildOccurrence(r, date, exceptions[dayKey(date)]))
+}
+
+// DeleteOccurrenceException restores a skipped or overridden occurrence
+func (h *RecurringPaymentHandler) DeleteOccurrenceException(c *gin.Context) {
+	id := c.Param("id")
+
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid date format")
+		return
+	}
+
+	result, err := h.db.Exec(`
+		DELETE FROM recurring_payment_exceptions
+		WHERE recurring_payment_id IN (SELECT id FROM recurring_payments WHERE id = ? AND workspace_id = ?)
+			AND occurrence_date = ?
+	`, id, workspaceID(c), date)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete occurrence exception")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Occurrence exception not found")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GeneratePayments materialises all due occurrences of the workspace's
+// schedules immediately instead of waiting for the scheduler
+func (h *RecurringPaymentHandler) GeneratePayments(c *gin.Context) {
+	created, err := h.generateDue(time.Now(), auditorFor(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to generate recurring payments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Recurring payments generated successfully",
+		"created": created,
+	})
+}
+
+// GenerateDue creates a payment for every occurrence of every active
+// schedule up to and including now. It is idempotent: an occurrence that
+// already has a payment is never generated twice, and skipped occurrences
+// are not generated at all. Payments are created in each schedule's
+// workspace and audited as made by the server. It returns the number of
+// payments created.
+func (h *RecurringPaymentHandler) GenerateDue(now time.Time) (int, error) {
+	return h.generateDue(now, auditor{})
+}
+
+// generateDue is GenerateDue on behalf of the auditor's user, limited to
+// the auditor's workspace or covering all of them when it has none
+func (h *RecurringPaymentHandler) generateDue(now time.Time, a auditor) (int, error) {
+	workspaceID := a.workspaceID
+	rows, err := h.db.Query(`
+		SELECT `+recurringPaymentColumns+`, GROUP_CONCAT(rt.tag_id) as tag_ids
+		FROM recurring_payments r
+		LEFT JOIN recurring_payment_tags rt ON r.id = rt.recurring_payment_id
+		WHERE r.active AND (? = '' OR r.workspace_id = ?)
+		GROUP BY r.id
+	`, workspaceID, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+
+	var schedules []models.RecurringPayment
+	for rows.Next() {
+		var r models.RecurringPayment
+		var tagIDs sql.NullString
+		if err := scanRecurringPayment(rows, &r, &tagIDs); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		r.Tags = []string{}
+		if tagIDs.Valid {
+			r.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		schedules = append(schedules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A broken schedule is retried on the next run and mustn't hold up the
+	// others
+	total := 0
+	for _, r := range schedules {
+		created, err := h.generateSchedule(r, now, auditor{workspaceID: r.WorkspaceID, actorID: a.actorID})
+		if err != nil {
+			log.Printf("Failed to generate recurring payment %s: %v", r.ID, err)
+			continue
+		}
+		total += created
+	}
+	return total, nil
+}
+
+// generateSchedule materialises the due occurrences of one schedule in a
+// single transaction
+func (h *RecurringPaymentHandler) generateSchedule(r models.RecurringPayment, now time.Time, a auditor) (int, error) {
+	schedule, err := recurringSchedule(r)
+	if err != nil {
+		return 0, err
+	}
+
+	after := r.StartDate.AddDate(0, 0, -1)
+	if r.GeneratedUntil != nil && r.GeneratedUntil.After(after) {
+		after = *r.GeneratedUntil
+	}
+	today := recurring.Day(now)
+	dates := schedule.Between(after, today)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	exceptions, err := loadExceptions(tx, r.ID)
+	if err != nil {
+		return 0, err
+	}
+	rules, err := loadTagRules(tx, r.WorkspaceID)
+	if err != nil {
+		return 0, err
+	}
+	vendors, err := loadVendorMatcher(tx, r.WorkspaceID)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, date := range dates {
+		occurrence := buildOccurrence(r, date, exceptions[dayKey(date)])
+		if occurrence.Status == models.OccurrenceSkipped {
+			continue
+		}
+
+		// Trashed payments count too, so deleting an occurrence doesn't
+		// bring it back on the next run
+		var exists int
+		err := tx.QueryRow("SELECT COUNT(*) FROM payments WHERE recurring_payment_id = ? AND occurrence_date = ?", r.ID, date).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if exists > 0 {
+			continue
+		}
+
+		occurrenceDate := date
+		payment := models.Payment{
+			ID:                 uuid.New().String(),
+			Info:               occurrence.Info,
+			Amount:             occurrence.Amount,
+			Currency:           r.Currency,
+			DatePaid:           occurrence.DatePaid,
+			Tags:               r.Tags,
+			RecurringPaymentID: r.ID,
+			OccurrenceDate:     &occurrenceDate,
+			WorkspaceID:        r.WorkspaceID,
+			CreatedAt:          now,
+			UpdatedAt:          now,
+		}
+		tagrules.ApplyAll(rules, &payment)
+		payment.VendorID = vendors.match(payment.Info)
+		if err := insertPayment(tx, &payment); err != nil {
+			return 0, err
+		}
+		if err := a.record(tx, audit.EntityPayment, payment.ID, audit.ActionCreate, nil, payment); err != nil {
+			return 0, err
+		}
+		created++
+	}
+
+	if _, err := tx.Exec("UPDATE recurring_payments SET generated_until = ? WHERE id = ?", today, r.ID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return created, nil
+}
+
+// StartScheduler generates due recurring payments immediately and then on
+// every tick of interval until stop is closed
+func (h *RecurringPaymentHandler) StartScheduler(interval time.Duration, stop <-chan struct{}) {
+	run := func() {
+		created, err := h.GenerateDue(time.Now())
+		if err != nil {
+			log.Printf("Recurring payment generation failed: %v", err)
+			return
+		}
+		if created > 0 {
+			log.Printf("Generated %d recurring payment(s)", created)
+		}
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// occurrenceException is a stored skip or override for one occurrence
+type occurrenceException struct {
+	action   string
+	info     sql.NullString
+	amount   sql.NullInt64
+	datePaid sql.NullTime
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func loadExceptions(db querier, recurringID string) (map[string]occurrenceException, error) {
+	rows, err := db.Query(`
+		SELECT occurrence_date, action, info, amount_minor, date_paid
+		FROM recurring_payment_exceptions
+		WHERE recurring_payment_id = ?
+	`, recurringID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exceptions := make(map[string]occurrenceException)
+	for rows.Next() {
+		var date time.Time
+		var e occurrenceException
+		if err := rows.Scan(&date, &e.action, &e.info, &e.amount, &e.datePaid); err != nil {
+			return nil, err
+		}
+		exceptions[dayKey(date)] = e
+	}
+	return exceptions, rows.Err()
+}
+
+// loadGeneratedPayments maps occurrence dates to the payments generated for them
+func loadGeneratedPayments(db querier, recurringID string) (map[string]string, error) {
+	rows, err := db.Query("SELECT occurrence_date, id FROM payments WHERE recurring_payment_id = ?", recurringID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	generated := make(map[string]string)
+	for rows.Next() {
+		var date time.Time
+		var id string
+		if err := rows.Scan(&date, &id); err != nil {
+			return nil, err
+		}
+		generated[dayKey(date)] = id
+	}
+	return generated, rows.Err()
+}
+
+func fetchRecurringPayment(db querier, workspaceID, id string) (models.RecurringPayment, error) {
+	var r models.RecurringPayment
+	var tagIDs sql.NullString
+
+	row := db.QueryRow(`
+		SELECT `+recurringPaymentColumns+`, GROUP_CONCAT(rt.tag_id) as tag_ids
+		FROM recurring_payments r
+		LEFT JOIN recurring_payment_tags rt ON r.id = rt.recurring_payment_id
+		WHERE r.id = ? AND r.workspace_id = ?
+		GROUP BY r.id
+	`, id, workspaceID)
+	if err := scanRecurringPayment(row, &r, &tagIDs); err != nil {
+		return r, err
+	}
+
+	r.Tags = []string{}
+	if tagIDs.Valid {
+		r.Tags = utils.SplitCommaString(tagIDs.String)
+	}
+	return r, nil
+}
+
+func setRecurringTags(tx *sql.Tx, recurringID string, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM recurring_payment_tags WHERE recurring_payment_id = ?", recurringID); err != nil {
+		return err
+	}
+	for _, tagID := range tags {
+		if _, err := tx.Exec("INSERT INTO recurring_payment_tags (recurring_payment_id, tag_id) VALUES (?, ?)", recurringID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recurringSchedule(r models.RecurringPayment) (recurring.Schedule, error) {
+	rule, err := recurring.ParseRule(r.Rule)
+	if err != nil {
+		return recurring.Schedule{}, err
+	}
+	return recurring.Schedule{Rule: rule, Start: r.StartDate, End: r.EndDate}, nil
+}
+
+// buildOccurrence applies an optional exception to the schedule's defaults
+func buildOccurrence(r models.RecurringPayment, date time.Time, e occurrenceException) models.RecurringOccurrence {
+	occurrence := models.RecurringOccurrence{
+		Date:     date,
+		Status:   models.OccurrenceScheduled,
+		Info:     renderInfo(r.Info, date),
+		Amount:   r.Amount,
+		DatePaid: date,
+	}
+
+	switch e.action {
+	case "skip":
+		occurrence.Status = models.OccurrenceSkipped
+	case "override":
+		occurrence.Status = models.OccurrenceOverridden
+		if e.info.Valid {
+			occurrence.Info = renderInfo(e.info.String, date)
+		}
+		if e.amount.Valid {
+			occurrence.Amount = models.NewMoney(e.amount.Int64, r.Currency)
+		}
+		if e.datePaid.Valid {
+			occurrence.DatePaid = e.datePaid.Time
+		}
+	}
+
+	return occurrence
+}
+
+// renderInfo fills the {date}, {month} and {year} placeholders
+func renderInfo(template string, date time.Time) string {
+	return strings.NewReplacer(
+		"{date}", date.Format("2006-01-02"),
+		"{month}", date.Format("January"),
+		"{year}", date.Format("2006"),
+	).Replace(template)
+}
+
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateDueSkipsBrokenSchedules(t *testing.T) {
+	db := newTestDB(t)
+	h := NewRecurringPaymentHandler(db)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	otherID := createTestWorkspace(t, db, "other")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, r := range []struct{ id, workspaceID, rule string }{
+		{"broken", workspaceID, "FREQ=SOMETIMES"},
+		{"rent", otherID, "FREQ=MONTHLY"},
+	} {
+		_, err := db.Exec(`
+			INSERT INTO recurring_payments (id, info, amount_minor, currency, rule, start_date, active, workspace_id, created_at, updated_at)
+			VALUES (?, 'Rent', 85000, 'EUR', ?, ?, true, ?, ?, ?)
+		`, r.id, r.rule, start, r.workspaceID, start, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created, err := h.GenerateDue(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 3 {
+		t.Errorf("created %d payments, want 3", created)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM payments WHERE workspace_id = ?", otherID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("%d payments for the valid schedule, want 3", count)
+	}
+}
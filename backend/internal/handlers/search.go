@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Entity types in the search index
+const (
+	searchPayment  = "payment"
+	searchDocument = "document"
+)
+
+// searchTypes maps each searchable entity type to the token scope needed to
+// see it and the table holding it
+var searchTypes = []struct{ entityType, scope, table string }{
+	{searchPayment, "payments", "payments"},
+	{searchDocument, "documents", "documents"},
+}
+
+// Highlighted terms are marked with control characters by SQLite so the
+// rest of the text can be escaped before they become <mark> tags
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+var (
+	errEmptySearch       = errors.New("search query has no words")
+	errInvalidSearchType = errors.New("invalid search type")
+)
+
+type SearchHandler struct {
+	db *sql.DB
+}
+
+func NewSearchHandler(db *sql.DB) *SearchHandler {
+	return &SearchHandler{db: db}
+}
+
+// RegisterRoutes registers the search route
+func (h *SearchHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/search", requirePermission(auth.PermRead), h.Search)
+}
+
+// Search finds payments and documents matching the words of the q query
+// parameter, best matches first. Titles count more than descriptions and
+// file names, which count more than text extracted from files. The type
+// parameter limits results to payments or documents; API tokens only see
+// the types their scopes allow.
+func (h *SearchHandler) Search(c *gin.Context) {
+	match := ftsQuery(c.Query("q"))
+	if match == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errEmptySearch, "Search query is required")
+		return
+	}
+
+	filter := c.Query("type")
+	if filter != "" && filter != searchPayment && filter != searchDocument {
+		utils.RespondWithError(c, http.StatusBadRequest, errInvalidSearchType, "type must be payment or document")
+		return
+	}
+
+	params := []interface{}{match, workspaceID(c)}
+	whereClause := []string{"search_index MATCH ?", "workspace_id = ?"}
+
+	var visible []string
+	for _, t := range searchTypes {
+		if filter != "" && filter != t.entityType {
+			continue
+		}
+		if !tokenAllows(c, t.scope) {
+			continue
+		}
+		// Trashed items stay indexed so restoring them needs no reindexing
+		visible = append(visible, "(entity_type = ? AND entity_id IN (SELECT id FROM "+t.table+" WHERE deleted_at IS NULL))")
+		params = append(params, t.entityType)
+	}
+	if len(visible) == 0 {
+		utils.RespondWithError(c, http.StatusForbidden, errMissingScope, "API token needs the payments:read or documents:read scope")
+		return
+	}
+	whereClause = append(whereClause, "("+strings.Join(visible, " OR ")+")")
+
+	page := utils.ParseIntWithDefault(c.Query("page"), 1)
+	limit := utils.ParseIntWithDefault(c.Query("limit"), 20)
+
+	rows, err := h.db.Query(`
+		SELECT entity_type, entity_id,
+			highlight(search_index, 3, ?, ?),
+			snippet(search_index, -1, ?, ?, '…', 16),
+			bm25(search_index, 0, 0, 0, 10.0, 4.0, 1.0) AS score
+		FROM search_index
+		WHERE `+utils.JoinWithAND(whereClause)+`
+		ORDER BY score
+		LIMIT ? OFFSET ?
+	`, append(append([]interface{}{highlightStart, highlightEnd, highlightStart, highlightEnd}, params...), limit, (page-1)*limit)...)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to search")
+		return
+	}
+	defer rows.Close()
+
+	hits := make([]models.SearchHit, 0)
+	for rows.Next() {
+		var hit models.SearchHit
+		if err := rows.Scan(&hit.Type, &hit.ID, &hit.Title, &hit.Snippet, &hit.Score); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan search result")
+			return
+		}
+		hit.Title = markHighlights(hit.Title)
+		hit.Snippet = markHighlights(hit.Snippet)
+		// bm25 scores better matches lower; flip it so higher is better
+		hit.Score = -hit.Score
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to search")
+		return
+	}
+
+	var total int
+	err = h.db.QueryRow("SELECT COUNT(*) FROM search_index WHERE "+utils.JoinWithAND(whereClause), params...).Scan(&total)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get total count")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+		"results": hits,
+	})
+}
+
+// tokenAllows reports whether the request may read resource. Only API
+// tokens are limited by scopes.
+func tokenAllows(c *gin.Context, resource string) bool {
+	scopes, ok := c.Get(tokenScopesKey)
+	return !ok || auth.ScopeAllows(scopes.([]string), resource, false)
+}
+
+// ftsQuery turns free text into an FTS5 query matching entries that contain
+// every word, each as a prefix. Punctuation is dropped, so the text can
+// never be a malformed query. It returns "" if the text has no words.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// markHighlights escapes text for HTML and turns the highlight markers into
+// <mark> tags
+func markHighlights(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, highlightStart, "<mark>")
+	return strings.ReplaceAll(text, highlightEnd, "</mark>")
+}
+
+// indexPayment brings a payment's search entry up to date with its row,
+// its attachments and the text extracted from them so far, or removes it
+// if the payment no longer exists
+func indexPayment(tx execer, id string) error {
+	if _, err := tx.Exec("DELETE FROM search_index WHERE entity_type = ? AND entity_id = ?", searchPayment, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO search_index (entity_type, entity_id, workspace_id, title, body, file_text)
+		SELECT ?, p.id, p.workspace_id, p.info,
+			COALESCE((SELECT GROUP_CONCAT(original_name, ' ') FROM payment_attachments WHERE payment_id = p.id), ''),
+			COALESCE((
+				SELECT GROUP_CONCAT(b.text, ' ') FROM payment_attachments a
+				JOIN blobs b ON b.hash = a.file_hash
+				WHERE a.payment_id = p.id AND b.text IS NOT NULL
+			), '')
+		FROM payments p
+		WHERE p.id = ?
+	`, searchPayment, id)
+	return err
+}
+
+// indexDocument brings a document's search entry up to date with its row
+// and the text extracted from its file so far, or removes it if the
+// document no longer exists
+func indexDocument(tx execer, id string) error {
+	if _, err := tx.Exec("DELETE FROM search_index WHERE entity_type = ? AND entity_id = ?", searchDocument, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO search_index (entity_type, entity_id, workspace_id, title, body, file_text)
+		SELECT ?, d.id, d.workspace_id, d.title, COALESCE(d.description, '') || ' ' || d.original_name,
+			COALESCE((SELECT text FROM blobs WHERE hash = d.file_hash), '')
+		FROM documents d
+		WHERE d.id = ?
+	`, searchDocument, id)
+	return err
+}
+
+// searchFilter returns a condition restricting column, the ID of an entity
+// of entityType, to entries matching the words of the q query parameter,
+// with its parameters. It returns "" if q has no words.
+func searchFilter(c *gin.Context, entityType, column string) (string, []interface{}) {
+	match := ftsQuery(c.Query("q"))
+	if match == "" {
+		return "", nil
+	}
+	return column + " IN (SELECT entity_id FROM search_index WHERE search_index MATCH ? AND entity_type = ?)",
+		[]interface{}{match, entityType}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestWorkspace(t, db, "other")
+	createTestPayment(t, db, workspaceID, "rent", "Rent <March>", 100000, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "trashed", "Rent February", 100000, "2024-02-01")
+	createTestPayment(t, db, workspaceID, "coffee", "Coffee", 450, "2024-03-02")
+	createTestPayment(t, db, "other", "elsewhere", "Rent April", 100000, "2024-04-01")
+	now := time.Now()
+	for _, exec := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE payments SET deleted_at = ? WHERE id = 'trashed'", []interface{}{now}},
+		{`INSERT INTO documents (id, title, description, file_path, original_name, file_size, workspace_id, created_at, updated_at)
+			VALUES ('lease', 'Lease', 'Monthly rent agreement', 'documents/lease.pdf', 'lease.pdf', 0, 'ws', ?, ?)`, []interface{}{now, now}},
+	} {
+		if _, err := db.Exec(exec.query, exec.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"rent", "trashed", "coffee", "elsewhere"} {
+		if err := indexPayment(db, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := indexDocument(db, "lease"); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(router http.Handler, query string) []models.SearchHit {
+		t.Helper()
+		w := serve(router, http.MethodGet, "/search"+query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /search%s = %d: %s", query, w.Code, w.Body)
+		}
+		var resp struct {
+			Total   int                `json:"total"`
+			Results []models.SearchHit `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Total != len(resp.Results) {
+			t.Errorf("GET /search%s total = %d with %d results", query, resp.Total, len(resp.Results))
+		}
+		return resp.Results
+	}
+	router := newTestRouter(workspaceID, auth.RoleViewer)
+	NewSearchHandler(db).RegisterRoutes(router.Group(""))
+
+	// The payment titled Rent ranks above the document describing rent; the
+	// trashed payment and the other workspace's are left out
+	hits := search(router, "?q=ren")
+	if len(hits) != 2 || hits[0].ID != "rent" || hits[1].ID != "lease" {
+		t.Fatalf("q=ren = %+v, want rent then lease", hits)
+	}
+	if hits[0].Title != "<mark>Rent</mark> &lt;March&gt;" {
+		t.Errorf("title = %q, want the match marked and the rest escaped", hits[0].Title)
+	}
+	if hits[0].Score <= hits[1].Score {
+		t.Errorf("scores %v and %v, want the title match higher", hits[0].Score, hits[1].Score)
+	}
+
+	if hits := search(router, "?q=rent&type=document"); len(hits) != 1 || hits[0].ID != "lease" {
+		t.Errorf("type=document = %+v, want lease", hits)
+	}
+	if hits := search(router, "?q=rent+march"); len(hits) != 1 || hits[0].ID != "rent" {
+		t.Errorf("q=rent march = %+v, want rent", hits)
+	}
+
+	// A payments token does not see documents
+	tokenRouter := newTestRouter(workspaceID, auth.RoleViewer, "payments:read")
+	NewSearchHandler(db).RegisterRoutes(tokenRouter.Group(""))
+	if hits := search(tokenRouter, "?q=rent"); len(hits) != 1 || hits[0].Type != "payment" {
+		t.Errorf("payments token = %+v, want only the payment", hits)
+	}
+	if w := serve(tokenRouter, http.MethodGet, "/search?q=rent&type=document", ""); w.Code != http.StatusForbidden {
+		t.Errorf("payments token searching documents = %d, want 403", w.Code)
+	}
+
+	for _, query := range []string{"?q=", "?q=%22*", "?q=rent&type=tag"} {
+		if w := serve(router, http.MethodGet, "/search"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /search%s = %d, want 400", query, w.Code)
+		}
+	}
+}
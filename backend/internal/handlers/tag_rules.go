@@ -0,0 +1,753 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/tagrules"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Roles of a tag in a rule, as stored in tag_rule_tags
+const (
+	ruleTagHas    = "has"
+	ruleTagLacks  = "lacks"
+	ruleTagAdd    = "add"
+	ruleTagRemove = "remove"
+)
+
+// maxRuleTestResults caps the matches listed by TestTagRule
+const maxRuleTestResults = 100
+
+type TagRuleHandler struct {
+	db *sql.DB
+}
+
+func NewTagRuleHandler(db *sql.DB) *TagRuleHandler {
+	return &TagRuleHandler{db: db}
+}
+
+// RegisterRoutes registers all tag rule routes
+func (h *TagRuleHandler) RegisterRoutes(router *gin.RouterGroup) {
+	rules := router.Group("/tag-rules", requireScope("tag-rules"))
+	{
+		rules.GET("", requirePermission(auth.PermRead), h.ListTagRules)
+		rules.POST("", requirePermission(auth.PermWrite), h.CreateTagRule)
+		rules.PUT("/order", requirePermission(auth.PermWrite), h.ReorderTagRules)
+		rules.POST("/apply", requirePermission(auth.PermWrite), h.ApplyTagRules)
+		rules.GET("/:id", requirePermission(auth.PermRead), h.GetTagRule)
+		rules.PUT("/:id", requirePermission(auth.PermWrite), h.UpdateTagRule)
+		rules.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteTagRule)
+	}
+
+	// Testing a rule changes nothing, so a read scope is enough
+	router.POST("/tag-rules/test", requireReadScope("tag-rules"), requirePermission(auth.PermRead), h.TestTagRule)
+}
+
+const tagRuleColumns = `r.id, r.name, r.position, r.active, r.info_contains, r.info_pattern,
+	r.min_amount, r.max_amount, r.currency, r.weekdays, r.set_fully_paid, r.created_at, r.updated_at`
+
+// tagRulePayload is the request body for creating, updating or testing a rule
+type tagRulePayload struct {
+	Name       string                   `json:"name"`
+	Active     *bool                    `json:"active"`
+	Conditions models.TagRuleConditions `json:"conditions"`
+	Actions    models.TagRuleActions    `json:"actions"`
+}
+
+// bindTagRule parses and validates the request body, responding with 400
+// on failure. The name is only required for rules that are saved.
+func bindTagRule(c *gin.Context, db queryRower, requireName bool) (models.TagRule, bool) {
+	var r models.TagRule
+	var payload tagRulePayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid tag rule data")
+		return r, false
+	}
+
+	if requireName && strings.TrimSpace(payload.Name) == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("name is required"), "Invalid tag rule data")
+		return r, false
+	}
+
+	cond := payload.Conditions
+	if cond.Currency != "" {
+		currency, err := models.NormalizeCurrency(cond.Currency)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid currency")
+			return r, false
+		}
+		cond.Currency = currency
+	}
+
+	r.Name = strings.TrimSpace(payload.Name)
+	r.Active = payload.Active == nil || *payload.Active
+	r.Conditions = cond
+	r.Actions = payload.Actions
+	if _, err := tagrules.Compile(r); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid tag rule data")
+		return r, false
+	}
+	r.Conditions.Weekdays = normalizeWeekdays(cond.Weekdays)
+
+	var tags []string
+	for _, list := range [][]string{cond.HasTags, cond.LacksTags, r.Actions.AddTags, r.Actions.RemoveTags} {
+		tags = append(tags, list...)
+	}
+	if !validateTags(c, db, tags) {
+		return r, false
+	}
+	return r, true
+}
+
+// allowPaymentChanges responds with 403 and returns false unless the
+// request may change payments the way rules do: rules rewrite payment tags,
+// and those setting fullyPaid settle payments too
+func allowPaymentChanges(c *gin.Context, setsFullyPaid bool) bool {
+	if !scopeAllows(c, "payments", true) {
+		return false
+	}
+	return !setsFullyPaid || allow(c, currentRole(c), auth.PermMarkPaid)
+}
+
+// normalizeWeekdays turns validated day names into lower-case full names in
+// week order, Monday first
+func normalizeWeekdays(names []string) []string {
+	days := make(map[time.Weekday]bool)
+	for _, name := range names {
+		day, _ := tagrules.ParseWeekday(name)
+		days[day] = true
+	}
+	var normalized []string
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		if days[day] {
+			normalized = append(normalized, strings.ToLower(day.String()))
+		}
+	}
+	return normalized
+}
+
+// ListTagRules returns the workspace's rules in the order they run
+func (h *TagRuleHandler) ListTagRules(c *gin.Context) {
+	rules, err := fetchTagRules(h.db, workspaceID(c), "", false)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rules")
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// GetTagRule returns a specific rule by ID
+func (h *TagRuleHandler) GetTagRule(c *gin.Context) {
+	rules, err := fetchTagRules(h.db, workspaceID(c), c.Param("id"), false)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rule")
+		return
+	}
+	if len(rules) == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Tag rule not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, rules[0])
+}
+
+// CreateTagRule adds a rule after the workspace's existing ones
+func (h *TagRuleHandler) CreateTagRule(c *gin.Context) {
+	r, ok := bindTagRule(c, h.db, true)
+	if !ok {
+		return
+	}
+	if r.Actions.FullyPaid != nil && !allowPaymentChanges(c, true) {
+		return
+	}
+
+	r.ID = uuid.New().String()
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = r.CreatedAt
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT COALESCE(MAX(position), 0) + 1 FROM tag_rules WHERE workspace_id = ?", workspaceID(c)).Scan(&r.Position)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create tag rule")
+		return
+	}
+
+	cond := r.Conditions
+	_, err = tx.Exec(`
+		INSERT INTO tag_rules (id, workspace_id, name, position, active, info_contains, info_pattern,
+			min_amount, max_amount, currency, weekdays, set_fully_paid, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ID, workspaceID(c), r.Name, r.Position, r.Active, cond.InfoContains, cond.InfoPattern,
+		string(cond.MinAmount), string(cond.MaxAmount), cond.Currency, strings.Join(cond.Weekdays, ","),
+		r.Actions.FullyPaid, r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create tag rule")
+		return
+	}
+
+	if err := setTagRuleTags(tx, r); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, r)
+}
+
+// UpdateTagRule updates a specific rule, keeping its position
+func (h *TagRuleHandler) UpdateTagRule(c *gin.Context) {
+	id := c.Param("id")
+
+	r, ok := bindTagRule(c, h.db, true)
+	if !ok {
+		return
+	}
+	if r.Actions.FullyPaid != nil && !allowPaymentChanges(c, true) {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	cond := r.Conditions
+	result, err := tx.Exec(`
+		UPDATE tag_rules
+		SET name = ?, active = ?, info_contains = ?, info_pattern = ?, min_amount = ?, max_amount = ?,
+			currency = ?, weekdays = ?, set_fully_paid = ?, updated_at = ?
+		WHERE id = ? AND workspace_id = ?
+	`, r.Name, r.Active, cond.InfoContains, cond.InfoPattern, string(cond.MinAmount), string(cond.MaxAmount),
+		cond.Currency, strings.Join(cond.Weekdays, ","), r.Actions.FullyPaid, time.Now(), id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update tag rule")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Tag rule not found")
+		return
+	}
+
+	r.ID = id
+	if err := setTagRuleTags(tx, r); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to associate tags")
+		return
+	}
+
+	rules, err := fetchTagRules(tx, workspaceID(c), id, false)
+	if err != nil || len(rules) == 0 {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rule")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, rules[0])
+}
+
+// DeleteTagRule deletes a specific rule
+func (h *TagRuleHandler) DeleteTagRule(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM tag_rule_tags WHERE rule_id IN (SELECT id FROM tag_rules WHERE id = ? AND workspace_id = ?)", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete tag rule tags")
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM tag_rules WHERE id = ? AND workspace_id = ?", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete tag rule")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Tag rule not found")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ReorderTagRules sets the order rules run in. The body lists the IDs of
+// all of the workspace's rules, first to run first.
+func (h *TagRuleHandler) ReorderTagRules(c *gin.Context) {
+	var payload struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid rule order")
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	rules, err := fetchTagRules(tx, workspaceID(c), "", false)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rules")
+		return
+	}
+
+	known := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		known[r.ID] = true
+	}
+	for _, id := range payload.IDs {
+		if !known[id] {
+			utils.RespondWithError(c, http.StatusBadRequest, errors.New("ids must list every rule of the workspace once"), "Invalid rule order")
+			return
+		}
+		delete(known, id)
+	}
+	if len(known) > 0 || len(payload.IDs) != len(rules) {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("ids must list every rule of the workspace once"), "Invalid rule order")
+		return
+	}
+
+	for i, id := range payload.IDs {
+		if _, err := tx.Exec("UPDATE tag_rules SET position = ? WHERE id = ?", i+1, id); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to reorder tag rules")
+			return
+		}
+	}
+
+	rules, err = fetchTagRules(tx, workspaceID(c), "", false)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rules")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// ruleChange is what rules do, or would do, to one payment
+type ruleChange struct {
+	PaymentID   string       `json:"paymentId"`
+	Info        string       `json:"info"`
+	DatePaid    time.Time    `json:"datePaid"`
+	Amount      models.Money `json:"amount"`
+	Rules       []string     `json:"rules,omitempty"`
+	AddedTags   []string     `json:"addedTags"`
+	RemovedTags []string     `json:"removedTags"`
+	// Whether the payment becomes fully paid
+	Settles bool `json:"settles"`
+
+	before models.Payment
+}
+
+func (rc ruleChange) changed() bool {
+	return len(rc.AddedTags) > 0 || len(rc.RemovedTags) > 0 || rc.Settles
+}
+
+// TestTagRule runs the rule in the request body, which need not be saved,
+// against the workspace's payments without changing them. It takes the
+// filters of ListPayments and lists up to maxRuleTestResults matches with
+// what the rule would do to them.
+func (h *TagRuleHandler) TestTagRule(c *gin.Context) {
+	r, ok := bindTagRule(c, h.db, false)
+	if !ok {
+		return
+	}
+	rule, _ := tagrules.Compile(r)
+
+	checked, changes, err := matchTagRules(h.db, c, []tagrules.Rule{rule})
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to test tag rule")
+		return
+	}
+
+	matched := len(changes)
+	wouldChange := 0
+	for _, change := range changes {
+		if change.changed() {
+			wouldChange++
+		}
+	}
+	if len(changes) > maxRuleTestResults {
+		changes = changes[:maxRuleTestResults]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"checked": checked,
+		"matched": matched,
+		"changed": wouldChange,
+		"matches": changes,
+	})
+}
+
+// ApplyTagRules runs the workspace's active rules over its existing
+// payments, selected with the filters of ListPayments, in one transaction.
+// With dry_run=true it only reports what would change. Changed payments are
+// recorded in the audit log.
+func (h *TagRuleHandler) ApplyTagRules(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	rules, err := loadTagRules(tx, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag rules")
+		return
+	}
+
+	if !dryRun {
+		setsFullyPaid := false
+		for _, rule := range rules {
+			setsFullyPaid = setsFullyPaid || rule.SetsFullyPaid()
+		}
+		if !allowPaymentChanges(c, setsFullyPaid) {
+			return
+		}
+	}
+
+	checked, matches, err := matchTagRules(tx, c, rules)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to apply tag rules")
+		return
+	}
+
+	changes := make([]ruleChange, 0)
+	for _, change := range matches {
+		if change.changed() {
+			changes = append(changes, change)
+		}
+	}
+
+	if !dryRun {
+		a := auditorFor(c)
+		now := time.Now()
+		for _, change := range changes {
+			if err := applyRuleChange(tx, a, change, now); err != nil {
+				utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to apply tag rules")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dryRun":  dryRun,
+		"checked": checked,
+		"changed": len(changes),
+		"changes": changes,
+	})
+}
+
+// matchTagRules runs rules over the workspace's untrashed payments selected
+// by the request's filters. It returns how many payments were checked and
+// a change for every payment at least one rule matched.
+func matchTagRules(db querier, c *gin.Context, rules []tagrules.Rule) (int, []ruleChange, error) {
+	whereClause, params := paymentFilters(c)
+	whereClause = append(whereClause, "p.deleted_at IS NULL")
+
+	rows, err := db.Query(`
+		SELECT
+			`+paymentColumns+`,
+			GROUP_CONCAT(pt.tag_id) as tag_ids
+		FROM payments p
+		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
+		WHERE `+utils.JoinWithAND(whereClause)+`
+		GROUP BY p.id
+		ORDER BY p.date_paid DESC
+	`, params...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	checked := 0
+	changes := make([]ruleChange, 0)
+	for rows.Next() {
+		var p models.Payment
+		var tagIDs sql.NullString
+		if err := scanPayment(rows, &p, &tagIDs); err != nil {
+			return 0, nil, err
+		}
+		p.Tags = []string{}
+		if tagIDs.Valid {
+			p.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		p.WorkspaceID = workspaceID(c)
+		checked++
+
+		after := p
+		matched := tagrules.ApplyAll(rules, &after)
+		if len(matched) == 0 {
+			continue
+		}
+
+		change := ruleChange{
+			PaymentID:   p.ID,
+			Info:        p.Info,
+			DatePaid:    p.DatePaid,
+			Amount:      p.Amount,
+			AddedTags:   []string{},
+			RemovedTags: []string{},
+			Settles:     after.FullyPaid && !p.FullyPaid,
+			before:      p,
+		}
+		for _, id := range matched {
+			if id != "" {
+				change.Rules = append(change.Rules, id)
+			}
+		}
+		for _, tag := range after.Tags {
+			if !containsString(p.Tags, tag) {
+				change.AddedTags = append(change.AddedTags, tag)
+			}
+		}
+		for _, tag := range p.Tags {
+			if !containsString(after.Tags, tag) {
+				change.RemovedTags = append(change.RemovedTags, tag)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return checked, changes, rows.Err()
+}
+
+// applyRuleChange writes the tags and payment status rules gave an
+// existing payment. A payment the rules mark as paid is settled as of its
+// date; rules never unsettle a payment.
+func applyRuleChange(tx *sql.Tx, a auditor, change ruleChange, now time.Time) error {
+	id := change.PaymentID
+	for _, tag := range change.AddedTags {
+		if _, err := tx.Exec("INSERT INTO payment_tags (payment_id, tag_id) VALUES (?, ?)", id, tag); err != nil {
+			return err
+		}
+	}
+	for _, tag := range change.RemovedTags {
+		if _, err := tx.Exec("DELETE FROM payment_tags WHERE payment_id = ? AND tag_id = ?", id, tag); err != nil {
+			return err
+		}
+	}
+	if change.Settles {
+		if err := settlePayment(tx, id, change.before.DatePaid); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE payments SET updated_at = ? WHERE id = ?", now, id); err != nil {
+		return err
+	}
+
+	updated, err := fetchPayment(tx, a.workspaceID, id)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, audit.EntityPayment, id, audit.ActionUpdate, change.before, updated)
+}
+
+// fetchTagRules loads the workspace's rules in the order they run, or only
+// the one with the given ID, or only the active ones
+func fetchTagRules(db querier, workspaceID, id string, activeOnly bool) ([]models.TagRule, error) {
+	query := "SELECT " + tagRuleColumns + " FROM tag_rules r WHERE r.workspace_id = ?"
+	params := []interface{}{workspaceID}
+	if id != "" {
+		query += " AND r.id = ?"
+		params = append(params, id)
+	}
+	if activeOnly {
+		query += " AND r.active"
+	}
+	query += " ORDER BY r.position, r.created_at"
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]models.TagRule, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var r models.TagRule
+		var minAmount, maxAmount, weekdays string
+		var fullyPaid sql.NullBool
+		err := rows.Scan(&r.ID, &r.Name, &r.Position, &r.Active, &r.Conditions.InfoContains,
+			&r.Conditions.InfoPattern, &minAmount, &maxAmount, &r.Conditions.Currency, &weekdays,
+			&fullyPaid, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Conditions.MinAmount = models.Decimal(minAmount)
+		r.Conditions.MaxAmount = models.Decimal(maxAmount)
+		if weekdays != "" {
+			r.Conditions.Weekdays = strings.Split(weekdays, ",")
+		}
+		if fullyPaid.Valid {
+			r.Actions.FullyPaid = &fullyPaid.Bool
+		}
+		index[r.ID] = len(rules)
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT rt.rule_id, rt.tag_id, rt.role FROM tag_rule_tags rt
+		JOIN tag_rules r ON r.id = rt.rule_id
+		WHERE r.workspace_id = ?
+		ORDER BY rt.rowid
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ruleID, tagID, role string
+		if err := rows.Scan(&ruleID, &tagID, &role); err != nil {
+			return nil, err
+		}
+		i, ok := index[ruleID]
+		if !ok {
+			continue
+		}
+		r := &rules[i]
+		switch role {
+		case ruleTagHas:
+			r.Conditions.HasTags = append(r.Conditions.HasTags, tagID)
+		case ruleTagLacks:
+			r.Conditions.LacksTags = append(r.Conditions.LacksTags, tagID)
+		case ruleTagAdd:
+			r.Actions.AddTags = append(r.Actions.AddTags, tagID)
+		case ruleTagRemove:
+			r.Actions.RemoveTags = append(r.Actions.RemoveTags, tagID)
+		}
+	}
+	return rules, rows.Err()
+}
+
+// setTagRuleTags replaces the tags a rule refers to
+func setTagRuleTags(tx *sql.Tx, r models.TagRule) error {
+	if _, err := tx.Exec("DELETE FROM tag_rule_tags WHERE rule_id = ?", r.ID); err != nil {
+		return err
+	}
+	for _, role := range []struct {
+		name string
+		tags []string
+	}{
+		{ruleTagHas, r.Conditions.HasTags},
+		{ruleTagLacks, r.Conditions.LacksTags},
+		{ruleTagAdd, r.Actions.AddTags},
+		{ruleTagRemove, r.Actions.RemoveTags},
+	} {
+		for _, tagID := range role.tags {
+			_, err := tx.Exec("INSERT OR IGNORE INTO tag_rule_tags (rule_id, tag_id, role) VALUES (?, ?, ?)", r.ID, tagID, role.name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadTagRules compiles the workspace's active rules in the order they run.
+// Rules left without actions by deleted tags are skipped.
+func loadTagRules(db querier, workspaceID string) ([]tagrules.Rule, error) {
+	stored, err := fetchTagRules(db, workspaceID, "", true)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]tagrules.Rule, 0, len(stored))
+	for _, r := range stored {
+		rule, err := tagrules.Compile(r)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// applyTagRules runs the workspace's active rules on a payment about to be
+// created or updated
+func applyTagRules(db querier, workspaceID string, payment *models.Payment) error {
+	rules, err := loadTagRules(db, workspaceID)
+	if err != nil {
+		return err
+	}
+	tagrules.ApplyAll(rules, payment)
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, existing := range list {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"expense_tracker/internal/auth"
+	"net/http"
+	"testing"
+)
+
+func TestTagRulesSettlingPaymentsNeedPaymentRights(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestPayment(t, db, workspaceID, "p1", "Rent March", 85000, "2024-03-01")
+	h := NewTagRuleHandler(db)
+
+	const settling = `{"name": "Rent", "conditions": {"infoContains": "rent"}, "actions": {"fullyPaid": true}}`
+	tests := []struct {
+		name   string
+		scopes []string
+		want   int
+	}{
+		{"rules scope only", []string{"tag-rules:write"}, http.StatusForbidden},
+		{"rules and payments scopes", []string{"tag-rules:write", "payments:write"}, http.StatusCreated},
+		{"session", nil, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(workspaceID, auth.RoleEditor, tt.scopes...)
+		h.RegisterRoutes(router.Group(""))
+		if w := serve(router, "POST", "/tag-rules", settling); w.Code != tt.want {
+			t.Errorf("%s: create settling rule = %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+
+	// Rules that only tag don't touch payment rights when created
+	router := newTestRouter(workspaceID, auth.RoleEditor, "tag-rules:write")
+	h.RegisterRoutes(router.Group(""))
+	if _, err := db.Exec("INSERT INTO tags (id, name, color, workspace_id) VALUES ('t1', 'Housing', '#00ff00', ?)", workspaceID); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, "POST", "/tag-rules", `{"name": "Tag", "conditions": {"infoContains": "rent"}, "actions": {"addTags": ["t1"]}}`); w.Code != http.StatusCreated {
+		t.Errorf("create tagging rule = %d %s, want 201", w.Code, w.Body)
+	}
+
+	// Applying changes payments, a dry run doesn't
+	if w := serve(router, "POST", "/tag-rules/apply", ""); w.Code != http.StatusForbidden {
+		t.Errorf("apply with the rules scope only = %d, want 403", w.Code)
+	}
+	if w := serve(router, "POST", "/tag-rules/apply?dry_run=true", ""); w.Code != http.StatusOK {
+		t.Errorf("dry run with the rules scope only = %d %s, want 200", w.Code, w.Body)
+	}
+	var fullyPaid bool
+	if err := db.QueryRow("SELECT fully_paid FROM payments WHERE id = 'p1'").Scan(&fullyPaid); err != nil {
+		t.Fatal(err)
+	}
+	if fullyPaid {
+		t.Error("payment was settled by a request without payment rights")
+	}
+
+	router = newTestRouter(workspaceID, auth.RoleEditor, "tag-rules:write", "payments:write")
+	h.RegisterRoutes(router.Group(""))
+	if w := serve(router, "POST", "/tag-rules/apply", ""); w.Code != http.StatusOK {
+		t.Errorf("apply with both scopes = %d %s, want 200", w.Code, w.Body)
+	}
+	if err := db.QueryRow("SELECT fully_paid FROM payments WHERE id = 'p1'").Scan(&fullyPaid); err != nil {
+		t.Fatal(err)
+	}
+	if !fullyPaid {
+		t.Error("applying the settling rule left the payment unpaid")
+	}
+}
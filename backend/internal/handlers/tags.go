@@ -2,15 +2,21 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
 	"expense_tracker/internal/models"
 	"expense_tracker/internal/utils"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+var errUnknownTag = errors.New("tag does not exist in this workspace")
+
 type TagHandler struct {
 	db *sql.DB
 }
@@ -21,20 +27,20 @@ func NewTagHandler(db *sql.DB) *TagHandler {
 
 // RegisterRoutes registers all tag-related routes
 func (h *TagHandler) RegisterRoutes(router *gin.RouterGroup) {
-	tags := router.Group("/tags")
+	tags := router.Group("/tags", requireScope("tags"))
 	{
-		tags.GET("", h.ListTags)
-		tags.POST("", h.CreateTag)
-		tags.GET("/:id", h.GetTag)
-		tags.PUT("/:id", h.UpdateTag)
-		tags.DELETE("/:id", h.DeleteTag)
-		tags.GET("/stats", h.GetTagStats)
+		tags.GET("", requirePermission(auth.PermRead), h.ListTags)
+		tags.POST("", requirePermission(auth.PermWrite), h.CreateTag)
+		tags.GET("/:id", requirePermission(auth.PermRead), h.GetTag)
+		tags.PUT("/:id", requirePermission(auth.PermWrite), h.UpdateTag)
+		tags.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteTag)
+		tags.GET("/stats", requirePermission(auth.PermRead), h.GetTagStats)
 	}
 }
 
-// ListTags returns all tags
+// ListTags returns all tags of the workspace
 func (h *TagHandler) ListTags(c *gin.Context) {
-	rows, err := h.db.Query("SELECT id, name, color, created_at FROM tags")
+	rows, err := h.db.Query("SELECT id, name, color, created_at FROM tags WHERE workspace_id = ?", workspaceID(c))
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tags")
 		return
@@ -65,15 +71,32 @@ func (h *TagHandler) CreateTag(c *gin.Context) {
 	tag.ID = uuid.New().String()
 	tag.CreatedAt = time.Now()
 
-	_, err := h.db.Exec(
-		"INSERT INTO tags (id, name, color, created_at) VALUES (?, ?, ?, ?)",
-		tag.ID, tag.Name, tag.Color, tag.CreatedAt,
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO tags (id, name, color, workspace_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		tag.ID, tag.Name, tag.Color, workspaceID(c), tag.CreatedAt,
 	)
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create tag")
 		return
 	}
 
+	if err := auditorFor(c).record(tx, audit.EntityTag, tag.ID, audit.ActionCreate, nil, tag); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
 	c.JSON(http.StatusCreated, tag)
 }
 
@@ -81,12 +104,7 @@ func (h *TagHandler) CreateTag(c *gin.Context) {
 func (h *TagHandler) GetTag(c *gin.Context) {
 	id := c.Param("id")
 
-	var tag models.Tag
-	err := h.db.QueryRow(
-		"SELECT id, name, color, created_at FROM tags WHERE id = ?",
-		id,
-	).Scan(&tag.ID, &tag.Name, &tag.Color, &tag.CreatedAt)
-
+	tag, err := fetchTag(h.db, workspaceID(c), id)
 	if err == sql.ErrNoRows {
 		utils.RespondWithError(c, http.StatusNotFound, err, "Tag not found")
 		return
@@ -102,33 +120,47 @@ func (h *TagHandler) GetTag(c *gin.Context) {
 func (h *TagHandler) UpdateTag(c *gin.Context) {
 	id := c.Param("id")
 
-	var tag models.Tag
-	if err := c.ShouldBindJSON(&tag); err != nil {
+	var update models.Tag
+	if err := c.ShouldBindJSON(&update); err != nil {
 		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid tag data")
 		return
 	}
 
-	result, err := h.db.Exec(
-		"UPDATE tags SET name = ?, color = ? WHERE id = ?",
-		tag.Name, tag.Color, id,
-	)
+	tx, err := h.db.Begin()
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update tag")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	before, err := fetchTag(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Tag not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag")
+		return
+	}
+
+	_, err = tx.Exec("UPDATE tags SET name = ?, color = ? WHERE id = ?", update.Name, update.Color, id)
 	if err != nil {
-		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update tag")
 		return
 	}
 
-	if rowsAffected == 0 {
-		utils.RespondWithError(c, http.StatusNotFound, nil, "Tag not found")
+	tag := before
+	tag.Name = update.Name
+	tag.Color = update.Color
+	if err := auditorFor(c).record(tx, audit.EntityTag, id, audit.ActionUpdate, before, tag); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
 	}
 
-	tag.ID = id
 	c.JSON(http.StatusOK, tag)
 }
 
@@ -143,6 +175,16 @@ func (h *TagHandler) DeleteTag(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	// Check the tag belongs to the workspace before unlinking it
+	tag, err := fetchTag(tx, workspaceID(c), id)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Tag not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag")
+		return
+	}
+
 	// Remove tag from payment_tags
 	_, err = tx.Exec("DELETE FROM payment_tags WHERE tag_id = ?", id)
 	if err != nil {
@@ -157,6 +199,22 @@ func (h *TagHandler) DeleteTag(c *gin.Context) {
 		return
 	}
 
+	// A rule requiring the tag could never match again, and would match
+	// far more than intended if the condition were just dropped
+	_, err = tx.Exec("UPDATE tag_rules SET active = false WHERE id IN (SELECT rule_id FROM tag_rule_tags WHERE tag_id = ? AND role = ?)", id, ruleTagHas)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to deactivate tag rules")
+		return
+	}
+
+	// Remove tag from recurring payments, budgets and tag rules
+	for _, table := range []string{"recurring_payment_tags", "budget_tags", "tag_rule_tags"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE tag_id = ?", id); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to remove tag from "+table)
+			return
+		}
+	}
+
 	// Delete the tag
 	result, err := tx.Exec("DELETE FROM tags WHERE id = ?", id)
 	if err != nil {
@@ -175,6 +233,11 @@ func (h *TagHandler) DeleteTag(c *gin.Context) {
 		return
 	}
 
+	if err := auditorFor(c).record(tx, audit.EntityTag, id, audit.ActionDelete, tag, nil); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
 		return
@@ -183,22 +246,39 @@ func (h *TagHandler) DeleteTag(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// GetTagStats returns usage statistics for tags
+// fetchTag loads a single tag of the workspace
+func fetchTag(db queryRower, workspaceID, id string) (models.Tag, error) {
+	var tag models.Tag
+	err := db.QueryRow(
+		"SELECT id, name, color, created_at FROM tags WHERE id = ? AND workspace_id = ?",
+		id, workspaceID,
+	).Scan(&tag.ID, &tag.Name, &tag.Color, &tag.CreatedAt)
+	return tag, err
+}
+
+// GetTagStats returns usage statistics for tags, with amounts converted
+// into the base currency
 func (h *TagHandler) GetTagStats(c *gin.Context) {
+	cv, ok := requestConverter(c, h.db)
+	if !ok {
+		return
+	}
+
 	rows, err := h.db.Query(`
 		SELECT 
 			t.id,
 			t.name,
 			t.color,
 			COUNT(DISTINCT pt.payment_id) as payment_count,
-			COUNT(DISTINCT dt.document_id) as document_count,
-			COALESCE(SUM(p.amount), 0) as total_amount
+			COUNT(DISTINCT dt.document_id) as document_count
 		FROM tags t
 		LEFT JOIN payment_tags pt ON t.id = pt.tag_id
-		LEFT JOIN payments p ON pt.payment_id = p.id
+			AND pt.payment_id IN (SELECT id FROM payments WHERE deleted_at IS NULL)
 		LEFT JOIN document_tags dt ON t.id = dt.tag_id
+			AND dt.document_id IN (SELECT id FROM documents WHERE deleted_at IS NULL)
+		WHERE t.workspace_id = ?
 		GROUP BY t.id, t.name, t.color
-	`)
+	`, workspaceID(c))
 	if err != nil {
 		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag stats")
 		return
@@ -206,6 +286,7 @@ func (h *TagHandler) GetTagStats(c *gin.Context) {
 	defer rows.Close()
 
 	var stats []gin.H
+	amounts := make(map[string]*models.Money)
 	for rows.Next() {
 		var (
 			id           string
@@ -213,21 +294,95 @@ func (h *TagHandler) GetTagStats(c *gin.Context) {
 			color        string
 			paymentCount int
 			docCount     int
-			totalAmount  float64
 		)
-		if err := rows.Scan(&id, &name, &color, &paymentCount, &docCount, &totalAmount); err != nil {
+		if err := rows.Scan(&id, &name, &color, &paymentCount, &docCount); err != nil {
 			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan tag stats")
 			return
 		}
+		amount := cv.money(0)
+		amounts[id] = &amount
 		stats = append(stats, gin.H{
 			"id":             id,
 			"name":           name,
 			"color":          color,
 			"payment_count":  paymentCount,
 			"document_count": docCount,
-			"total_amount":   totalAmount,
+			"total_amount":   amounts[id],
+			"currency":       cv.base,
 		})
 	}
 
+	// Sum amounts separately so document joins don't multiply them
+	amountRows, err := h.db.Query(`
+		SELECT pt.tag_id, p.amount_minor, p.currency, p.date_paid
+		FROM payment_tags pt
+		JOIN payments p ON pt.payment_id = p.id
+		WHERE p.workspace_id = ? AND p.deleted_at IS NULL
+	`, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tag amounts")
+		return
+	}
+	defer amountRows.Close()
+
+	for amountRows.Next() {
+		var tagID, currency string
+		var minor int64
+		var datePaid time.Time
+		if err := amountRows.Scan(&tagID, &minor, &currency, &datePaid); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan tag amounts")
+			return
+		}
+		if total, ok := amounts[tagID]; ok {
+			if amount, ok := cv.convert(minor, currency, datePaid); ok {
+				total.Minor += amount
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, stats)
 }
+
+// validateTags checks that every tag ID belongs to the workspace,
+// responding with 400 if one does not
+func validateTags(c *gin.Context, db queryRower, tags []string) bool {
+	if err := checkTags(db, workspaceID(c), tags); err == errUnknownTag {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid tags")
+		return false
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch tags")
+		return false
+	}
+	return true
+}
+
+// checkTags returns errUnknownTag unless every tag ID belongs to the workspace
+func checkTags(db queryRower, workspaceID string, tags []string) error {
+	unique := make(map[string]bool)
+	for _, id := range tags {
+		unique[id] = true
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(unique))
+	params := []interface{}{workspaceID}
+	for id := range unique {
+		ids = append(ids, "?")
+		params = append(params, id)
+	}
+
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM tags WHERE workspace_id = ? AND id IN ("+strings.Join(ids, ", ")+")",
+		params...,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(unique) {
+		return errUnknownTag
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
+	"expense_tracker/internal/textextract"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	// maxTextAttempts is how often extracting a file's text is tried before
+	// it is marked failed
+	maxTextAttempts = 5
+	// textRetryDelay is the wait after the first failure, doubled after
+	// each further one
+	textRetryDelay = time.Minute
+	textBatchSize  = 20
+)
+
+// TextExtractor pulls the text out of stored PDFs and plain-text files in
+// the background, so that documents and payments can be found by what
+// their files say
+type TextExtractor struct {
+	db      *sql.DB
+	storage storage.Storage
+}
+
+func NewTextExtractor(db *sql.DB, store storage.Storage) *TextExtractor {
+	return &TextExtractor{db: db, storage: store}
+}
+
+// StartExtractor extracts pending text immediately and then on every tick
+// of interval until stop is closed
+func (e *TextExtractor) StartExtractor(interval time.Duration, stop <-chan struct{}) {
+	run := func() {
+		extracted, err := e.Extract(time.Now())
+		if err != nil {
+			log.Printf("Text extraction failed: %v", err)
+			return
+		}
+		if extracted > 0 {
+			log.Printf("Extracted text from %d file(s)", extracted)
+		}
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// pendingText is a blob waiting for its text to be extracted
+type pendingText struct {
+	hash, key, contentType string
+	attempts               int
+}
+
+// Extract extracts the text of every blob that is due, refreshing the
+// search entries of the documents and payments using it. A failure is
+// retried after a delay until maxTextAttempts is reached. It returns how
+// many blobs had their text extracted.
+func (e *TextExtractor) Extract(now time.Time) (int, error) {
+	extracted := 0
+	for {
+		rows, err := e.db.Query(`
+			SELECT hash, storage_key, COALESCE(content_type, ''), text_attempts FROM blobs
+			WHERE text_status = ? AND (text_retry_at IS NULL OR text_retry_at <= ?)
+			ORDER BY created_at
+			LIMIT ?
+		`, models.TextPending, now, textBatchSize)
+		if err != nil {
+			return extracted, err
+		}
+		var batch []pendingText
+		for rows.Next() {
+			var p pendingText
+			if err := rows.Scan(&p.hash, &p.key, &p.contentType, &p.attempts); err != nil {
+				rows.Close()
+				return extracted, err
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return extracted, err
+		}
+
+		for _, p := range batch {
+			ok, err := e.extractBlob(p, now)
+			if err != nil {
+				return extracted, err
+			}
+			if ok {
+				extracted++
+			}
+		}
+
+		// Every blob in a batch leaves the queue or is rescheduled for later
+		if len(batch) < textBatchSize {
+			return extracted, nil
+		}
+	}
+}
+
+// extractBlob extracts and stores the text of one blob, or records why it
+// could not. It reports whether text was stored.
+func (e *TextExtractor) extractBlob(p pendingText, now time.Time) (bool, error) {
+	text, extractErr := e.readText(p)
+	if extractErr != nil {
+		attempts := p.attempts + 1
+		status := models.TextPending
+		var retryAt interface{} = now.Add(textRetryDelay << (attempts - 1))
+		switch {
+		case errors.Is(extractErr, textextract.ErrUnsupported):
+			status, retryAt = models.TextUnsupported, nil
+		case attempts >= maxTextAttempts:
+			status, retryAt = models.TextFailed, nil
+		}
+		log.Printf("Failed to extract text from %s (attempt %d): %v", p.key, attempts, extractErr)
+
+		_, err := e.db.Exec(`
+			UPDATE blobs SET text_status = ?, text_attempts = ?, text_error = ?, text_retry_at = ?
+			WHERE hash = ?
+		`, status, attempts, extractErr.Error(), retryAt, p.hash)
+		return false, err
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := storeBlobText(tx, p.hash, text, now); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// storeBlobText records the extracted text of a blob that is pending
+// extraction and refreshes the search entries using it
+func storeBlobText(tx *sql.Tx, hash, text string, now time.Time) error {
+	result, err := tx.Exec(`
+		UPDATE blobs SET text_status = ?, text = ?, text_attempts = text_attempts + 1,
+			text_error = NULL, text_retry_at = NULL, text_extracted_at = ?
+		WHERE hash = ? AND text_status = ?
+	`, models.TextDone, text, now, hash, models.TextPending)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	return reindexBlob(tx, hash)
+}
+
+// readText fetches a blob from storage and extracts its text
+func (e *TextExtractor) readText(p pendingText) (string, error) {
+	src, _, err := e.storage.Get(context.Background(), p.key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	return extractText(src, p.contentType)
+}
+
+// extractText reads a file of a detected content type and extracts its
+// text. Malformed files must not take the server down, so a panic in the
+// extractor is returned as an error.
+func extractText(r io.Reader, contentType string) (text string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("extractor crashed: %v", p)
+		}
+	}()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return textextract.Extract(data, contentType)
+}
+
+// reindexBlob refreshes the search entries of the documents and payments
+// whose files are a blob
+func reindexBlob(tx *sql.Tx, hash string) error {
+	for _, source := range []struct {
+		query string
+		index func(execer, string) error
+	}{
+		{"SELECT id FROM documents WHERE file_hash = ?", indexDocument},
+		{"SELECT DISTINCT payment_id FROM payment_attachments WHERE file_hash = ?", indexPayment},
+	} {
+		rows, err := tx.Query(source.query, hash)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := source.index(tx, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchExtractedText loads the extraction status and text of a blob. Files
+// stored before blobs have none and are reported as unsupported.
+func fetchExtractedText(db queryRower, hash string) (models.ExtractedText, error) {
+	var t models.ExtractedText
+	var extractedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT text_status, COALESCE(text, ''), text_attempts, COALESCE(text_error, ''), text_extracted_at
+		FROM blobs WHERE hash = ?
+	`, hash).Scan(&t.Status, &t.Text, &t.Attempts, &t.Error, &extractedAt)
+	if err == sql.ErrNoRows {
+		return models.ExtractedText{Status: models.TextUnsupported}, nil
+	}
+	if extractedAt.Valid {
+		t.ExtractedAt = &extractedAt.Time
+	}
+	return t, err
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/storage"
+	"expense_tracker/internal/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrashHandler lists, restores and purges deleted payments and documents.
+// Deleted items stay in the trash for retention before they are purged.
+type TrashHandler struct {
+	db        *sql.DB
+	storage   storage.Storage
+	retention time.Duration
+}
+
+func NewTrashHandler(db *sql.DB, store storage.Storage, retention time.Duration) *TrashHandler {
+	return &TrashHandler{db: db, storage: store, retention: retention}
+}
+
+// RegisterRoutes registers the trash routes
+func (h *TrashHandler) RegisterRoutes(router *gin.RouterGroup) {
+	trash := router.Group("/trash", requireScope("trash"))
+	{
+		trash.GET("", requirePermission(auth.PermRead), h.ListTrash)
+		trash.POST("/payments/:id/restore", requirePermission(auth.PermDelete), h.RestorePayment)
+		trash.POST("/documents/:id/restore", requirePermission(auth.PermDelete), h.RestoreDocument)
+	}
+}
+
+// ListTrash returns the workspace's trashed payments and documents, most
+// recently deleted first, with the time each will be purged
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT
+			`+paymentColumns+`,
+			GROUP_CONCAT(pt.tag_id) as tag_ids
+		FROM payments p
+		LEFT JOIN payment_tags pt ON p.id = pt.payment_id
+		WHERE p.workspace_id = ? AND p.deleted_at IS NOT NULL
+		GROUP BY p.id
+		ORDER BY p.deleted_at DESC
+	`, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch trashed payments")
+		return
+	}
+	defer rows.Close()
+
+	payments := make([]gin.H, 0)
+	for rows.Next() {
+		var p models.Payment
+		var tagIDs sql.NullString
+		if err := scanPayment(rows, &p, &tagIDs); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment")
+			return
+		}
+		p.Tags = []string{}
+		if tagIDs.Valid {
+			p.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		payments = append(payments, gin.H{"payment": p, "purgeAt": p.DeletedAt.Add(h.retention)})
+	}
+	if err := rows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch trashed payments")
+		return
+	}
+
+	docRows, err := h.db.Query(`
+		SELECT d.id, d.title, d.description, d.file_path, COALESCE(d.file_hash, ''), COALESCE(d.content_type, ''), d.original_name, d.file_size, d.created_at, d.updated_at, d.deleted_at, GROUP_CONCAT(dt.tag_id) as tag_ids
+		FROM documents d
+		LEFT JOIN document_tags dt ON d.id = dt.document_id
+		WHERE d.workspace_id = ? AND d.deleted_at IS NOT NULL
+		GROUP BY d.id
+		ORDER BY d.deleted_at DESC
+	`, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch trashed documents")
+		return
+	}
+	defer docRows.Close()
+
+	documents := make([]gin.H, 0)
+	for docRows.Next() {
+		var d models.Document
+		var deletedAt time.Time
+		var tagIDs sql.NullString
+		err := docRows.Scan(&d.ID, &d.Title, &d.Description, &d.FilePath, &d.FileHash, &d.ContentType, &d.OriginalName, &d.FileSize, &d.CreatedAt, &d.UpdatedAt, &deletedAt, &tagIDs)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan document")
+			return
+		}
+		d.Tags = []string{}
+		if tagIDs.Valid {
+			d.Tags = utils.SplitCommaString(tagIDs.String)
+		}
+		documents = append(documents, gin.H{
+			"document": gin.H{"id": d.ID, "title": d.Title, "description": d.Description, "filePath": d.FilePath, "fileHash": d.FileHash, "contentType": d.ContentType, "originalName": d.OriginalName, "fileSize": d.FileSize, "tags": d.Tags, "createdAt": d.CreatedAt, "updatedAt": d.UpdatedAt, "deletedAt": deletedAt},
+			"purgeAt":  deletedAt.Add(h.retention),
+		})
+	}
+	if err := docRows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch trashed documents")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payments":  payments,
+		"documents": documents,
+		"retention": h.retention.String(),
+	})
+}
+
+// RestorePayment takes a payment out of the trash
+func (h *TrashHandler) RestorePayment(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.restore(c, tx, "payments", id) {
+		return
+	}
+
+	payment, err := fetchPayment(tx, workspaceID(c), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityPayment, id, audit.ActionRestore, nil, payment); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// RestoreDocument takes a document out of the trash
+func (h *TrashHandler) RestoreDocument(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.restore(c, tx, "documents", id) {
+		return
+	}
+
+	doc, err := fetchDocument(tx, workspaceID(c), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch document")
+		return
+	}
+
+	if err := auditorFor(c).record(tx, audit.EntityDocument, id, audit.ActionRestore, nil, doc); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": doc.ID, "title": doc.Title, "description": doc.Description, "filePath": doc.FilePath, "fileHash": doc.FileHash, "contentType": doc.ContentType, "originalName": doc.OriginalName, "fileSize": doc.FileSize, "tags": doc.Tags, "createdAt": doc.CreatedAt, "updatedAt": doc.UpdatedAt})
+}
+
+// restore clears deleted_at on a trashed row of table in the request's
+// workspace. It responds with 404 and returns false if there is no such row.
+func (h *TrashHandler) restore(c *gin.Context, tx *sql.Tx, table, id string) bool {
+	result, err := tx.Exec("UPDATE "+table+" SET deleted_at = NULL WHERE id = ? AND workspace_id = ? AND deleted_at IS NOT NULL", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to restore item")
+		return false
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return false
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Item not found in trash")
+		return false
+	}
+	return true
+}
+
+// trashedItem is a payment or document due to be purged
+type trashedItem struct {
+	entityType  string
+	id          string
+	workspaceID string
+}
+
+// Purge permanently deletes payments and documents trashed more than the
+// retention before now, along with their files. It returns how many items
+// were purged.
+func (h *TrashHandler) Purge(now time.Time) (int, error) {
+	cutoff := now.Add(-h.retention)
+
+	// Select the expired items in the purge transaction, so an item restored
+	// meanwhile is not deleted
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var items []trashedItem
+	for _, source := range []struct{ entityType, query string }{
+		{audit.EntityPayment, "SELECT id, workspace_id FROM payments WHERE deleted_at IS NOT NULL AND deleted_at < ?"},
+		{audit.EntityDocument, "SELECT id, workspace_id FROM documents WHERE deleted_at IS NOT NULL AND deleted_at < ?"},
+	} {
+		rows, err := tx.Query(source.query, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			item := trashedItem{entityType: source.entityType}
+			if err := rows.Scan(&item.id, &item.workspaceID); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var released []string
+	for _, item := range items {
+		if item.entityType == audit.EntityPayment {
+			keys, err := purgePayment(tx, item.id)
+			if err != nil {
+				return 0, err
+			}
+			released = append(released, keys...)
+		} else {
+			key, err := purgeDocument(tx, item.id)
+			if err != nil {
+				return 0, err
+			}
+			released = append(released, key)
+		}
+		if err := (auditor{workspaceID: item.workspaceID}).record(tx, item.entityType, item.id, audit.ActionPurge, nil, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Files go only once the rows are gone, so a failed purge leaves
+	// nothing pointing at a missing file. Files still shared with other
+	// records are kept.
+	deleteFiles(context.Background(), h.storage, released...)
+	return len(items), nil
+}
+
+// StartPurger purges expired trash immediately and then on every tick of
+// interval until stop is closed
+func (h *TrashHandler) StartPurger(interval time.Duration, stop <-chan struct{}) {
+	run := func() {
+		purged, err := h.Purge(time.Now())
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+			return
+		}
+		if purged > 0 {
+			log.Printf("Purged %d trashed item(s)", purged)
+		}
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
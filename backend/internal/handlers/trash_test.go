@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/invoiceparse"
+	"expense_tracker/internal/storage"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRestorePayment(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestWorkspace(t, db, "other")
+	createTestPayment(t, db, workspaceID, "p1", "Rent", 100000, "2024-03-01")
+
+	register := func(role auth.Role, workspaceID string) http.Handler {
+		router := newTestRouter(workspaceID, role)
+		NewPaymentHandler(db, nil, 0, invoiceparse.Rules{}).RegisterRoutes(router.Group(""))
+		NewTrashHandler(db, nil, 30*24*time.Hour).RegisterRoutes(router.Group(""))
+		return router
+	}
+	editor := register(auth.RoleEditor, workspaceID)
+
+	if w := serve(editor, http.MethodDelete, "/payments/p1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete payment = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(editor, http.MethodGet, "/payments/p1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET trashed payment = %d, want 404", w.Code)
+	}
+
+	w := serve(editor, http.MethodGet, "/trash", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /trash = %d: %s", w.Code, w.Body)
+	}
+	var trash struct {
+		Payments []struct {
+			Payment struct {
+				ID string `json:"id"`
+			} `json:"payment"`
+			PurgeAt time.Time `json:"purgeAt"`
+		} `json:"payments"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Payments) != 1 || trash.Payments[0].Payment.ID != "p1" {
+		t.Fatalf("trash = %s, want p1", w.Body)
+	}
+	if until := time.Until(trash.Payments[0].PurgeAt); until < 29*24*time.Hour {
+		t.Errorf("p1 is purged in %v, want the 30 day retention", until)
+	}
+
+	for name, tc := range map[string]struct {
+		router http.Handler
+		want   int
+	}{
+		"accountant":      {register(auth.RoleAccountant, workspaceID), http.StatusForbidden},
+		"other workspace": {register(auth.RoleEditor, "other"), http.StatusNotFound},
+	} {
+		if w := serve(tc.router, http.MethodPost, "/trash/payments/p1/restore", ""); w.Code != tc.want {
+			t.Errorf("%s restore = %d, want %d", name, w.Code, tc.want)
+		}
+	}
+
+	if w := serve(editor, http.MethodPost, "/trash/payments/p1/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(editor, http.MethodGet, "/payments/p1", ""); w.Code != http.StatusOK {
+		t.Errorf("GET restored payment = %d, want 200", w.Code)
+	}
+	if w := serve(editor, http.MethodPost, "/trash/payments/p1/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restoring a payment not in the trash = %d, want 404", w.Code)
+	}
+
+	var restores int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE entity_id = 'p1' AND action = 'restore'").Scan(&restores); err != nil {
+		t.Fatal(err)
+	}
+	if restores != 1 {
+		t.Errorf("restore audit entries = %d, want 1", restores)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := []byte("%PDF-1.4 invoice")
+	if err := store.Put(ctx, "invoices/expired.pdf", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	createTestPayment(t, db, workspaceID, "expired", "Old rent", 100000, "2024-01-01")
+	createTestPayment(t, db, workspaceID, "recent", "New rent", 100000, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "live", "Groceries", 4500, "2024-03-02")
+	for _, exec := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE payments SET deleted_at = ? WHERE id = 'expired'", []interface{}{now.Add(-31 * 24 * time.Hour)}},
+		{"UPDATE payments SET deleted_at = ? WHERE id = 'recent'", []interface{}{now.Add(-24 * time.Hour)}},
+		{`INSERT INTO payment_attachments (id, payment_id, kind, file_path, original_name, file_size)
+			VALUES ('a1', 'expired', 'invoice', 'invoices/expired.pdf', 'expired.pdf', 16)`, nil},
+	} {
+		if _, err := db.Exec(exec.query, exec.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewTrashHandler(db, store, 30*24*time.Hour)
+	purged, err := h.Purge(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Purge = %d, want 1", purged)
+	}
+
+	for id, want := range map[string]int{"expired": 0, "recent": 1, "live": 1} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM payments WHERE id = ?", id).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("%s rows after Purge = %d, want %d", id, count, want)
+		}
+	}
+	var attachments int
+	if err := db.QueryRow("SELECT COUNT(*) FROM payment_attachments WHERE payment_id = 'expired'").Scan(&attachments); err != nil {
+		t.Fatal(err)
+	}
+	if attachments != 0 {
+		t.Errorf("expired payment kept %d attachments", attachments)
+	}
+	if _, err := store.Stat(ctx, "invoices/expired.pdf"); err != storage.ErrNotFound {
+		t.Errorf("Stat purged invoice = %v, want ErrNotFound", err)
+	}
+
+	var actor *string
+	if err := db.QueryRow("SELECT actor_id FROM audit_log WHERE entity_id = 'expired' AND action = 'purge'").Scan(&actor); err != nil {
+		t.Fatalf("purge audit entry: %v", err)
+	}
+	if actor != nil {
+		t.Errorf("purge actor = %q, want none", *actor)
+	}
+
+	// Nothing else has expired yet
+	if purged, err := h.Purge(now); err != nil || purged != 0 {
+		t.Errorf("second Purge = %d, %v, want 0", purged, err)
+	}
+}
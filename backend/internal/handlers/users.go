@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	errLastAdmin  = errors.New("at least one admin must remain")
+	errEmailTaken = errors.New("a user with this email already exists")
+)
+
+type UserHandler struct {
+	db *sql.DB
+}
+
+func NewUserHandler(db *sql.DB) *UserHandler {
+	return &UserHandler{db: db}
+}
+
+// RegisterRoutes registers the routes for managing user accounts. They are
+// limited to admins signed in with a session.
+func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup) {
+	users := router.Group("/users", requireSession(), requireAdmin())
+	{
+		users.GET("", h.ListUsers)
+		users.POST("", h.CreateUser)
+		users.PUT("/:id", h.UpdateUser)
+		users.DELETE("/:id", h.DeleteUser)
+	}
+}
+
+// userPayload is the request body for creating or updating a user. The
+// password is optional on update, leaving it unchanged.
+type userPayload struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"isAdmin"`
+}
+
+// ListUsers returns every user account
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	rows, err := h.db.Query("SELECT " + userColumns + " FROM users u ORDER BY u.created_at")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch users")
+		return
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		var u models.User
+		if err := scanUser(rows, &u); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan user")
+			return
+		}
+		users = append(users, u)
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser creates an account that can sign in with the given email and
+// password. Add it to workspaces with the workspace member routes.
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req userPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid user data")
+		return
+	}
+
+	user := models.User{
+		ID:      uuid.New().String(),
+		Email:   strings.TrimSpace(req.Email),
+		Name:    strings.TrimSpace(req.Name),
+		IsAdmin: req.IsAdmin,
+	}
+	if !strings.Contains(user.Email, "@") || user.Name == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("email and name are required"), "Invalid user data")
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err == auth.ErrPasswordTooShort {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid user data")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to hash password")
+		return
+	}
+
+	if !h.emailAvailable(c, user.Email, "") {
+		return
+	}
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	_, err = h.db.Exec(`
+		INSERT INTO users (id, email, name, password_hash, is_admin, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, user.ID, user.Email, user.Name, hash, user.IsAdmin, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create user")
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser changes a user's email, name and admin flag, and their
+// password when one is given. A new password signs the user out
+// everywhere. The last admin cannot lose the flag.
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var req userPayload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid user data")
+		return
+	}
+	email, name := strings.TrimSpace(req.Email), strings.TrimSpace(req.Name)
+	if !strings.Contains(email, "@") || name == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("email and name are required"), "Invalid user data")
+		return
+	}
+
+	var hash string
+	if req.Password != "" {
+		var err error
+		hash, err = auth.HashPassword(req.Password)
+		if err == auth.ErrPasswordTooShort {
+			utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid user data")
+			return
+		} else if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to hash password")
+			return
+		}
+	}
+
+	if !h.emailAvailable(c, email, id) {
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET email = ?, name = ?, is_admin = ?, password_hash = COALESCE(NULLIF(?, ''), password_hash), updated_at = ?
+		WHERE id = ?
+	`, email, name, req.IsAdmin, hash, time.Now(), id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update user")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "User not found")
+		return
+	}
+	if !keepsAdmin(c, tx, "Cannot remove the last admin") {
+		return
+	}
+
+	if hash != "" {
+		for _, query := range []string{"DELETE FROM sessions WHERE user_id = ?", "DELETE FROM api_tokens WHERE user_id = ?"} {
+			if _, err := tx.Exec(query, id); err != nil {
+				utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to end sessions")
+				return
+			}
+		}
+	}
+
+	var user models.User
+	if err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users u WHERE u.id = ?", id), &user); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch user")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser deletes a user with their sessions, API tokens and
+// memberships. The last admin, and the last owner of a workspace, cannot
+// be deleted.
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	owned, err := ownedWorkspaces(tx, id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch workspaces")
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete user")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "User not found")
+		return
+	}
+	if !keepsAdmin(c, tx, "Cannot delete the last admin") {
+		return
+	}
+	for _, workspaceID := range owned {
+		if !keepsOwner(c, tx, workspaceID, "Cannot delete the last owner of a workspace") {
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// emailAvailable responds with 409 and returns false when another user
+// than exceptID already has the email
+func (h *UserHandler) emailAvailable(c *gin.Context, email, exceptID string) bool {
+	var taken int
+	err := h.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? AND id != ?", email, exceptID).Scan(&taken)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch users")
+		return false
+	}
+	if taken > 0 {
+		utils.RespondWithError(c, http.StatusConflict, errEmailTaken, "Invalid user data")
+		return false
+	}
+	return true
+}
+
+// keepsAdmin responds with 409 and returns false when a change has left no
+// admin
+func keepsAdmin(c *gin.Context, tx *sql.Tx, details string) bool {
+	var admins int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE is_admin").Scan(&admins); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch users")
+		return false
+	}
+	if admins == 0 {
+		utils.RespondWithError(c, http.StatusConflict, errLastAdmin, details)
+		return false
+	}
+	return true
+}
+
+// ownedWorkspaces returns the IDs of the workspaces a user owns
+func ownedWorkspaces(db querier, userID string) ([]string, error) {
+	rows, err := db.Query("SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role = ?", userID, auth.RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/audit"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Intervals of a vendor's spend over time
+const (
+	intervalMonth = "month"
+	intervalYear  = "year"
+)
+
+var errVendorAliasTaken = errors.New("name or alias already belongs to another vendor")
+
+type VendorHandler struct {
+	db *sql.DB
+}
+
+func NewVendorHandler(db *sql.DB) *VendorHandler {
+	return &VendorHandler{db: db}
+}
+
+// RegisterRoutes registers all vendor routes
+func (h *VendorHandler) RegisterRoutes(router *gin.RouterGroup) {
+	vendors := router.Group("/vendors", requireScope("vendors"))
+	{
+		vendors.GET("", requirePermission(auth.PermRead), h.ListVendors)
+		vendors.POST("", requirePermission(auth.PermWrite), h.CreateVendor)
+		vendors.GET("/analytics", requirePermission(auth.PermRead), h.ListVendorAnalytics)
+		vendors.POST("/resolve", requirePermission(auth.PermWrite), h.ResolveVendors)
+		vendors.GET("/:id", requirePermission(auth.PermRead), h.GetVendor)
+		vendors.PUT("/:id", requirePermission(auth.PermWrite), h.UpdateVendor)
+		vendors.DELETE("/:id", requirePermission(auth.PermDelete), h.DeleteVendor)
+		vendors.GET("/:id/analytics", requirePermission(auth.PermRead), h.GetVendorAnalytics)
+	}
+}
+
+// vendorPayload is the request body for creating or updating a vendor
+type vendorPayload struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// bindVendor parses and validates the request body, responding with 400 on failure
+func bindVendor(c *gin.Context) (models.Vendor, bool) {
+	var v models.Vendor
+	var payload vendorPayload
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid vendor data")
+		return v, false
+	}
+
+	v.Name = strings.TrimSpace(payload.Name)
+	if models.NormalizeInfo(v.Name) == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("name must contain a letter or digit"), "Invalid vendor data")
+		return v, false
+	}
+
+	v.Aliases = []string{}
+	seen := map[string]bool{models.NormalizeInfo(v.Name): true}
+	for _, alias := range payload.Aliases {
+		alias = strings.TrimSpace(alias)
+		key := models.NormalizeInfo(alias)
+		if key == "" {
+			utils.RespondWithError(c, http.StatusBadRequest, errors.New("aliases must contain a letter or digit"), "Invalid vendor data")
+			return v, false
+		}
+		if !seen[key] {
+			seen[key] = true
+			v.Aliases = append(v.Aliases, alias)
+		}
+	}
+	return v, true
+}
+
+// ListVendors returns all vendors of the workspace
+func (h *VendorHandler) ListVendors(c *gin.Context) {
+	vendors, err := fetchVendors(h.db, workspaceID(c), "")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendors")
+		return
+	}
+
+	c.JSON(http.StatusOK, vendors)
+}
+
+// GetVendor returns a specific vendor by ID
+func (h *VendorHandler) GetVendor(c *gin.Context) {
+	v, ok := h.findVendor(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, v)
+}
+
+// findVendor loads the vendor named by the id parameter, responding with
+// 404 if the workspace has none
+func (h *VendorHandler) findVendor(c *gin.Context) (models.Vendor, bool) {
+	vendors, err := fetchVendors(h.db, workspaceID(c), c.Param("id"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendor")
+		return models.Vendor{}, false
+	}
+	if len(vendors) == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Vendor not found")
+		return models.Vendor{}, false
+	}
+	return vendors[0], true
+}
+
+// CreateVendor creates a new vendor. Existing payments are linked to it by
+// ResolveVendors.
+func (h *VendorHandler) CreateVendor(c *gin.Context) {
+	v, ok := bindVendor(c)
+	if !ok {
+		return
+	}
+
+	v.ID = uuid.New().String()
+	v.CreatedAt = time.Now()
+	v.UpdatedAt = v.CreatedAt
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkVendorAliases(c, tx, v) {
+		return
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO vendors (id, workspace_id, name, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		v.ID, workspaceID(c), v.Name, v.CreatedAt, v.UpdatedAt,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create vendor")
+		return
+	}
+
+	if err := setVendorAliases(tx, v.ID, v.Aliases); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save aliases")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, v)
+}
+
+// UpdateVendor renames a vendor and replaces its aliases. Payments already
+// linked to it stay linked.
+func (h *VendorHandler) UpdateVendor(c *gin.Context) {
+	v, ok := bindVendor(c)
+	if !ok {
+		return
+	}
+	v.ID = c.Param("id")
+	v.UpdatedAt = time.Now()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	if !checkVendorAliases(c, tx, v) {
+		return
+	}
+
+	result, err := tx.Exec(
+		"UPDATE vendors SET name = ?, updated_at = ? WHERE id = ? AND workspace_id = ?",
+		v.Name, v.UpdatedAt, v.ID, workspaceID(c),
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update vendor")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Vendor not found")
+		return
+	}
+
+	if err := setVendorAliases(tx, v.ID, v.Aliases); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to save aliases")
+		return
+	}
+
+	vendors, err := fetchVendors(tx, workspaceID(c), v.ID)
+	if err != nil || len(vendors) == 0 {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendor")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, vendors[0])
+}
+
+// DeleteVendor deletes a vendor, unlinking its payments
+func (h *VendorHandler) DeleteVendor(c *gin.Context) {
+	id := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM vendors WHERE id = ? AND workspace_id = ?", id, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to delete vendor")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Vendor not found")
+		return
+	}
+
+	for _, query := range []string{
+		"DELETE FROM vendor_aliases WHERE vendor_id = ?",
+		"UPDATE payments SET vendor_id = NULL WHERE vendor_id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to unlink vendor")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ResolveVendors links the workspace's payments that have no vendor to the
+// vendor their info names, for payments recorded before the vendor or
+// alias was added. Linked payments are recorded in the audit log.
+func (h *VendorHandler) ResolveVendors(c *gin.Context) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	vendors, err := loadVendorMatcher(tx, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendors")
+		return
+	}
+
+	rows, err := tx.Query(
+		"SELECT id, info FROM payments WHERE workspace_id = ? AND vendor_id IS NULL AND deleted_at IS NULL",
+		workspaceID(c),
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
+		return
+	}
+	links := make(map[string]string)
+	for rows.Next() {
+		var id, info string
+		if err := rows.Scan(&id, &info); err != nil {
+			rows.Close()
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan payment")
+			return
+		}
+		if vendorID := vendors.match(info); vendorID != "" {
+			links[id] = vendorID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payments")
+		return
+	}
+
+	a := auditorFor(c)
+	now := time.Now()
+	for id, vendorID := range links {
+		before, err := fetchPayment(tx, workspaceID(c), id)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch payment")
+			return
+		}
+		if _, err := tx.Exec("UPDATE payments SET vendor_id = ?, updated_at = ? WHERE id = ?", vendorID, now, id); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to link payment")
+			return
+		}
+		after := before
+		after.VendorID = vendorID
+		after.UpdatedAt = now
+		if err := a.record(tx, audit.EntityPayment, id, audit.ActionUpdate, before, after); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to write audit log")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"linked": len(links)})
+}
+
+// ListVendorAnalytics returns the payment count, total spend, average
+// ticket and last payment date of every vendor, biggest spend first
+func (h *VendorHandler) ListVendorAnalytics(c *gin.Context) {
+	vendors, err := fetchVendors(h.db, workspaceID(c), "")
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendors")
+		return
+	}
+
+	stats, ok := h.vendorStats(c, vendors, "")
+	if !ok {
+		return
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].TotalSpend.Minor > stats[j].TotalSpend.Minor
+	})
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetVendorAnalytics returns the statistics of one vendor with its spend
+// per month, or per year with interval=year
+func (h *VendorHandler) GetVendorAnalytics(c *gin.Context) {
+	interval := c.DefaultQuery("interval", intervalMonth)
+	if interval != intervalMonth && interval != intervalYear {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("interval must be month or year"), "Invalid interval")
+		return
+	}
+
+	v, ok := h.findVendor(c)
+	if !ok {
+		return
+	}
+
+	stats, ok := h.vendorStats(c, []models.Vendor{v}, interval)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, stats[0])
+}
+
+// vendorStats summarises the untrashed payments of vendors, in the date
+// range of the start_date and end_date query parameters, converted into
+// the request's reporting currency. With an interval it also breaks the
+// spend down by month or year, oldest first. The average ticket leaves out
+// payments without an exchange rate, which are totalled under unconverted.
+func (h *VendorHandler) vendorStats(c *gin.Context, vendors []models.Vendor, interval string) ([]models.VendorStats, bool) {
+	cv, ok := requestConverter(c, h.db)
+	if !ok {
+		return nil, false
+	}
+
+	type accumulator struct {
+		stats     models.VendorStats
+		cv        *currencyConverter
+		converted int64
+		periods   map[string]*models.VendorPeriod
+	}
+	byID := make(map[string]*accumulator, len(vendors))
+	all := make([]*accumulator, 0, len(vendors))
+	params := []interface{}{workspaceID(c)}
+	for _, v := range vendors {
+		acc := &accumulator{
+			stats: models.VendorStats{
+				Vendor:        v,
+				Currency:      cv.base,
+				TotalSpend:    cv.money(0),
+				AverageTicket: cv.money(0),
+			},
+			cv:      &currencyConverter{base: cv.base, rates: cv.rates, unconverted: models.Totals{}},
+			periods: make(map[string]*models.VendorPeriod),
+		}
+		acc.stats.Unconverted = acc.cv.unconverted
+		byID[v.ID] = acc
+		all = append(all, acc)
+	}
+
+	whereClause := []string{"p.workspace_id = ?", "p.vendor_id IS NOT NULL", "p.deleted_at IS NULL"}
+	if len(vendors) == 1 {
+		whereClause = append(whereClause, "p.vendor_id = ?")
+		params = append(params, vendors[0].ID)
+	}
+	if startDate := c.Query("start_date"); startDate != "" {
+		whereClause = append(whereClause, "substr(p.date_paid, 1, 10) >= ?")
+		params = append(params, startDate)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		whereClause = append(whereClause, "substr(p.date_paid, 1, 10) <= ?")
+		params = append(params, endDate)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT p.vendor_id, p.amount_minor, p.currency, p.date_paid
+		FROM payments p
+		WHERE `+utils.JoinWithAND(whereClause), params...)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendor payments")
+		return nil, false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vendorID, currency string
+		var minor int64
+		var datePaid time.Time
+		if err := rows.Scan(&vendorID, &minor, &currency, &datePaid); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan vendor payments")
+			return nil, false
+		}
+		acc, ok := byID[vendorID]
+		if !ok {
+			continue
+		}
+
+		acc.stats.PaymentCount++
+		if acc.stats.LastPaymentDate == nil || datePaid.After(*acc.stats.LastPaymentDate) {
+			date := datePaid
+			acc.stats.LastPaymentDate = &date
+		}
+
+		var period *models.VendorPeriod
+		if interval != "" {
+			key := datePaid.Format("2006-01")
+			if interval == intervalYear {
+				key = datePaid.Format("2006")
+			}
+			if period = acc.periods[key]; period == nil {
+				period = &models.VendorPeriod{Period: key, Amount: cv.money(0)}
+				acc.periods[key] = period
+			}
+			period.Count++
+		}
+
+		amount, ok := acc.cv.convert(minor, currency, datePaid)
+		if !ok {
+			continue
+		}
+		acc.converted++
+		acc.stats.TotalSpend.Minor += amount
+		if period != nil {
+			period.Amount.Minor += amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendor payments")
+		return nil, false
+	}
+
+	stats := make([]models.VendorStats, 0, len(all))
+	for _, acc := range all {
+		if acc.converted > 0 {
+			acc.stats.AverageTicket.Minor = divideRounded(acc.stats.TotalSpend.Minor, acc.converted)
+		}
+		if interval != "" {
+			keys := make([]string, 0, len(acc.periods))
+			for key := range acc.periods {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			acc.stats.SpendOverTime = make([]models.VendorPeriod, 0, len(keys))
+			for _, key := range keys {
+				acc.stats.SpendOverTime = append(acc.stats.SpendOverTime, *acc.periods[key])
+			}
+		}
+		stats = append(stats, acc.stats)
+	}
+	return stats, true
+}
+
+// divideRounded divides rounding half away from zero
+func divideRounded(n, d int64) int64 {
+	q, r := n/d, n%d
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+// fetchVendors loads the workspace's vendors with their aliases, sorted by
+// name, or only the one with the given ID
+func fetchVendors(db querier, workspaceID, id string) ([]models.Vendor, error) {
+	query := "SELECT id, name, created_at, updated_at FROM vendors WHERE workspace_id = ?"
+	params := []interface{}{workspaceID}
+	if id != "" {
+		query += " AND id = ?"
+		params = append(params, id)
+	}
+	query += " ORDER BY name COLLATE NOCASE"
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	vendors := make([]models.Vendor, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var v models.Vendor
+		if err := rows.Scan(&v.ID, &v.Name, &v.CreatedAt, &v.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		v.Aliases = []string{}
+		index[v.ID] = len(vendors)
+		vendors = append(vendors, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT a.vendor_id, a.alias FROM vendor_aliases a
+		JOIN vendors v ON v.id = a.vendor_id
+		WHERE v.workspace_id = ?
+		ORDER BY a.alias COLLATE NOCASE
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vendorID, alias string
+		if err := rows.Scan(&vendorID, &alias); err != nil {
+			return nil, err
+		}
+		if i, ok := index[vendorID]; ok {
+			vendors[i].Aliases = append(vendors[i].Aliases, alias)
+		}
+	}
+	return vendors, rows.Err()
+}
+
+func setVendorAliases(tx *sql.Tx, vendorID string, aliases []string) error {
+	if _, err := tx.Exec("DELETE FROM vendor_aliases WHERE vendor_id = ?", vendorID); err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if _, err := tx.Exec("INSERT INTO vendor_aliases (vendor_id, alias) VALUES (?, ?)", vendorID, alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVendorAliases responds with 409 if the vendor's name or one of its
+// aliases already resolves to another vendor, which would make matching
+// ambiguous. It reports whether the vendor may be saved.
+func checkVendorAliases(c *gin.Context, db querier, v models.Vendor) bool {
+	vendors, err := loadVendorMatcher(db, workspaceID(c))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendors")
+		return false
+	}
+	for _, name := range append([]string{v.Name}, v.Aliases...) {
+		key := models.NormalizeInfo(name)
+		for _, k := range vendors.keys {
+			if k.key == key && k.vendorID != v.ID {
+				utils.RespondWithError(c, http.StatusConflict, errVendorAliasTaken, "Vendor name or alias already in use: "+name)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateVendor responds with 400 unless id is empty or names a vendor of
+// the workspace. It reports whether the vendor is valid.
+func validateVendor(c *gin.Context, db queryRower, id string) bool {
+	if id == "" {
+		return true
+	}
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM vendors WHERE id = ? AND workspace_id = ?", id, workspaceID(c)).Scan(&exists)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch vendor")
+		return false
+	}
+	if exists == 0 {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("vendor does not exist in this workspace"), "Invalid vendor")
+		return false
+	}
+	return true
+}
+
+// vendorKey is a normalised vendor name or alias
+type vendorKey struct {
+	key      string
+	vendorID string
+}
+
+// vendorMatcher resolves payment info to the workspace's vendors
+type vendorMatcher struct {
+	// Longest first, so the most specific name wins
+	keys []vendorKey
+}
+
+// loadVendorMatcher loads the names and aliases of the workspace's vendors
+func loadVendorMatcher(db querier, workspaceID string) (vendorMatcher, error) {
+	var m vendorMatcher
+	rows, err := db.Query(`
+		SELECT id, name FROM vendors WHERE workspace_id = ?
+		UNION ALL
+		SELECT a.vendor_id, a.alias FROM vendor_aliases a
+		JOIN vendors v ON v.id = a.vendor_id
+		WHERE v.workspace_id = ?
+	`, workspaceID, workspaceID)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vendorID, name string
+		if err := rows.Scan(&vendorID, &name); err != nil {
+			return m, err
+		}
+		if key := models.NormalizeInfo(name); key != "" {
+			m.keys = append(m.keys, vendorKey{key: key, vendorID: vendorID})
+		}
+	}
+	sort.Slice(m.keys, func(i, j int) bool {
+		if len(m.keys[i].key) != len(m.keys[j].key) {
+			return len(m.keys[i].key) > len(m.keys[j].key)
+		}
+		return m.keys[i].key < m.keys[j].key
+	})
+	return m, rows.Err()
+}
+
+// match returns the ID of the vendor whose name or alias appears in info as
+// whole words, or "" if none does
+func (m vendorMatcher) match(info string) string {
+	normalized := " " + models.NormalizeInfo(info) + " "
+	for _, k := range m.keys {
+		if strings.Contains(normalized, " "+k.key+" ") {
+			return k.vendorID
+		}
+	}
+	return ""
+}
+
+// resolveVendor links a payment about to be created or updated to the
+// vendor its info names, unless a vendor was given
+func resolveVendor(db querier, workspaceID string, payment *models.Payment) error {
+	if payment.VendorID != "" {
+		return nil
+	}
+	vendors, err := loadVendorMatcher(db, workspaceID)
+	if err != nil {
+		return err
+	}
+	payment.VendorID = vendors.match(payment.Info)
+	return nil
+}
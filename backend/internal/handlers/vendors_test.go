@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVendorEndpoints(t *testing.T) {
+	db := newTestDB(t)
+	workspaceID := createTestWorkspace(t, db, "ws")
+	createTestWorkspace(t, db, "other")
+	createTestPayment(t, db, workspaceID, "coffee", "Coffee Shop Berlin", 450, "2024-03-01")
+	createTestPayment(t, db, workspaceID, "alias", "CS LTD invoice 12", 550, "2024-04-02")
+	createTestPayment(t, db, workspaceID, "trashed", "Coffee Shop", 1000, "2024-04-03")
+	createTestPayment(t, db, workspaceID, "groceries", "Groceries", 2000, "2024-04-04")
+	if _, err := db.Exec("UPDATE payments SET deleted_at = ? WHERE id = 'trashed'", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(role auth.Role, workspaceID string) http.Handler {
+		router := newTestRouter(workspaceID, role)
+		NewVendorHandler(db).RegisterRoutes(router.Group(""))
+		return router
+	}
+	editor := register(auth.RoleEditor, workspaceID)
+
+	w := serve(editor, http.MethodPost, "/vendors", `{"name": " Coffee Shop ", "aliases": ["coffee  shop", "CS Ltd", "cs ltd"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create vendor = %d: %s", w.Code, w.Body)
+	}
+	var vendor models.Vendor
+	if err := json.Unmarshal(w.Body.Bytes(), &vendor); err != nil {
+		t.Fatal(err)
+	}
+	if vendor.Name != "Coffee Shop" || len(vendor.Aliases) != 1 || vendor.Aliases[0] != "CS Ltd" {
+		t.Errorf("created vendor = %+v, want Coffee Shop with the single alias CS Ltd", vendor)
+	}
+
+	for body, want := range map[string]int{
+		`{"name": "cs ltd"}`:                   http.StatusConflict,
+		`{"name": "Bakery", "aliases": ["!"]}`: http.StatusBadRequest,
+		`{"name": "--"}`:                       http.StatusBadRequest,
+	} {
+		if w := serve(editor, http.MethodPost, "/vendors", body); w.Code != want {
+			t.Errorf("create vendor %s = %d, want %d", body, w.Code, want)
+		}
+	}
+	if w := serve(register(auth.RoleViewer, workspaceID), http.MethodPost, "/vendors", `{"name": "Bakery"}`); w.Code != http.StatusForbidden {
+		t.Errorf("viewer create vendor = %d, want 403", w.Code)
+	}
+	if w := serve(register(auth.RoleEditor, "other"), http.MethodGet, "/vendors/"+vendor.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET vendor from another workspace = %d, want 404", w.Code)
+	}
+
+	// Resolving links untrashed payments naming the vendor or an alias
+	for _, want := range []int{2, 0} {
+		w := serve(editor, http.MethodPost, "/vendors/resolve", "")
+		var resp struct {
+			Linked int `json:"linked"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || resp.Linked != want {
+			t.Errorf("resolve = %d %s, want %d linked", w.Code, w.Body, want)
+		}
+	}
+	for id, want := range map[string]string{"coffee": vendor.ID, "alias": vendor.ID, "trashed": "", "groceries": ""} {
+		var vendorID string
+		if err := db.QueryRow("SELECT COALESCE(vendor_id, '') FROM payments WHERE id = ?", id).Scan(&vendorID); err != nil {
+			t.Fatal(err)
+		}
+		if vendorID != want {
+			t.Errorf("%s vendor = %q, want %q", id, vendorID, want)
+		}
+	}
+
+	type vendorStats struct {
+		Vendor        models.Vendor `json:"vendor"`
+		PaymentCount  int           `json:"paymentCount"`
+		TotalSpend    string        `json:"totalSpend"`
+		AverageTicket string        `json:"averageTicket"`
+		SpendOverTime []struct {
+			Period string `json:"period"`
+			Amount string `json:"amount"`
+			Count  int    `json:"count"`
+		} `json:"spendOverTime"`
+	}
+	w = serve(editor, http.MethodGet, "/vendors/"+vendor.ID+"/analytics?currency=EUR", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("vendor analytics = %d: %s", w.Code, w.Body)
+	}
+	var stats vendorStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.PaymentCount != 2 || stats.TotalSpend != "10.00" || stats.AverageTicket != "5.00" {
+		t.Errorf("vendor analytics = %+v, want 2 payments of 10.00 averaging 5.00", stats)
+	}
+	if len(stats.SpendOverTime) != 2 || stats.SpendOverTime[0].Period != "2024-03" || stats.SpendOverTime[1].Amount != "5.50" {
+		t.Errorf("monthly spend = %+v, want 2024-03 then 2024-04 at 5.50", stats.SpendOverTime)
+	}
+
+	w = serve(editor, http.MethodGet, "/vendors/"+vendor.ID+"/analytics?currency=EUR&interval=year&start_date=2024-04-01", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.SpendOverTime) != 1 || stats.SpendOverTime[0].Period != "2024" || stats.SpendOverTime[0].Count != 1 {
+		t.Errorf("yearly spend from April = %+v, want one payment in 2024", stats.SpendOverTime)
+	}
+	if w := serve(editor, http.MethodGet, "/vendors/"+vendor.ID+"/analytics?interval=week", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("interval=week = %d, want 400", w.Code)
+	}
+
+	// Vendors without payments are listed after those with spend
+	if w := serve(editor, http.MethodPost, "/vendors", `{"name": "Bakery"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create second vendor = %d: %s", w.Code, w.Body)
+	}
+	w = serve(editor, http.MethodGet, "/vendors/analytics?currency=EUR", "")
+	var all []vendorStats
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Vendor.ID != vendor.ID || all[1].PaymentCount != 0 {
+		t.Errorf("analytics = %s, want Coffee Shop then Bakery", w.Body)
+	}
+
+	w = serve(editor, http.MethodPut, "/vendors/"+vendor.ID, `{"name": "Coffee House", "aliases": []}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update vendor = %d: %s", w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &vendor); err != nil {
+		t.Fatal(err)
+	}
+	if vendor.Name != "Coffee House" || len(vendor.Aliases) != 0 {
+		t.Errorf("updated vendor = %+v", vendor)
+	}
+	if w := serve(editor, http.MethodPut, "/vendors/missing", `{"name": "Nobody"}`); w.Code != http.StatusNotFound {
+		t.Errorf("update missing vendor = %d, want 404", w.Code)
+	}
+
+	// Deleting a vendor unlinks its payments
+	if w := serve(register(auth.RoleAccountant, workspaceID), http.MethodDelete, "/vendors/"+vendor.ID, ""); w.Code != http.StatusForbidden {
+		t.Errorf("accountant delete vendor = %d, want 403", w.Code)
+	}
+	if w := serve(editor, http.MethodDelete, "/vendors/"+vendor.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete vendor = %d: %s", w.Code, w.Body)
+	}
+	var linked int
+	if err := db.QueryRow("SELECT COUNT(*) FROM payments WHERE vendor_id IS NOT NULL").Scan(&linked); err != nil {
+		t.Fatal(err)
+	}
+	if linked != 0 {
+		t.Errorf("%d payments still linked to the deleted vendor", linked)
+	}
+	if w := serve(editor, http.MethodGet, "/vendors/"+vendor.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted vendor = %d, want 404", w.Code)
+	}
+}
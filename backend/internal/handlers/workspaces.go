@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"expense_tracker/internal/auth"
+	"expense_tracker/internal/models"
+	"expense_tracker/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	errLastOwner   = errors.New("a workspace must keep at least one owner")
+	errUnknownRole = errors.New("role must be owner, editor, accountant or viewer")
+)
+
+type WorkspaceHandler struct {
+	db *sql.DB
+}
+
+func NewWorkspaceHandler(db *sql.DB) *WorkspaceHandler {
+	return &WorkspaceHandler{db: db}
+}
+
+// RegisterRoutes registers the workspace and membership routes. They only
+// require a session, so a user without a workspace can create one, and
+// cannot be called with an API token. Routes
+// on a single workspace check the user's role in that workspace rather
+// than the session's.
+func (h *WorkspaceHandler) RegisterRoutes(router *gin.RouterGroup) {
+	workspaces := router.Group("/workspaces", requireSession())
+	{
+		workspaces.GET("", h.ListWorkspaces)
+		workspaces.POST("", h.CreateWorkspace)
+		workspaces.GET("/:id", h.requireWorkspacePermission(auth.PermRead), h.GetWorkspace)
+		workspaces.PUT("/:id", h.requireWorkspacePermission(auth.PermManageWorkspace), h.UpdateWorkspace)
+		workspaces.POST("/:id/switch", h.requireWorkspacePermission(auth.PermRead), h.SwitchWorkspace)
+		workspaces.GET("/:id/members", h.requireWorkspacePermission(auth.PermRead), h.ListMembers)
+		workspaces.POST("/:id/members", h.requireWorkspacePermission(auth.PermManageWorkspace), h.AddMember)
+		workspaces.PUT("/:id/members/:userId", h.requireWorkspacePermission(auth.PermManageWorkspace), h.UpdateMember)
+		workspaces.DELETE("/:id/members/:userId", h.requireWorkspacePermission(auth.PermManageWorkspace), h.RemoveMember)
+	}
+}
+
+const workspaceColumns = `w.id, w.name, w.created_at, w.updated_at`
+
+func scanWorkspace(row rowScanner, w *models.Workspace, extra ...interface{}) error {
+	dest := append([]interface{}{&w.ID, &w.Name, &w.CreatedAt, &w.UpdatedAt}, extra...)
+	return row.Scan(dest...)
+}
+
+// ListWorkspaces returns the workspaces the user belongs to with their role
+// in each, marking the one the session has switched to as current
+func (h *WorkspaceHandler) ListWorkspaces(c *gin.Context) {
+	user, _ := currentUser(c)
+
+	rows, err := h.db.Query(`
+		SELECT `+workspaceColumns+`, m.role
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = ?
+		ORDER BY m.created_at
+	`, user.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch workspaces")
+		return
+	}
+	defer rows.Close()
+
+	type workspaceEntry struct {
+		models.Workspace
+		Role    string `json:"role"`
+		Current bool   `json:"current"`
+	}
+
+	workspaces := make([]workspaceEntry, 0)
+	for rows.Next() {
+		var w workspaceEntry
+		if err := scanWorkspace(rows, &w.Workspace, &w.Role); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan workspace")
+			return
+		}
+		w.Current = w.ID == workspaceID(c)
+		workspaces = append(workspaces, w)
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// CreateWorkspace creates a workspace with the user as its owner. A session
+// without a workspace switches to the new one.
+func (h *WorkspaceHandler) CreateWorkspace(c *gin.Context) {
+	user, _ := currentUser(c)
+
+	workspace, ok := bindWorkspace(c)
+	if !ok {
+		return
+	}
+	workspace.ID = uuid.New().String()
+	workspace.CreatedAt = time.Now()
+	workspace.UpdatedAt = workspace.CreatedAt
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO workspaces (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		workspace.ID, workspace.Name, workspace.CreatedAt, workspace.UpdatedAt,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to create workspace")
+		return
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		workspace.ID, user.ID, auth.RoleOwner, workspace.CreatedAt,
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to add workspace member")
+		return
+	}
+
+	if workspaceID(c) == "" {
+		if err := switchSession(tx, c, workspace.ID); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to switch workspace")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// GetWorkspace returns a workspace the user belongs to
+func (h *WorkspaceHandler) GetWorkspace(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// UpdateWorkspace renames a workspace
+func (h *WorkspaceHandler) UpdateWorkspace(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	update, ok := bindWorkspace(c)
+	if !ok {
+		return
+	}
+	workspace.Name = update.Name
+	workspace.UpdatedAt = time.Now()
+
+	_, err := h.db.Exec("UPDATE workspaces SET name = ?, updated_at = ? WHERE id = ?", workspace.Name, workspace.UpdatedAt, workspace.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// SwitchWorkspace makes a workspace the session's current one. Later
+// requests on this session only see that workspace's data.
+func (h *WorkspaceHandler) SwitchWorkspace(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	if err := switchSession(h.db, c, workspace.ID); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to switch workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+// ListMembers returns the users belonging to a workspace with their roles
+func (h *WorkspaceHandler) ListMembers(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT `+userColumns+`, m.role
+		FROM users u
+		JOIN workspace_members m ON m.user_id = u.id
+		WHERE m.workspace_id = ?
+		ORDER BY m.created_at
+	`, workspace.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch members")
+		return
+	}
+	defer rows.Close()
+
+	members := make([]models.WorkspaceMember, 0)
+	for rows.Next() {
+		var m models.WorkspaceMember
+		if err := scanUser(rows, &m.User, &m.Role); err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to scan member")
+			return
+		}
+		members = append(members, m)
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember adds an existing user, given by email, to a workspace. The
+// role defaults to viewer; a user who is already a member keeps their role.
+func (h *WorkspaceHandler) AddMember(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email string    `json:"email" binding:"required"`
+		Role  auth.Role `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid member data")
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+	if !req.Role.Valid() {
+		utils.RespondWithError(c, http.StatusBadRequest, errUnknownRole, "Invalid member data")
+		return
+	}
+
+	var userID string
+	err := h.db.QueryRow("SELECT id FROM users WHERE email = ?", strings.TrimSpace(req.Email)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "User not found")
+		return
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch user")
+		return
+	}
+
+	_, err = h.db.Exec(
+		"INSERT OR IGNORE INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		workspace.ID, userID, req.Role, time.Now(),
+	)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to add workspace member")
+		return
+	}
+
+	member, err := fetchMember(h.db, workspace.ID, userID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch member")
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember changes a member's role. The last owner cannot be demoted.
+func (h *WorkspaceHandler) UpdateMember(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+	userID := c.Param("userId")
+
+	var req struct {
+		Role auth.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid member data")
+		return
+	}
+	if !req.Role.Valid() {
+		utils.RespondWithError(c, http.StatusBadRequest, errUnknownRole, "Invalid member data")
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?", req.Role, workspace.ID, userID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update workspace member")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Member not found")
+		return
+	}
+	if !keepsOwner(c, tx, workspace.ID, "Cannot demote the last owner") {
+		return
+	}
+
+	member, err := fetchMember(tx, workspace.ID, userID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch member")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a user from a workspace. Their sessions in it lose
+// access straight away. The last owner cannot be removed.
+func (h *WorkspaceHandler) RemoveMember(c *gin.Context) {
+	workspace, ok := h.findWorkspace(c)
+	if !ok {
+		return
+	}
+	userID := c.Param("userId")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspace.ID, userID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to remove workspace member")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to get rows affected")
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.RespondWithError(c, http.StatusNotFound, sql.ErrNoRows, "Member not found")
+		return
+	}
+	if !keepsOwner(c, tx, workspace.ID, "Cannot remove the last owner") {
+		return
+	}
+
+	_, err = tx.Exec("UPDATE sessions SET workspace_id = NULL WHERE user_id = ? AND workspace_id = ?", userID, workspace.ID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to update sessions")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to commit transaction")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// keepsOwner responds with 409 and returns false when a membership change
+// has left the workspace without an owner
+func keepsOwner(c *gin.Context, tx *sql.Tx, workspaceID, details string) bool {
+	var owners int
+	err := tx.QueryRow("SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ?", workspaceID, auth.RoleOwner).Scan(&owners)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch members")
+		return false
+	}
+	if owners == 0 {
+		utils.RespondWithError(c, http.StatusConflict, errLastOwner, details)
+		return false
+	}
+	return true
+}
+
+// fetchMember loads a user together with their role in a workspace
+func fetchMember(db queryRower, workspaceID, userID string) (models.WorkspaceMember, error) {
+	var member models.WorkspaceMember
+	row := db.QueryRow(`
+		SELECT `+userColumns+`, m.role
+		FROM users u
+		JOIN workspace_members m ON m.user_id = u.id
+		WHERE m.workspace_id = ? AND u.id = ?
+	`, workspaceID, userID)
+	err := scanUser(row, &member.User, &member.Role)
+	return member, err
+}
+
+// requireWorkspacePermission is middleware for routes on the workspace in
+// the URL. It responds with 404 unless the user is a member of it and 403
+// unless their role there grants perm.
+func (h *WorkspaceHandler) requireWorkspacePermission(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, _ := currentUser(c)
+
+		var role auth.Role
+		err := h.db.QueryRow(
+			"SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?",
+			c.Param("id"), user.ID,
+		).Scan(&role)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(c, http.StatusNotFound, err, "Workspace not found")
+			c.Abort()
+			return
+		} else if err != nil {
+			utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch workspace")
+			c.Abort()
+			return
+		}
+
+		if !allow(c, role, perm) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// findWorkspace loads the workspace in the URL, responding with 404 unless
+// the user is a member of it
+func (h *WorkspaceHandler) findWorkspace(c *gin.Context) (models.Workspace, bool) {
+	user, _ := currentUser(c)
+
+	var workspace models.Workspace
+	row := h.db.QueryRow(`
+		SELECT `+workspaceColumns+`
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE w.id = ? AND m.user_id = ?
+	`, c.Param("id"), user.ID)
+	if err := scanWorkspace(row, &workspace); err == sql.ErrNoRows {
+		utils.RespondWithError(c, http.StatusNotFound, err, "Workspace not found")
+		return workspace, false
+	} else if err != nil {
+		utils.RespondWithError(c, http.StatusInternalServerError, err, "Failed to fetch workspace")
+		return workspace, false
+	}
+	return workspace, true
+}
+
+// bindWorkspace parses a workspace name from the request body
+func bindWorkspace(c *gin.Context) (models.Workspace, bool) {
+	var workspace models.Workspace
+	if err := c.ShouldBindJSON(&workspace); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err, "Invalid workspace data")
+		return workspace, false
+	}
+	workspace.Name = strings.TrimSpace(workspace.Name)
+	if workspace.Name == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, errors.New("name must not be empty"), "Invalid workspace data")
+		return workspace, false
+	}
+	return workspace, true
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// switchSession points the request's session at a workspace
+func switchSession(db execer, c *gin.Context, workspaceID string) error {
+	_, err := db.Exec("UPDATE sessions SET workspace_id = ? WHERE id = ?", workspaceID, auth.HashToken(requestToken(c)))
+	return err
+}
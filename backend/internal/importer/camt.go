@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"encoding/xml"
+	"errors"
+	"expense_tracker/internal/models"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// camtDocument is the subset of an ISO 20022 camt.053 bank-to-customer
+// statement used for importing. Element names are matched without their
+// namespace so all camt.053 versions are accepted.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	IBAN     string      `xml:"Acct>Id>IBAN"`
+	OtherID  string      `xml:"Acct>Id>Othr>Id"`
+	Currency string      `xml:"Acct>Ccy"`
+	Entries  []camtEntry `xml:"Ntry"`
+}
+
+type camtEntry struct {
+	Reference   string          `xml:"NtryRef"`
+	Amount      camtAmount      `xml:"Amt"`
+	Indicator   string          `xml:"CdtDbtInd"`
+	Status      camtStatus      `xml:"Sts"`
+	BookingDate camtDate        `xml:"BookgDt"`
+	ValueDate   camtDate        `xml:"ValDt"`
+	ServicerRef string          `xml:"AcctSvcrRef"`
+	Info        string          `xml:"AddtlNtryInf"`
+	Details     []camtTxDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtStatus is a plain code before camt.053.001.08 and a Cd element after
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTxDetails struct {
+	ServicerRef   string     `xml:"Refs>AcctSvcrRef"`
+	TxID          string     `xml:"Refs>TxId"`
+	EndToEndID    string     `xml:"Refs>EndToEndId"`
+	Amount        camtAmount `xml:"Amt"`
+	Indicator     string     `xml:"CdtDbtInd"`
+	Creditor      string     `xml:"RltdPties>Cdtr>Nm"`
+	CreditorParty string     `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Debtor        string     `xml:"RltdPties>Dbtr>Nm"`
+	DebtorParty   string     `xml:"RltdPties>Dbtr>Pty>Nm"`
+	Remittance    []string   `xml:"RmtInf>Ustrd"`
+	Info          string     `xml:"AddtlTxInf"`
+}
+
+// ParseCAMT053 reads an ISO 20022 camt.053 statement. Debit entries are
+// imported and credits skipped; pending entries are skipped as well. A
+// batch entry with several amounted transaction details yields one row per
+// detail. The account servicer's reference, prefixed with the account,
+// becomes the row's external ID.
+func ParseCAMT053(r io.Reader) ([]Row, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid camt.053 file: %w", err)
+	}
+	if len(doc.Statements) == 0 {
+		return nil, errors.New("invalid camt.053 file: no statements found")
+	}
+
+	rows := make([]Row, 0)
+	for _, stmt := range doc.Statements {
+		account := stmt.IBAN
+		if account == "" {
+			account = stmt.OtherID
+		}
+
+		for _, entry := range stmt.Entries {
+			if len(entry.Details) > 1 && detailsHaveAmounts(entry.Details) {
+				for i, detail := range entry.Details {
+					ref := firstRef(detail.ServicerRef, detail.TxID, detail.EndToEndID)
+					if ref == "" {
+						ref = firstRef(entry.ServicerRef, entry.Reference)
+						if ref != "" {
+							ref += "/" + strconv.Itoa(i+1)
+						}
+					}
+					indicator := detail.Indicator
+					if indicator == "" {
+						indicator = entry.Indicator
+					}
+					rows = append(rows, camtRow(len(rows)+1, entry, detail, detail.Amount, indicator, externalID(account, ref), stmt.Currency))
+				}
+				continue
+			}
+
+			var detail camtTxDetails
+			if len(entry.Details) > 0 {
+				detail = entry.Details[0]
+			}
+			ref := firstRef(entry.ServicerRef, detail.ServicerRef, entry.Reference, detail.TxID, detail.EndToEndID)
+			rows = append(rows, camtRow(len(rows)+1, entry, detail, entry.Amount, entry.Indicator, externalID(account, ref), stmt.Currency))
+		}
+	}
+
+	return rows, nil
+}
+
+// camtRow converts an entry, or one transaction detail of it, into a row
+func camtRow(line int, entry camtEntry, detail camtTxDetails, amt camtAmount, indicator, externalID, accountCurrency string) Row {
+	row := Row{Line: line, Status: StatusValid, Tags: []string{}, ExternalID: externalID}
+
+	// The counterparty of a debit is the creditor
+	name := firstRef(detail.Creditor, detail.CreditorParty)
+	if indicator == "CRDT" {
+		name = firstRef(detail.Debtor, detail.DebtorParty)
+	}
+	row.Info = joinInfo(name, strings.Join(detail.Remittance, " "))
+	if row.Info == "" {
+		row.Info = firstRef(detail.Info, entry.Info)
+	}
+	if row.Info == "" {
+		row.addError("entry has no counterparty, remittance or additional information")
+	}
+
+	date := entry.BookingDate
+	if date.Date == "" && date.DateTime == "" {
+		date = entry.ValueDate
+	}
+	if parsed, err := date.parse(); err != nil {
+		row.addError(err.Error())
+	} else {
+		row.DatePaid = parsed
+	}
+
+	currency := firstRef(amt.Currency, accountCurrency)
+	if code, err := models.NormalizeCurrency(currency); err != nil {
+		row.addError(err.Error())
+	} else {
+		row.Currency = code
+	}
+
+	amount, err := ParseAmount(amt.Value, ".", row.Currency)
+	if err != nil {
+		row.addError(fmt.Sprintf("invalid amount %q: %v", amt.Value, err))
+		return row
+	}
+	switch indicator {
+	case "DBIT":
+		amount.Minor = -amount.Minor
+	case "CRDT":
+	default:
+		row.addError(fmt.Sprintf("invalid credit/debit indicator %q", indicator))
+	}
+	row.Amount = ApplySign(&row, amount, SignNegativeIsExpense)
+
+	if status := firstRef(entry.Status.Code, entry.Status.Value); status == "PDNG" {
+		row.skip("entry is pending")
+	}
+	return row
+}
+
+func (d camtDate) parse() (time.Time, error) {
+	if d.Date != "" {
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	}
+	if s := strings.TrimSpace(d.DateTime); len(s) >= 10 {
+		return time.Parse("2006-01-02", s[:10])
+	}
+	return time.Time{}, errors.New("entry has no booking or value date")
+}
+
+func detailsHaveAmounts(details []camtTxDetails) bool {
+	for _, detail := range details {
+		if strings.TrimSpace(detail.Amount.Value) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// firstRef returns the first non-empty value, ignoring the NOTPROVIDED
+// placeholder used by SEPA payments without an end-to-end ID
+func firstRef(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" && v != "NOTPROVIDED" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCAMT053(t *testing.T) {
+	rows, err := ParseCAMT053(openFixture(t, "camt053.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const account = "DE89370400440532013000:"
+	checkRows(t, rows, []wantRow{
+		{1, "Hausverwaltung Schmidt GmbH - Miete Maerz 2024", 85000, "EUR", "2024-03-01", nil, account + "2024030100001", StatusValid},
+		{2, "Example Employer AG - Gehalt Maerz", 320000, "EUR", "2024-03-05", nil, account + "2024030500007", StatusSkipped},
+		// A batch entry yields one row per amounted detail
+		{3, "Stadtwerke Musterstadt - Abschlag Strom", 5490, "EUR", "2024-03-15", nil, account + "2024031500012-1", StatusValid},
+		{4, "Telekom Deutschland - Rechnung 03/2024", 4150, "EUR", "2024-03-15", nil, account + "2024031500012-2", StatusValid},
+		{5, "Kartenzahlung Buchhandlung", 1299, "EUR", "2024-03-31", nil, account + "2024033100003", StatusSkipped},
+	})
+}
+
+func TestParseCAMT053BatchWithoutDetailRefs(t *testing.T) {
+	input := `<Document><BkToCstmrStmt><Stmt>
+		<Acct><Id><Othr><Id>ACC-1</Id></Othr></Id><Ccy>EUR</Ccy></Acct>
+		<Ntry>
+			<Amt Ccy="EUR">3.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts><Cd>BOOK</Cd></Sts>
+			<ValDt><DtTm>2024-02-01T10:00:00</DtTm></ValDt><AcctSvcrRef>REF</AcctSvcrRef>
+			<NtryDtls>
+				<TxDtls><Amt>1.00</Amt><RltdPties><Cdtr><Pty><Nm>A</Nm></Pty></Cdtr></RltdPties></TxDtls>
+				<TxDtls><Amt>2.00</Amt><RltdPties><Cdtr><Pty><Nm>B</Nm></Pty></Cdtr></RltdPties></TxDtls>
+			</NtryDtls>
+		</Ntry>
+	</Stmt></BkToCstmrStmt></Document>`
+	rows, err := ParseCAMT053(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []wantRow{
+		{1, "A", 100, "EUR", "2024-02-01", nil, "ACC-1:REF/1", StatusValid},
+		{2, "B", 200, "EUR", "2024-02-01", nil, "ACC-1:REF/2", StatusValid},
+	})
+}
+
+func TestParseCAMT053Errors(t *testing.T) {
+	for _, input := range []string{"not xml", "<Document></Document>"} {
+		if _, err := ParseCAMT053(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseCAMT053(%q) succeeded, want an error", input)
+		}
+	}
+}
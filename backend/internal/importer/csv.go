@@ -0,0 +1,290 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"expense_tracker/internal/models"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Amount sign conventions
+const (
+	// SignNegativeIsExpense imports negative amounts (debits) as payments
+	// and skips positive ones
+	SignNegativeIsExpense = "negative_is_expense"
+	// SignPositiveIsExpense imports positive amounts as payments and skips
+	// negative ones
+	SignPositiveIsExpense = "positive_is_expense"
+	// SignAbsolute imports every row using its absolute amount
+	SignAbsolute = "absolute"
+)
+
+// Mapping describes how to read a CSV export. Columns are referenced by
+// their header name, compared case-insensitively.
+type Mapping struct {
+	DateColumn       string `json:"dateColumn"`
+	DateFormat       string `json:"dateFormat"`
+	AmountColumn     string `json:"amountColumn"`
+	AmountSign       string `json:"amountSign"`
+	DecimalSeparator string `json:"decimalSeparator"`
+	InfoColumn       string `json:"infoColumn"`
+	TagColumn        string `json:"tagColumn"`
+	TagSeparator     string `json:"tagSeparator"`
+	CurrencyColumn   string `json:"currencyColumn"`
+	IDColumn         string `json:"idColumn"`
+	Currency         string `json:"currency"`
+	Delimiter        string `json:"delimiter"`
+}
+
+// Validate fills defaults and checks the mapping is usable
+func (m *Mapping) Validate() error {
+	if m.DateColumn == "" || m.AmountColumn == "" || m.InfoColumn == "" {
+		return errors.New("dateColumn, amountColumn and infoColumn are required")
+	}
+	if m.DateFormat == "" {
+		m.DateFormat = "YYYY-MM-DD"
+	}
+	switch m.AmountSign {
+	case "":
+		m.AmountSign = SignAbsolute
+	case SignNegativeIsExpense, SignPositiveIsExpense, SignAbsolute:
+	default:
+		return fmt.Errorf("amountSign must be %s, %s or %s", SignNegativeIsExpense, SignPositiveIsExpense, SignAbsolute)
+	}
+	switch m.DecimalSeparator {
+	case "":
+		m.DecimalSeparator = "."
+	case ".", ",":
+	default:
+		return errors.New(`decimalSeparator must be "." or ","`)
+	}
+	if m.TagSeparator == "" {
+		m.TagSeparator = ","
+	}
+	if len([]rune(m.Delimiter)) > 1 {
+		return errors.New("delimiter must be a single character")
+	}
+	currency, err := models.NormalizeCurrency(m.Currency)
+	if err != nil {
+		return err
+	}
+	m.Currency = currency
+	return nil
+}
+
+// ParseCSV reads a CSV export with a header row and maps every data row to
+// a Row. Problems with individual rows are reported on the row; only an
+// unreadable file or missing columns return an error.
+func ParseCSV(r io.Reader, m Mapping) ([]Row, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		reader.Comma = []rune(m.Delimiter)[0]
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[normalizeHeader(name)] = i
+	}
+	index := func(name string) (int, error) {
+		if name == "" {
+			return -1, nil
+		}
+		i, ok := columns[normalizeHeader(name)]
+		if !ok {
+			return -1, fmt.Errorf("column %q not found in header", name)
+		}
+		return i, nil
+	}
+
+	dateCol, err := index(m.DateColumn)
+	if err != nil {
+		return nil, err
+	}
+	amountCol, err := index(m.AmountColumn)
+	if err != nil {
+		return nil, err
+	}
+	infoCol, err := index(m.InfoColumn)
+	if err != nil {
+		return nil, err
+	}
+	tagCol, err := index(m.TagColumn)
+	if err != nil {
+		return nil, err
+	}
+	currencyCol, err := index(m.CurrencyColumn)
+	if err != nil {
+		return nil, err
+	}
+	idCol, err := index(m.IDColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := DateLayout(m.DateFormat)
+	rows := make([]Row, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isBlank(record) {
+			continue
+		}
+
+		// The reader skips empty lines, so ask it where the record started
+		line, _ := reader.FieldPos(0)
+		row := Row{Line: line, Status: StatusValid, Tags: []string{}}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row.Info = field(infoCol)
+		if row.Info == "" {
+			row.addError("info is empty")
+		}
+
+		if date, err := time.Parse(layout, field(dateCol)); err != nil {
+			row.addError(fmt.Sprintf("invalid date %q, expected %s", field(dateCol), m.DateFormat))
+		} else {
+			row.DatePaid = date
+		}
+
+		row.Currency = m.Currency
+		if currencyCol >= 0 && field(currencyCol) != "" {
+			if currency, err := models.NormalizeCurrency(field(currencyCol)); err != nil {
+				row.addError(err.Error())
+			} else {
+				row.Currency = currency
+			}
+		}
+
+		if amount, err := ParseAmount(field(amountCol), m.DecimalSeparator, row.Currency); err != nil {
+			row.addError(fmt.Sprintf("invalid amount %q: %v", field(amountCol), err))
+		} else {
+			row.Amount = ApplySign(&row, amount, m.AmountSign)
+		}
+
+		row.ExternalID = field(idCol)
+
+		if tagCol >= 0 {
+			for _, name := range strings.Split(field(tagCol), m.TagSeparator) {
+				if name = strings.TrimSpace(name); name != "" {
+					row.Tags = append(row.Tags, name)
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// ApplySign converts a signed statement amount into a positive payment
+// amount according to the sign convention, skipping rows that are income
+func ApplySign(row *Row, amount models.Money, convention string) models.Money {
+	switch convention {
+	case SignNegativeIsExpense:
+		if amount.Minor >= 0 {
+			row.skip("not an expense (amount is not negative)")
+		}
+	case SignPositiveIsExpense:
+		if amount.Minor <= 0 {
+			row.skip("not an expense (amount is not positive)")
+		}
+	}
+	if amount.Minor < 0 {
+		amount.Minor = -amount.Minor
+	}
+	return amount
+}
+
+// ParseAmount parses a bank-formatted amount such as "1.234,56",
+// "(12.00)", "-€ 45.10" or "1,234.56 USD" into minor units
+func ParseAmount(s, decimalSeparator, currency string) (models.Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+
+	// Keep digits, separators and sign; drop currency symbols and codes
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '.', r == ',':
+			b.WriteRune(r)
+		case r == '-' || r == '−':
+			negative = !negative
+		}
+	}
+	s = b.String()
+
+	thousands := ","
+	if decimalSeparator == "," {
+		thousands = "."
+	}
+	s = strings.ReplaceAll(s, thousands, "")
+	s = strings.ReplaceAll(s, decimalSeparator, ".")
+	if s == "" {
+		return models.Money{}, models.ErrInvalidAmount
+	}
+
+	amount, err := models.ParseMoney(s, currency)
+	if err != nil {
+		return amount, err
+	}
+	if negative {
+		amount.Minor = -amount.Minor
+	}
+	return amount, nil
+}
+
+// DateLayout converts a format such as "DD/MM/YYYY" into a Go time layout.
+// Formats without YYYY, YY, MM or DD tokens are treated as Go layouts.
+func DateLayout(format string) string {
+	return strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"DD", "02",
+	).Replace(format)
+}
+
+func normalizeHeader(name string) string {
+	// Strip a UTF-8 byte order mark that some exports put before the first header
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
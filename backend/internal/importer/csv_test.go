@@ -0,0 +1,113 @@
+package importer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	mapping := Mapping{
+		DateColumn:       "Booking date",
+		DateFormat:       "DD/MM/YYYY",
+		AmountColumn:     "amount",
+		AmountSign:       SignNegativeIsExpense,
+		DecimalSeparator: ",",
+		InfoColumn:       "Description",
+		TagColumn:        "Category",
+		IDColumn:         "Reference",
+		Currency:         "eur",
+		Delimiter:        ";",
+	}
+	rows, err := ParseCSV(openFixture(t, "statement.csv"), mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []wantRow{
+		{2, "Coffee shop", 450, "EUR", "2024-03-01", []string{"Food"}, "TX-1001", StatusValid},
+		{3, "Salary", 250000, "EUR", "2024-03-02", []string{"Income"}, "TX-1002", StatusSkipped},
+		{4, "Groceries", 123456, "EUR", "2024-03-03", []string{"Food"}, "TX-1003", StatusValid},
+		{Line: 5, Status: StatusInvalid},
+	})
+}
+
+func TestParseCSVMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		mapping Mapping
+		want    []wantRow
+	}{
+		{
+			name:    "defaults keep absolute amounts",
+			input:   "\ufeffDate,Amount,Info\n2024-01-02,-12.50,Lunch\n\n2024-01-03,3,Refund\n",
+			mapping: Mapping{DateColumn: "date", AmountColumn: "amount", InfoColumn: "info", Currency: "USD"},
+			want: []wantRow{
+				{2, "Lunch", 1250, "USD", "2024-01-02", nil, "", StatusValid},
+				{4, "Refund", 300, "USD", "2024-01-03", nil, "", StatusValid},
+			},
+		},
+		{
+			name:    "positive is expense with a currency column",
+			input:   "date,amount,info,ccy,tags\n2024-01-02,1500,Hotel,jpy,travel | work\n2024-01-03,-5.00,Refund,,\n",
+			mapping: Mapping{DateColumn: "date", AmountColumn: "amount", AmountSign: SignPositiveIsExpense, InfoColumn: "info", CurrencyColumn: "ccy", TagColumn: "tags", TagSeparator: "|", Currency: "EUR"},
+			want: []wantRow{
+				{2, "Hotel", 1500, "JPY", "2024-01-02", []string{"travel", "work"}, "", StatusValid},
+				{3, "Refund", 500, "EUR", "2024-01-03", nil, "", StatusSkipped},
+			},
+		},
+		{
+			name:    "missing values make a row invalid",
+			input:   "date,amount,info\n02.01.2024,1,\n",
+			mapping: Mapping{DateColumn: "date", AmountColumn: "amount", InfoColumn: "info", Currency: "EUR"},
+			want:    []wantRow{{Line: 2, Status: StatusInvalid}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ParseCSV(strings.NewReader(tt.input), tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRows(t, rows, tt.want)
+		})
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	valid := Mapping{DateColumn: "date", AmountColumn: "amount", InfoColumn: "info", Currency: "EUR"}
+	tests := []struct {
+		name    string
+		input   string
+		mapping Mapping
+	}{
+		{"empty file", "", valid},
+		{"missing column", "date,amount\n", valid},
+		{"missing mapping", "date,amount,info\n", Mapping{DateColumn: "date", Currency: "EUR"}},
+		{"bad sign", "date,amount,info\n", Mapping{DateColumn: "date", AmountColumn: "amount", InfoColumn: "info", AmountSign: "up", Currency: "EUR"}},
+		{"bad currency", "date,amount,info\n", Mapping{DateColumn: "date", AmountColumn: "amount", InfoColumn: "info", Currency: "EURO"}},
+	}
+	for _, tt := range tests {
+		if _, err := ParseCSV(strings.NewReader(tt.input), tt.mapping); err == nil {
+			t.Errorf("%s: ParseCSV succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestMappingJSON(t *testing.T) {
+	var m Mapping
+	err := json.Unmarshal([]byte(`{"dateColumn": "Date", "amountColumn": "Amount", "amountSign": "negative_is_expense",
+		"infoColumn": "Description", "idColumn": "Reference", "decimalSeparator": ","}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := Mapping{DateColumn: "Date", DateFormat: "YYYY-MM-DD", AmountColumn: "Amount", AmountSign: SignNegativeIsExpense,
+		DecimalSeparator: ",", InfoColumn: "Description", TagSeparator: m.TagSeparator, IDColumn: "Reference",
+		Currency: m.Currency, Delimiter: m.Delimiter}
+	if m != want {
+		t.Errorf("mapping = %+v, want %+v", m, want)
+	}
+}
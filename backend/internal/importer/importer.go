@@ -0,0 +1,101 @@
+// Package importer turns bank exports into payment rows that the payment
+// handlers can validate, preview and insert.
+package importer
+
+import (
+	"expense_tracker/internal/models"
+	"fmt"
+	"time"
+)
+
+// Row statuses
+const (
+	StatusValid     = "valid"
+	StatusInvalid   = "invalid"
+	StatusSkipped   = "skipped"
+	StatusDuplicate = "duplicate"
+)
+
+// Row is a single parsed statement line
+type Row struct {
+	Line       int          `json:"line"`
+	Info       string       `json:"info"`
+	Amount     models.Money `json:"amount"`
+	Currency   string       `json:"currency"`
+	DatePaid   time.Time    `json:"datePaid"`
+	Tags       []string     `json:"tags"`
+	ExternalID string       `json:"externalId,omitempty"`
+	Status     string       `json:"status"`
+	Errors     []string     `json:"errors,omitempty"`
+
+	// Set when the row looks like an existing payment or an earlier row
+	DuplicateOf     string `json:"duplicateOf,omitempty"`
+	DuplicateOfLine int    `json:"duplicateOfLine,omitempty"`
+}
+
+// addError records a validation error and marks the row invalid
+func (r *Row) addError(msg string) {
+	r.Errors = append(r.Errors, msg)
+	r.Status = StatusInvalid
+}
+
+// skip marks a row that is well-formed but should not be imported
+func (r *Row) skip(reason string) {
+	if r.Status != StatusInvalid {
+		r.Status = StatusSkipped
+		r.Errors = append(r.Errors, reason)
+	}
+}
+
+// MarkDuplicate records that the row is a probable duplicate. Rejected
+// duplicates are not imported.
+func (r *Row) MarkDuplicate(paymentID string, line int, reject bool) {
+	r.DuplicateOf, r.DuplicateOfLine = paymentID, line
+	if !reject {
+		return
+	}
+	r.Status = StatusDuplicate
+	if paymentID != "" {
+		r.Errors = append(r.Errors, "probable duplicate of payment "+paymentID)
+	} else {
+		r.Errors = append(r.Errors, fmt.Sprintf("probable duplicate of line %d", line))
+	}
+}
+
+// MarkImported skips a row whose bank transaction was already imported as
+// the given payment, or appears earlier in the same file if paymentID is ""
+func (r *Row) MarkImported(paymentID string) {
+	r.Status = StatusSkipped
+	if paymentID != "" {
+		r.Errors = append(r.Errors, "already imported as payment "+paymentID)
+	} else {
+		r.Errors = append(r.Errors, "transaction ID repeats an earlier row")
+	}
+}
+
+// Summary counts rows per status
+type Summary struct {
+	Total     int `json:"total"`
+	Valid     int `json:"valid"`
+	Invalid   int `json:"invalid"`
+	Skipped   int `json:"skipped"`
+	Duplicate int `json:"duplicate"`
+}
+
+// Summarize counts the rows per status
+func Summarize(rows []Row) Summary {
+	s := Summary{Total: len(rows)}
+	for _, r := range rows {
+		switch r.Status {
+		case StatusValid:
+			s.Valid++
+		case StatusInvalid:
+			s.Invalid++
+		case StatusSkipped:
+			s.Skipped++
+		case StatusDuplicate:
+			s.Duplicate++
+		}
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// wantRow is the part of a parsed row the fixture tests compare
+type wantRow struct {
+	Line       int
+	Info       string
+	Minor      int64
+	Currency   string
+	DatePaid   string
+	Tags       []string
+	ExternalID string
+	Status     string
+}
+
+func openFixture(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// checkRows compares parsed rows field by field. Invalid rows are only
+// compared by line and status since their other fields are partial.
+func checkRows(t *testing.T, got []Row, want []wantRow) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Line != w.Line || g.Status != w.Status {
+			t.Errorf("row %d: line %d status %q (%v), want line %d status %q", i, g.Line, g.Status, g.Errors, w.Line, w.Status)
+			continue
+		}
+		if w.Status == StatusInvalid {
+			if len(g.Errors) == 0 {
+				t.Errorf("row %d: invalid without errors", i)
+			}
+			continue
+		}
+		if g.Info != w.Info {
+			t.Errorf("row %d: info %q, want %q", i, g.Info, w.Info)
+		}
+		if g.Amount.Minor != w.Minor || g.Amount.Currency != w.Currency || g.Currency != w.Currency {
+			t.Errorf("row %d: amount %d %s (row %s), want %d %s", i, g.Amount.Minor, g.Amount.Currency, g.Currency, w.Minor, w.Currency)
+		}
+		if date := g.DatePaid.Format(time.DateOnly); date != w.DatePaid {
+			t.Errorf("row %d: date %s, want %s", i, date, w.DatePaid)
+		}
+		tags := w.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		if !reflect.DeepEqual(g.Tags, tags) {
+			t.Errorf("row %d: tags %q, want %q", i, g.Tags, tags)
+		}
+		if g.ExternalID != w.ExternalID {
+			t.Errorf("row %d: external ID %q, want %q", i, g.ExternalID, w.ExternalID)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in        string
+		separator string
+		currency  string
+		want      int64
+		wantErr   bool
+	}{
+		{"12.34", ".", "EUR", 1234, false},
+		{"1,234.56 USD", ".", "USD", 123456, false},
+		{"1.234,56", ",", "EUR", 123456, false},
+		{"-€ 45.10", ".", "EUR", -4510, false},
+		{"(12.00)", ".", "EUR", -1200, false},
+		{"−3,5", ",", "EUR", -350, false},
+		{"1500", ".", "JPY", 1500, false},
+		{"12.345", ".", "EUR", 0, true},
+		{"abc", ".", "EUR", 0, true},
+		{"", ".", "EUR", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in, tt.separator, tt.currency)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseAmount(%q) = %d, want an error", tt.in, got.Minor)
+			}
+			continue
+		}
+		if err != nil || got.Minor != tt.want || got.Currency != tt.currency {
+			t.Errorf("ParseAmount(%q) = %d %s, %v, want %d %s", tt.in, got.Minor, got.Currency, err, tt.want, tt.currency)
+		}
+	}
+}
+
+func TestRowStatuses(t *testing.T) {
+	row := Row{Status: StatusValid}
+	row.MarkImported("p1")
+	if row.Status != StatusSkipped || len(row.Errors) != 1 {
+		t.Errorf("MarkImported = %+v", row)
+	}
+
+	s := Summarize([]Row{row, {Status: StatusValid}, {Status: StatusInvalid}, {Status: StatusDuplicate}})
+	if s != (Summary{Total: 4, Valid: 1, Invalid: 1, Skipped: 1, Duplicate: 1}) {
+		t.Errorf("Summarize = %+v", s)
+	}
+}
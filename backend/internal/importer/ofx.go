@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"errors"
+	"expense_tracker/internal/models"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// ParseOFX reads an OFX or QFX statement. Both the SGML (1.x) and XML (2.x)
+// variants are accepted since only element names and leaf values are used.
+// Debits are negative in OFX, so only they are imported; credits are
+// skipped. Each transaction's FITID, prefixed with the account ID, becomes
+// the row's external ID. currency is used when the file has no CURDEF.
+func ParseOFX(r io.Reader, currency string) ([]Row, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(data)
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("not an OFX file: <OFX> element not found")
+	}
+	s = s[start:]
+
+	if currency, err = models.NormalizeCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0)
+	var account string
+	var txn map[string]string
+	for {
+		open := strings.IndexByte(s, '<')
+		if open < 0 {
+			break
+		}
+		s = s[open+1:]
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+		tag := strings.ToUpper(strings.TrimSpace(s[:end]))
+		s = s[end+1:]
+
+		// In SGML files leaf elements aren't closed, so the value runs up
+		// to the next tag
+		next := strings.IndexByte(s, '<')
+		if next < 0 {
+			next = len(s)
+		}
+		value := strings.TrimSpace(html.UnescapeString(s[:next]))
+
+		switch tag {
+		case "STMTTRN":
+			txn = make(map[string]string)
+		case "/STMTTRN":
+			if txn != nil {
+				rows = append(rows, ofxRow(len(rows)+1, txn, account, currency))
+			}
+			txn = nil
+		case "CURDEF":
+			if code, err := models.NormalizeCurrency(value); err == nil {
+				currency = code
+			}
+		case "ACCTID":
+			account = value
+		default:
+			if txn != nil && value != "" && !strings.HasPrefix(tag, "/") {
+				txn[tag] = value
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// ofxRow converts the leaf values of a STMTTRN element into a row
+func ofxRow(line int, txn map[string]string, account, currency string) Row {
+	row := Row{Line: line, Status: StatusValid, Tags: []string{}, Currency: currency}
+
+	row.Info = joinInfo(txn["NAME"], txn["MEMO"])
+	if row.Info == "" {
+		row.addError("transaction has no NAME or MEMO")
+	}
+
+	if date, err := parseOFXDate(txn["DTPOSTED"]); err != nil {
+		row.addError(fmt.Sprintf("invalid DTPOSTED %q", txn["DTPOSTED"]))
+	} else {
+		row.DatePaid = date
+	}
+
+	separator := "."
+	if strings.Contains(txn["TRNAMT"], ",") && !strings.Contains(txn["TRNAMT"], ".") {
+		separator = ","
+	}
+	if amount, err := ParseAmount(txn["TRNAMT"], separator, currency); err != nil {
+		row.addError(fmt.Sprintf("invalid TRNAMT %q: %v", txn["TRNAMT"], err))
+	} else {
+		row.Amount = ApplySign(&row, amount, SignNegativeIsExpense)
+	}
+
+	row.ExternalID = externalID(account, txn["FITID"])
+	return row
+}
+
+// parseOFXDate parses the date part of an OFX datetime such as
+// 20240301120000.000[-5:EST]
+func parseOFXDate(s string) (time.Time, error) {
+	if len(s) < 8 {
+		return time.Time{}, errors.New("date too short")
+	}
+	return time.Parse("20060102", s[:8])
+}
+
+// joinInfo combines a payee name and a memo into a payment description
+func joinInfo(name, memo string) string {
+	name, memo = strings.TrimSpace(name), strings.TrimSpace(memo)
+	switch {
+	case name == "":
+		return memo
+	case memo == "" || strings.EqualFold(name, memo):
+		return name
+	}
+	return name + " - " + memo
+}
+
+// externalID scopes a bank transaction ID to its account, since banks only
+// guarantee uniqueness per account
+func externalID(account, id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return ""
+	}
+	if account = strings.TrimSpace(account); account != "" {
+		return account + ":" + id
+	}
+	return id
+}
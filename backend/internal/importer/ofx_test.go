@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOFX(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		currency string
+		want     []wantRow
+	}{
+		{
+			// SGML with unclosed leaf elements; CURDEF overrides the fallback
+			fixture:  "statement.ofx",
+			currency: "EUR",
+			want: []wantRow{
+				{1, "CITY POWER & LIGHT - Electricity March", 4215, "USD", "2024-03-02", nil, "000123456789:202403020001", StatusValid},
+				{2, "ACME PAYROLL", 250000, "USD", "2024-03-05", nil, "000123456789:202403050001", StatusSkipped},
+				{3, "CORNER COFFEE", 780, "USD", "2024-03-10", nil, "000123456789:202403100001", StatusValid},
+				{Line: 4, Status: StatusInvalid},
+			},
+		},
+		{
+			// XML credit card statement as exported by Quicken
+			fixture:  "statement.qfx",
+			currency: "USD",
+			want: []wantRow{
+				{1, "STREAMING SERVICE", 1999, "EUR", "2024-03-04", nil, "4111XXXXXXXX1111:CC-7781", StatusValid},
+				{2, "SUPERMARKET - Weekly groceries", 6430, "EUR", "2024-03-18", nil, "4111XXXXXXXX1111:CC-7802", StatusValid},
+				{3, "REFUND STREAMING SERVICE", 1999, "EUR", "2024-03-20", nil, "4111XXXXXXXX1111:CC-7810", StatusSkipped},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			rows, err := ParseOFX(openFixture(t, tt.fixture), tt.currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRows(t, rows, tt.want)
+		})
+	}
+}
+
+func TestParseOFXWithoutCurrency(t *testing.T) {
+	input := "<OFX><STMTTRN><DTPOSTED>20240102<TRNAMT>-3,20<FITID>1<MEMO>Parking</STMTTRN></OFX>"
+	rows, err := ParseOFX(strings.NewReader(input), "chf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, rows, []wantRow{{1, "Parking", 320, "CHF", "2024-01-02", nil, "1", StatusValid}})
+}
+
+func TestParseOFXErrors(t *testing.T) {
+	if _, err := ParseOFX(strings.NewReader("Date,Amount\n"), "EUR"); err == nil {
+		t.Error("ParseOFX accepted a file without an OFX element")
+	}
+	if _, err := ParseOFX(strings.NewReader("<OFX></OFX>"), "EURO"); err == nil {
+		t.Error("ParseOFX accepted an invalid fallback currency")
+	}
+}
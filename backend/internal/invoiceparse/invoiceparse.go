@@ -0,0 +1,499 @@
+// Package invoiceparse reads the likely vendor, total, currency and date
+// out of the text of an invoice or receipt, to pre-fill a payment the user
+// then confirms.
+package invoiceparse
+
+import (
+	"encoding/json"
+	"errors"
+	"expense_tracker/internal/importer"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rules configure how an invoice is read. Patterns are regular expressions
+// matched case-insensitively against each line of the text; fields left
+// empty keep the defaults.
+type Rules struct {
+	// TotalPatterns find the line holding the total, strongest first. The
+	// amount is the "amount" group if the pattern has one, else the last
+	// amount after the match on that line or, failing that, the first on
+	// the next line. Among the lines one pattern finds, the largest amount
+	// wins, so a gross total beats net and tax totals.
+	TotalPatterns []string `json:"total_patterns"`
+	// DatePatterns find the line holding the invoice date. The date is the
+	// "date" group or the first date after the match. Without a match the
+	// first date in the text is used.
+	DatePatterns []string `json:"date_patterns"`
+	// DateFormats are tried in order on each candidate date, as Go layouts
+	// or formats such as "DD.MM.YYYY"
+	DateFormats []string `json:"date_formats"`
+	// VendorPatterns find the vendor in their "vendor" group. Without a
+	// match the vendor is the first line near the top that looks like a
+	// name.
+	VendorPatterns []string `json:"vendor_patterns"`
+	// VendorSkipPatterns reject lines as the vendor name
+	VendorSkipPatterns []string `json:"vendor_skip_patterns"`
+	// CurrencySymbols maps symbols and codes found in the text to ISO 4217
+	// codes
+	CurrencySymbols map[string]string `json:"currency_symbols"`
+	// DecimalSeparator is "." or ","; empty guesses it for each amount
+	DecimalSeparator string `json:"decimal_separator"`
+}
+
+// DefaultRules read common English, German, French and Spanish invoices
+func DefaultRules() Rules {
+	return Rules{
+		TotalPatterns: []string{
+			`\b(?:grand\s+total|total\s+(?:due|to\s+pay|payable|incl\.?|including|gross)|amount\s+(?:due|payable|paid|charged)|balance\s+due|invoice\s+total|gesamtbetrag|gesamtsumme|rechnungsbetrag|endbetrag|bruttobetrag|zu\s+zahlen|total\s+ttc|montant\s+total|net\s+[àa]\s+payer|importe\s+total|total\s+a\s+pagar)\b`,
+			`\b(?:total|summe|gesamt|betrag|totale|importe)\b`,
+		},
+		DatePatterns: []string{
+			`\b(?:invoice\s+date|date\s+of\s+issue|issue\s+date|issued(?:\s+on)?|receipt\s+date|rechnungsdatum|belegdatum|date\s+de\s+facturation|date\s+d'[ée]mission|fecha\s+de\s+(?:emisi[oó]n|factura))\b`,
+			`\b(?:date|dated|datum|fecha)\b`,
+		},
+		DateFormats: []string{
+			"2006-01-02",
+			"2.1.2006",
+			"2/1/2006",
+			"1/2/2006",
+			"2-1-2006",
+			"2.1.06",
+			"2/1/06",
+			"2 January 2006",
+			"2. January 2006",
+			"January 2, 2006",
+			"January 2 2006",
+			"2 Jan 2006",
+			"Jan 2, 2006",
+			"Jan 2 2006",
+		},
+		VendorPatterns: []string{
+			`^\s*(?:from|vendor|seller|supplier|sold\s+by|merchant|issued\s+by|verk[äa]ufer|lieferant)\s*:\s*(?P<vendor>.+?)\s*$`,
+		},
+		VendorSkipPatterns: []string{
+			`\b(?:invoice|receipt|rechnung|quittung|beleg|facture|factura|tax|vat|page|seite|date|datum|number|tel|phone|fax|e-?mail|bill\s+to|ship\s+to|customer|kunde)\b|\b(?:no|nr)\.`,
+			`@|www\.|https?://`,
+		},
+		CurrencySymbols: map[string]string{
+			"€": "EUR", "EUR": "EUR", "$": "USD", "US$": "USD", "USD": "USD",
+			"£": "GBP", "GBP": "GBP", "¥": "JPY", "JPY": "JPY", "CHF": "CHF",
+			"Fr.": "CHF", "zł": "PLN", "PLN": "PLN", "Kč": "CZK", "CZK": "CZK",
+			"SEK": "SEK", "NOK": "NOK", "DKK": "DKK", "HUF": "HUF", "C$": "CAD",
+			"CAD": "CAD", "A$": "AUD", "AUD": "AUD", "NZD": "NZD", "₹": "INR",
+			"INR": "INR", "R$": "BRL", "BRL": "BRL", "MXN": "MXN", "CNY": "CNY",
+			"₩": "KRW", "KRW": "KRW",
+		},
+	}
+}
+
+// Merge returns the rules with the fields set in override replacing theirs
+func (r Rules) Merge(override Rules) Rules {
+	if len(override.TotalPatterns) > 0 {
+		r.TotalPatterns = override.TotalPatterns
+	}
+	if len(override.DatePatterns) > 0 {
+		r.DatePatterns = override.DatePatterns
+	}
+	if len(override.DateFormats) > 0 {
+		r.DateFormats = override.DateFormats
+	}
+	if len(override.VendorPatterns) > 0 {
+		r.VendorPatterns = override.VendorPatterns
+	}
+	if len(override.VendorSkipPatterns) > 0 {
+		r.VendorSkipPatterns = override.VendorSkipPatterns
+	}
+	if len(override.CurrencySymbols) > 0 {
+		r.CurrencySymbols = override.CurrencySymbols
+	}
+	if override.DecimalSeparator != "" {
+		r.DecimalSeparator = override.DecimalSeparator
+	}
+	return r
+}
+
+// LoadRules reads rules from a JSON file and merges them over the defaults
+func LoadRules(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rules{}, err
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return Rules{}, err
+	}
+	merged := DefaultRules().Merge(rules)
+	if _, err := New(merged); err != nil {
+		return Rules{}, err
+	}
+	return merged, nil
+}
+
+// Draft is what an invoice was read as. Fields that could not be found are
+// empty. Sources holds, for each field found, the line it was read from.
+type Draft struct {
+	Vendor   string
+	Amount   string // decimal with a "." separator
+	Currency string
+	Date     time.Time
+	Sources  map[string]string
+}
+
+// Parser reads invoices with compiled rules
+type Parser struct {
+	total, date, vendor, vendorSkip []*regexp.Regexp
+	dateLayouts                     []string
+	symbols                         []string
+	currencies                      map[string]string
+	decimalSeparator                string
+}
+
+var (
+	// amountPattern matches amounts such as 1234, 1.234,56, 1,234.56,
+	// 1'234.56 and 1 234,56
+	amountPattern = regexp.MustCompile(`\d{1,3}(?:[ \x{a0}\x{202f}]\d{3})+(?:[.,]\d{1,2})?\b|\d[\d.,']*\d|\d`)
+	// datePattern matches the shapes of date the default formats read
+	datePattern = regexp.MustCompile(`\d{4}-\d{1,2}-\d{1,2}|\d{1,2}[./-]\d{1,2}[./-]\d{2,4}|\d{1,2}\.?\s+\p{L}{3,9}\.?\s+\d{4}|\p{L}{3,9}\.?\s+\d{1,2},?\s+\d{4}`)
+)
+
+// New compiles rules into a parser
+func New(rules Rules) (*Parser, error) {
+	p := &Parser{currencies: rules.CurrencySymbols}
+	for _, set := range []struct {
+		field    string
+		patterns []string
+		into     *[]*regexp.Regexp
+	}{
+		{"total_patterns", rules.TotalPatterns, &p.total},
+		{"date_patterns", rules.DatePatterns, &p.date},
+		{"vendor_patterns", rules.VendorPatterns, &p.vendor},
+		{"vendor_skip_patterns", rules.VendorSkipPatterns, &p.vendorSkip},
+	} {
+		for _, pattern := range set.patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", set.field, err)
+			}
+			*set.into = append(*set.into, re)
+		}
+	}
+
+	for _, format := range rules.DateFormats {
+		p.dateLayouts = append(p.dateLayouts, importer.DateLayout(format))
+	}
+
+	switch rules.DecimalSeparator {
+	case "", ".", ",":
+		p.decimalSeparator = rules.DecimalSeparator
+	default:
+		return nil, errors.New(`decimal_separator must be "." or ","`)
+	}
+
+	// Longer symbols are looked for first so "US$" is not read as "$"
+	for symbol := range rules.CurrencySymbols {
+		p.symbols = append(p.symbols, symbol)
+	}
+	sort.Slice(p.symbols, func(i, j int) bool {
+		if len(p.symbols[i]) != len(p.symbols[j]) {
+			return len(p.symbols[i]) > len(p.symbols[j])
+		}
+		return p.symbols[i] < p.symbols[j]
+	})
+	return p, nil
+}
+
+// Parse reads an invoice's text
+func (p *Parser) Parse(text string) Draft {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	d := Draft{Sources: make(map[string]string)}
+	if vendor, line := p.findVendor(lines); vendor != "" {
+		d.Vendor = vendor
+		d.Sources["info"] = line
+	}
+	totalLine := ""
+	if amount, line := p.findTotal(lines); amount != "" {
+		d.Amount = amount
+		d.Sources["amount"] = line
+		totalLine = line
+	}
+	if currency, line := p.findCurrency(totalLine, lines); currency != "" {
+		d.Currency = currency
+		d.Sources["currency"] = line
+	}
+	if date, line := p.findDate(lines); !date.IsZero() {
+		d.Date = date
+		d.Sources["datePaid"] = line
+	}
+	return d
+}
+
+// findTotal returns the total amount and the line it was found on
+func (p *Parser) findTotal(lines []string) (string, string) {
+	for _, re := range p.total {
+		best, bestLine, bestValue := "", "", -1.0
+		for i, line := range lines {
+			loc := re.FindStringSubmatchIndex(line)
+			if loc == nil {
+				continue
+			}
+
+			var candidate string
+			if group := re.SubexpIndex("amount"); group > 0 && loc[2*group] >= 0 {
+				candidate = p.normalizeAmount(line[loc[2*group]:loc[2*group+1]])
+			} else {
+				amounts := amountPattern.FindAllString(line[loc[1]:], -1)
+				if len(amounts) > 0 {
+					candidate = p.normalizeAmount(amounts[len(amounts)-1])
+				} else if i+1 < len(lines) {
+					// Table layouts often put the amount on its own line
+					if next := amountPattern.FindString(lines[i+1]); next != "" {
+						candidate = p.normalizeAmount(next)
+						line += " " + lines[i+1]
+					}
+				}
+			}
+
+			if value, err := strconv.ParseFloat(candidate, 64); err == nil && value > bestValue {
+				best, bestLine, bestValue = candidate, line, value
+			}
+		}
+		if best != "" {
+			return best, bestLine
+		}
+	}
+	return "", ""
+}
+
+// normalizeAmount turns an amount as printed into a decimal with a "."
+// separator, or "" if it is not one. Without a configured separator, the
+// last of "." and "," is the decimal separator if both appear, and a single
+// one is if one or two digits follow it.
+func (p *Parser) normalizeAmount(s string) string {
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "", "'", "").Replace(s)
+
+	decimal := p.decimalSeparator
+	if decimal == "" {
+		lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+		switch {
+		case lastDot >= 0 && lastComma >= 0:
+			decimal = "."
+			if lastComma > lastDot {
+				decimal = ","
+			}
+		case lastDot >= 0 || lastComma >= 0:
+			sep := s[max(lastDot, lastComma)]
+			if strings.Count(s, string(sep)) == 1 && len(s)-max(lastDot, lastComma)-1 <= 2 {
+				decimal = string(sep)
+			}
+		}
+	}
+
+	whole, frac := s, ""
+	if decimal != "" {
+		if i := strings.LastIndex(s, decimal); i >= 0 {
+			whole, frac = s[:i], s[i+1:]
+		}
+	}
+	// Thousands separators must split the whole part into groups of three
+	groups := strings.FieldsFunc(whole, func(r rune) bool { return r == '.' || r == ',' })
+	for i, group := range groups {
+		if i > 0 && len(group) != 3 {
+			return ""
+		}
+	}
+	whole = strings.Join(groups, "")
+	if whole == "" || strings.ContainsAny(frac, ".,") || len(frac) > 4 {
+		return ""
+	}
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// findCurrency returns the currency on the total's line, or else the one
+// mentioned most often, with the line it was first found on
+func (p *Parser) findCurrency(totalLine string, lines []string) (string, string) {
+	if currency := p.currencyIn(totalLine); currency != "" {
+		return currency, totalLine
+	}
+
+	counts := make(map[string]int)
+	firstLine := make(map[string]string)
+	best := ""
+	for _, line := range lines {
+		for _, currency := range p.currenciesIn(line) {
+			counts[currency]++
+			if _, seen := firstLine[currency]; !seen {
+				firstLine[currency] = line
+			}
+			if best == "" || counts[currency] > counts[best] || (counts[currency] == counts[best] && currency < best) {
+				best = currency
+			}
+		}
+	}
+	return best, firstLine[best]
+}
+
+func (p *Parser) currencyIn(line string) string {
+	if found := p.currenciesIn(line); len(found) > 0 {
+		return found[0]
+	}
+	return ""
+}
+
+// currenciesIn returns the currency of every symbol or code in a line.
+// Letters and "$" must not be glued to a letter, so "USt" is not a code
+// and "US$" is not "$".
+func (p *Parser) currenciesIn(line string) []string {
+	var found []string
+	masked := []rune(line)
+	for _, symbol := range p.symbols {
+		target := []rune(symbol)
+		for i := 0; i+len(target) <= len(masked); i++ {
+			if string(masked[i:i+len(target)]) != symbol {
+				continue
+			}
+			if i > 0 && unicode.IsLetter(masked[i-1]) && isWordish(target[0]) {
+				continue
+			}
+			if end := i + len(target); end < len(masked) && unicode.IsLetter(masked[end]) && unicode.IsLetter(target[len(target)-1]) {
+				continue
+			}
+			found = append(found, p.currencies[symbol])
+			for j := i; j < i+len(target); j++ {
+				masked[j] = ' '
+			}
+		}
+	}
+	return found
+}
+
+func isWordish(r rune) bool {
+	return unicode.IsLetter(r) || r == '$'
+}
+
+// findDate returns the invoice date and the line it was found on
+func (p *Parser) findDate(lines []string) (time.Time, string) {
+	for _, re := range p.date {
+		for i, line := range lines {
+			loc := re.FindStringSubmatchIndex(line)
+			if loc == nil {
+				continue
+			}
+			if group := re.SubexpIndex("date"); group > 0 && loc[2*group] >= 0 {
+				if date, ok := p.parseDate(line[loc[2*group]:loc[2*group+1]]); ok {
+					return date, line
+				}
+				continue
+			}
+			if date, ok := p.firstDate(line[loc[1]:]); ok {
+				return date, line
+			}
+			if i+1 < len(lines) {
+				if date, ok := p.firstDate(lines[i+1]); ok {
+					return date, line + " " + lines[i+1]
+				}
+			}
+		}
+	}
+
+	for _, line := range lines {
+		if date, ok := p.firstDate(line); ok {
+			return date, line
+		}
+	}
+	return time.Time{}, ""
+}
+
+func (p *Parser) firstDate(s string) (time.Time, bool) {
+	for _, candidate := range datePattern.FindAllString(s, -1) {
+		if date, ok := p.parseDate(candidate); ok {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// monthDot matches the dot after an abbreviated month name, as in "Jan."
+var monthDot = regexp.MustCompile(`(\p{L})\.`)
+
+func (p *Parser) parseDate(s string) (time.Time, bool) {
+	s = strings.Join(strings.Fields(monthDot.ReplaceAllString(s, "$1")), " ")
+	for _, layout := range p.dateLayouts {
+		if date, err := time.Parse(layout, s); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// findVendor returns the vendor and the line it was found on
+func (p *Parser) findVendor(lines []string) (string, string) {
+	for _, re := range p.vendor {
+		for _, line := range lines {
+			m := re.FindStringSubmatch(line)
+			if group := re.SubexpIndex("vendor"); m != nil && group > 0 && m[group] != "" {
+				return truncate(m[group]), line
+			}
+		}
+	}
+
+	// The vendor's name usually heads the invoice
+	checked := 0
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if checked++; checked > 10 {
+			break
+		}
+		if p.looksLikeName(line) {
+			return truncate(line), line
+		}
+	}
+	return "", ""
+}
+
+// looksLikeName reports whether a line is mostly letters and not one of
+// the skipped kinds of line
+func (p *Parser) looksLikeName(line string) bool {
+	letters, digits := 0, 0
+	for _, r := range line {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+	if letters < 3 || digits > letters {
+		return false
+	}
+	for _, re := range p.vendorSkip {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// truncate shortens a vendor name to a sensible payment info length
+func truncate(s string) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) > 100 {
+		runes = runes[:100]
+	}
+	return string(runes)
+}
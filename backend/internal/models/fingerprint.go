@@ -0,0 +1,27 @@
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Fingerprint identifies payments that are probably the same expense: the
+// same normalised info, amount and currency. Dates are compared separately
+// within a window because banks and people record the same expense on
+// slightly different days.
+func Fingerprint(info string, amountMinor int64, currency string) string {
+	sum := sha1.Sum([]byte(NormalizeInfo(info) + "|" + strconv.FormatInt(amountMinor, 10) + "|" + currency))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeInfo lowercases info and reduces punctuation and runs of
+// whitespace to single spaces
+func NormalizeInfo(info string) string {
+	fields := strings.FieldsFunc(strings.ToLower(info), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 const (
 	// Collection names
@@ -16,16 +19,172 @@ type Tag struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// User is an account that can sign in to the API
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	IsAdmin   bool      `json:"isAdmin"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Workspace is a household or team owning its own payments, documents and
+// tags. Users see the data of the workspace their session has switched to.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name" binding:"required"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WorkspaceMember is a user together with their role in a workspace: owner,
+// editor, accountant or viewer
+type WorkspaceMember struct {
+	User
+	Role string `json:"role"`
+}
+
+// APIToken is a named, long-lived credential a user creates for scripts.
+// It acts as the user in one workspace, limited to its scopes.
+type APIToken struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name" binding:"required"`
+	Scopes      []string   `json:"scopes" binding:"required"`
+	WorkspaceID string     `json:"workspaceId"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// AuditEntry records one change to a payment, document or tag. Changes maps
+// each changed field to its before and after values.
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actorId"`
+	ActorName  string          `json:"actorName"`
+	EntityType string          `json:"entityType"`
+	EntityID   string          `json:"entityId"`
+	Action     string          `json:"action"`
+	Changes    json.RawMessage `json:"changes"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
 type Payment struct {
 	ID          string    `json:"id"`
 	Info        string    `json:"info" binding:"required"`
-	Amount      float64   `json:"amount" binding:"required"`
+	Amount      Money     `json:"amount"`
+	Currency    string    `json:"currency"`
 	DatePaid    time.Time `json:"datePaid" binding:"required"`
 	FullyPaid   bool      `json:"fullyPaid"`
-	InvoicePath string    `json:"invoicePath,omitempty"`
+	PaidAmount  Money     `json:"paidAmount"`
+	Outstanding Money     `json:"outstanding"`
 	Tags        []string  `json:"tags"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
+
+	// The number of invoices, receipts and other files attached
+	AttachmentCount int `json:"attachmentCount"`
+
+	// Set on payments generated from a recurring schedule
+	RecurringPaymentID string     `json:"recurringPaymentId,omitempty"`
+	OccurrenceDate     *time.Time `json:"occurrenceDate,omitempty"`
+
+	// Set on payments flagged as a probable duplicate of another payment
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+
+	// The bank's transaction ID for imported payments
+	ExternalID string `json:"externalId,omitempty"`
+
+	// The payee, set by hand or resolved from the info
+	VendorID string `json:"vendorId,omitempty"`
+
+	// Set while the payment is in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// The owning workspace, implied by the caller's session
+	WorkspaceID string `json:"-"`
+}
+
+// SearchHit is a payment or document matching a search. Title and Snippet
+// are HTML with the matched words in <mark> tags.
+type SearchHit struct {
+	Type    string  `json:"type"`
+	ID      string  `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// Text extraction statuses of a stored file
+const (
+	TextPending     = "pending"
+	TextDone        = "done"
+	TextFailed      = "failed"
+	TextUnsupported = "unsupported"
+)
+
+// ExtractedText is the text pulled out of an uploaded file in the
+// background. Status is pending until extraction succeeds or runs out of
+// retries; Error holds the last failure.
+type ExtractedText struct {
+	Status      string     `json:"status"`
+	Text        string     `json:"text"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	ExtractedAt *time.Time `json:"extractedAt,omitempty"`
+}
+
+// InvoiceDraft is a payment read from an uploaded invoice, waiting to be
+// confirmed. Its fields match the payment request body; those that could
+// not be read are empty. Sources holds, for each field that was read, the
+// line of the invoice it came from.
+type InvoiceDraft struct {
+	ID           string            `json:"id"`
+	Info         string            `json:"info"`
+	Amount       string            `json:"amount"`
+	Currency     string            `json:"currency"`
+	DatePaid     string            `json:"datePaid"`
+	Sources      map[string]string `json:"sources"`
+	OriginalName string            `json:"originalName"`
+	FileSize     int64             `json:"fileSize"`
+	ContentType  string            `json:"contentType"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	ExpiresAt    time.Time         `json:"expiresAt"`
+	FilePath     string            `json:"-"`
+	FileHash     string            `json:"-"`
+}
+
+// Kinds of payment attachment
+const (
+	AttachmentInvoice = "invoice"
+	AttachmentReceipt = "receipt"
+	AttachmentOther   = "other"
+)
+
+// PaymentAttachment is a file attached to a payment, such as its invoice,
+// a receipt or a delivery note
+type PaymentAttachment struct {
+	ID           string    `json:"id"`
+	PaymentID    string    `json:"paymentId"`
+	Kind         string    `json:"kind"`
+	FilePath     string    `json:"filePath"`
+	FileHash     string    `json:"fileHash"`
+	OriginalName string    `json:"originalName"`
+	FileSize     int64     `json:"fileSize"`
+	ContentType  string    `json:"contentType"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// PaymentTransaction is a single instalment paid towards a payment, in the
+// payment's currency
+type PaymentTransaction struct {
+	ID        string    `json:"id"`
+	PaymentID string    `json:"paymentId"`
+	Amount    Money     `json:"amount"`
+	DatePaid  time.Time `json:"datePaid"`
+	Method    string    `json:"method"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 type Document struct {
@@ -33,17 +192,175 @@ type Document struct {
 	Title        string    `form:"title" json:"title" binding:"required"`
 	Description  string    `form:"description" json:"description"`
 	FilePath     string    `json:"file_path"`
+	FileHash     string    `json:"file_hash"`
+	ContentType  string    `json:"content_type"`
 	OriginalName string    `json:"original_name"`
 	FileSize     int64     `json:"file_size"`
 	Tags         []string  `form:"tags" json:"tags"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Set while the document is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// RecurringPayment is a template from which payments are generated on every
+// occurrence of Rule. Info may contain {date}, {month} and {year}
+// placeholders that are filled in from the occurrence date.
+type RecurringPayment struct {
+	ID             string     `json:"id"`
+	Info           string     `json:"info"`
+	Amount         Money      `json:"amount"`
+	Currency       string     `json:"currency"`
+	Rule           string     `json:"rule"`
+	StartDate      time.Time  `json:"startDate"`
+	EndDate        *time.Time `json:"endDate,omitempty"`
+	Active         bool       `json:"active"`
+	Tags           []string   `json:"tags"`
+	GeneratedUntil *time.Time `json:"generatedUntil,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+
+	// The owning workspace, implied by the caller's session
+	WorkspaceID string `json:"-"`
+}
+
+// Occurrence statuses of a recurring payment
+const (
+	OccurrenceScheduled  = "scheduled"
+	OccurrenceSkipped    = "skipped"
+	OccurrenceOverridden = "overridden"
+	OccurrenceGenerated  = "generated"
+)
+
+// RecurringOccurrence is a single date of a recurring payment with any
+// skip or override applied
+type RecurringOccurrence struct {
+	Date      time.Time `json:"date"`
+	Status    string    `json:"status"`
+	Info      string    `json:"info"`
+	Amount    Money     `json:"amount"`
+	DatePaid  time.Time `json:"datePaid"`
+	PaymentID string    `json:"paymentId,omitempty"`
+}
+
+// TagRule tags payments matching its conditions as they are created,
+// updated or imported. A workspace's active rules run in Position order,
+// each seeing the changes made by the ones before it.
+type TagRule struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Position   int               `json:"position"`
+	Active     bool              `json:"active"`
+	Conditions TagRuleConditions `json:"conditions"`
+	Actions    TagRuleActions    `json:"actions"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+// TagRuleConditions must all hold for a rule to match. Empty ones are
+// ignored, so a rule without conditions matches every payment.
+type TagRuleConditions struct {
+	// Case-insensitive substring of, and regular expression matching, the info
+	InfoContains string `json:"infoContains,omitempty"`
+	InfoPattern  string `json:"infoPattern,omitempty"`
+	// Inclusive amount range, compared in the payment's own currency
+	MinAmount Decimal `json:"minAmount,omitempty"`
+	MaxAmount Decimal `json:"maxAmount,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
+	// Lower-case English names of the days the payment may be dated on
+	Weekdays []string `json:"weekdays,omitempty"`
+	// Tags the payment must all have, and must have none of
+	HasTags   []string `json:"hasTags,omitempty"`
+	LacksTags []string `json:"lacksTags,omitempty"`
+}
+
+// TagRuleActions are applied to payments a rule matches
+type TagRuleActions struct {
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+	// Marks the payment as fully paid or keeps it from being settled
+	FullyPaid *bool `json:"fullyPaid,omitempty"`
 }
 
-type FileInfo struct {
-	FileName     string `json:"file_name"`
-	FilePath     string `json:"file_path"`
-	FileSize     int64  `json:"file_size"`
-	ContentType  string `json:"content_type"`
-	OriginalName string `json:"original_name"`
+// Vendor is a payee. Payments are linked to it when their info contains
+// its name or one of its aliases as whole words, ignoring case and
+// punctuation, so "AMZN Mktp DE" and "Amazon.com" can both be Amazon.
+type Vendor struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// VendorStats summarises the payments to a vendor, with amounts converted
+// into Currency
+type VendorStats struct {
+	Vendor          Vendor         `json:"vendor"`
+	Currency        string         `json:"currency"`
+	PaymentCount    int            `json:"paymentCount"`
+	TotalSpend      Money          `json:"totalSpend"`
+	AverageTicket   Money          `json:"averageTicket"`
+	LastPaymentDate *time.Time     `json:"lastPaymentDate"`
+	SpendOverTime   []VendorPeriod `json:"spendOverTime,omitempty"`
+	Unconverted     Totals         `json:"unconverted"`
+}
+
+// VendorPeriod is the spending at a vendor in one month or year
+type VendorPeriod struct {
+	Period string `json:"period"`
+	Amount Money  `json:"amount"`
+	Count  int    `json:"count"`
+}
+
+// Budget periods
+const (
+	PeriodMonthly   = "monthly"
+	PeriodQuarterly = "quarterly"
+	PeriodYearly    = "yearly"
+)
+
+// Budget limits spending on payments carrying any of its tags. With
+// Rollover, the unspent (or overspent) amount of each period since
+// StartDate is carried into the next one.
+type Budget struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Amount    Money     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Period    string    `json:"period"`
+	Rollover  bool      `json:"rollover"`
+	StartDate time.Time `json:"startDate"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BudgetStatus compares a budget with actual spending for one period
+type BudgetStatus struct {
+	Budget        Budget    `json:"budget"`
+	PeriodStart   time.Time `json:"periodStart"`
+	PeriodEnd     time.Time `json:"periodEnd"`
+	Budgeted      Money     `json:"budgeted"`
+	CarriedOver   Money     `json:"carriedOver"`
+	Available     Money     `json:"available"`
+	Actual        Money     `json:"actual"`
+	Remaining     Money     `json:"remaining"`
+	Projected     Money     `json:"projected"`
+	OverBudget    bool      `json:"overBudget"`
+	ProjectedOver bool      `json:"projectedOver"`
+	PaymentCount  int       `json:"paymentCount"`
+	Unconverted   Totals    `json:"unconverted"`
+}
+
+// ExchangeRate converts one unit of From into Rate units of To, effective
+// from Date until the next rate for the same pair.
+type ExchangeRate struct {
+	ID        string    `json:"id"`
+	Date      time.Time `json:"date"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Rate      string    `json:"rate"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for payments created without an explicit currency
+// and for rows converted from the old REAL amount column.
+var DefaultCurrency = "USD"
+
+// BaseCurrency is the currency analytics and stats are reported in when the
+// request does not ask for a different one. Empty means DefaultCurrency.
+var BaseCurrency = ""
+
+// currencyExponents lists ISO 4217 currencies whose minor unit is not 1/100
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+	"XAF": 0, "XOF": 0,
+}
+
+var (
+	ErrInvalidCurrency = errors.New("currency must be a three-letter ISO 4217 code")
+	ErrInvalidAmount   = errors.New("amount must be a decimal number")
+	ErrTooManyDecimals = errors.New("amount has more decimal places than the currency allows")
+)
+
+// CurrencyExponent returns the number of decimal places of a currency's minor unit
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// NormalizeCurrency upper-cases and validates an ISO 4217 code. An empty
+// code resolves to DefaultCurrency.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	if len(code) != 3 {
+		return "", ErrInvalidCurrency
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrInvalidCurrency
+		}
+	}
+	return code, nil
+}
+
+// Money is an amount stored as integer minor units (e.g. cents) of a currency
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// NewMoney builds a Money value from minor units
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "1234.50" into minor units of
+// the given currency without going through floating point.
+func ParseMoney(s, currency string) (Money, error) {
+	exp := CurrencyExponent(currency)
+	s = strings.TrimSpace(s)
+
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" && (!hasPoint || frac == "") {
+		return Money{}, ErrInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	// Extra trailing zeros are harmless; anything else would lose precision
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Money{}, ErrTooManyDecimals
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// String formats the amount as a plain decimal string, e.g. "-12.50"
+func (m Money) String() string {
+	exp := CurrencyExponent(m.Currency)
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// Add returns the sum of two amounts in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+	return Money{Minor: m.Minor + other.Minor, Currency: m.Currency}, nil
+}
+
+// Convert converts the amount into another currency at the given rate,
+// rounding half away from zero to the target currency's minor unit.
+func (m Money) Convert(rate *big.Rat, currency string) Money {
+	// Adjust for currencies with a different number of decimal places
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Minor), rate)
+	shift := CurrencyExponent(currency) - CurrencyExponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		value.Mul(value, scale)
+	} else {
+		value.Quo(value, scale)
+	}
+
+	num, den := value.Num(), value.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+
+	return Money{Minor: quo.Int64(), Currency: currency}
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// Totals accumulates amounts per currency
+type Totals map[string]Money
+
+// Add adds minor units to the running total for a currency
+func (t Totals) Add(currency string, minor int64) {
+	current := t[currency]
+	t[currency] = Money{Minor: current.Minor + minor, Currency: currency}
+}
+
+// Decimal is a decimal amount from a request body. It accepts both JSON
+// strings ("12.34") and JSON numbers (12.34) and keeps the literal text so
+// it can be converted to Money without rounding.
+type Decimal string
+
+// UnmarshalJSON accepts a quoted or bare decimal literal
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*d = Decimal(strings.TrimSpace(s))
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return ErrInvalidAmount
+	}
+	*d = Decimal(n.String())
+	return nil
+}
+
+// Money converts the literal into minor units of the given currency
+func (d Decimal) Money(currency string) (Money, error) {
+	s := string(d)
+	// Bare JSON numbers may use exponent notation
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Money{}, ErrInvalidAmount
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return ParseMoney(s, currency)
+}
+
+// ParseRate parses a positive decimal exchange rate such as "132.4517"
+func ParseRate(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsAny(s, "/eE") {
+		return nil, errors.New("rate must be a decimal number")
+	}
+	if rate.Sign() <= 0 {
+		return nil, errors.New("rate must be greater than zero")
+	}
+	return rate, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
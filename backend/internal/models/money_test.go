@@ -0,0 +1,168 @@
+package models
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"1234.50", "EUR", 123450, nil},
+		{" 12 ", "EUR", 1200, nil},
+		{".5", "EUR", 50, nil},
+		{"5.", "EUR", 500, nil},
+		{"-0.10", "EUR", -10, nil},
+		{"+3", "EUR", 300, nil},
+		// Trailing zeros beyond the minor unit don't lose precision
+		{"1.230", "EUR", 123, nil},
+		{"1.000", "JPY", 1, nil},
+		{"1.001", "KWD", 1001, nil},
+		{"1.235", "EUR", 0, ErrTooManyDecimals},
+		{"1.5", "JPY", 0, ErrTooManyDecimals},
+		{"", "EUR", 0, ErrInvalidAmount},
+		{".", "EUR", 0, ErrInvalidAmount},
+		{"-", "EUR", 0, ErrInvalidAmount},
+		{"1,00", "EUR", 0, ErrInvalidAmount},
+		{"1/3", "EUR", 0, ErrInvalidAmount},
+		{"1e5", "EUR", 0, ErrInvalidAmount},
+		{"99999999999999999999", "EUR", 0, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.currency)
+		if err != tt.err {
+			t.Errorf("ParseMoney(%q, %s) error = %v, want %v", tt.in, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && (got.Minor != tt.want || got.Currency != tt.currency) {
+			t.Errorf("ParseMoney(%q, %s) = %d %s, want %d", tt.in, tt.currency, got.Minor, got.Currency, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(123450, "EUR"), "1234.50"},
+		{NewMoney(5, "EUR"), "0.05"},
+		{NewMoney(-5, "EUR"), "-0.05"},
+		{NewMoney(0, "EUR"), "0.00"},
+		{NewMoney(1500, "JPY"), "1500"},
+		{NewMoney(1, "KWD"), "0.001"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+		// Formatting and parsing must round-trip
+		if back, err := ParseMoney(tt.money.String(), tt.money.Currency); err != nil || back != tt.money {
+			t.Errorf("ParseMoney(%q) = %+v, %v, want %+v", tt.money.String(), back, err, tt.money)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		money    Money
+		rate     string
+		currency string
+		want     int64
+	}{
+		// Halves round away from zero
+		{NewMoney(1001, "EUR"), "0.5", "USD", 501},
+		{NewMoney(-1001, "EUR"), "0.5", "USD", -501},
+		{NewMoney(1, "EUR"), "0.5", "USD", 1},
+		{NewMoney(1, "EUR"), "0.49", "USD", 0},
+		{NewMoney(-1, "EUR"), "0.49", "USD", 0},
+		// Currencies with fewer or more decimal places
+		{NewMoney(1234, "USD"), "150", "JPY", 1851},
+		{NewMoney(1850, "JPY"), "0.00615", "EUR", 1138},
+		{NewMoney(1000, "USD"), "0.3", "KWD", 3000},
+		{NewMoney(1005, "KWD"), "1", "EUR", 101},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := tt.money.Convert(rate, tt.currency)
+		if got.Minor != tt.want || got.Currency != tt.currency {
+			t.Errorf("%+v.Convert(%s, %s) = %+v, want %d", tt.money, tt.rate, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		json     string
+		currency string
+		want     int64
+		err      error
+	}{
+		{`"12.34"`, "EUR", 1234, nil},
+		{`" 12.30 "`, "EUR", 1230, nil},
+		{`12.34`, "EUR", 1234, nil},
+		// Bare numbers keep their literal text instead of going through float64
+		{`0.29`, "EUR", 29, nil},
+		{`1e2`, "EUR", 10000, nil},
+		{`1.5E-1`, "EUR", 15, nil},
+		{`1e-5`, "EUR", 0, ErrTooManyDecimals},
+		{`"12.345"`, "EUR", 0, ErrTooManyDecimals},
+		{`"abc"`, "EUR", 0, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		var d Decimal
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Errorf("Unmarshal(%s) = %v", tt.json, err)
+			continue
+		}
+		got, err := d.Money(tt.currency)
+		if err != tt.err {
+			t.Errorf("Decimal(%s).Money error = %v, want %v", tt.json, err, tt.err)
+			continue
+		}
+		if err == nil && got.Minor != tt.want {
+			t.Errorf("Decimal(%s).Money = %d, want %d", tt.json, got.Minor, tt.want)
+		}
+	}
+
+	var d Decimal = "1"
+	if err := json.Unmarshal([]byte(`null`), &d); err != nil || d != "" {
+		t.Errorf("Unmarshal(null) = %q, %v", d, err)
+	}
+	if err := json.Unmarshal([]byte(`true`), &d); err == nil {
+		t.Error("Unmarshal(true) succeeded")
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	if rate, err := ParseRate(" 132.4517 "); err != nil || rate.Cmp(big.NewRat(1324517, 10000)) != 0 {
+		t.Errorf("ParseRate = %v, %v", rate, err)
+	}
+	for _, s := range []string{"", "0", "-1.5", "1/3", "1e5", "abc"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q) succeeded", s)
+		}
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	if code, err := NormalizeCurrency(" eur "); err != nil || code != "EUR" {
+		t.Errorf("NormalizeCurrency = %q, %v", code, err)
+	}
+	if code, err := NormalizeCurrency(""); err != nil || code != DefaultCurrency {
+		t.Errorf("NormalizeCurrency(\"\") = %q, %v", code, err)
+	}
+	for _, code := range []string{"EURO", "E1R", "€"} {
+		if _, err := NormalizeCurrency(code); err != ErrInvalidCurrency {
+			t.Errorf("NormalizeCurrency(%q) = %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package recurring implements the subset of iCalendar RRULE recurrence
+// rules used by recurring payments.
+package recurring
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the RRULE FREQ part
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxIterations guards against runaway enumeration of a schedule
+const maxIterations = 100000
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// Rule is a parsed recurrence rule such as "FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=1".
+//
+// Supported parts are FREQ, INTERVAL, COUNT, BYDAY (weekly rules only) and
+// BYMONTHDAY (monthly rules only, -1 meaning the last day of the month).
+// Monthly and yearly occurrences that fall on a day the month doesn't have
+// are moved to the month's last day rather than skipped.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	ByDay      []time.Weekday
+	ByMonthDay int
+}
+
+// ParseRule parses an RRULE string. The "RRULE:" prefix is optional.
+func ParseRule(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(s)), "RRULE:")
+	if s == "" {
+		return rule, errors.New("rule is required")
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return rule, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		switch key {
+		case "FREQ":
+			rule.Freq = Frequency(value)
+			switch rule.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return rule, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid interval %q", value)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid count %q", value)
+			}
+			rule.Count = n
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					return rule, fmt.Errorf("invalid weekday %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(value)
+			if err != nil || n == 0 || n < -1 || n > 31 {
+				return rule, fmt.Errorf("invalid month day %q", value)
+			}
+			rule.ByMonthDay = n
+		case "UNTIL", "DTSTART":
+			return rule, fmt.Errorf("%s is not supported in the rule, use the start and end dates instead", key)
+		default:
+			return rule, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, errors.New("FREQ is required")
+	}
+	if len(rule.ByDay) > 0 && rule.Freq != Weekly {
+		return rule, errors.New("BYDAY is only supported for weekly rules")
+	}
+	if rule.ByMonthDay != 0 && rule.Freq != Monthly {
+		return rule, errors.New("BYMONTHDAY is only supported for monthly rules")
+	}
+
+	sort.Slice(rule.ByDay, func(i, j int) bool {
+		return mondayIndex(rule.ByDay[i]) < mondayIndex(rule.ByDay[j])
+	})
+	return rule, nil
+}
+
+// String formats the rule in canonical RRULE form
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = strings.ToUpper(wd.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.ByMonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.ByMonthDay))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Schedule is a rule anchored at a start date with an optional end date.
+// All dates are calendar days in UTC.
+type Schedule struct {
+	Rule  Rule
+	Start time.Time
+	End   *time.Time
+}
+
+// Between returns the occurrences after `after` up to and including `until`
+func (s Schedule) Between(after, until time.Time) []time.Time {
+	after, until = Day(after), Day(until)
+	var dates []time.Time
+	s.each(func(t time.Time) bool {
+		if t.After(until) {
+			return false
+		}
+		if t.After(after) {
+			dates = append(dates, t)
+		}
+		return true
+	})
+	return dates
+}
+
+// Next returns up to n occurrences on or after `from`
+func (s Schedule) Next(from time.Time, n int) []time.Time {
+	from = Day(from)
+	dates := make([]time.Time, 0, n)
+	if n <= 0 {
+		return dates
+	}
+	s.each(func(t time.Time) bool {
+		if !t.Before(from) {
+			dates = append(dates, t)
+		}
+		return len(dates) < n
+	})
+	return dates
+}
+
+// Includes reports whether date is one of the schedule's occurrences
+func (s Schedule) Includes(date time.Time) bool {
+	date = Day(date)
+	found := false
+	s.each(func(t time.Time) bool {
+		if t.Equal(date) {
+			found = true
+		}
+		return t.Before(date)
+	})
+	return found
+}
+
+// each calls fn for every occurrence in order until fn returns false or the
+// schedule ends
+func (s Schedule) each(fn func(time.Time) bool) {
+	start := Day(s.Start)
+	emitted := 0
+	emit := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if s.End != nil && t.After(Day(*s.End)) {
+			return false
+		}
+		if s.Rule.Count > 0 && emitted >= s.Rule.Count {
+			return false
+		}
+		emitted++
+		return fn(t)
+	}
+
+	interval := s.Rule.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	for k := 0; k < maxIterations; k++ {
+		switch s.Rule.Freq {
+		case Daily:
+			if !emit(start.AddDate(0, 0, k*interval)) {
+				return
+			}
+		case Weekly:
+			if len(s.Rule.ByDay) == 0 {
+				if !emit(start.AddDate(0, 0, 7*k*interval)) {
+					return
+				}
+				continue
+			}
+			weekStart := start.AddDate(0, 0, -mondayIndex(start.Weekday())+7*k*interval)
+			for _, wd := range s.Rule.ByDay {
+				if !emit(weekStart.AddDate(0, 0, mondayIndex(wd))) {
+					return
+				}
+			}
+		case Monthly:
+			day := start.Day()
+			if s.Rule.ByMonthDay != 0 {
+				day = s.Rule.ByMonthDay
+			}
+			if !emit(dateInMonth(start.Year(), start.Month()+time.Month(k*interval), day)) {
+				return
+			}
+		case Yearly:
+			if !emit(dateInMonth(start.Year()+k*interval, start.Month(), start.Day())) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Day truncates t to midnight UTC of its calendar day
+func Day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// dateInMonth returns the given day of a month, clamped to the month's last
+// day. A day of -1 also means the last day. Month overflow is normalised.
+func dateInMonth(year int, month time.Month, day int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	if day == -1 || day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// mondayIndex numbers weekdays from Monday = 0
+func mondayIndex(wd time.Weekday) int {
+	return (int(wd) + 6) % 7
+}
@@ -0,0 +1,193 @@
+package recurring
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func formatDates(dates []time.Time) string {
+	s := make([]string, len(dates))
+	for i, d := range dates {
+		s[i] = d.Format(time.DateOnly)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start string
+		end   string
+		from  string
+		n     int
+		want  string
+	}{
+		{
+			name: "monthly clamps to the last day of short months",
+			rule: "FREQ=MONTHLY", start: "2024-01-31", n: 5,
+			want: "2024-01-31 2024-02-29 2024-03-31 2024-04-30 2024-05-31",
+		},
+		{
+			name: "last day of the month",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=-1", start: "2023-01-15", n: 3,
+			want: "2023-01-31 2023-02-28 2023-03-31",
+		},
+		{
+			name: "month day later than the start",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=30", start: "2024-01-10", n: 3,
+			want: "2024-01-30 2024-02-29 2024-03-30",
+		},
+		{
+			name: "month day earlier than the start skips the first month",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=1", start: "2024-01-10", n: 2,
+			want: "2024-02-01 2024-03-01",
+		},
+		{
+			name: "quarterly clamping doesn't drift",
+			rule: "FREQ=MONTHLY;INTERVAL=3", start: "2024-11-30", n: 4,
+			want: "2024-11-30 2025-02-28 2025-05-30 2025-08-30",
+		},
+		{
+			name: "yearly on a leap day",
+			rule: "FREQ=YEARLY", start: "2024-02-29", n: 5,
+			want: "2024-02-29 2025-02-28 2026-02-28 2027-02-28 2028-02-29",
+		},
+		{
+			name: "weekly on several days",
+			rule: "FREQ=WEEKLY;BYDAY=FR,MO", start: "2024-01-03", n: 4,
+			want: "2024-01-05 2024-01-08 2024-01-12 2024-01-15",
+		},
+		{
+			name: "every other week",
+			rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", start: "2024-01-01", n: 3,
+			want: "2024-01-02 2024-01-16 2024-01-30",
+		},
+		{
+			name: "weekly without days repeats the start weekday",
+			rule: "FREQ=WEEKLY", start: "2024-02-26", n: 2,
+			want: "2024-02-26 2024-03-04",
+		},
+		{
+			name: "count is counted from the start",
+			rule: "FREQ=DAILY;COUNT=3", start: "2024-01-01", from: "2024-01-02", n: 10,
+			want: "2024-01-02 2024-01-03",
+		},
+		{
+			name: "end date is inclusive",
+			rule: "FREQ=DAILY;INTERVAL=2", start: "2024-01-01", end: "2024-01-05", n: 10,
+			want: "2024-01-01 2024-01-03 2024-01-05",
+		},
+		{
+			name: "no occurrences requested",
+			rule: "FREQ=DAILY", start: "2024-01-01", n: 0,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := Schedule{Rule: rule, Start: date(tt.start)}
+			if tt.end != "" {
+				end := date(tt.end)
+				s.End = &end
+			}
+			from := s.Start
+			if tt.from != "" {
+				from = date(tt.from)
+			}
+			if got := formatDates(s.Next(from, tt.n)); got != tt.want {
+				t.Errorf("Next = %s\n want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleBetween(t *testing.T) {
+	rule, _ := ParseRule("FREQ=MONTHLY")
+	s := Schedule{Rule: rule, Start: date("2024-01-31")}
+
+	// after is exclusive and until is inclusive
+	got := formatDates(s.Between(date("2024-01-31"), date("2024-04-30")))
+	if want := "2024-02-29 2024-03-31 2024-04-30"; got != want {
+		t.Errorf("Between = %s, want %s", got, want)
+	}
+
+	// Times of day are ignored
+	got = formatDates(s.Between(time.Date(2024, 2, 28, 23, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 1, 0, 0, 0, time.UTC)))
+	if want := "2024-02-29"; got != want {
+		t.Errorf("Between = %s, want %s", got, want)
+	}
+}
+
+func TestScheduleIncludes(t *testing.T) {
+	rule, _ := ParseRule("FREQ=MONTHLY;BYMONTHDAY=-1")
+	s := Schedule{Rule: rule, Start: date("2024-01-01")}
+	for day, want := range map[string]bool{
+		"2024-01-31": true,
+		"2024-02-29": true,
+		"2024-02-28": false,
+		"2023-12-31": false,
+		"2024-04-30": true,
+		"2024-05-01": false,
+	} {
+		if got := s.Includes(date(day)); got != want {
+			t.Errorf("Includes(%s) = %v, want %v", day, got, want)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;byday=fr,mo;interval=1", "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{"FREQ=MONTHLY;INTERVAL=2;COUNT=6;BYMONTHDAY=-1", "FREQ=MONTHLY;INTERVAL=2;COUNT=6;BYMONTHDAY=-1"},
+		{" RRULE:FREQ=YEARLY ", "FREQ=YEARLY"},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if err != nil {
+			t.Errorf("ParseRule(%q) = %v", tt.in, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseRule(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYDAY=MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=-2",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;UNTIL=20240101",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;COUNT",
+	} {
+		if _, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local stores files in a directory on disk
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Local storage rooted at dir, creating it if needed
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the file to a temporary name first so readers never see a
+// partial file
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get returns an *os.File, so callers can seek it to serve ranges
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	info, err := l.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	name, _ := l.path(key)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, info, ErrNotFound
+	}
+	return f, info, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Size: fi.Size(), ContentType: contentTypeFor(key), ModTime: fi.ModTime()}, nil
+}
+
+// SignedURL is unsupported; local files are served through the API
+func (l *Local) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
@@ -0,0 +1,68 @@
+// Package storage keeps uploaded files under slash-separated keys such as
+// "invoices/<uuid>.pdf", either on local disk or in an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for keys that have no file
+	ErrNotFound = errors.New("file not found")
+	// ErrSignedURLUnsupported is returned by backends that can only serve
+	// files through the API
+	ErrSignedURLUnsupported = errors.New("storage backend does not support signed URLs")
+	errInvalidKey           = errors.New("invalid storage key")
+)
+
+// Info describes a stored file
+type Info struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage stores and retrieves files by key
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing
+	// file
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the file stored under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	// Delete removes the file stored under key. Deleting a missing key is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// Stat describes the file stored under key
+	Stat(ctx context.Context, key string) (Info, error)
+	// SignedURL returns a URL that downloads the file without further
+	// authentication until expiry has passed
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Key joins a directory and file name into a storage key
+func Key(dir, name string) string {
+	return path.Join(dir, name)
+}
+
+// validKey rejects empty, absolute and parent-relative keys
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return errInvalidKey
+	}
+	return nil
+}
+
+// contentTypeFor guesses a key's content type from its extension
+func contentTypeFor(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,215 @@
+// Package tagrules matches payments against the rules a workspace defines
+// to tag them and mark them as paid automatically.
+package tagrules
+
+import (
+	"errors"
+	"expense_tracker/internal/models"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sunday": time.Sunday,
+}
+
+// ParseWeekday parses an English day name or its three-letter abbreviation,
+// in any case
+func ParseWeekday(s string) (time.Weekday, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if len(name) == 3 {
+		for full, day := range weekdayNames {
+			if strings.HasPrefix(full, name) {
+				return day, nil
+			}
+		}
+	}
+	if day, ok := weekdayNames[name]; ok {
+		return day, nil
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+// Rule is a tag rule compiled for matching
+type Rule struct {
+	ID string
+
+	infoContains string
+	infoPattern  *regexp.Regexp
+	minAmount    *big.Rat
+	maxAmount    *big.Rat
+	currency     string
+	weekdays     map[time.Weekday]bool
+	hasTags      []string
+	lacksTags    []string
+
+	addTags    []string
+	removeTags []string
+	fullyPaid  *bool
+}
+
+// SetsFullyPaid reports whether the rule marks payments as paid or unpaid
+func (r Rule) SetsFullyPaid() bool {
+	return r.fullyPaid != nil
+}
+
+// Compile validates a rule and prepares it for matching
+func Compile(r models.TagRule) (Rule, error) {
+	cond, act := r.Conditions, r.Actions
+	rule := Rule{
+		ID:           r.ID,
+		infoContains: strings.ToLower(cond.InfoContains),
+		currency:     strings.ToUpper(cond.Currency),
+		hasTags:      cond.HasTags,
+		lacksTags:    cond.LacksTags,
+		addTags:      act.AddTags,
+		removeTags:   act.RemoveTags,
+		fullyPaid:    act.FullyPaid,
+	}
+
+	if len(act.AddTags) == 0 && len(act.RemoveTags) == 0 && act.FullyPaid == nil {
+		return rule, errors.New("at least one action is required")
+	}
+	for _, add := range act.AddTags {
+		for _, remove := range act.RemoveTags {
+			if add == remove {
+				return rule, fmt.Errorf("tag %s is both added and removed", add)
+			}
+		}
+	}
+
+	if cond.InfoPattern != "" {
+		if _, err := regexp.Compile(cond.InfoPattern); err != nil {
+			return rule, fmt.Errorf("infoPattern: %w", err)
+		}
+		rule.infoPattern = regexp.MustCompile("(?i)" + cond.InfoPattern)
+	}
+
+	var err error
+	if rule.minAmount, err = parseAmount("minAmount", cond.MinAmount, rule.currency); err != nil {
+		return rule, err
+	}
+	if rule.maxAmount, err = parseAmount("maxAmount", cond.MaxAmount, rule.currency); err != nil {
+		return rule, err
+	}
+	if rule.minAmount != nil && rule.maxAmount != nil && rule.minAmount.Cmp(rule.maxAmount) > 0 {
+		return rule, errors.New("minAmount is greater than maxAmount")
+	}
+
+	if len(cond.Weekdays) > 0 {
+		rule.weekdays = make(map[time.Weekday]bool)
+		for _, name := range cond.Weekdays {
+			day, err := ParseWeekday(name)
+			if err != nil {
+				return rule, err
+			}
+			rule.weekdays[day] = true
+		}
+	}
+
+	return rule, nil
+}
+
+// parseAmount parses an optional amount bound as a plain decimal with at
+// most as many decimal places as the rule's currency, or two without one
+func parseAmount(field string, d models.Decimal, currency string) (*big.Rat, error) {
+	if d == "" {
+		return nil, nil
+	}
+	amount, err := models.ParseMoney(string(d), currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return amountRat(amount), nil
+}
+
+// Matches reports whether a payment meets all of the rule's conditions
+func (r Rule) Matches(p models.Payment) bool {
+	if r.infoContains != "" && !strings.Contains(strings.ToLower(p.Info), r.infoContains) {
+		return false
+	}
+	if r.infoPattern != nil && !r.infoPattern.MatchString(p.Info) {
+		return false
+	}
+	if r.currency != "" && p.Currency != r.currency {
+		return false
+	}
+	if r.minAmount != nil || r.maxAmount != nil {
+		amount := amountRat(p.Amount)
+		if r.minAmount != nil && amount.Cmp(r.minAmount) < 0 {
+			return false
+		}
+		if r.maxAmount != nil && amount.Cmp(r.maxAmount) > 0 {
+			return false
+		}
+	}
+	if r.weekdays != nil && !r.weekdays[p.DatePaid.Weekday()] {
+		return false
+	}
+	for _, tag := range r.hasTags {
+		if !contains(p.Tags, tag) {
+			return false
+		}
+	}
+	for _, tag := range r.lacksTags {
+		if contains(p.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply runs the rule's actions on a payment
+func (r Rule) Apply(p *models.Payment) {
+	for _, tag := range r.addTags {
+		if !contains(p.Tags, tag) {
+			// Never append into a slice the payment may share
+			p.Tags = append(p.Tags[:len(p.Tags):len(p.Tags)], tag)
+		}
+	}
+	if len(r.removeTags) > 0 {
+		kept := make([]string, 0, len(p.Tags))
+		for _, tag := range p.Tags {
+			if !contains(r.removeTags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+		p.Tags = kept
+	}
+	if r.fullyPaid != nil {
+		p.FullyPaid = *r.fullyPaid
+	}
+}
+
+// ApplyAll runs rules in order on a payment, each seeing the changes made
+// by the ones before it, and returns the IDs of the rules that matched
+func ApplyAll(rules []Rule, p *models.Payment) []string {
+	var matched []string
+	for _, r := range rules {
+		if r.Matches(*p) {
+			r.Apply(p)
+			matched = append(matched, r.ID)
+		}
+	}
+	return matched
+}
+
+// amountRat returns an amount in major units, e.g. 12.5 for 1250 cents
+func amountRat(m models.Money) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(models.CurrencyExponent(m.Currency))), nil)
+	return new(big.Rat).SetFrac(big.NewInt(m.Minor), scale)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package tagrules
+
+import (
+	"expense_tracker/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func payment(info string, minor int64, currency string, date string, tags ...string) models.Payment {
+	d, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		panic(err)
+	}
+	return models.Payment{Info: info, Amount: models.NewMoney(minor, currency), Currency: currency, DatePaid: d, Tags: tags}
+}
+
+func compile(t *testing.T, id string, cond models.TagRuleConditions, act models.TagRuleActions) Rule {
+	t.Helper()
+	rule, err := Compile(models.TagRule{ID: id, Conditions: cond, Actions: act})
+	if err != nil {
+		t.Fatalf("Compile(%s) = %v", id, err)
+	}
+	return rule
+}
+
+func TestMatches(t *testing.T) {
+	addTag := models.TagRuleActions{AddTags: []string{"x"}}
+	// 2024-03-01 is a Friday
+	p := payment("AMZN Mktp DE*1234", 1250, "EUR", "2024-03-01", "shopping", "online")
+
+	tests := []struct {
+		name string
+		cond models.TagRuleConditions
+		want bool
+	}{
+		{"no conditions", models.TagRuleConditions{}, true},
+		{"info contains ignores case", models.TagRuleConditions{InfoContains: "amzn mktp"}, true},
+		{"info contains", models.TagRuleConditions{InfoContains: "ebay"}, false},
+		{"info pattern ignores case", models.TagRuleConditions{InfoPattern: `^amzn .*\*\d+$`}, true},
+		{"info pattern", models.TagRuleConditions{InfoPattern: `^Amazon`}, false},
+		{"currency ignores case", models.TagRuleConditions{Currency: "eur"}, true},
+		{"currency", models.TagRuleConditions{Currency: "USD"}, false},
+		{"bounds are inclusive", models.TagRuleConditions{MinAmount: "12.50", MaxAmount: "12.5"}, true},
+		{"negative minimum", models.TagRuleConditions{MinAmount: "-1", MaxAmount: "+13"}, true},
+		{"below minimum", models.TagRuleConditions{MinAmount: "12.51"}, false},
+		{"above maximum", models.TagRuleConditions{MaxAmount: "12.49"}, false},
+		{"weekday", models.TagRuleConditions{Weekdays: []string{"mon", "Friday"}}, true},
+		{"other weekday", models.TagRuleConditions{Weekdays: []string{"saturday", "SUN"}}, false},
+		{"has tags", models.TagRuleConditions{HasTags: []string{"online", "shopping"}}, true},
+		{"has a missing tag", models.TagRuleConditions{HasTags: []string{"online", "travel"}}, false},
+		{"lacks tags", models.TagRuleConditions{LacksTags: []string{"travel"}}, true},
+		{"lacks a present tag", models.TagRuleConditions{LacksTags: []string{"travel", "online"}}, false},
+		{"all conditions", models.TagRuleConditions{InfoContains: "amzn", Currency: "EUR", MinAmount: "10", Weekdays: []string{"fri"}, HasTags: []string{"online"}}, true},
+		{"one condition fails", models.TagRuleConditions{InfoContains: "amzn", Currency: "EUR", MinAmount: "20"}, false},
+	}
+	for _, tt := range tests {
+		if got := compile(t, tt.name, tt.cond, addTag).Matches(p); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesAmountInMajorUnits(t *testing.T) {
+	rule := compile(t, "r", models.TagRuleConditions{MinAmount: "1000"}, models.TagRuleActions{AddTags: []string{"big"}})
+	tests := []struct {
+		p    models.Payment
+		want bool
+	}{
+		{payment("a", 100000, "EUR", "2024-03-01"), true},
+		{payment("a", 99999, "EUR", "2024-03-01"), false},
+		// Yen have no minor unit and dinars have three decimal places
+		{payment("a", 1000, "JPY", "2024-03-01"), true},
+		{payment("a", 999, "JPY", "2024-03-01"), false},
+		{payment("a", 1000000, "KWD", "2024-03-01"), true},
+		{payment("a", 999999, "KWD", "2024-03-01"), false},
+	}
+	for _, tt := range tests {
+		if got := rule.Matches(tt.p); got != tt.want {
+			t.Errorf("Matches(%d %s) = %v, want %v", tt.p.Amount.Minor, tt.p.Currency, got, tt.want)
+		}
+	}
+
+	// A currency condition allows as many decimal places as the currency has
+	rule = compile(t, "r", models.TagRuleConditions{MinAmount: "0.005", Currency: "kwd"}, models.TagRuleActions{AddTags: []string{"big"}})
+	if !rule.Matches(payment("a", 5, "KWD", "2024-03-01")) || rule.Matches(payment("a", 4, "KWD", "2024-03-01")) {
+		t.Error("KWD bound with three decimal places matched the wrong payments")
+	}
+}
+
+func TestApplyAll(t *testing.T) {
+	paid := true
+	rules := []Rule{
+		compile(t, "tag", models.TagRuleConditions{InfoContains: "netflix"}, models.TagRuleActions{AddTags: []string{"subscription", "streaming"}}),
+		// Sees the tag the first rule added
+		compile(t, "settle", models.TagRuleConditions{HasTags: []string{"subscription"}}, models.TagRuleActions{FullyPaid: &paid, RemoveTags: []string{"inbox"}}),
+		compile(t, "unmatched", models.TagRuleConditions{Currency: "USD"}, models.TagRuleActions{AddTags: []string{"usd"}}),
+	}
+
+	tags := make([]string, 2, 4)
+	copy(tags, []string{"inbox", "streaming"})
+	p := payment("NETFLIX.COM", 1299, "EUR", "2024-03-01")
+	p.Tags = tags
+
+	matched := ApplyAll(rules, &p)
+	if want := []string{"tag", "settle"}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("matched %v, want %v", matched, want)
+	}
+	if want := []string{"streaming", "subscription"}; !reflect.DeepEqual(p.Tags, want) {
+		t.Errorf("tags %v, want %v", p.Tags, want)
+	}
+	if !p.FullyPaid {
+		t.Error("payment was not marked fully paid")
+	}
+	// The caller's slice must not be written through
+	if tags[0] != "inbox" || tags[:3][2] != "" {
+		t.Errorf("ApplyAll modified the original tags: %v", tags[:3])
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	addTag := models.TagRuleActions{AddTags: []string{"x"}}
+	tests := []struct {
+		name string
+		cond models.TagRuleConditions
+		act  models.TagRuleActions
+	}{
+		{"no actions", models.TagRuleConditions{}, models.TagRuleActions{}},
+		{"tag added and removed", models.TagRuleConditions{}, models.TagRuleActions{AddTags: []string{"a"}, RemoveTags: []string{"a"}}},
+		{"invalid pattern", models.TagRuleConditions{InfoPattern: "("}, addTag},
+		{"invalid amount", models.TagRuleConditions{MinAmount: "ten"}, addTag},
+		{"fraction", models.TagRuleConditions{MinAmount: "1/3"}, addTag},
+		{"exponent", models.TagRuleConditions{MaxAmount: "1e5"}, addTag},
+		{"too many decimals", models.TagRuleConditions{MinAmount: "0.001"}, addTag},
+		{"too many decimals for the currency", models.TagRuleConditions{MinAmount: "1.5", Currency: "JPY"}, addTag},
+		{"min above max", models.TagRuleConditions{MinAmount: "10", MaxAmount: "9.99"}, addTag},
+		{"unknown weekday", models.TagRuleConditions{Weekdays: []string{"someday"}}, addTag},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(models.TagRule{Conditions: tt.cond, Actions: tt.act}); err == nil {
+			t.Errorf("%s: Compile succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	for s, want := range map[string]time.Weekday{
+		"monday": time.Monday, " Tue ": time.Tuesday, "WED": time.Wednesday,
+		"thu": time.Thursday, "Friday": time.Friday, "sat": time.Saturday, "SUNDAY": time.Sunday,
+	} {
+		if got, err := ParseWeekday(s); err != nil || got != want {
+			t.Errorf("ParseWeekday(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "mo", "mond", "funday"} {
+		if _, err := ParseWeekday(s); err == nil {
+			t.Errorf("ParseWeekday(%q) succeeded", s)
+		}
+	}
+}
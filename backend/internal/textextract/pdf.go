@@ -0,0 +1,1033 @@
+package textextract
+
+// This file reads just enough of the PDF format to recover the text shown
+// on each page: objects, including those packed into object streams,
+// Flate-compressed streams, the page tree, fonts' ToUnicode maps and the
+// text operators of content streams. Text in simple fonts without a
+// ToUnicode map is decoded as Windows-1252, which covers the usual Latin
+// encodings. Scanned PDFs have no text to recover.
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	errEncrypted         = errors.New("encrypted PDFs are not supported")
+	errNotPDF            = errors.New("file has no PDF objects")
+	errUnsupportedFilter = errors.New("unsupported stream filter")
+)
+
+const (
+	// maxStreamSize is the most decoded data read from one stream
+	maxStreamSize = 64 << 20
+	// maxDepth bounds nested objects, page trees and form XObjects
+	maxDepth = 32
+)
+
+var (
+	objectHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	encryptEntry = regexp.MustCompile(`/Encrypt\s*(\d+\s+\d+\s+R|<<)`)
+)
+
+// PDF values besides numbers (float64), strings ([]byte) and keywords,
+// which are operators, true, false, null and delimiters
+type (
+	name    string
+	keyword string
+	ref     int
+	dict    map[name]interface{}
+	array   []interface{}
+)
+
+type stream struct {
+	dict dict
+	raw  []byte
+}
+
+func extractPDF(data []byte) (string, error) {
+	if encryptEntry.Match(data) {
+		return "", errEncrypted
+	}
+	f := parsePDF(data)
+	if len(f.objects) == 0 {
+		return "", errNotPDF
+	}
+	return f.text(), nil
+}
+
+// pdfFile holds a PDF's objects by number
+type pdfFile struct {
+	objects map[int]interface{}
+	fonts   map[int]*font
+}
+
+// parsePDF finds every object in the file. Later definitions of an object
+// number, written by incremental updates, replace earlier ones.
+func parsePDF(data []byte) *pdfFile {
+	f := &pdfFile{objects: make(map[int]interface{}), fonts: make(map[int]*font)}
+
+	end := 0
+	for _, m := range objectHeader.FindAllSubmatchIndex(data, -1) {
+		// Skip matches inside the data of a stream already read
+		if m[0] < end {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		l := &lexer{data: data, pos: m[1]}
+		v, ok := l.object(0)
+		if !ok {
+			continue
+		}
+		if d, isDict := v.(dict); isDict {
+			start := l.pos
+			if tok, _ := l.token(); tok == keyword("stream") {
+				v = l.stream(d)
+			} else {
+				l.pos = start
+			}
+		}
+		f.objects[num] = v
+		end = l.pos
+	}
+
+	f.loadObjectStreams()
+	return f
+}
+
+// loadObjectStreams adds the objects packed into object streams
+func (f *pdfFile) loadObjectStreams() {
+	var containers []*stream
+	for _, v := range f.objects {
+		if s, ok := v.(*stream); ok && s.dict["Type"] == name("ObjStm") {
+			containers = append(containers, s)
+		}
+	}
+
+	for _, s := range containers {
+		data, err := f.decode(s)
+		if err != nil {
+			continue
+		}
+		count, _ := f.resolve(s.dict["N"]).(float64)
+		first, _ := f.resolve(s.dict["First"]).(float64)
+
+		// The stream starts with pairs of object numbers and offsets
+		l := &lexer{data: data}
+		type entry struct{ num, offset int }
+		var entries []entry
+		for i := 0; i < int(count); i++ {
+			num, ok1 := l.number()
+			offset, ok2 := l.number()
+			if !ok1 || !ok2 {
+				break
+			}
+			entries = append(entries, entry{int(num), int(offset)})
+		}
+
+		for _, e := range entries {
+			if _, exists := f.objects[e.num]; exists {
+				continue
+			}
+			pos := int(first) + e.offset
+			if pos < 0 || pos >= len(data) {
+				continue
+			}
+			l := &lexer{data: data, pos: pos}
+			if v, ok := l.object(0); ok {
+				f.objects[e.num] = v
+			}
+		}
+	}
+}
+
+// resolve follows references to the object they point at
+func (f *pdfFile) resolve(v interface{}) interface{} {
+	for i := 0; i < maxDepth; i++ {
+		r, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = f.objects[int(r)]
+	}
+	return nil
+}
+
+// dictOf returns the dictionary v is or refers to, including a stream's
+func (f *pdfFile) dictOf(v interface{}) dict {
+	switch v := f.resolve(v).(type) {
+	case dict:
+		return v
+	case *stream:
+		return v.dict
+	}
+	return nil
+}
+
+// decode returns the data of a stream with its filters undone
+func (f *pdfFile) decode(s *stream) ([]byte, error) {
+	var filters array
+	switch v := f.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = array{v}
+	case array:
+		filters = v
+	}
+
+	data := s.raw
+	for _, filter := range filters {
+		switch f.resolve(filter) {
+		case name("FlateDecode"), name("Fl"):
+			var err error
+			if data, err = inflate(data); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errUnsupportedFilter
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib or raw deflate data, keeping whatever a
+// truncated stream yields
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxStreamSize))
+	if len(out) > 0 {
+		return out, nil
+	}
+	return nil, err
+}
+
+// text returns the text of every page in order. Files whose page tree
+// cannot be followed fall back to reading every content stream.
+func (f *pdfFile) text() string {
+	w := &textWriter{}
+
+	nums := make([]int, 0, len(f.objects))
+	for num := range f.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	for _, num := range nums {
+		if d, ok := f.objects[num].(dict); ok && d["Type"] == name("Catalog") {
+			if pages := f.dictOf(d["Pages"]); pages != nil {
+				f.walkPages(pages, nil, w, map[int]bool{}, 0)
+			}
+			break
+		}
+	}
+
+	if len(w.buf) == 0 {
+		for _, num := range nums {
+			s, ok := f.objects[num].(*stream)
+			if !ok || s.dict["Type"] != nil || s.dict["Subtype"] != nil || s.dict["Length1"] != nil {
+				continue
+			}
+			if data, err := f.decode(s); err == nil {
+				f.showText(data, nil, w, 0)
+				w.newline()
+			}
+		}
+	}
+	return string(w.buf)
+}
+
+// walkPages shows the text of the pages under a node of the page tree.
+// Pages inherit resources from their ancestors.
+func (f *pdfFile) walkPages(node dict, resources dict, w *textWriter, visited map[int]bool, depth int) {
+	if depth > maxDepth || w.full() {
+		return
+	}
+	if r := f.dictOf(node["Resources"]); r != nil {
+		resources = r
+	}
+
+	kids, isTree := f.resolve(node["Kids"]).(array)
+	if !isTree {
+		f.showText(f.contents(node["Contents"]), resources, w, 0)
+		w.newline()
+		return
+	}
+	for _, kid := range kids {
+		if r, ok := kid.(ref); ok {
+			if visited[int(r)] {
+				continue
+			}
+			visited[int(r)] = true
+		}
+		if d := f.dictOf(kid); d != nil {
+			f.walkPages(d, resources, w, visited, depth+1)
+		}
+	}
+}
+
+// contents joins a page's content streams, which may split an operation
+// between them
+func (f *pdfFile) contents(v interface{}) []byte {
+	var parts [][]byte
+	switch v := f.resolve(v).(type) {
+	case *stream:
+		if data, err := f.decode(v); err == nil {
+			parts = append(parts, data)
+		}
+	case array:
+		for _, item := range v {
+			if s, ok := f.resolve(item).(*stream); ok {
+				if data, err := f.decode(s); err == nil {
+					parts = append(parts, data)
+				}
+			}
+		}
+	}
+	return bytes.Join(parts, []byte("\n"))
+}
+
+// showText runs the text operators of a content stream, writing the text
+// they show
+func (f *pdfFile) showText(content []byte, resources dict, w *textWriter, depth int) {
+	l := &lexer{data: content}
+	var operands []interface{}
+	var current *font
+	var lineY float64
+
+	for !w.full() {
+		v, ok := l.object(0)
+		if !ok {
+			return
+		}
+		op, isOp := v.(keyword)
+		if !isOp {
+			operands = append(operands, v)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if n, ok := operands[len(operands)-2].(name); ok {
+					current = f.font(resources, n)
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				w.write(current.decode(operands[len(operands)-1]))
+			}
+		case "'", "\"":
+			w.newline()
+			if len(operands) >= 1 {
+				w.write(current.decode(operands[len(operands)-1]))
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				items, _ := operands[len(operands)-1].(array)
+				for _, item := range items {
+					// Large negative adjustments, in thousandths of the font
+					// size, move right by about a space
+					if n, ok := item.(float64); ok && n < -150 {
+						w.space()
+					} else {
+						w.write(current.decode(item))
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, _ := operands[len(operands)-1].(float64); ty != 0 {
+					w.newline()
+				} else {
+					w.space()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				y, _ := operands[5].(float64)
+				if y != lineY {
+					w.newline()
+				} else {
+					w.space()
+				}
+				lineY = y
+			}
+		case "T*":
+			w.newline()
+		case "ET":
+			w.space()
+		case "ID":
+			l.skipInlineImage()
+		case "Do":
+			if len(operands) >= 1 && depth < maxDepth {
+				f.showForm(resources, operands[len(operands)-1], w, depth)
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// showForm shows the text of a form XObject drawn by the Do operator
+func (f *pdfFile) showForm(resources dict, operand interface{}, w *textWriter, depth int) {
+	n, ok := operand.(name)
+	if !ok {
+		return
+	}
+	xobjects := f.dictOf(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	s, ok := f.resolve(xobjects[n]).(*stream)
+	if !ok || s.dict["Subtype"] != name("Form") {
+		return
+	}
+	data, err := f.decode(s)
+	if err != nil {
+		return
+	}
+	if r := f.dictOf(s.dict["Resources"]); r != nil {
+		resources = r
+	}
+	f.showText(data, resources, w, depth+1)
+}
+
+// font returns the font a resource name refers to, or nil
+func (f *pdfFile) font(resources dict, n name) *font {
+	fonts := f.dictOf(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	v := fonts[n]
+	r, isRef := v.(ref)
+	if isRef {
+		if cached, ok := f.fonts[int(r)]; ok {
+			return cached
+		}
+	}
+	d := f.dictOf(v)
+	if d == nil {
+		return nil
+	}
+
+	ft := &font{composite: d["Subtype"] == name("Type0")}
+	if enc := f.dictOf(d["Encoding"]); enc != nil {
+		ft.differences = f.differences(enc)
+	}
+	if s, ok := f.resolve(d["ToUnicode"]).(*stream); ok {
+		if data, err := f.decode(s); err == nil {
+			ft.cmap = parseCMap(data)
+		}
+	}
+	if isRef {
+		f.fonts[int(r)] = ft
+	}
+	return ft
+}
+
+// differences reads the glyph names an encoding assigns to codes. Codes
+// whose names are not known keep their Windows-1252 meaning.
+func (f *pdfFile) differences(enc dict) map[byte]string {
+	entries, _ := f.resolve(enc["Differences"]).(array)
+	if len(entries) == 0 {
+		return nil
+	}
+	m := make(map[byte]string)
+	code := 0
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case float64:
+			code = int(v)
+		case name:
+			if text, ok := glyphText(string(v)); ok && code >= 0 && code < 256 {
+				m[byte(code)] = text
+			}
+			code++
+		}
+	}
+	return m
+}
+
+// glyphNames holds the text of common glyph names that are not a single
+// letter or digit
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$",
+	"percent": "%", "ampersand": "&", "quotesingle": "'", "quoteright": "’",
+	"quoteleft": "‘", "parenleft": "(", "parenright": ")", "asterisk": "*",
+	"plus": "+", "comma": ",", "hyphen": "-", "period": ".", "slash": "/",
+	"colon": ":", "semicolon": ";", "less": "<", "equal": "=", "greater": ">",
+	"question": "?", "at": "@", "bracketleft": "[", "backslash": "\\",
+	"bracketright": "]", "underscore": "_", "braceleft": "{", "bar": "|",
+	"braceright": "}", "endash": "–", "emdash": "—", "bullet": "•",
+	"quotedblleft": "“", "quotedblright": "”", "ellipsis": "…", "Euro": "€",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+}
+
+// glyphText returns the text of a glyph name: a known name, a single
+// letter, or uniXXXX
+func glyphText(glyph string) (string, bool) {
+	if text, ok := glyphNames[glyph]; ok {
+		return text, true
+	}
+	if len(glyph) == 1 {
+		return glyph, true
+	}
+	if len(glyph) == 7 && glyph[:3] == "uni" {
+		if v, err := strconv.ParseUint(glyph[3:], 16, 16); err == nil {
+			return string(rune(v)), true
+		}
+	}
+	return "", false
+}
+
+// font turns the codes of shown strings into text
+type font struct {
+	cmap        *cmap
+	differences map[byte]string
+	// composite fonts use multi-byte codes that mean nothing without a cmap
+	composite bool
+}
+
+func (ft *font) decode(v interface{}) string {
+	b, ok := v.([]byte)
+	if !ok {
+		return ""
+	}
+	switch {
+	case ft != nil && ft.cmap != nil:
+		return ft.cmap.decode(b)
+	case ft != nil && ft.composite:
+		return ""
+	case ft != nil && ft.differences != nil:
+		var out []byte
+		for _, c := range b {
+			if text, ok := ft.differences[c]; ok {
+				out = append(out, text...)
+			} else {
+				out = append(out, decodeWinAnsi([]byte{c})...)
+			}
+		}
+		return string(out)
+	}
+	return decodeWinAnsi(b)
+}
+
+// winAnsi holds the characters Windows-1252 places in 0x80-0x9F; the other
+// bytes match Latin-1
+var winAnsi = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡',
+	0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž', 0x91: '‘',
+	0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜',
+	0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+func decodeWinAnsi(b []byte) string {
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if r, ok := winAnsi[c]; ok {
+			runes = append(runes, r)
+		} else if c < 0x80 || c >= 0xA0 {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
+
+// cmap is a font's ToUnicode map from character codes to text
+type cmap struct {
+	ranges []codespace
+	chars  map[uint64]string
+	// width is the code length used when no codespace range matches
+	width int
+}
+
+type codespace struct {
+	lo, hi uint32
+	n      int
+}
+
+// cmapKey keys codes by length as well as value, since <41> and <0041>
+// are different codes
+func cmapKey(n int, code uint32) uint64 {
+	return uint64(n)<<32 | uint64(code)
+}
+
+func codeValue(b []byte) uint32 {
+	var code uint32
+	for _, c := range b {
+		code = code<<8 | uint32(c)
+	}
+	return code
+}
+
+// parseCMap reads the codespace ranges and bfchar and bfrange mappings of a
+// ToUnicode CMap
+func parseCMap(data []byte) *cmap {
+	m := &cmap{chars: make(map[uint64]string)}
+	l := &lexer{data: data}
+
+	// nextBytes reads the next string of a mapping block, or reports its end
+	nextBytes := func() ([]byte, bool) {
+		v, ok := l.object(0)
+		b, isBytes := v.([]byte)
+		return b, ok && isBytes && len(b) > 0 && len(b) <= 4
+	}
+
+	for {
+		tok, ok := l.token()
+		if !ok {
+			break
+		}
+		switch tok {
+		case keyword("begincodespacerange"):
+			for {
+				lo, ok1 := nextBytes()
+				hi, ok2 := nextBytes()
+				if !ok1 || !ok2 {
+					break
+				}
+				m.ranges = append(m.ranges, codespace{codeValue(lo), codeValue(hi), len(lo)})
+			}
+		case keyword("beginbfchar"):
+			for {
+				src, ok := nextBytes()
+				if !ok {
+					break
+				}
+				dst, _ := l.object(0)
+				if b, ok := dst.([]byte); ok {
+					m.chars[cmapKey(len(src), codeValue(src))] = decodeUTF16(b)
+				}
+				if m.width == 0 {
+					m.width = len(src)
+				}
+			}
+		case keyword("beginbfrange"):
+			for {
+				lo, ok1 := nextBytes()
+				hi, ok2 := nextBytes()
+				if !ok1 || !ok2 {
+					break
+				}
+				dst, _ := l.object(0)
+				m.addRange(len(lo), codeValue(lo), codeValue(hi), dst)
+				if m.width == 0 {
+					m.width = len(lo)
+				}
+			}
+		}
+	}
+
+	sort.Slice(m.ranges, func(i, j int) bool { return m.ranges[i].n < m.ranges[j].n })
+	if m.width == 0 {
+		m.width = 1
+	}
+	return m
+}
+
+// addRange maps a bfrange: either consecutive codes to consecutive text,
+// or each code to an entry of an array
+func (m *cmap) addRange(n int, lo, hi uint32, dst interface{}) {
+	if hi < lo || hi-lo > 0xFFFF {
+		return
+	}
+	switch dst := dst.(type) {
+	case []byte:
+		units := toUTF16(dst)
+		if len(units) == 0 {
+			return
+		}
+		for code := lo; code <= hi; code++ {
+			shifted := append([]uint16(nil), units...)
+			shifted[len(shifted)-1] += uint16(code - lo)
+			m.chars[cmapKey(n, code)] = string(utf16.Decode(shifted))
+		}
+	case array:
+		for i, item := range dst {
+			if b, ok := item.([]byte); ok && lo+uint32(i) <= hi {
+				m.chars[cmapKey(n, lo+uint32(i))] = decodeUTF16(b)
+			}
+		}
+	}
+}
+
+func (m *cmap) decode(b []byte) string {
+	var out []byte
+	for i := 0; i < len(b); {
+		n := m.codeLength(b[i:])
+		if s, ok := m.chars[cmapKey(n, codeValue(b[i:i+n]))]; ok {
+			out = append(out, s...)
+		}
+		i += n
+	}
+	return string(out)
+}
+
+// codeLength returns the length of the code at the start of b: the
+// shortest codespace range it falls in
+func (m *cmap) codeLength(b []byte) int {
+	for _, r := range m.ranges {
+		if r.n <= len(b) {
+			if code := codeValue(b[:r.n]); code >= r.lo && code <= r.hi {
+				return r.n
+			}
+		}
+	}
+	if m.width < len(b) {
+		return m.width
+	}
+	return len(b)
+}
+
+func toUTF16(b []byte) []uint16 {
+	units := make([]uint16, 0, (len(b)+1)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return units
+}
+
+func decodeUTF16(b []byte) string {
+	return string(utf16.Decode(toUTF16(b)))
+}
+
+// textWriter collects shown text, collapsing the spaces and line breaks
+// that text positioning implies
+type textWriter struct {
+	buf []byte
+}
+
+func (w *textWriter) full() bool {
+	return len(w.buf) >= MaxText
+}
+
+func (w *textWriter) write(s string) {
+	for _, r := range s {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			w.space()
+		case unicode.IsControl(r) || r == utf8.RuneError:
+		default:
+			w.buf = utf8.AppendRune(w.buf, r)
+		}
+	}
+}
+
+func (w *textWriter) space() {
+	if n := len(w.buf); n > 0 && w.buf[n-1] != ' ' && w.buf[n-1] != '\n' {
+		w.buf = append(w.buf, ' ')
+	}
+}
+
+func (w *textWriter) newline() {
+	n := len(w.buf)
+	switch {
+	case n == 0 || w.buf[n-1] == '\n':
+	case w.buf[n-1] == ' ':
+		w.buf[n-1] = '\n'
+	default:
+		w.buf = append(w.buf, '\n')
+	}
+}
+
+// lexer reads PDF tokens and objects
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelimiter(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+// skipSpace skips whitespace and comments
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case isSpace(c):
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+// token reads a number, name, string or keyword
+func (l *lexer) token() (interface{}, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, false
+	}
+
+	c := l.data[l.pos]
+	switch c {
+	case '(':
+		return l.literalString(), true
+	case '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return keyword("<<"), true
+		}
+		return l.hexString(), true
+	case '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return keyword(">>"), true
+		}
+		l.pos++
+		return keyword(">"), true
+	case '[', ']', '{', '}', ')':
+		l.pos++
+		return keyword(string(c)), true
+	case '/':
+		l.pos++
+		return name(decodeName(l.word())), true
+	}
+
+	word := l.word()
+	if isNumber(word) {
+		if n, err := strconv.ParseFloat(string(word), 64); err == nil {
+			return n, true
+		}
+	}
+	return keyword(word), true
+}
+
+// word reads up to the next whitespace or delimiter
+func (l *lexer) word() []byte {
+	start := l.pos
+	for l.pos < len(l.data) && !isSpace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return l.data[start:l.pos]
+}
+
+func isNumber(word []byte) bool {
+	if len(word) == 0 {
+		return false
+	}
+	for _, c := range word {
+		if (c < '0' || c > '9') && c != '.' && c != '-' && c != '+' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeName undoes #xx escapes in a name
+func decodeName(b []byte) string {
+	if bytes.IndexByte(b, '#') < 0 {
+		return string(b)
+	}
+	var out []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			if v, err := strconv.ParseUint(string(b[i+1:i+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+func (l *lexer) literalString() []byte {
+	l.pos++
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return out
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// A backslash at the end of a line continues the string
+				if e == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := int(e - '0')
+				for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+					v = v*8 + int(l.data[l.pos]-'0')
+					l.pos++
+				}
+				c = byte(v)
+			default:
+				c = e
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (l *lexer) hexString() []byte {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; unicode.Is(unicode.ASCII_Hex_Digit, rune(c)) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return out
+}
+
+// number reads a token that must be a number
+func (l *lexer) number() (float64, bool) {
+	tok, ok := l.token()
+	n, isNumber := tok.(float64)
+	return n, ok && isNumber
+}
+
+// object reads a complete object: an array, dictionary or reference, a
+// single token, or a keyword such as an operator or closing delimiter
+func (l *lexer) object(depth int) (interface{}, bool) {
+	if depth > maxDepth {
+		return nil, false
+	}
+	tok, ok := l.token()
+	if !ok {
+		return nil, false
+	}
+
+	switch tok {
+	case keyword("["):
+		var a array
+		for {
+			v, ok := l.object(depth + 1)
+			if !ok || v == keyword("]") {
+				return a, true
+			}
+			a = append(a, v)
+		}
+	case keyword("<<"):
+		d := make(dict)
+		for {
+			k, ok := l.object(depth + 1)
+			if !ok || k == keyword(">>") {
+				return d, true
+			}
+			v, ok := l.object(depth + 1)
+			if !ok || v == keyword(">>") {
+				return d, true
+			}
+			if key, isName := k.(name); isName {
+				d[key] = v
+			}
+		}
+	}
+
+	// "n g R" is a reference to object n
+	if n, isNumber := tok.(float64); isNumber {
+		start := l.pos
+		if _, ok := l.number(); ok {
+			if r, _ := l.token(); r == keyword("R") {
+				return ref(int(n)), true
+			}
+		}
+		l.pos = start
+	}
+	return tok, true
+}
+
+// stream reads the data following the stream keyword, trusting the Length
+// entry when it is direct and ends at endstream
+func (l *lexer) stream(d dict) *stream {
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	if n, ok := d["Length"].(float64); ok && n >= 0 && start+int(n) <= len(l.data) {
+		after := &lexer{data: l.data, pos: start + int(n)}
+		if tok, _ := after.token(); tok == keyword("endstream") {
+			l.pos = after.pos
+			return &stream{dict: d, raw: l.data[start : start+int(n)]}
+		}
+	}
+
+	i := bytes.Index(l.data[start:], []byte("endstream"))
+	if i < 0 {
+		l.pos = len(l.data)
+		return &stream{dict: d, raw: l.data[start:]}
+	}
+	l.pos = start + i + len("endstream")
+	return &stream{dict: d, raw: bytes.TrimRight(l.data[start:start+i], "\r\n")}
+}
+
+// skipInlineImage skips the data of an inline image, which follows the ID
+// operator and ends at EI
+func (l *lexer) skipInlineImage() {
+	for i := l.pos; i+2 <= len(l.data); i++ {
+		if l.data[i] == 'E' && l.data[i+1] == 'I' && i > l.pos && isSpace(l.data[i-1]) &&
+			(i+2 == len(l.data) || isSpace(l.data[i+2]) || isDelimiter(l.data[i+2])) {
+			l.pos = i + 2
+			return
+		}
+	}
+	l.pos = len(l.data)
+}
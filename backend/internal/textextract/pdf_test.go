@@ -0,0 +1,229 @@
+package textextract
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLexerTokens(t *testing.T) {
+	l := &lexer{data: []byte(`% comment
+		(a (nested) \(escaped\) \101\60 \n\
+end) <48 65 6c6C 6> /A#20B 12 -3.5 +.5 Tj true`)}
+	want := []interface{}{
+		[]byte("a (nested) (escaped) A0 \nend"),
+		[]byte("Hell`"),
+		name("A B"),
+		12.0,
+		-3.5,
+		0.5,
+		keyword("Tj"),
+		keyword("true"),
+	}
+	for i, w := range want {
+		got, ok := l.token()
+		if !ok || !reflect.DeepEqual(got, w) {
+			t.Errorf("token %d = %#v, want %#v", i, got, w)
+		}
+	}
+	if got, ok := l.token(); ok {
+		t.Errorf("token after the end = %#v", got)
+	}
+}
+
+func TestLexerObjects(t *testing.T) {
+	l := &lexer{data: []byte(`<< /Type /Page /Kids [1 0 R 2 0 R] /Box [0 0 612 792] /Sub << /N 3 >> >> 4 5 Tj`)}
+	got, ok := l.object(0)
+	want := dict{
+		"Type": name("Page"),
+		"Kids": array{ref(1), ref(2)},
+		"Box":  array{0.0, 0.0, 612.0, 792.0},
+		"Sub":  dict{"N": 3.0},
+	}
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("object = %#v, want %#v", got, want)
+	}
+
+	// Two numbers not followed by R are separate operands
+	for _, w := range []interface{}{4.0, 5.0, keyword("Tj")} {
+		if got, _ := l.object(0); got != w {
+			t.Errorf("object = %#v, want %#v", got, w)
+		}
+	}
+
+	// Deeply nested arrays are cut off instead of overflowing the stack
+	l = &lexer{data: []byte(strings.Repeat("[", 1000))}
+	if _, ok := l.object(0); !ok {
+		t.Error("object of nested arrays failed")
+	}
+}
+
+func TestParseCMap(t *testing.T) {
+	m := parseCMap([]byte(`
+		/CIDInit /ProcSet findresource begin
+		begincmap
+		2 begincodespacerange
+		<00> <7F>
+		<8000> <FFFF>
+		endcodespacerange
+		2 beginbfchar
+		<41> <0048>
+		<8001> <D83DDE00>
+		endbfchar
+		2 beginbfrange
+		<42> <43> <0069>
+		<8010> <8011> [<0066 0069> <00E9>]
+		endbfrange
+		endcmap`))
+
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte{0x41, 0x42, 0x43}, "Hij"},
+		// One- and two-byte codes mixed in one string
+		{[]byte{0x41, 0x80, 0x01, 0x42}, "H😀i"},
+		{[]byte{0x80, 0x10, 0x80, 0x11}, "fié"},
+		// Unmapped codes are dropped
+		{[]byte{0x44, 0x80, 0x02}, ""},
+	}
+	for _, tt := range tests {
+		if got := m.decode(tt.in); got != tt.want {
+			t.Errorf("decode(% x) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// Without codespace ranges the width of the mappings is used
+	m = parseCMap([]byte(`1 beginbfrange <0001> <0003> <0061> endbfrange`))
+	if got := m.decode([]byte{0, 1, 0, 3}); got != "ac" {
+		t.Errorf("decode = %q, want %q", got, "ac")
+	}
+}
+
+func TestFontDecode(t *testing.T) {
+	var plain *font
+	if got := plain.decode([]byte("Caf\xe9 \x80 5\x96")); got != "Café € 5–" {
+		t.Errorf("Windows-1252 decode = %q", got)
+	}
+	if got := (&font{composite: true}).decode([]byte{0, 1}); got != "" {
+		t.Errorf("composite font without a cmap decoded %q", got)
+	}
+	ft := &font{differences: map[byte]string{'A': "€", 'B': "fi"}}
+	if got := ft.decode([]byte("ABx")); got != "€fix" {
+		t.Errorf("differences decode = %q", got)
+	}
+	if got := ft.decode(12.0); got != "" {
+		t.Errorf("decode of a number = %q", got)
+	}
+}
+
+// pdfBuilder writes numbered objects into a minimal PDF
+type pdfBuilder struct {
+	buf bytes.Buffer
+}
+
+func newPDF() *pdfBuilder {
+	b := &pdfBuilder{}
+	b.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	return b
+}
+
+func (b *pdfBuilder) object(num int, body string) {
+	fmt.Fprintf(&b.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (b *pdfBuilder) stream(num int, entries string, data []byte) {
+	fmt.Fprintf(&b.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n%s\nendstream\nendobj\n", num, entries, len(data), data)
+}
+
+func zlibData(s string) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func deflateData(s string) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestExtractPDF(t *testing.T) {
+	b := newPDF()
+	b.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	b.object(2, "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 6 0 R >> >> >>")
+	b.object(3, "<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>")
+	b.object(4, "<< /Type /Page /Parent 2 0 R /Contents [10 0 R 11 0 R] /Resources << /Font << /F2 7 0 R /F3 9 0 R >> /XObject << /X1 12 0 R >> >> >>")
+	b.stream(5, "", []byte("BT /F1 12 Tf (Replaced) Tj ET"))
+	b.object(6, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+	b.object(7, "<< /Type /Font /Subtype /Type0 /ToUnicode 8 0 R >>")
+	b.stream(8, "/Filter /FlateDecode", zlibData("begincmap 1 begincodespacerange <0000> <FFFF> endcodespacerange "+
+		"2 beginbfchar <0001> <0048> <0004> <D83DDE00> endbfchar 1 beginbfrange <0002> <0003> <0069> endbfrange endcmap"))
+	// Font 9 lives in an object stream, compressed as raw deflate data
+	objStm := "9 0 << /Type /Font /Subtype /Type1 /Encoding << /Differences [65 /Euro /uni00E9 /space] >> >>"
+	b.stream(20, "/Type /ObjStm /N 1 /First 4 /Filter [/FlateDecode]", deflateData(objStm))
+	// A content stream may split an operation between its parts
+	b.stream(10, "/Filter /FlateDecode", zlibData("BT /F2 10 Tf <0001000200040003>"))
+	b.stream(11, "", []byte(" Tj T* /F3 10 Tf (ABCx) Tj ET q 1 0 0 1 0 0 cm /X1 Do Q"))
+	b.stream(12, "/Type /XObject /Subtype /Form /Resources << /Font << /F1 6 0 R >> >>", []byte("BT /F1 9 Tf 1 0 0 1 72 40 Tm (Page) Tj 1 0 0 1 100 40 Tm (2) Tj ET"))
+	// An incremental update replaces the first page's content
+	b.stream(5, "/Filter /FlateDecode", zlibData(`BT /F1 12 Tf 72 700 Td (Invoice \(copy\)) Tj 0 -14 Td [(Tot) 20 (al) -300 (\20042,00)] TJ ET`))
+	b.buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	got, err := Extract(b.buf.Bytes(), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Invoice (copy)\nTotal €42,00\nHi😀j\n€é x\nPage 2"
+	if got != want {
+		t.Errorf("Extract =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestExtractPDFWithoutPageTree(t *testing.T) {
+	b := newPDF()
+	b.stream(1, "", []byte("BT (First) Tj (line) ' ET"))
+	b.stream(2, "/Subtype /Image", []byte("BT (not text) Tj ET"))
+	b.stream(3, "", []byte("BT 1 0 0 1 0 10 Tm (Second) Tj BI /W 1 ID \x00EI\x00 EI ( after image) Tj ET"))
+
+	got, err := Extract(b.buf.Bytes(), "application/pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "First\nline\nSecond after image"; got != want {
+		t.Errorf("Extract = %q, want %q", got, want)
+	}
+}
+
+func TestExtractPDFErrors(t *testing.T) {
+	b := newPDF()
+	b.object(1, "<< /Type /Catalog >>")
+	b.buf.WriteString("trailer\n<< /Root 1 0 R /Encrypt 2 0 R >>\n")
+	if _, err := Extract(b.buf.Bytes(), "application/pdf"); err != errEncrypted {
+		t.Errorf("encrypted PDF: %v, want errEncrypted", err)
+	}
+	if _, err := Extract([]byte("%PDF-1.4\nnothing here"), "application/pdf"); err != errNotPDF {
+		t.Errorf("PDF without objects: %v, want errNotPDF", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	got, err := Extract([]byte("\xef\xbb\xbf  # Notes\nbad \xff byte\n"), "text/plain; charset=utf-8")
+	if err != nil || got != "# Notes\nbad � byte" {
+		t.Errorf("Extract text = %q, %v", got, err)
+	}
+	if _, err := Extract([]byte("GIF89a"), "image/gif"); err != ErrUnsupported {
+		t.Errorf("Extract image = %v, want ErrUnsupported", err)
+	}
+	if got := truncate("aé", 2); got != "a" {
+		t.Errorf("truncate split a character: %q", got)
+	}
+}
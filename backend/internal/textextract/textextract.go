@@ -0,0 +1,63 @@
+// Package textextract pulls the text out of uploaded files so they can be
+// searched and matched by rules.
+package textextract
+
+import (
+	"bytes"
+	"errors"
+	"expense_tracker/internal/filetype"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxText is the most text kept from one file, in bytes
+const MaxText = 1 << 20
+
+// ErrUnsupported is returned for file types text cannot be extracted from
+var ErrUnsupported = errors.New("text extraction is not supported for this file type")
+
+// Supported reports whether text can be extracted from files of a detected
+// content type
+func Supported(contentType string) bool {
+	switch baseType(contentType) {
+	case filetype.PDF, filetype.Text:
+		return true
+	}
+	return false
+}
+
+// Extract returns the text of a file of the given content type, at most
+// MaxText bytes of it. Markdown and other plain text files are returned
+// as they are.
+func Extract(data []byte, contentType string) (string, error) {
+	var text string
+	switch baseType(contentType) {
+	case filetype.Text:
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		text = strings.ToValidUTF8(string(data), "�")
+	case filetype.PDF:
+		var err error
+		if text, err = extractPDF(data); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrUnsupported
+	}
+	return truncate(strings.TrimSpace(text), MaxText), nil
+}
+
+// baseType drops parameters such as charset from a content type
+func baseType(contentType string) string {
+	return strings.TrimSpace(strings.Split(contentType, ";")[0])
+}
+
+// truncate cuts text to at most max bytes without splitting a character
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	for max > 0 && !utf8.RuneStart(text[max]) {
+		max--
+	}
+	return text[:max]
+}
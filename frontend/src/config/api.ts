@@ -2,8 +2,10 @@ import axios from 'axios';
 
 export const API_URL = 'http://localhost:8080/api';
 
+// Send the session cookie set by /auth/login with every request
 export const api = axios.create({
   baseURL: API_URL,
+  withCredentials: true,
 });
 
 // Response interceptor
@@ -37,8 +39,33 @@ export interface ApiResponse<T = any> {
   error?: string;
 }
 
+export interface User {
+  id: string;
+  email: string;
+  name: string;
+  isAdmin: boolean;
+}
+
+// A file attached to a payment
+export interface Attachment {
+  id: string;
+  paymentId: string;
+  kind: 'invoice' | 'receipt' | 'other';
+  originalName: string;
+  contentType: string;
+  fileSize: number;
+  createdAt: string;
+}
+
 // API endpoints
 export const endpoints = {
+  // Session
+  auth: {
+    login: (data: { email: string; password: string }) => api.post('/auth/login', data),
+    logout: () => api.post('/auth/logout'),
+    me: () => api.get<User>('/auth/me'),
+  },
+
   // Tags
   tags: {
     list: () => api.get('/tags'),
@@ -64,10 +91,13 @@ export const endpoints = {
     delete: (id: string) => api.delete(`/payments/${id}`),
     uploadInvoice: (id: string, file: File) => {
       const formData = new FormData();
-      formData.append('invoice', file);
-      return api.post(`/payments/${id}/invoice`, formData);
+      formData.append('file', file);
+      formData.append('kind', 'invoice');
+      return api.post(`/payments/${id}/attachments`, formData);
     },
-    downloadInvoice: (id: string) => api.get(`/payments/${id}/invoice`, { responseType: 'blob' }),
+    listAttachments: (id: string) => api.get<Attachment[]>(`/payments/${id}/attachments`),
+    downloadAttachment: (id: string, attachmentId: string) =>
+      api.get(`/payments/${id}/attachments/${attachmentId}/download`, { responseType: 'blob' }),
   },
   
   // Documents
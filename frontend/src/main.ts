@@ -8,6 +8,8 @@ import App from './App.vue';
 import router from './router';
 // Import Vuetify configuration
 import vuetify from './plugins/vuetify';
+import { api } from './config/api';
+import { useAuthStore } from './stores/auth';
 
 // Create the app instance
 const app = createApp(App);
@@ -17,5 +19,16 @@ app.use(createPinia());
 app.use(router);
 app.use(vuetify);
 
+// Back to the login page when the session expires or is ended elsewhere
+api.interceptors.response.use(undefined, (error) => {
+  const current = router.currentRoute.value;
+  const sessionCall = error.config?.url?.startsWith('/auth/');
+  if (error.response?.status === 401 && !sessionCall && !current.meta.public) {
+    useAuthStore().clear();
+    router.push({ name: 'Login', query: { redirect: current.fullPath } });
+  }
+  return Promise.reject(error);
+});
+
 // Mount the app
 app.mount('#app');
@@ -1,4 +1,5 @@
 import { createRouter, createWebHistory } from 'vue-router';
+import { useAuthStore } from '../stores/auth';
 
 const router = createRouter({
   history: createWebHistory(),
@@ -7,6 +8,15 @@ const router = createRouter({
       path: '/',
       redirect: '/dashboard',
     },
+    {
+      path: '/login',
+      name: 'Login',
+      component: () => import('../views/LoginView.vue'),
+      meta: {
+        title: 'Sign in',
+        public: true,
+      },
+    },
     {
       path: '/dashboard',
       name: 'Dashboard',
@@ -46,9 +56,21 @@ const router = createRouter({
   ],
 });
 
-// Navigation guard to update document title
-router.beforeEach((to, from, next) => {
+// Navigation guard to update document title and send signed-out users to
+// the login page
+router.beforeEach(async (to, _from, next) => {
   document.title = `${to.meta.title} - Expense Tracker` || 'Expense Tracker';
+  if (to.meta.public) {
+    next();
+    return;
+  }
+
+  const auth = useAuthStore();
+  if (!auth.checked) await auth.fetchUser();
+  if (!auth.user) {
+    next({ name: 'Login', query: { redirect: to.fullPath } });
+    return;
+  }
   next();
 });
 
@@ -0,0 +1,39 @@
+import { defineStore } from 'pinia';
+import { endpoints, type User } from '../config/api';
+
+// Signed-in user, loaded once from /auth/me and kept until logout or a 401
+export const useAuthStore = defineStore('auth', {
+  state: () => ({
+    user: null as User | null,
+    checked: false,
+  }),
+  actions: {
+    async fetchUser() {
+      try {
+        const response = await endpoints.auth.me();
+        this.user = response.data;
+      } catch {
+        this.user = null;
+      } finally {
+        this.checked = true;
+      }
+      return this.user;
+    },
+    async login(email: string, password: string) {
+      const response = await endpoints.auth.login({ email, password });
+      this.user = response.data.user;
+      this.checked = true;
+    },
+    async logout() {
+      try {
+        await endpoints.auth.logout();
+      } finally {
+        this.clear();
+      }
+    },
+    clear() {
+      this.user = null;
+      this.checked = true;
+    },
+  },
+});
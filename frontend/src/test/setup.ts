@@ -27,6 +27,11 @@ if (typeof window !== 'undefined') {
 // Mock API endpoints
 vi.mock('../config/api', () => ({
   endpoints: {
+    auth: {
+      login: vi.fn(),
+      logout: vi.fn(),
+      me: vi.fn(),
+    },
     tags: {
       list: vi.fn(),
       create: vi.fn(),
@@ -40,7 +45,8 @@ vi.mock('../config/api', () => ({
       update: vi.fn(),
       delete: vi.fn(),
       uploadInvoice: vi.fn(),
-      downloadInvoice: vi.fn(),
+      listAttachments: vi.fn(),
+      downloadAttachment: vi.fn(),
     },
     documents: {
       list: vi.fn(),
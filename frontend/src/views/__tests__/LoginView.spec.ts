@@ -0,0 +1,78 @@
+import { describe, it, expect, beforeEach, vi } from 'vitest';
+import { mount, flushPromises } from '@vue/test-utils';
+import { createPinia, setActivePinia } from 'pinia';
+import { createVuetify } from 'vuetify';
+import * as components from 'vuetify/components';
+import * as directives from 'vuetify/directives';
+import type { AxiosResponse } from 'axios';
+import { endpoints } from '../../config/api';
+import LoginView from '../LoginView.vue';
+
+const vuetify = createVuetify({
+  components,
+  directives,
+});
+
+const { replace } = vi.hoisted(() => ({ replace: vi.fn() }));
+vi.mock('vue-router', () => ({
+  useRoute: () => ({ query: { redirect: '/payments' } }),
+  useRouter: () => ({ replace }),
+}));
+
+// Helper to create mock axios responses
+const createAxiosResponse = <T>(data: T): AxiosResponse<T> => ({
+  data,
+  status: 200,
+  statusText: 'OK',
+  headers: {},
+  config: {} as any,
+});
+
+describe('LoginView', () => {
+  const user = { id: '1', email: 'admin@example.com', name: 'Admin', isAdmin: true };
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+  });
+
+  const mountLogin = () => {
+    const pinia = createPinia();
+    setActivePinia(pinia);
+    return mount(LoginView, {
+      global: {
+        plugins: [vuetify, pinia],
+      },
+    });
+  };
+
+  it('signs in and returns to the page that needed a login', async () => {
+    vi.mocked(endpoints.auth.login).mockResolvedValue(
+      createAxiosResponse({ token: 'secret', user, workspaceId: 'ws' })
+    );
+
+    const wrapper = mountLogin();
+    await wrapper.find('[data-test="login-email-input"] input').setValue(user.email);
+    await wrapper.find('[data-test="login-password-input"] input').setValue('password123');
+    await (wrapper.vm as any).handleSubmit();
+    await flushPromises();
+
+    expect(endpoints.auth.login).toHaveBeenCalledWith({
+      email: user.email,
+      password: 'password123',
+    });
+    expect(replace).toHaveBeenCalledWith('/payments');
+  });
+
+  it('shows an error for wrong credentials', async () => {
+    vi.mocked(endpoints.auth.login).mockRejectedValue({ response: { status: 401 } });
+
+    const wrapper = mountLogin();
+    await wrapper.find('[data-test="login-email-input"] input').setValue(user.email);
+    await wrapper.find('[data-test="login-password-input"] input').setValue('wrong');
+    await (wrapper.vm as any).handleSubmit();
+    await flushPromises();
+
+    expect(replace).not.toHaveBeenCalled();
+    expect(wrapper.find('[data-test="login-error"]').text()).toContain('Wrong email or password');
+  });
+});